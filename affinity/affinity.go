@@ -0,0 +1,92 @@
+// Package affinity keeps the calls of a logical session, like the requests of
+// a user or the steps of a workflow, on the same backend, for servers caching
+// session state. Client interceptors send the affinity key of sessions as
+// metadata, and the "affinity" balancer routes each key to the same backend
+// while the set of backends is unchanged.
+package affinity
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// MetadataKey is the metadata key carrying the affinity key of calls.
+const MetadataKey = "x-affinity-key"
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx whose calls have the affinity key, like a
+// user or cart ID.
+func NewContext(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+// FromContext returns the affinity key of ctx, false if it has none.
+func FromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(contextKey{}).(string)
+	return key, ok && key != ""
+}
+
+// NewSession returns a copy of ctx whose calls have a new random affinity key,
+// unless ctx has one, for sessions without a natural key.
+func NewSession(ctx context.Context) context.Context {
+	if _, ok := FromContext(ctx); ok {
+		return ctx
+	}
+	var b [16]byte
+	rand.Read(b[:])
+	return NewContext(ctx, hex.EncodeToString(b[:]))
+}
+
+type options struct {
+	key func(ctx context.Context) (string, bool)
+}
+
+// Option for affinity client interceptors.
+type Option func(o *options)
+
+// WithKey sets how the affinity key of calls is found, false for calls
+// without one. Default is FromContext.
+func WithKey(f func(ctx context.Context) (string, bool)) Option {
+	return func(o *options) {
+		o.key = f
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{key: FromContext}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// outgoing returns ctx sending the affinity key of its calls, if any.
+func (o options) outgoing(ctx context.Context) context.Context {
+	if key, ok := o.key(ctx); ok {
+		return metadata.AppendToOutgoingContext(ctx, MetadataKey, key)
+	}
+	return ctx
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor sending the
+// affinity key of calls.
+func UnaryClientInterceptor(opts ...Option) grpc.UnaryClientInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		return invoker(o.outgoing(ctx), method, req, reply, cc, callOpts...)
+	}
+}
+
+// StreamClientInterceptor returns a new streaming client interceptor sending
+// the affinity key of streams.
+func StreamClientInterceptor(opts ...Option) grpc.StreamClientInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(o.outgoing(ctx), desc, cc, method, callOpts...)
+	}
+}
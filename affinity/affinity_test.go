@@ -0,0 +1,122 @@
+package affinity
+
+import (
+	"fmt"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	var sent []string
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		sent = md.Get(MetadataKey)
+		return nil
+	}
+	interceptor := UnaryClientInterceptor()
+
+	session := NewSession(context.Background())
+	key, ok := FromContext(session)
+	if !ok || len(key) != 32 {
+		t.Fatalf("session key: have %q", key)
+	}
+	if again, _ := FromContext(NewSession(session)); key != again {
+		t.Fatalf("nested session: want %q, have %q", key, again)
+	}
+
+	for _, tc := range []struct {
+		ctx  context.Context
+		want string
+	}{
+		{session, key},
+		{NewContext(context.Background(), "user-42"), "user-42"},
+		{context.Background(), ""},
+	} {
+		sent = nil
+		interceptor(tc.ctx, "/test.Service/Get", nil, nil, nil, invoker)
+		have := ""
+		if len(sent) > 0 {
+			have = sent[0]
+		}
+		if tc.want != have {
+			t.Errorf("want %q, have %q", tc.want, have)
+		}
+	}
+}
+
+type testSubConn struct {
+	balancer.SubConn
+	addr string
+}
+
+// build returns a picker of backends at addrs.
+func build(addrs ...string) balancer.V2Picker {
+	info := base.PickerBuildInfo{ReadySCs: make(map[balancer.SubConn]base.SubConnInfo)}
+	for _, addr := range addrs {
+		info.ReadySCs[&testSubConn{addr: addr}] = base.SubConnInfo{Address: resolver.Address{Addr: addr}}
+	}
+	return pickerBuilder{}.Build(info)
+}
+
+func pick(t *testing.T, p balancer.V2Picker, key string) string {
+	ctx := context.Background()
+	if key != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, MetadataKey, key)
+	}
+	res, err := p.Pick(balancer.PickInfo{FullMethodName: "/test.Service/Get", Ctx: ctx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.SubConn.(*testSubConn).addr
+}
+
+func TestPicker(t *testing.T) {
+	p := build("10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80")
+	spread := map[string]int{}
+	moved := 0
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("session-%d", i)
+		addr := pick(t, p, key)
+		if again := pick(t, p, key); addr != again {
+			t.Fatalf("%s: picked %s then %s", key, addr, again)
+		}
+		spread[addr]++
+
+		// Removing another backend leaves the key in place.
+		var others []string
+		for _, a := range []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"} {
+			if a != addr {
+				others = append(others, a)
+			}
+		}
+		if have := pick(t, build(addr, others[0]), key); addr != have {
+			moved++
+		}
+	}
+	if want, have := 0, moved; want != have {
+		t.Fatalf("moved keys: want %d, have %d", want, have)
+	}
+	for addr, n := range spread {
+		if n < 50 {
+			t.Errorf("%s: only %d of 300 keys", addr, n)
+		}
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		seen[pick(t, p, "")] = true
+	}
+	if want, have := 3, len(seen); want != have {
+		t.Fatalf("without key: want %d backends, have %d", want, have)
+	}
+
+	if _, err := build().Pick(balancer.PickInfo{Ctx: context.Background()}); err != balancer.ErrNoSubConnAvailable {
+		t.Fatalf("no backends: want %v, have %v", balancer.ErrNoSubConnAvailable, err)
+	}
+}
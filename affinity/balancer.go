@@ -0,0 +1,67 @@
+package affinity
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+)
+
+// Name of the balancer, selected by the service config of clients, like
+// grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy":"affinity"}`).
+const Name = "affinity"
+
+func init() {
+	balancer.Register(base.NewBalancerBuilderV2(Name, pickerBuilder{}, base.Config{HealthCheck: true}))
+}
+
+type pickerBuilder struct{}
+
+func (pickerBuilder) Build(info base.PickerBuildInfo) balancer.V2Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPickerV2(balancer.ErrNoSubConnAvailable)
+	}
+	p := &picker{}
+	for sc, sci := range info.ReadySCs {
+		p.backends = append(p.backends, backend{addr: sci.Address.Addr, sc: sc})
+	}
+	sort.Slice(p.backends, func(i, j int) bool { return p.backends[i].addr < p.backends[j].addr })
+	return p
+}
+
+type backend struct {
+	addr string
+	sc   balancer.SubConn
+}
+
+// picker sends calls with an affinity key to the backend with the highest
+// hash of key and address, so keys only move when their backend goes away,
+// and calls without one round robin.
+type picker struct {
+	backends []backend
+	next     uint32
+}
+
+func (p *picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	md, _ := metadata.FromOutgoingContext(info.Ctx)
+	keys := md.Get(MetadataKey)
+	if len(keys) == 0 {
+		n := atomic.AddUint32(&p.next, 1)
+		return balancer.PickResult{SubConn: p.backends[n%uint32(len(p.backends))].sc}, nil
+	}
+	var best balancer.SubConn
+	var max uint64
+	for _, b := range p.backends {
+		h := fnv.New64a()
+		h.Write([]byte(keys[0]))
+		h.Write([]byte{0})
+		h.Write([]byte(b.addr))
+		if s := h.Sum64(); best == nil || s > max {
+			best, max = b.sc, s
+		}
+	}
+	return balancer.PickResult{SubConn: best}, nil
+}
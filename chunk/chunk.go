@@ -0,0 +1,131 @@
+// Package chunk streams large result sets from server-streaming handlers in
+// pages bounded in size, so list endpoints stay under the maximum message
+// size of clients however many items they return.
+package chunk
+
+import (
+	"io"
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultMaxBytes is the default encoded size of pages, a quarter of the
+// default maximum message size of gRPC clients.
+const DefaultMaxBytes = 1 << 20
+
+// Iterator yields the items of a result set.
+type Iterator interface {
+	// Next returns the next item, or io.EOF after the last one.
+	Next(ctx context.Context) (proto.Message, error)
+}
+
+// IteratorFunc is an adapter to use a function as an Iterator.
+type IteratorFunc func(ctx context.Context) (proto.Message, error)
+
+// Next calls f.
+func (f IteratorFunc) Next(ctx context.Context) (proto.Message, error) {
+	return f(ctx)
+}
+
+// Slice returns an Iterator over a slice of messages, like []*pb.User. It
+// panics if items isn't a slice of messages.
+func Slice(items interface{}) Iterator {
+	v := reflect.ValueOf(items)
+	if v.Kind() != reflect.Slice || !v.Type().Elem().Implements(reflect.TypeOf((*proto.Message)(nil)).Elem()) {
+		panic("chunk: Slice of non-message slice")
+	}
+	i := 0
+	return IteratorFunc(func(ctx context.Context) (proto.Message, error) {
+		if i == v.Len() {
+			return nil, io.EOF
+		}
+		i++
+		return v.Index(i - 1).Interface().(proto.Message), nil
+	})
+}
+
+// PageFunc builds the page message sent for items, like a ListUsersResponse
+// with the items as its repeated field.
+type PageFunc func(items []proto.Message) proto.Message
+
+type options struct {
+	maxBytes int
+	maxItems int
+}
+
+// Option for Send.
+type Option func(o *options)
+
+// WithMaxBytes sets the encoded size of the items of a page. Default is
+// DefaultMaxBytes. Items larger than the size are sent alone.
+func WithMaxBytes(n int) Option {
+	return func(o *options) {
+		o.maxBytes = n
+	}
+}
+
+// WithMaxItems sets the number of items of a page. Default is no limit.
+func WithMaxItems(n int) Option {
+	return func(o *options) {
+		o.maxItems = n
+	}
+}
+
+// size returns the encoded size of m as an element of a repeated field: its
+// bytes, tag and length prefix.
+func size(m proto.Message) int {
+	n := proto.Size(m)
+	return n + 1 + len(proto.EncodeVarint(uint64(n)))
+}
+
+// Send sends the items of it on stream in pages built by page, each as
+// large as the options allow. Pages are sent one at a time, so a slow client
+// holds back the iterator rather than filling memory. Send stops with the
+// status of the context of the stream once it is done, and returns errors of
+// it and of the stream as is.
+func Send(stream grpc.ServerStream, it Iterator, page PageFunc, opts ...Option) error {
+	o := options{maxBytes: DefaultMaxBytes}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	ctx := stream.Context()
+	var (
+		items []proto.Message
+		bytes int
+	)
+	flush := func() error {
+		if len(items) == 0 {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+		err := stream.SendMsg(page(items))
+		items, bytes = nil, 0
+		return err
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+		m, err := it.Next(ctx)
+		if err == io.EOF {
+			return flush()
+		}
+		if err != nil {
+			return err
+		}
+		n := size(m)
+		if len(items) > 0 && (bytes+n > o.maxBytes || o.maxItems > 0 && len(items) == o.maxItems) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		items = append(items, m)
+		bytes += n
+	}
+}
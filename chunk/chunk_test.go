@@ -0,0 +1,117 @@
+package chunk
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	pages []*structpb.ListValue
+}
+
+func (s *testStream) Context() context.Context { return s.ctx }
+func (s *testStream) SendMsg(m interface{}) error {
+	s.pages = append(s.pages, m.(*structpb.ListValue))
+	return nil
+}
+
+func page(items []proto.Message) proto.Message {
+	l := &structpb.ListValue{}
+	for _, m := range items {
+		l.Values = append(l.Values, m.(*structpb.Value))
+	}
+	return l
+}
+
+func values(n, size int) []*structpb.Value {
+	var out []*structpb.Value
+	for i := 0; i < n; i++ {
+		out = append(out, &structpb.Value{Kind: &structpb.Value_StringValue{StringValue: strings.Repeat("x", size)}})
+	}
+	return out
+}
+
+func TestSend(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		items []*structpb.Value
+		opts  []Option
+		pages []int
+	}{
+		{"empty", nil, nil, nil},
+		{"one page", values(10, 10), nil, []int{10}},
+		{"max bytes", values(10, 100), []Option{WithMaxBytes(350)}, []int{3, 3, 3, 1}},
+		{"max items", values(10, 10), []Option{WithMaxItems(4)}, []int{4, 4, 2}},
+		{"large item", values(2, 1000), []Option{WithMaxBytes(100)}, []int{1, 1}},
+	} {
+		stream := &testStream{ctx: context.Background()}
+		if err := Send(stream, Slice(tc.items), page, tc.opts...); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var have []int
+		for _, p := range stream.pages {
+			have = append(have, len(p.Values))
+		}
+		if want, have := tc.pages, have; !equal(want, have) {
+			t.Errorf("%s: pages: want %v, have %v", tc.name, want, have)
+		}
+	}
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSendCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &testStream{ctx: ctx}
+	items := values(10, 10)
+	n := 0
+	it := IteratorFunc(func(ctx context.Context) (proto.Message, error) {
+		if n == 5 {
+			cancel()
+		}
+		n++
+		return items[n-1], nil
+	})
+	err := Send(stream, it, page, WithMaxItems(2))
+	if want, have := codes.Canceled, status.Code(err); want != have {
+		t.Fatalf("code: want %v, have %v", want, have)
+	}
+	if want, have := 2, len(stream.pages); want != have {
+		t.Fatalf("pages: want %d, have %d", want, have)
+	}
+}
+
+func TestSendIteratorError(t *testing.T) {
+	errQuery := errors.New("query failed")
+	stream := &testStream{ctx: context.Background()}
+	it := IteratorFunc(func(ctx context.Context) (proto.Message, error) {
+		return nil, errQuery
+	})
+	if want, have := errQuery, Send(stream, it, page); want != have {
+		t.Fatalf("want %v, have %v", want, have)
+	}
+	if _, err := Slice([]*structpb.Value{}).Next(context.Background()); err != io.EOF {
+		t.Fatalf("empty slice: want EOF, have %v", err)
+	}
+}
@@ -0,0 +1,246 @@
+// Package credentials provides TLS transport credentials reloading their
+// certificate and CA from a source, like files rotated by cert-manager or
+// Vault, so listeners and connections pick new certificates up without a
+// restart.
+package credentials
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	grpccredentials "google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/grpclog"
+)
+
+// Source returns the current certificate and CA pool. The pool is nil when
+// there is no CA to verify peers with.
+type Source interface {
+	Load() (*tls.Certificate, *x509.CertPool, error)
+}
+
+// SourceFunc is an adapter to allow the use of ordinary functions as Source,
+// like one reading the X.509 SVID and bundle of a SPIFFE workload API client.
+type SourceFunc func() (*tls.Certificate, *x509.CertPool, error)
+
+// Load calls f.
+func (f SourceFunc) Load() (*tls.Certificate, *x509.CertPool, error) {
+	return f()
+}
+
+// Files returns a Source reading a PEM encoded certificate and key, and CA
+// certificates unless caFile is "".
+func Files(certFile, keyFile, caFile string) Source {
+	return SourceFunc(func() (*tls.Certificate, *x509.CertPool, error) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		if caFile == "" {
+			return &cert, nil, nil
+		}
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("credentials: no CA certificate in %s", caFile)
+		}
+		return &cert, pool, nil
+	})
+}
+
+type options struct {
+	interval time.Duration
+	logger   grpclog.LoggerV2
+}
+
+// Option for Reloader instance.
+type Option func(o *options)
+
+// WithInterval sets how often the source is reloaded. Default is 1 minute.
+func WithInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.interval = d
+	}
+}
+
+// WithLogger replaced built-in logger to given.
+func WithLogger(logger grpclog.LoggerV2) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// Reloader serves the latest certificate and CA pool of a source.
+type Reloader struct {
+	source Source
+	logger grpclog.LoggerV2
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	pool *x509.CertPool
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// New loads source and reloads it in the background until Close is called.
+// Certificates failing to load are logged and the previous ones kept.
+func New(source Source, opts ...Option) (*Reloader, error) {
+	o := options{
+		interval: time.Minute,
+		logger:   grpclog.NewLoggerV2(os.Stdout, os.Stdout, os.Stderr),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	r := &Reloader{source: source, logger: o.logger, stop: make(chan struct{})}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	go r.watch(o.interval)
+	return r, nil
+}
+
+func (r *Reloader) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.Reload(); err != nil {
+				r.logger.Errorf("credentials: reload: %v", err)
+			}
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// Reload loads the source now, keeping the previous certificate and pool if
+// it fails.
+func (r *Reloader) Reload() error {
+	cert, pool, err := r.source.Load()
+	if err != nil {
+		return err
+	}
+	if cert == nil || len(cert.Certificate) == 0 {
+		return errors.New("credentials: no certificate")
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return err
+		}
+	}
+	r.mu.Lock()
+	rotated := r.cert != nil && !bytes.Equal(r.cert.Certificate[0], cert.Certificate[0])
+	r.cert, r.pool = cert, pool
+	r.mu.Unlock()
+	if rotated {
+		r.logger.Infof("credentials: rotated certificate %s, expires %v", cert.Leaf.Subject, cert.Leaf.NotAfter)
+	}
+	return nil
+}
+
+// Certificate returns the current certificate and CA pool.
+func (r *Reloader) Certificate() (*tls.Certificate, *x509.CertPool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, r.pool
+}
+
+// Close stops reloading.
+func (r *Reloader) Close() {
+	r.once.Do(func() { close(r.stop) })
+}
+
+// ServerConfig returns a copy of base, nil for defaults, serving the current
+// certificate to each handshake and, with a CA pool, requiring client
+// certificates verified against the current pool unless base.ClientAuth says
+// otherwise.
+func (r *Reloader) ServerConfig(base *tls.Config) *tls.Config {
+	config := clone(base)
+	config.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, _ := r.Certificate()
+		return cert, nil
+	}
+	if _, pool := r.Certificate(); pool == nil {
+		return config
+	}
+	// The pool changing across handshakes, verification is done by hand.
+	if config.ClientAuth == tls.NoClientCert {
+		config.ClientAuth = tls.RequireAnyClientCert
+	}
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return nil
+		}
+		return r.verify(cs.PeerCertificates, "", x509.ExtKeyUsageClientAuth)
+	}
+	return config
+}
+
+// ClientConfig returns a copy of base, nil for defaults, presenting the
+// current certificate and, with a CA pool, verifying servers against the
+// current pool instead of base.RootCAs.
+func (r *Reloader) ClientConfig(base *tls.Config) *tls.Config {
+	config := clone(base)
+	config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		cert, _ := r.Certificate()
+		return cert, nil
+	}
+	if _, pool := r.Certificate(); pool == nil {
+		return config
+	}
+	config.InsecureSkipVerify = true
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("credentials: no server certificate")
+		}
+		return r.verify(cs.PeerCertificates, cs.ServerName, x509.ExtKeyUsageServerAuth)
+	}
+	return config
+}
+
+func clone(base *tls.Config) *tls.Config {
+	if base == nil {
+		return &tls.Config{}
+	}
+	return base.Clone()
+}
+
+// verify verifies the chain of a peer against the current pool.
+func (r *Reloader) verify(chain []*x509.Certificate, name string, usage x509.ExtKeyUsage) error {
+	_, pool := r.Certificate()
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		DNSName:       name,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+	return err
+}
+
+// ServerCredentials returns transport credentials of ServerConfig(nil), for
+// grpc.Creds.
+func (r *Reloader) ServerCredentials() grpccredentials.TransportCredentials {
+	return grpccredentials.NewTLS(r.ServerConfig(nil))
+}
+
+// ClientCredentials returns transport credentials of ClientConfig(nil), for
+// grpc.WithTransportCredentials.
+func (r *Reloader) ClientCredentials() grpccredentials.TransportCredentials {
+	return grpccredentials.NewTLS(r.ClientConfig(nil))
+}
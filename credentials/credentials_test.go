@@ -0,0 +1,137 @@
+package credentials
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// issue returns a certificate for name signed by parent, self-signed if
+// parent is nil, and its key.
+func issue(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// write writes cert, and key unless nil, as PEM files under dir.
+func write(t *testing.T, dir, name string, cert *x509.Certificate, key *ecdsa.PrivateKey) {
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if key == nil {
+		return
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+"-key.pem"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// handshake returns the common name of the certificate the server presents to
+// the client, or the handshake error.
+func handshake(t *testing.T, server, client *tls.Config) (string, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tls.Server(conn, server).Handshake()
+	}()
+	conn, err := tls.Dial("tcp", lis.Addr().String(), client)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := issue(t, "ca", nil, nil)
+	write(t, dir, "ca", ca, nil)
+	cert, key := issue(t, "first", ca, caKey)
+	write(t, dir, "tls", cert, key)
+
+	r, err := New(Files(filepath.Join(dir, "tls.pem"), filepath.Join(dir, "tls-key.pem"), filepath.Join(dir, "ca.pem")), WithInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	server := r.ServerConfig(nil)
+	client := r.ClientConfig(&tls.Config{ServerName: "localhost"})
+
+	if name, err := handshake(t, server, client); err != nil || name != "first" {
+		t.Fatalf("first: want first, have %q, %v", name, err)
+	}
+
+	cert, key = issue(t, "second", ca, caKey)
+	write(t, dir, "tls", cert, key)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if name, err := handshake(t, server, client); err != nil || name != "second" {
+		t.Fatalf("rotated: want second, have %q, %v", name, err)
+	}
+
+	// A broken rotation keeps the previous certificate.
+	ioutil.WriteFile(filepath.Join(dir, "tls-key.pem"), []byte("garbage"), 0600)
+	if err := r.Reload(); err == nil {
+		t.Fatal("broken key: want error")
+	}
+	if name, err := handshake(t, server, client); err != nil || name != "second" {
+		t.Fatalf("broken: want second, have %q, %v", name, err)
+	}
+
+	// Servers of another CA are rejected.
+	other, otherKey := issue(t, "other", nil, nil)
+	leaf, leafKey := issue(t, "impostor", other, otherKey)
+	impostor := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{leaf.Raw}, PrivateKey: leafKey}}}
+	if _, err := handshake(t, impostor, client); err == nil {
+		t.Fatal("other CA: want error")
+	}
+}
@@ -0,0 +1,189 @@
+// Package errors builds gRPC status errors with google.rpc error details, and
+// extracts the details from errors on the client side.
+//
+// Import it under another name than errors, like grpcerrors, next to the
+// standard library package.
+package errors
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Builder builds a status with details. Its methods return the Builder, so
+// calls can be chained:
+//
+//	return nil, errors.New(codes.InvalidArgument, "invalid user").
+//		FieldViolation("email", "must be an email address").
+//		Err()
+type Builder struct {
+	code    codes.Code
+	message string
+	details []proto.Message
+
+	badRequest *errdetails.BadRequest
+	quota      *errdetails.QuotaFailure
+}
+
+// New returns a Builder of a status of code and message.
+func New(code codes.Code, message string) *Builder {
+	return &Builder{code: code, message: message}
+}
+
+// Newf returns a Builder of a status of code and a formatted message.
+func Newf(code codes.Code, format string, a ...interface{}) *Builder {
+	return New(code, fmt.Sprintf(format, a...))
+}
+
+// From returns a Builder of the status of err, keeping its details. Errors
+// without a status are Unknown.
+func From(err error) *Builder {
+	st := status.Convert(err)
+	b := New(st.Code(), st.Message())
+	for _, d := range st.Details() {
+		m, ok := d.(proto.Message)
+		if !ok {
+			continue
+		}
+		switch m := m.(type) {
+		case *errdetails.BadRequest:
+			b.badRequest = m
+		case *errdetails.QuotaFailure:
+			b.quota = m
+		}
+		b.details = append(b.details, m)
+	}
+	return b
+}
+
+// FieldViolation adds a violation of the request field, like
+// "address.zip_code", to the BadRequest detail.
+func (b *Builder) FieldViolation(field, description string) *Builder {
+	if b.badRequest == nil {
+		b.badRequest = &errdetails.BadRequest{}
+		b.details = append(b.details, b.badRequest)
+	}
+	b.badRequest.FieldViolations = append(b.badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: field, Description: description})
+	return b
+}
+
+// RetryAfter adds a RetryInfo detail telling clients to wait for d before
+// retrying, which the retry interceptors honor.
+func (b *Builder) RetryAfter(d time.Duration) *Builder {
+	b.details = append(b.details, &errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(d)})
+	return b
+}
+
+// QuotaViolation adds a violation of the quota of subject, like "clientip:
+// 10.0.0.1" or the name of a rate limit rule, to the QuotaFailure detail.
+func (b *Builder) QuotaViolation(subject, description string) *Builder {
+	if b.quota == nil {
+		b.quota = &errdetails.QuotaFailure{}
+		b.details = append(b.details, b.quota)
+	}
+	b.quota.Violations = append(b.quota.Violations, &errdetails.QuotaFailure_Violation{Subject: subject, Description: description})
+	return b
+}
+
+// Reason adds an ErrorInfo detail of a machine-readable reason, like
+// "API_DISABLED", within domain, like "pubsub.example.com", with optional
+// metadata.
+func (b *Builder) Reason(reason, domain string, metadata map[string]string) *Builder {
+	b.details = append(b.details, &errdetails.ErrorInfo{Reason: reason, Domain: domain, Metadata: metadata})
+	return b
+}
+
+// Localized adds a LocalizedMessage detail of a message safe to show to end
+// users in locale, like "fr-CH". The status message stays meant for
+// developers.
+func (b *Builder) Localized(locale, message string) *Builder {
+	b.details = append(b.details, &errdetails.LocalizedMessage{Locale: locale, Message: message})
+	return b
+}
+
+// Detail adds any other detail message.
+func (b *Builder) Detail(m proto.Message) *Builder {
+	b.details = append(b.details, m)
+	return b
+}
+
+// Status returns the status built. Details are dropped if they can't be
+// encoded, keeping the code and message.
+func (b *Builder) Status() *status.Status {
+	st := status.New(b.code, b.message)
+	if len(b.details) == 0 {
+		return st
+	}
+	if detailed, err := st.WithDetails(b.details...); err == nil {
+		return detailed
+	}
+	return st
+}
+
+// Err returns the status built as an error, nil if its code is OK.
+func (b *Builder) Err() error {
+	return b.Status().Err()
+}
+
+// FieldViolations returns the field violations of the BadRequest details of
+// err.
+func FieldViolations(err error) []*errdetails.BadRequest_FieldViolation {
+	var out []*errdetails.BadRequest_FieldViolation
+	for _, d := range status.Convert(err).Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			out = append(out, br.FieldViolations...)
+		}
+	}
+	return out
+}
+
+// RetryDelay returns the delay of the RetryInfo detail of err, false if it
+// has none.
+func RetryDelay(err error) (time.Duration, bool) {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.RetryInfo); ok && info.RetryDelay != nil {
+			if d, err := ptypes.Duration(info.RetryDelay); err == nil && d >= 0 {
+				return d, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// QuotaViolations returns the violations of the QuotaFailure details of err.
+func QuotaViolations(err error) []*errdetails.QuotaFailure_Violation {
+	var out []*errdetails.QuotaFailure_Violation
+	for _, d := range status.Convert(err).Details() {
+		if qf, ok := d.(*errdetails.QuotaFailure); ok {
+			out = append(out, qf.Violations...)
+		}
+	}
+	return out
+}
+
+// ErrorInfo returns the ErrorInfo detail of err, false if it has none.
+func ErrorInfo(err error) (*errdetails.ErrorInfo, bool) {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info, true
+		}
+	}
+	return nil, false
+}
+
+// LocalizedMessage returns the LocalizedMessage detail of err, false if it
+// has none.
+func LocalizedMessage(err error) (*errdetails.LocalizedMessage, bool) {
+	for _, d := range status.Convert(err).Details() {
+		if m, ok := d.(*errdetails.LocalizedMessage); ok {
+			return m, true
+		}
+	}
+	return nil, false
+}
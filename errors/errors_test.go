@@ -0,0 +1,77 @@
+package errors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBuilder(t *testing.T) {
+	err := New(codes.InvalidArgument, "invalid user").
+		FieldViolation("email", "must be an email address").
+		FieldViolation("age", "must be positive").
+		Reason("INVALID_USER", "users.example.com", map[string]string{"user": "42"}).
+		Localized("fr", "utilisateur invalide").
+		Detail(&wrappers.StringValue{Value: "extra"}).
+		Err()
+
+	st := status.Convert(err)
+	if want, have := codes.InvalidArgument, st.Code(); want != have {
+		t.Fatalf("code: want %v, have %v", want, have)
+	}
+	if want, have := 4, len(st.Details()); want != have {
+		t.Fatalf("details: want %d, have %d", want, have)
+	}
+	if m, ok := LocalizedMessage(err); !ok || m.Locale != "fr" || m.Message != "utilisateur invalide" {
+		t.Fatalf("localized message: want fr message, have %v", m)
+	}
+	violations := FieldViolations(err)
+	if want, have := 2, len(violations); want != have {
+		t.Fatalf("field violations: want %d, have %d", want, have)
+	}
+	if want, have := "age", violations[1].Field; want != have {
+		t.Fatalf("field: want %q, have %q", want, have)
+	}
+	info, ok := ErrorInfo(err)
+	if !ok {
+		t.Fatal("no ErrorInfo")
+	}
+	if want, have := "INVALID_USER", info.Reason; want != have {
+		t.Fatalf("reason: want %q, have %q", want, have)
+	}
+	if want, have := "42", info.Metadata["user"]; want != have {
+		t.Fatalf("metadata: want %q, have %q", want, have)
+	}
+	if _, ok := RetryDelay(err); ok {
+		t.Fatal("retry delay without RetryInfo")
+	}
+}
+
+func TestFrom(t *testing.T) {
+	base := Newf(codes.ResourceExhausted, "limit of %d exceeded", 10).RetryAfter(2*time.Second).QuotaViolation("per-ip", "too many requests").Err()
+	err := From(base).QuotaViolation("per-user", "too many requests").Err()
+
+	if want, have := "limit of 10 exceeded", status.Convert(err).Message(); want != have {
+		t.Fatalf("message: want %q, have %q", want, have)
+	}
+	d, ok := RetryDelay(err)
+	if !ok || d != 2*time.Second {
+		t.Fatalf("retry delay: want 2s, have %v, %v", d, ok)
+	}
+	violations := QuotaViolations(err)
+	if want, have := 2, len(violations); want != have {
+		t.Fatalf("quota violations: want %d in one detail, have %d", want, have)
+	}
+	if want, have := 2, len(status.Convert(err).Details()); want != have {
+		t.Fatalf("details: want %d, have %d", want, have)
+	}
+}
+
+func TestOK(t *testing.T) {
+	if err := New(codes.OK, "").RetryAfter(time.Second).Err(); err != nil {
+		t.Fatalf("OK: want nil, have %v", err)
+	}
+}
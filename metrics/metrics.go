@@ -0,0 +1,168 @@
+// Package metrics is the shared metrics hook used by middlewares in grpctools.
+//
+// Middlewares only depend on the small interfaces defined here. Users plug a
+// concrete backend (Prometheus, StatsD, expvar, ...) in by implementing
+// Provider; when no Provider is configured, Discard is used.
+package metrics
+
+import "sync"
+
+// Counter is a monotonically increasing value.
+type Counter interface {
+	// With returns a Counter bound to the given label values.
+	With(labelValues ...string) Counter
+	Add(delta float64)
+}
+
+// Gauge is a value that can go up and down.
+type Gauge interface {
+	// With returns a Gauge bound to the given label values.
+	With(labelValues ...string) Gauge
+	Set(value float64)
+	Add(delta float64)
+}
+
+// Histogram records observations into buckets.
+type Histogram interface {
+	// With returns a Histogram bound to the given label values.
+	With(labelValues ...string) Histogram
+	Observe(value float64)
+}
+
+// Provider creates metric instruments. Label names passed to the constructors
+// match, in order, the label values later passed to With.
+type Provider interface {
+	NewCounter(name, help string, labelNames ...string) Counter
+	NewGauge(name, help string, labelNames ...string) Gauge
+	NewHistogram(name, help string, labelNames ...string) Histogram
+}
+
+// Discard is a Provider whose instruments drop every value.
+var Discard Provider = discard{}
+
+type discard struct{}
+
+func (discard) NewCounter(string, string, ...string) Counter     { return discardCounter{} }
+func (discard) NewGauge(string, string, ...string) Gauge         { return discardGauge{} }
+func (discard) NewHistogram(string, string, ...string) Histogram { return discardHistogram{} }
+
+type discardCounter struct{}
+
+func (c discardCounter) With(...string) Counter { return c }
+func (discardCounter) Add(float64)              {}
+
+type discardGauge struct{}
+
+func (g discardGauge) With(...string) Gauge { return g }
+func (discardGauge) Set(float64)            {}
+func (discardGauge) Add(float64)            {}
+
+type discardHistogram struct{}
+
+func (h discardHistogram) With(...string) Histogram { return h }
+func (discardHistogram) Observe(float64)            {}
+
+// Overflow is the label value replacing the values over the limit of
+// LimitLabelValues.
+const Overflow = "other"
+
+// LimitLabelValues returns a Provider whose instruments record at most n
+// distinct values of each label, the first seen, and Overflow for the others,
+// so labels taken from requests, like clients or tenants, can't blow up the
+// number of series of p.
+func LimitLabelValues(p Provider, n int) Provider {
+	return limited{p, n}
+}
+
+type limited struct {
+	p Provider
+	n int
+}
+
+func (l limited) NewCounter(name, help string, labelNames ...string) Counter {
+	return limitedCounter{l.p.NewCounter(name, help, labelNames...), newLimiter(l.n, len(labelNames)), 0}
+}
+
+func (l limited) NewGauge(name, help string, labelNames ...string) Gauge {
+	return limitedGauge{l.p.NewGauge(name, help, labelNames...), newLimiter(l.n, len(labelNames)), 0}
+}
+
+func (l limited) NewHistogram(name, help string, labelNames ...string) Histogram {
+	return limitedHistogram{l.p.NewHistogram(name, help, labelNames...), newLimiter(l.n, len(labelNames)), 0}
+}
+
+// limiter tracks the values seen of each label of an instrument.
+type limiter struct {
+	n    int
+	mu   sync.Mutex
+	seen []map[string]bool
+}
+
+func newLimiter(n, labels int) *limiter {
+	l := &limiter{n: n, seen: make([]map[string]bool, labels)}
+	for i := range l.seen {
+		l.seen[i] = make(map[string]bool)
+	}
+	return l
+}
+
+// values returns values, labels from offset on, with those over the limit
+// replaced by Overflow.
+func (l *limiter) values(offset int, values []string) []string {
+	out := make([]string, len(values))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, v := range values {
+		out[i] = v
+		if offset+i >= len(l.seen) {
+			continue
+		}
+		seen := l.seen[offset+i]
+		if !seen[v] {
+			if len(seen) >= l.n {
+				out[i] = Overflow
+				continue
+			}
+			seen[v] = true
+		}
+	}
+	return out
+}
+
+type limitedCounter struct {
+	c      Counter
+	l      *limiter
+	offset int
+}
+
+func (c limitedCounter) With(labelValues ...string) Counter {
+	return limitedCounter{c.c.With(c.l.values(c.offset, labelValues)...), c.l, c.offset + len(labelValues)}
+}
+
+func (c limitedCounter) Add(delta float64) { c.c.Add(delta) }
+
+type limitedGauge struct {
+	g      Gauge
+	l      *limiter
+	offset int
+}
+
+func (g limitedGauge) With(labelValues ...string) Gauge {
+	return limitedGauge{g.g.With(g.l.values(g.offset, labelValues)...), g.l, g.offset + len(labelValues)}
+}
+
+func (g limitedGauge) Set(value float64) { g.g.Set(value) }
+
+func (g limitedGauge) Add(delta float64) { g.g.Add(delta) }
+
+type limitedHistogram struct {
+	h      Histogram
+	l      *limiter
+	offset int
+}
+
+func (h limitedHistogram) With(labelValues ...string) Histogram {
+	return limitedHistogram{h.h.With(h.l.values(h.offset, labelValues)...), h.l, h.offset + len(labelValues)}
+}
+
+func (h limitedHistogram) Observe(value float64) { h.h.Observe(value) }
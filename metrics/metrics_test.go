@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// recording is a Provider recording the label values of counters.
+type recording struct {
+	values map[string]float64
+}
+
+func (r *recording) NewCounter(name, help string, labelNames ...string) Counter {
+	return recordingCounter{r, nil}
+}
+func (r *recording) NewGauge(string, string, ...string) Gauge         { return discardGauge{} }
+func (r *recording) NewHistogram(string, string, ...string) Histogram { return discardHistogram{} }
+
+type recordingCounter struct {
+	r      *recording
+	values []string
+}
+
+func (c recordingCounter) With(labelValues ...string) Counter {
+	return recordingCounter{c.r, append(append([]string(nil), c.values...), labelValues...)}
+}
+
+func (c recordingCounter) Add(delta float64) { c.r.values[fmt.Sprint(c.values)] += delta }
+
+func TestLimitLabelValues(t *testing.T) {
+	r := &recording{values: map[string]float64{}}
+	c := LimitLabelValues(r, 2).NewCounter("requests_total", "", "client", "code")
+	for _, client := range []string{"ios", "android", "ios", "scanner", "curl"} {
+		c.With(client).With("OK").Add(1)
+	}
+
+	var have []string
+	for k, v := range r.values {
+		have = append(have, fmt.Sprintf("%s=%v", k, v))
+	}
+	sort.Strings(have)
+	if want := "[[android OK]=1 [ios OK]=2 [other OK]=2]"; want != fmt.Sprint(have) {
+		t.Fatalf("want %s, have %v", want, have)
+	}
+}
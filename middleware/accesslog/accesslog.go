@@ -0,0 +1,234 @@
+// Package accesslog provides server interceptors writing an access log in
+// standard formats.
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ipfans/grpctools/middleware/logging"
+	"github.com/ipfans/grpctools/middleware/tenant"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Entry is a line of the access log.
+type Entry struct {
+	Time      time.Time     `json:"time"`
+	Method    string        `json:"method"`
+	Peer      string        `json:"peer"`
+	UserAgent string        `json:"user_agent,omitempty"`
+	Tenant    string        `json:"tenant,omitempty"`
+	Code      string        `json:"code"`
+	Duration  time.Duration `json:"duration_ns"`
+	// RequestBytes and ResponseBytes are the encoded sizes of unary messages.
+	RequestBytes  int `json:"request_bytes"`
+	ResponseBytes int `json:"response_bytes"`
+	// Request is the redacted unary request, if WithPayloads is given.
+	Request json.RawMessage `json:"request,omitempty"`
+}
+
+// Sink writes access log entries.
+type Sink interface {
+	Write(e *Entry) error
+}
+
+// Formatter encodes an entry as a line, including the trailing newline.
+type Formatter func(e *Entry) []byte
+
+// JSON formats entries as JSON lines.
+func JSON(e *Entry) []byte {
+	b, _ := json.Marshal(e)
+	return append(b, '\n')
+}
+
+// Combined formats entries like the Combined Log Format of web servers, with
+// the gRPC code name in place of the HTTP status and the duration appended:
+//
+//	10.0.0.1:5000 - - [02/Jan/2006:15:04:05 -0700] "POST /foo.v1.UserService/Get HTTP/2" OK 42 "-" "grpc-go/1.29.1" 0.001234
+func Combined(e *Entry) []byte {
+	var buf bytes.Buffer
+	ua := e.UserAgent
+	if ua == "" {
+		ua = "-"
+	}
+	fmt.Fprintf(&buf, "%s - - [%s] \"POST %s HTTP/2\" %s %d \"-\" %q %.6f\n",
+		e.Peer, e.Time.Format("02/Jan/2006:15:04:05 -0700"), e.Method, e.Code, e.ResponseBytes, ua, e.Duration.Seconds())
+	return buf.Bytes()
+}
+
+// NewWriterSink returns a Sink writing entries formatted by f to w, a line
+// at a time.
+func NewWriterSink(w io.Writer, f Formatter) Sink {
+	return &writerSink{w: w, format: f}
+}
+
+type writerSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format Formatter
+}
+
+func (s *writerSink) Write(e *Entry) error {
+	line := s.format(e)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.w.Write(line)
+	return err
+}
+
+// File is an append-only log file which can be reopened after it was rotated,
+// e.g. by logrotate, by calling Reopen on SIGHUP.
+type File struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenFile opens the log file at path, creating it if needed.
+func OpenFile(path string) (*File, error) {
+	f := &File{path: path}
+	if err := f.Reopen(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reopen closes the file and opens path again.
+func (f *File) Reopen() error {
+	nf, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	old := f.f
+	f.f = nf
+	f.mu.Unlock()
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+// Write appends p to the file.
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.f.Write(p)
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.f.Close()
+}
+
+type options struct {
+	sink     Sink
+	logger   grpclog.LoggerV2
+	payloads *logging.Redactor
+}
+
+// Option for accesslog interceptors.
+type Option func(o *options)
+
+// WithSink sets where entries are written. Default writes JSON lines to
+// stdout.
+func WithSink(s Sink) Option {
+	return func(o *options) {
+		o.sink = s
+	}
+}
+
+// WithLogger replaced built-in logger, reporting sink errors, to given.
+func WithLogger(logger grpclog.LoggerV2) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithPayloads adds unary requests to entries as JSON, with sensitive fields
+// masked by r. Requests are not logged by default.
+func WithPayloads(r *logging.Redactor) Option {
+	return func(o *options) {
+		o.payloads = r
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		sink:   NewWriterSink(os.Stdout, JSON),
+		logger: grpclog.NewLoggerV2(os.Stdout, os.Stdout, os.Stderr),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func (o options) write(ctx context.Context, method string, start time.Time, err error, req, resp interface{}) {
+	e := &Entry{
+		Time:     start,
+		Method:   method,
+		Peer:     "-",
+		Code:     status.Code(err).String(),
+		Duration: time.Since(start),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		e.Peer = p.Addr.String()
+	}
+	e.Tenant = tenant.ID(ctx)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ua := md.Get("user-agent"); len(ua) > 0 {
+			e.UserAgent = ua[0]
+		}
+	}
+	if m, ok := req.(proto.Message); ok {
+		e.RequestBytes = proto.Size(m)
+		if o.payloads != nil {
+			e.Request = o.payloads.JSON(m)
+		}
+	}
+	if m, ok := resp.(proto.Message); ok && err == nil {
+		e.ResponseBytes = proto.Size(m)
+	}
+	if werr := o.sink.Write(e); werr != nil {
+		o.logger.Errorf("middleware/accesslog: writing entry: %v", werr)
+	}
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor writing an
+// entry per RPC.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		o.write(ctx, info.FullMethod, start, err, req, resp)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor writing
+// an entry per stream once it ends.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, stream)
+		o.write(stream.Context(), info.FullMethod, start, err, nil, nil)
+		return err
+	}
+}
@@ -0,0 +1,95 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/ipfans/grpctools/middleware/logging"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	var buf bytes.Buffer
+	interceptor := UnaryServerInterceptor(WithSink(NewWriterSink(&buf, JSON)), WithPayloads(logging.NewRedactor("value")))
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5000}})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("user-agent", "grpc-go/1.29.1"))
+	interceptor(ctx, &wrappers.StringValue{Value: "abc"}, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "not found")
+	})
+
+	var e Entry
+	if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+		t.Fatalf("%v: %s", err, buf.Bytes())
+	}
+	for _, tc := range []struct {
+		field      string
+		want, have interface{}
+	}{
+		{"method", "/test.Service/Get", e.Method},
+		{"peer", "10.0.0.1:5000", e.Peer},
+		{"user agent", "grpc-go/1.29.1", e.UserAgent},
+		{"code", "NotFound", e.Code},
+		{"request bytes", 5, e.RequestBytes},
+		{"request", `"[REDACTED]"`, string(e.Request)},
+	} {
+		if tc.want != tc.have {
+			t.Errorf("%s: want %v, have %v", tc.field, tc.want, tc.have)
+		}
+	}
+}
+
+func TestCombined(t *testing.T) {
+	e := &Entry{
+		Time:          time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC),
+		Method:        "/test.Service/Get",
+		Peer:          "10.0.0.1:5000",
+		Code:          "OK",
+		Duration:      1500 * time.Microsecond,
+		ResponseBytes: 42,
+	}
+	want := `10.0.0.1:5000 - - [01/Mar/2017:12:00:00 +0000] "POST /test.Service/Get HTTP/2" OK 42 "-" "-" 0.001500` + "\n"
+	if have := string(Combined(e)); want != have {
+		t.Fatalf("want %q, have %q", want, have)
+	}
+}
+
+func TestFileReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accesslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+	f, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	f.Write([]byte("first\n"))
+	os.Rename(path, path+".1")
+	if err := f.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("second\n"))
+
+	for name, want := range map[string]string{path + ".1": "first", path: "second"} {
+		b, _ := ioutil.ReadFile(name)
+		if have := strings.TrimSpace(string(b)); want != have {
+			t.Errorf("%s: want %q, have %q", name, want, have)
+		}
+	}
+}
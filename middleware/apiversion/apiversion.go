@@ -0,0 +1,266 @@
+// Package apiversion provides interceptors exchanging the API version of
+// clients and servers through metadata, so servers can reject clients too old
+// to be served and handlers can adapt to the version negotiated.
+package apiversion
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	grpcerrors "github.com/ipfans/grpctools/errors"
+	"github.com/ipfans/grpctools/middleware"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// MetadataKey is the metadata key carrying the API version of clients in
+// requests, and of servers in response headers.
+const MetadataKey = "x-api-version"
+
+// ViolationType is the type of the PreconditionFailure violation of clients
+// too old, whose description is the minimum version.
+const ViolationType = "API_VERSION"
+
+// Version is a major.minor.patch version.
+type Version struct {
+	Major, Minor, Patch int
+}
+
+// Parse parses a version like "1.4.2", "v1.4" or "2", missing parts being
+// zero.
+func Parse(s string) (Version, error) {
+	var v Version
+	parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(s), "v"), ".")
+	if len(parts) > 3 {
+		return v, fmt.Errorf("apiversion: invalid version %q", s)
+	}
+	fields := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return Version{}, fmt.Errorf("apiversion: invalid version %q", s)
+		}
+		*fields[i] = n
+	}
+	return v, nil
+}
+
+// MustParse is like Parse but panics on invalid versions, for initializing
+// variables.
+func MustParse(s string) Version {
+	v, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Less reports whether v is older than w.
+func (v Version) Less(w Version) bool {
+	if v.Major != w.Major {
+		return v.Major < w.Major
+	}
+	if v.Minor != w.Minor {
+		return v.Minor < w.Minor
+	}
+	return v.Patch < w.Patch
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the negotiated version v.
+func NewContext(ctx context.Context, v Version) context.Context {
+	return context.WithValue(ctx, contextKey{}, v)
+}
+
+// FromContext returns the version negotiated for a request, false if the
+// client didn't send one.
+func FromContext(ctx context.Context) (Version, bool) {
+	v, ok := ctx.Value(contextKey{}).(Version)
+	return v, ok
+}
+
+// MinimumVersion returns the minimum version of the FailedPrecondition
+// error a server rejected a client too old with, false if err isn't one.
+func MinimumVersion(err error) (Version, bool) {
+	if status.Code(err) != codes.FailedPrecondition {
+		return Version{}, false
+	}
+	for _, d := range status.Convert(err).Details() {
+		pf, ok := d.(*errdetails.PreconditionFailure)
+		if !ok {
+			continue
+		}
+		for _, v := range pf.Violations {
+			if v.Type != ViolationType {
+				continue
+			}
+			if min, err := Parse(v.Description); err == nil {
+				return min, true
+			}
+		}
+	}
+	return Version{}, false
+}
+
+type options struct {
+	current  *Version
+	minimum  *Version
+	minimums map[string]Version
+	required bool
+}
+
+// Option for apiversion server interceptors.
+type Option func(o *options)
+
+// WithCurrent sets the version of the server, sent to clients in response
+// headers. Requests are negotiated down to it.
+func WithCurrent(v Version) Option {
+	return func(o *options) {
+		o.current = &v
+	}
+}
+
+// WithMinimum sets the oldest client version served for methods without a
+// method minimum.
+func WithMinimum(v Version) Option {
+	return func(o *options) {
+		o.minimum = &v
+	}
+}
+
+// WithMethodMinimums sets the oldest client versions served per full method
+// name, like "/foo.v1.UserService/Get", or per service, like
+// "foo.v1.UserService", the most specific winning.
+func WithMethodMinimums(minimums map[string]Version) Option {
+	return func(o *options) {
+		o.minimums = minimums
+	}
+}
+
+// WithRequired rejects requests without a version with FailedPrecondition.
+// By default they are served without a negotiated version.
+func WithRequired() Option {
+	return func(o *options) {
+		o.required = true
+	}
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func (o options) minimumOf(method string) (Version, bool) {
+	if v, ok := o.minimums[method]; ok {
+		return v, true
+	}
+	if v, ok := o.minimums[path.Dir(method)[1:]]; ok {
+		return v, true
+	}
+	if o.minimum != nil {
+		return *o.minimum, true
+	}
+	return Version{}, false
+}
+
+func tooOld(min Version, message string) error {
+	return grpcerrors.New(codes.FailedPrecondition, message).
+		Detail(&errdetails.PreconditionFailure{Violations: []*errdetails.PreconditionFailure_Violation{{
+			Type:        ViolationType,
+			Subject:     MetadataKey,
+			Description: min.String(),
+		}}}).
+		Err()
+}
+
+// negotiate returns ctx with the negotiated version of the request, or an
+// error if its client is too old.
+func (o options) negotiate(ctx context.Context, method string) (context.Context, error) {
+	if o.current != nil {
+		grpc.SetHeader(ctx, metadata.Pairs(MetadataKey, o.current.String()))
+	}
+	min, hasMin := o.minimumOf(method)
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(MetadataKey)
+	if len(values) == 0 {
+		switch {
+		case o.required && hasMin:
+			return nil, tooOld(min, "apiversion: missing client version")
+		case o.required:
+			return nil, status.Error(codes.FailedPrecondition, "apiversion: missing client version")
+		}
+		return ctx, nil
+	}
+	v, err := Parse(values[0])
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if hasMin && v.Less(min) {
+		return nil, tooOld(min, fmt.Sprintf("apiversion: client version %s is older than the minimum %s, upgrade the client", v, min))
+	}
+	if o.current != nil && o.current.Less(v) {
+		v = *o.current
+	}
+	return NewContext(ctx, v), nil
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor rejecting
+// clients older than the minimum version with FailedPrecondition and a
+// PreconditionFailure detail holding the minimum, and storing the negotiated
+// version, the older of the client and the server ones, in the context.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := o.negotiate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor rejecting
+// clients older than the minimum version and storing the negotiated version
+// in the context, like UnaryServerInterceptor.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := o.negotiate(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		wrapped := middleware.WrapServerStream(stream)
+		wrapped.SetContext(ctx)
+		return handler(srv, wrapped)
+	}
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor sending the
+// client version v with requests.
+func UnaryClientInterceptor(v Version) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(metadata.AppendToOutgoingContext(ctx, MetadataKey, v.String()), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor returns a new streaming client interceptor sending
+// the client version v with streams.
+func StreamClientInterceptor(v Version) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(metadata.AppendToOutgoingContext(ctx, MetadataKey, v.String()), desc, cc, method, opts...)
+	}
+}
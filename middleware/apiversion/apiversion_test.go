@@ -0,0 +1,128 @@
+package apiversion
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		s   string
+		v   Version
+		err bool
+	}{
+		{"1.4.2", Version{1, 4, 2}, false},
+		{"v1.4", Version{1, 4, 0}, false},
+		{"2", Version{2, 0, 0}, false},
+		{"1.x", Version{}, true},
+		{"1.2.3.4", Version{}, true},
+		{"", Version{}, true},
+	} {
+		v, err := Parse(tc.s)
+		if want, have := tc.err, err != nil; want != have {
+			t.Errorf("%q: error: want %v, have %v", tc.s, want, err)
+			continue
+		}
+		if want, have := tc.v, v; want != have {
+			t.Errorf("%q: want %v, have %v", tc.s, want, have)
+		}
+	}
+	if !MustParse("1.9.9").Less(MustParse("1.10")) {
+		t.Fatal("1.9.9 not less than 1.10.0")
+	}
+}
+
+// headerStream records the header set by interceptors.
+type headerStream struct {
+	header metadata.MD
+}
+
+func (s *headerStream) Method() string { return "/foo.v1.UserService/Get" }
+func (s *headerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+func (s *headerStream) SendHeader(md metadata.MD) error { return nil }
+func (s *headerStream) SetTrailer(md metadata.MD) error { return nil }
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := UnaryServerInterceptor(
+		WithCurrent(MustParse("2.3.0")),
+		WithMinimum(MustParse("1.2.0")),
+		WithMethodMinimums(map[string]Version{"foo.v1.BillingService": MustParse("2.0")}),
+	)
+	var negotiated string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		negotiated = ""
+		if v, ok := FromContext(ctx); ok {
+			negotiated = v.String()
+		}
+		return nil, nil
+	}
+
+	for _, tc := range []struct {
+		name       string
+		version    string
+		method     string
+		code       codes.Code
+		negotiated string
+		minimum    string
+	}{
+		{"current", "1.5.0", "/foo.v1.UserService/Get", codes.OK, "1.5.0", ""},
+		{"newer", "3.0.0", "/foo.v1.UserService/Get", codes.OK, "2.3.0", ""},
+		{"missing", "", "/foo.v1.UserService/Get", codes.OK, "", ""},
+		{"too old", "1.1.9", "/foo.v1.UserService/Get", codes.FailedPrecondition, "", "1.2.0"},
+		{"service minimum", "1.5.0", "/foo.v1.BillingService/Charge", codes.FailedPrecondition, "", "2.0.0"},
+		{"invalid", "latest", "/foo.v1.UserService/Get", codes.InvalidArgument, "", ""},
+	} {
+		stream := &headerStream{}
+		ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+		if tc.version != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(MetadataKey, tc.version))
+		}
+		negotiated = ""
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tc.method}, handler)
+		if want, have := tc.code, status.Code(err); want != have {
+			t.Errorf("%s: code: want %v, have %v", tc.name, want, have)
+			continue
+		}
+		if want, have := tc.negotiated, negotiated; want != have {
+			t.Errorf("%s: negotiated: want %q, have %q", tc.name, want, have)
+		}
+		if min, ok := MinimumVersion(err); ok != (tc.minimum != "") || ok && min.String() != tc.minimum {
+			t.Errorf("%s: minimum: want %q, have %v", tc.name, tc.minimum, min)
+		}
+		if want, have := "2.3.0", stream.header.Get(MetadataKey); len(have) != 1 || want != have[0] {
+			t.Errorf("%s: header: want %q, have %v", tc.name, want, have)
+		}
+	}
+}
+
+func TestRequired(t *testing.T) {
+	interceptor := UnaryServerInterceptor(WithRequired())
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/foo.v1.UserService/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	if want, have := codes.FailedPrecondition, status.Code(err); want != have {
+		t.Fatalf("want %v, have %v", want, have)
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	interceptor := UnaryClientInterceptor(MustParse("1.4"))
+	err := interceptor(context.Background(), "/foo.v1.UserService/Get", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		if want, have := "1.4.0", md.Get(MetadataKey); len(have) != 1 || want != have[0] {
+			t.Fatalf("metadata: want %q, have %v", want, have)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,238 @@
+// Package audit provides server interceptors recording who did what, and the
+// outcome, to pluggable sinks.
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ipfans/grpctools/middleware/authz"
+	"github.com/ipfans/grpctools/middleware/logging"
+	"github.com/ipfans/grpctools/middleware/requestid"
+	"github.com/ipfans/grpctools/middleware/tenant"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Event is an audit record of a call.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Principal string    `json:"principal,omitempty"`
+	Roles     []string  `json:"roles,omitempty"`
+	Method    string    `json:"method"`
+	Resources []string  `json:"resources,omitempty"`
+	Code      string    `json:"code"`
+	Error     string    `json:"error,omitempty"`
+	Peer      string    `json:"peer,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Tenant    string    `json:"tenant,omitempty"`
+	// Request is the redacted unary request, if WithPayloads is given.
+	Request json.RawMessage `json:"request,omitempty"`
+}
+
+// ResourceFunc returns the identifiers of the resources a request acts on,
+// like "users/42".
+type ResourceFunc func(req interface{}) []string
+
+type options struct {
+	principal authz.PrincipalFunc
+	resources map[string]ResourceFunc
+	retries   int
+	backoff   time.Duration
+	queueSize int
+	logger    grpclog.LoggerV2
+	payloads  *logging.Redactor
+}
+
+// Option for Auditor instance.
+type Option func(o *options)
+
+// WithPrincipalFunc sets the function returning the principal of requests.
+// Default is authz.DefaultPrincipal.
+func WithPrincipalFunc(f authz.PrincipalFunc) Option {
+	return func(o *options) {
+		o.principal = f
+	}
+}
+
+// WithResources sets the functions extracting resource identifiers from
+// unary requests, per full method name.
+func WithResources(resources map[string]ResourceFunc) Option {
+	return func(o *options) {
+		o.resources = resources
+	}
+}
+
+// WithRetries sets how many times delivery of an event failing is retried
+// before it is logged and dropped. Default is 3.
+func WithRetries(n int) Option {
+	return func(o *options) {
+		o.retries = n
+	}
+}
+
+// WithBackoff sets the delay before the first retry, doubled after each.
+// Default is 100ms.
+func WithBackoff(d time.Duration) Option {
+	return func(o *options) {
+		o.backoff = d
+	}
+}
+
+// WithQueueSize sets the number of events waiting for delivery above which
+// events are logged and dropped. Default is 1024.
+func WithQueueSize(n int) Option {
+	return func(o *options) {
+		o.queueSize = n
+	}
+}
+
+// WithPayloads adds unary requests to events as JSON, with sensitive fields
+// masked by r. Requests are not recorded by default.
+func WithPayloads(r *logging.Redactor) Option {
+	return func(o *options) {
+		o.payloads = r
+	}
+}
+
+// WithLogger replaced built-in logger to given.
+func WithLogger(logger grpclog.LoggerV2) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// Auditor delivers audit events to a sink in the background, so a slow sink
+// doesn't delay calls.
+type Auditor struct {
+	sink   Sink
+	opts   options
+	events chan *Event
+	wg     sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// New returns an Auditor writing to sink. Close flushes the pending events.
+func New(sink Sink, opts ...Option) *Auditor {
+	o := options{
+		principal: authz.DefaultPrincipal,
+		retries:   3,
+		backoff:   100 * time.Millisecond,
+		queueSize: 1024,
+		logger:    grpclog.NewLoggerV2(os.Stdout, os.Stdout, os.Stderr),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	a := &Auditor{
+		sink:   sink,
+		opts:   o,
+		events: make(chan *Event, o.queueSize),
+	}
+	a.wg.Add(1)
+	go a.run()
+	return a
+}
+
+func (a *Auditor) run() {
+	defer a.wg.Done()
+	for e := range a.events {
+		a.deliver(e)
+	}
+}
+
+// deliver writes e to the sink, retrying failures.
+func (a *Auditor) deliver(e *Event) {
+	backoff := a.opts.backoff
+	for attempt := 0; ; attempt++ {
+		err := a.sink.Write(e)
+		if err == nil {
+			return
+		}
+		if attempt >= a.opts.retries {
+			a.opts.logger.Errorf("middleware/audit: dropping event of %s by %q: %v", e.Method, e.Principal, err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Close stops the Auditor after delivering the pending events. Events of
+// calls ending afterwards are logged and dropped.
+func (a *Auditor) Close() error {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.events)
+	}
+	a.mu.Unlock()
+	a.wg.Wait()
+	return nil
+}
+
+// record queues the event of a call to method.
+func (a *Auditor) record(ctx context.Context, method string, req interface{}, err error) {
+	e := &Event{
+		Time:   time.Now(),
+		Method: method,
+		Code:   status.Code(err).String(),
+	}
+	if p, ok := a.opts.principal(ctx); ok {
+		e.Principal, e.Roles = p.Name, p.Roles
+	}
+	if f, ok := a.opts.resources[method]; ok && req != nil {
+		e.Resources = f(req)
+	}
+	if a.opts.payloads != nil {
+		e.Request = a.opts.payloads.JSON(req)
+	}
+	if err != nil {
+		e.Error = status.Convert(err).Message()
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		e.Peer = p.Addr.String()
+	}
+	if id, ok := requestid.FromContext(ctx); ok {
+		e.RequestID = id
+	}
+	e.Tenant = tenant.ID(ctx)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		a.opts.logger.Errorf("middleware/audit: auditor closed, dropping event of %s by %q", e.Method, e.Principal)
+		return
+	}
+	select {
+	case a.events <- e:
+	default:
+		a.opts.logger.Errorf("middleware/audit: queue full, dropping event of %s by %q", e.Method, e.Principal)
+	}
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor recording an
+// event for every call.
+func (a *Auditor) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		a.record(ctx, info.FullMethod, req, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor
+// recording an event for every call. Streams have no resources.
+func (a *Auditor) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := handler(srv, stream)
+		a.record(stream.Context(), info.FullMethod, nil, err)
+		return err
+	}
+}
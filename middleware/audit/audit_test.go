@@ -0,0 +1,124 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfans/grpctools/middleware/authz"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// recorder is a sink recording events, failing the first fails writes.
+type recorder struct {
+	mu     sync.Mutex
+	fails  int
+	events []*Event
+}
+
+func (r *recorder) Write(e *Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fails > 0 {
+		r.fails--
+		return errors.New("unavailable")
+	}
+	r.events = append(r.events, e)
+	return nil
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	sink := &recorder{fails: 2}
+	a := New(sink,
+		WithBackoff(time.Millisecond),
+		WithPrincipalFunc(func(ctx context.Context) (*authz.Principal, bool) {
+			return &authz.Principal{Name: "alice", Roles: []string{"admin"}}, true
+		}),
+		WithResources(map[string]ResourceFunc{
+			"/test.Service/Delete": func(req interface{}) []string { return []string{"users/" + req.(string)} },
+		}),
+	)
+	interceptor := a.UnaryServerInterceptor()
+	interceptor(context.Background(), "42", &grpc.UnaryServerInfo{FullMethod: "/test.Service/Delete"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "no such user")
+	})
+	a.Close()
+
+	if want, have := 1, len(sink.events); want != have {
+		t.Fatalf("events: want %d, have %d", want, have)
+	}
+	e := sink.events[0]
+	e.Time = time.Time{}
+	want := &Event{
+		Principal: "alice",
+		Roles:     []string{"admin"},
+		Method:    "/test.Service/Delete",
+		Resources: []string{"users/42"},
+		Code:      "NotFound",
+		Error:     "no such user",
+	}
+	if !reflect.DeepEqual(want, e) {
+		t.Fatalf("want %+v, have %+v", want, e)
+	}
+}
+
+func TestDeliveryFailure(t *testing.T) {
+	sink := &recorder{fails: 10}
+	a := New(sink, WithRetries(1), WithBackoff(time.Millisecond))
+	a.UnaryServerInterceptor()(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	a.Close()
+	if want, have := 8, sink.fails; want != have {
+		t.Fatalf("remaining failures: want %d, have %d", want, have)
+	}
+}
+
+func TestRecordAfterClose(t *testing.T) {
+	sink := &recorder{}
+	a := New(sink, WithLogger(grpclog.NewLoggerV2(ioutil.Discard, ioutil.Discard, ioutil.Discard)))
+	a.Close()
+	// Calls still in flight at shutdown must not panic.
+	_, err := a.UnaryServerInterceptor()(peer.NewContext(context.Background(), &peer.Peer{}), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 0, len(sink.events); want != have {
+		t.Fatalf("events: want %d, have %d", want, have)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var got Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		if got.Method == "/test.Service/Fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+	sink := NewWebhookSink(srv.URL, nil, time.Second)
+
+	if err := sink.Write(&Event{Method: "/test.Service/Get", Principal: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "alice", got.Principal; want != have {
+		t.Fatalf("principal: want %q, have %q", want, have)
+	}
+	if err := sink.Write(&Event{Method: "/test.Service/Fail"}); err == nil {
+		t.Fatal("bad gateway: want error")
+	}
+}
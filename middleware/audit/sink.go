@@ -0,0 +1,95 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+// Sink writes audit events. Writes are made by a single goroutine.
+type Sink interface {
+	Write(e *Event) error
+}
+
+// SinkFunc is an adapter to use ordinary functions as Sink.
+type SinkFunc func(e *Event) error
+
+// Write calls f(e).
+func (f SinkFunc) Write(e *Event) error {
+	return f(e)
+}
+
+// NewWriterSink returns a Sink writing events to w as JSON lines, e.g. to an
+// accesslog.File.
+func NewWriterSink(w io.Writer) Sink {
+	return &writerSink{w: w}
+}
+
+type writerSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *writerSink) Write(e *Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(b, '\n'))
+	return err
+}
+
+// NewWebhookSink returns a Sink POSTing events as JSON to url, failing on
+// non-2xx responses. A nil client uses http.DefaultClient.
+func NewWebhookSink(url string, client *http.Client, timeout time.Duration) Sink {
+	return SinkFunc(func(e *Event) error {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		ctx := context.Background()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		resp, err := ctxhttp.Post(ctx, client, url, "application/json", bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(ioutil.Discard, resp.Body)
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("middleware/audit: webhook %s: %s", url, resp.Status)
+		}
+		return nil
+	})
+}
+
+// Producer publishes messages to a Kafka topic, implemented by a thin wrapper
+// of the client of choice, e.g. a sarama.SyncProducer.
+type Producer interface {
+	Produce(topic string, key, value []byte) error
+}
+
+// NewKafkaSink returns a Sink publishing events as JSON to topic, keyed by
+// principal so the events of a principal stay ordered.
+func NewKafkaSink(p Producer, topic string) Sink {
+	return SinkFunc(func(e *Event) error {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return p.Produce(topic, []byte(e.Principal), b)
+	})
+}
@@ -0,0 +1,175 @@
+// Package apikey provides server interceptors authenticating requests with
+// API keys.
+package apikey
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+
+	"github.com/ipfans/grpctools/middleware"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultMetadataKey is the metadata key carrying the API key by default.
+const DefaultMetadataKey = "x-api-key"
+
+// ErrUnknownKey is returned by a Store for keys it doesn't know.
+var ErrUnknownKey = errors.New("unknown API key")
+
+// Key describes the holder of an API key.
+type Key struct {
+	// ID identifies the key without revealing it, e.g. in logs.
+	ID    string
+	Owner string
+	Tier  string
+	// Metadata holds any other attributes of the key.
+	Metadata map[string]string
+}
+
+// Store looks up API keys, returning ErrUnknownKey for unknown ones.
+type Store interface {
+	Lookup(ctx context.Context, key string) (*Key, error)
+}
+
+// StoreFunc is an adapter to use a function as a Store.
+type StoreFunc func(ctx context.Context, key string) (*Key, error)
+
+// Lookup calls f.
+func (f StoreFunc) Lookup(ctx context.Context, key string) (*Key, error) {
+	return f(ctx, key)
+}
+
+// Static returns a Store of fixed keys. Keys are compared in constant time,
+// so lookups don't leak how much of a key was guessed right.
+func Static(keys map[string]Key) Store {
+	s := make(staticStore, 0, len(keys))
+	for secret, k := range keys {
+		k := k
+		s = append(s, staticKey{digest: sha256.Sum256([]byte(secret)), key: &k})
+	}
+	return s
+}
+
+type staticKey struct {
+	digest [sha256.Size]byte
+	key    *Key
+}
+
+type staticStore []staticKey
+
+func (s staticStore) Lookup(ctx context.Context, key string) (*Key, error) {
+	digest := sha256.Sum256([]byte(key))
+	var found *Key
+	// Compare every key so the time taken doesn't depend on which matched.
+	for i := range s {
+		if subtle.ConstantTimeCompare(digest[:], s[i].digest[:]) == 1 {
+			found = s[i].key
+		}
+	}
+	if found == nil {
+		return nil, ErrUnknownKey
+	}
+	return found, nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying key.
+func NewContext(ctx context.Context, key *Key) context.Context {
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+// FromContext returns the key a request was authenticated with, false if it
+// has none.
+func FromContext(ctx context.Context) (*Key, bool) {
+	key, ok := ctx.Value(contextKey{}).(*Key)
+	return key, ok
+}
+
+type options struct {
+	metadataKey string
+	exempt      map[string]bool
+}
+
+// Option for apikey interceptors.
+type Option func(o *options)
+
+// WithMetadataKey sets the metadata key carrying the API key. Default is
+// DefaultMetadataKey.
+func WithMetadataKey(key string) Option {
+	return func(o *options) {
+		o.metadataKey = key
+	}
+}
+
+// WithExemptMethods sets full method names served without an API key.
+func WithExemptMethods(methods ...string) Option {
+	return func(o *options) {
+		for _, m := range methods {
+			o.exempt[m] = true
+		}
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		metadataKey: DefaultMetadataKey,
+		exempt:      make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func authenticate(ctx context.Context, store Store, o options, method string) (context.Context, error) {
+	if o.exempt[method] {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	v := md.Get(o.metadataKey)
+	if len(v) == 0 || v[0] == "" {
+		return nil, status.Error(codes.Unauthenticated, "missing API key")
+	}
+	key, err := store.Lookup(ctx, v[0])
+	switch {
+	case err == ErrUnknownKey:
+		return nil, status.Error(codes.Unauthenticated, "invalid API key")
+	case err != nil:
+		return nil, status.Errorf(codes.Unavailable, "looking up API key: %v", err)
+	}
+	return NewContext(ctx, key), nil
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor rejecting
+// requests without a known API key with Unauthenticated.
+func UnaryServerInterceptor(store Store, opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, store, o, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor
+// rejecting requests without a known API key with Unauthenticated.
+func StreamServerInterceptor(store Store, opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(stream.Context(), store, o, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		wrapped := middleware.WrapServerStream(stream)
+		wrapped.SetContext(ctx)
+		return handler(srv, wrapped)
+	}
+}
@@ -0,0 +1,67 @@
+package apikey
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	store := Static(map[string]Key{
+		"secret-1": {ID: "k1", Owner: "alice", Tier: "gold"},
+		"secret-2": {ID: "k2", Owner: "bob", Tier: "free"},
+	})
+	interceptor := UnaryServerInterceptor(store, WithExemptMethods("/test.Service/Public"))
+	var owner string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		owner = ""
+		if key, ok := FromContext(ctx); ok {
+			owner = key.Owner
+		}
+		return nil, nil
+	}
+
+	for _, tc := range []struct {
+		method string
+		key    string
+		code   codes.Code
+		owner  string
+	}{
+		{"/test.Service/Get", "secret-1", codes.OK, "alice"},
+		{"/test.Service/Get", "secret-2", codes.OK, "bob"},
+		{"/test.Service/Get", "secret-3", codes.Unauthenticated, ""},
+		{"/test.Service/Get", "", codes.Unauthenticated, ""},
+		{"/test.Service/Public", "", codes.OK, ""},
+	} {
+		ctx := context.Background()
+		if tc.key != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(DefaultMetadataKey, tc.key))
+		}
+		owner = ""
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tc.method}, handler)
+		if want, have := tc.code, status.Code(err); want != have {
+			t.Errorf("%s %q: want %v, have %v", tc.method, tc.key, want, have)
+		}
+		if want, have := tc.owner, owner; want != have {
+			t.Errorf("%s %q: owner: want %q, have %q", tc.method, tc.key, want, have)
+		}
+	}
+}
+
+func TestStoreError(t *testing.T) {
+	store := StoreFunc(func(ctx context.Context, key string) (*Key, error) {
+		return nil, errors.New("database down")
+	})
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-custom-key", "secret"))
+	_, err := UnaryServerInterceptor(store, WithMetadataKey("x-custom-key"))(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	if want, have := codes.Unavailable, status.Code(err); want != have {
+		t.Fatalf("want %v, have %v", want, have)
+	}
+}
@@ -0,0 +1,235 @@
+// Package identity provides server interceptors mapping the claims of JWT
+// authenticated requests to a typed identity, so handlers, logs and traces
+// use the same user, organization and scopes without parsing claims.
+package identity
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ipfans/grpctools/middleware"
+	"github.com/ipfans/grpctools/middleware/auth/jwt"
+	"github.com/ipfans/grpctools/middleware/logging"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// Identity is who made a request.
+type Identity struct {
+	UserID string
+	OrgID  string
+	Scopes []string
+	// Extra holds the custom claims of Mapping.Extra by key.
+	Extra map[string]string
+}
+
+// Mapping names the claims an Identity is read from.
+type Mapping struct {
+	UserID string
+	OrgID  string
+	// Scopes is a space separated string claim, like "scope", or an array
+	// claim, like "scp".
+	Scopes string
+	// Extra maps keys of Identity.Extra to the claims they are read from.
+	Extra map[string]string
+}
+
+// DefaultMapping reads the user from "sub", the organization from "org_id"
+// and scopes from "scope".
+var DefaultMapping = Mapping{UserID: "sub", OrgID: "org_id", Scopes: "scope"}
+
+// Identity returns the identity claims carry.
+func (m Mapping) Identity(claims *jwt.Claims) *Identity {
+	id := &Identity{
+		UserID: str(claims.Raw[m.UserID]),
+		OrgID:  str(claims.Raw[m.OrgID]),
+	}
+	switch scopes := claims.Raw[m.Scopes].(type) {
+	case string:
+		id.Scopes = strings.Fields(scopes)
+	case []interface{}:
+		for _, s := range scopes {
+			id.Scopes = append(id.Scopes, str(s))
+		}
+	}
+	for key, claim := range m.Extra {
+		if v, ok := claims.Raw[claim]; ok {
+			if id.Extra == nil {
+				id.Extra = make(map[string]string)
+			}
+			id.Extra[key] = str(v)
+		}
+	}
+	return id
+}
+
+// str returns a claim value as a string, "" if it is missing.
+func str(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		// JSON numbers, like numeric user IDs.
+		return fmt.Sprint(int64(v))
+	}
+	return fmt.Sprint(v)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying id.
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the identity of a request, false if it has none.
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(contextKey{}).(*Identity)
+	return id, ok
+}
+
+// UserID returns the user of a request, or "" if it has none.
+func UserID(ctx context.Context) string {
+	if id, ok := FromContext(ctx); ok {
+		return id.UserID
+	}
+	return ""
+}
+
+// OrgID returns the organization of a request, or "" if it has none.
+func OrgID(ctx context.Context) string {
+	if id, ok := FromContext(ctx); ok {
+		return id.OrgID
+	}
+	return ""
+}
+
+// Scopes returns the scopes granted to a request.
+func Scopes(ctx context.Context) []string {
+	if id, ok := FromContext(ctx); ok {
+		return id.Scopes
+	}
+	return nil
+}
+
+// HasScope reports whether scope is granted to a request.
+func HasScope(ctx context.Context, scope string) bool {
+	for _, s := range Scopes(ctx) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Claim returns the custom claim key of Mapping.Extra of a request, or "" if
+// it has none.
+func Claim(ctx context.Context, key string) string {
+	if id, ok := FromContext(ctx); ok {
+		return id.Extra[key]
+	}
+	return ""
+}
+
+// fields returns the "user.id", "org.id" and extra fields of id, in order.
+func (id *Identity) fields() []logging.Field {
+	var fields []logging.Field
+	if id.UserID != "" {
+		fields = append(fields, logging.Field{Key: "user.id", Value: id.UserID})
+	}
+	if id.OrgID != "" {
+		fields = append(fields, logging.Field{Key: "org.id", Value: id.OrgID})
+	}
+	keys := make([]string, 0, len(id.Extra))
+	for k := range id.Extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fields = append(fields, logging.Field{Key: k, Value: id.Extra[k]})
+	}
+	return fields
+}
+
+// LogFields returns the "user.id", "org.id" and extra fields of requests with
+// an identity, for logging.WithContextFields.
+func LogFields(ctx context.Context) []logging.Field {
+	if id, ok := FromContext(ctx); ok {
+		return id.fields()
+	}
+	return nil
+}
+
+type options struct {
+	mapping Mapping
+	tagger  func(ctx context.Context, key, value string)
+}
+
+// Option for identity interceptors.
+type Option func(o *options)
+
+// WithMapping sets the claims identities are read from. Default is
+// DefaultMapping.
+func WithMapping(m Mapping) Option {
+	return func(o *options) {
+		o.mapping = m
+	}
+}
+
+// WithTagger sets a function called with the context and each field of
+// LogFields once the identity of a request is known, like tagging the span of
+// the request with them.
+func WithTagger(f func(ctx context.Context, key, value string)) Option {
+	return func(o *options) {
+		o.tagger = f
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{mapping: DefaultMapping}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// enrich returns ctx carrying the identity of its claims, or ctx if it has
+// none.
+func (o options) enrich(ctx context.Context) context.Context {
+	claims, ok := jwt.FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	id := o.mapping.Identity(claims)
+	if o.tagger != nil {
+		for _, f := range id.fields() {
+			o.tagger(ctx, f.Key, f.Value.(string))
+		}
+	}
+	return NewContext(ctx, id)
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor storing the
+// identity of requests authenticated by the jwt interceptors, which must run
+// first, in the context of handlers.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(o.enrich(ctx), req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor storing
+// the identity of streams authenticated by the jwt interceptors in the
+// context of handlers.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		wrapped := middleware.WrapServerStream(ss)
+		wrapped.SetContext(o.enrich(ss.Context()))
+		return handler(srv, wrapped)
+	}
+}
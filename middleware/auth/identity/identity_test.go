@@ -0,0 +1,71 @@
+package identity
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ipfans/grpctools/middleware/auth/jwt"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+func TestMapping(t *testing.T) {
+	claims := &jwt.Claims{Raw: map[string]interface{}{
+		"sub":    "user-1",
+		"org_id": 42.0,
+		"scope":  "users:read users:write",
+		"scp":    []interface{}{"admin"},
+		"plan":   "pro",
+	}}
+	for _, tc := range []struct {
+		name    string
+		mapping Mapping
+		want    string
+	}{
+		{"default", DefaultMapping, "&{user-1 42 [users:read users:write] map[]}"},
+		{"custom", Mapping{UserID: "sub", OrgID: "tenant", Scopes: "scp", Extra: map[string]string{"plan": "plan", "missing": "missing"}}, "&{user-1  [admin] map[plan:pro]}"},
+	} {
+		if have := fmt.Sprint(tc.mapping.Identity(claims)); tc.want != have {
+			t.Errorf("%s: want %s, have %s", tc.name, tc.want, have)
+		}
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	tags := map[string]string{}
+	interceptor := UnaryServerInterceptor(WithTagger(func(ctx context.Context, key, value string) {
+		tags[key] = value
+	}))
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}
+
+	ctx := jwt.NewContext(context.Background(), &jwt.Claims{Raw: map[string]interface{}{
+		"sub":    "user-1",
+		"org_id": "acme",
+		"scope":  "users:read",
+	}})
+	interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		if want, have := "user-1", UserID(ctx); want != have {
+			t.Errorf("user: want %q, have %q", want, have)
+		}
+		if want, have := "acme", OrgID(ctx); want != have {
+			t.Errorf("org: want %q, have %q", want, have)
+		}
+		if !HasScope(ctx, "users:read") || HasScope(ctx, "users:write") {
+			t.Errorf("scopes: have %v", Scopes(ctx))
+		}
+		if want, have := "[{user.id user-1} {org.id acme}]", fmt.Sprint(LogFields(ctx)); want != have {
+			t.Errorf("log fields: want %s, have %s", want, have)
+		}
+		return nil, nil
+	})
+	if want, have := "map[org.id:acme user.id:user-1]", fmt.Sprint(tags); want != have {
+		t.Fatalf("tags: want %s, have %s", want, have)
+	}
+
+	interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		if _, ok := FromContext(ctx); ok {
+			t.Error("unauthenticated: want no identity")
+		}
+		return nil, nil
+	})
+}
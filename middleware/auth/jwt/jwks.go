@@ -0,0 +1,214 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+// KeySource returns the public key a token was signed with by its key ID, an
+// *rsa.PublicKey or *ecdsa.PublicKey.
+type KeySource interface {
+	Key(ctx context.Context, kid string) (interface{}, error)
+}
+
+// StaticKeys is a KeySource of fixed keys by key ID.
+type StaticKeys map[string]interface{}
+
+// Key returns the key of kid.
+func (k StaticKeys) Key(ctx context.Context, kid string) (interface{}, error) {
+	key, ok := k[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+// JWKS is a KeySource fetching keys from a JSON Web Key Set endpoint. Keys
+// are cached and refetched in the background once older than the refresh
+// interval. A token signed with an unknown key triggers a refetch, at most
+// once per minimum refresh interval, so rotated keys are picked up quickly.
+type JWKS struct {
+	url        string
+	client     *http.Client
+	refresh    time.Duration
+	minRefresh time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]interface{}
+	fetched   time.Time // last successful fetch
+	attempted time.Time // last fetch
+	fetching  bool
+
+	fetchMu sync.Mutex
+}
+
+// NewJWKS returns a JWKS fetching keys from url. It uses the WithHTTPClient,
+// WithRefreshInterval and WithMinRefreshInterval options.
+func NewJWKS(url string, opts ...Option) *JWKS {
+	o := newOptions(opts)
+	return &JWKS{
+		url:        url,
+		client:     o.client,
+		refresh:    o.refresh,
+		minRefresh: o.minRefresh,
+	}
+}
+
+// Key returns the key of kid.
+func (s *JWKS) Key(ctx context.Context, kid string) (interface{}, error) {
+	s.mu.Lock()
+	key, ok := s.keys[kid]
+	now := time.Now()
+	if ok {
+		if now.Sub(s.fetched) > s.refresh && !s.fetching {
+			s.fetching = true
+			go func() {
+				s.fetch(context.Background())
+				s.mu.Lock()
+				s.fetching = false
+				s.mu.Unlock()
+			}()
+		}
+		s.mu.Unlock()
+		return key, nil
+	}
+	// Claim the fetch under the lock, so concurrent requests with unknown
+	// keys make a single one and wait for it.
+	canFetch := s.attempted.IsZero() || now.Sub(s.attempted) >= s.minRefresh
+	if canFetch {
+		s.attempted = now
+	}
+	s.mu.Unlock()
+	if canFetch {
+		if err := s.fetch(ctx); err != nil {
+			return nil, err
+		}
+	} else {
+		s.fetchMu.Lock()
+		s.fetchMu.Unlock()
+	}
+	s.mu.Lock()
+	key, ok = s.keys[kid]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+// fetch replaces the cached keys with the keys served at s.url.
+func (s *JWKS) fetch(ctx context.Context) error {
+	s.fetchMu.Lock()
+	defer s.fetchMu.Unlock()
+	s.mu.Lock()
+	s.attempted = time.Now()
+	s.mu.Unlock()
+
+	resp, err := ctxhttp.Get(ctx, s.client, s.url)
+	if err != nil {
+		return fmt.Errorf("middleware/auth/jwt: fetching keys: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("middleware/auth/jwt: fetching keys: %s", resp.Status)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("middleware/auth/jwt: decoding keys: %v", err)
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		// Skip keys of unsupported types rather than failing the set.
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.fetched = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// jsonWebKey is a public key of a JWKS (RFC 7517).
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
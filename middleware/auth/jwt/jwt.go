@@ -0,0 +1,346 @@
+// Package jwt provides server interceptors authenticating requests with JSON
+// Web Tokens, verified against keys of a JWKS endpoint.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256" // register hashes of the supported algorithms
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ipfans/grpctools/middleware"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type options struct {
+	issuer        string
+	audience      string
+	leeway        time.Duration
+	requireExpiry bool
+	exempt        map[string]bool
+
+	client     *http.Client
+	refresh    time.Duration
+	minRefresh time.Duration
+}
+
+// Option for Authenticator and JWKS instances.
+type Option func(o *options)
+
+// WithIssuer sets the issuer ("iss") tokens must have. Default accepts any.
+func WithIssuer(iss string) Option {
+	return func(o *options) {
+		o.issuer = iss
+	}
+}
+
+// WithAudience sets the audience ("aud") tokens must include. Default accepts
+// any.
+func WithAudience(aud string) Option {
+	return func(o *options) {
+		o.audience = aud
+	}
+}
+
+// WithLeeway sets the clock skew allowed checking expiry and not-before
+// times. Default is one minute.
+func WithLeeway(d time.Duration) Option {
+	return func(o *options) {
+		o.leeway = d
+	}
+}
+
+// WithExemptMethods sets full method names served without a token.
+func WithExemptMethods(methods ...string) Option {
+	return func(o *options) {
+		for _, m := range methods {
+			o.exempt[m] = true
+		}
+	}
+}
+
+// WithHTTPClient sets the client a JWKS fetches keys with. Default is
+// http.DefaultClient.
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *options) {
+		o.client = c
+	}
+}
+
+// WithRefreshInterval sets how long a JWKS caches keys. Default is one hour.
+func WithRefreshInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.refresh = d
+	}
+}
+
+// WithMinRefreshInterval sets how often a JWKS may refetch keys for tokens
+// signed with an unknown key. Default is one minute.
+func WithMinRefreshInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.minRefresh = d
+	}
+}
+
+// WithRequireExpiry rejects tokens without an exp claim, so a leaked token
+// can't be used forever.
+func WithRequireExpiry() Option {
+	return func(o *options) {
+		o.requireExpiry = true
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		leeway:     time.Minute,
+		exempt:     make(map[string]bool),
+		client:     http.DefaultClient,
+		refresh:    time.Hour,
+		minRefresh: time.Minute,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Claims are the claims of a verified token.
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	// Raw holds all claims, including the ones above.
+	Raw map[string]interface{}
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying claims.
+func NewContext(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// FromContext returns the claims of the token a request was authenticated
+// with, false if it has none.
+func FromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(*Claims)
+	return claims, ok
+}
+
+// Authenticator verifies Bearer tokens of the authorization metadata.
+type Authenticator struct {
+	keys KeySource
+	opts options
+}
+
+// New returns an Authenticator verifying tokens with keys from keys.
+func New(keys KeySource, opts ...Option) *Authenticator {
+	return &Authenticator{keys: keys, opts: newOptions(opts)}
+}
+
+// Verify verifies token, returning its claims.
+func (a *Authenticator) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed header: %v", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %v", err)
+	}
+	key, err := a.keys.Key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return nil, fmt.Errorf("malformed claims: %v", err)
+	}
+	claims, err := parseClaims(raw)
+	if err != nil {
+		return nil, err
+	}
+	if a.opts.requireExpiry && claims.ExpiresAt.IsZero() {
+		return nil, errors.New("token has no expiry")
+	}
+	now := time.Now()
+	if !claims.ExpiresAt.IsZero() && now.After(claims.ExpiresAt.Add(a.opts.leeway)) {
+		return nil, errors.New("token expired")
+	}
+	if !claims.NotBefore.IsZero() && now.Add(a.opts.leeway).Before(claims.NotBefore) {
+		return nil, errors.New("token not valid yet")
+	}
+	if a.opts.issuer != "" && claims.Issuer != a.opts.issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if a.opts.audience != "" && !contains(claims.Audience, a.opts.audience) {
+		return nil, errors.New("token not issued for this audience")
+	}
+	return claims, nil
+}
+
+// authenticate verifies the token of a request, returning its context with
+// the claims.
+func (a *Authenticator) authenticate(ctx context.Context, method string) (context.Context, error) {
+	if a.opts.exempt[method] {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	v := md.Get("authorization")
+	if len(v) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+	const prefix = "bearer "
+	if len(v[0]) < len(prefix) || !strings.EqualFold(v[0][:len(prefix)], prefix) {
+		return nil, status.Error(codes.Unauthenticated, "authorization is not a bearer token")
+	}
+	claims, err := a.Verify(ctx, v[0][len(prefix):])
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid token: %v", err)
+	}
+	return NewContext(ctx, claims), nil
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor rejecting
+// requests without a valid token with Unauthenticated.
+func (a *Authenticator) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := a.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor
+// rejecting requests without a valid token with Unauthenticated.
+func (a *Authenticator) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authenticate(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		wrapped := middleware.WrapServerStream(stream)
+		wrapped.SetContext(ctx)
+		return handler(srv, wrapped)
+	}
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+var hashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// verifySignature verifies the signature sig of input made with alg. Only
+// RSA and ECDSA algorithms are supported, never "none".
+func verifySignature(alg string, key interface{}, input string, sig []byte) error {
+	hash, ok := hashes[alg]
+	if !ok {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(input))
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if alg[:2] != "RS" {
+			break
+		}
+		if err := rsa.VerifyPKCS1v15(key, hash, digest, sig); err != nil {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || len(sig) != 2*size {
+			break
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("algorithm %q doesn't match the key", alg)
+}
+
+func parseClaims(raw map[string]interface{}) (*Claims, error) {
+	c := &Claims{Raw: raw}
+	c.Issuer, _ = raw["iss"].(string)
+	c.Subject, _ = raw["sub"].(string)
+	switch aud := raw["aud"].(type) {
+	case string:
+		c.Audience = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				c.Audience = append(c.Audience, s)
+			}
+		}
+	}
+	for _, d := range []struct {
+		name string
+		t    *time.Time
+	}{
+		{"exp", &c.ExpiresAt},
+		{"nbf", &c.NotBefore},
+		{"iat", &c.IssuedAt},
+	} {
+		v, ok := raw[d.name]
+		if !ok {
+			continue
+		}
+		f, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("malformed %s claim", d.name)
+		}
+		*d.t = time.Unix(0, int64(f*float64(time.Second)))
+	}
+	return c, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
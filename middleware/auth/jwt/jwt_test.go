@@ -0,0 +1,263 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func encode(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	input := encode(t, map[string]string{"alg": "RS256", "kid": kid}) + "." + encode(t, claims)
+	digest := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func signES256(t *testing.T, key *ecdsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	input := encode(t, map[string]string{"alg": "ES256", "kid": kid}) + "." + encode(t, claims)
+	digest := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"kid": kid,
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+	}
+}
+
+// keyServer serves the JWKS set by setKeys, counting fetches.
+type keyServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    []map[string]string
+	fetches int
+}
+
+func newKeyServer() *keyServer {
+	s := &keyServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.fetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys})
+	}))
+	return s
+}
+
+func (s *keyServer) setKeys(keys ...map[string]string) {
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+}
+
+func TestVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := newKeyServer()
+	defer ks.Close()
+	ks.setKeys(rsaJWK("rsa", &rsaKey.PublicKey), ecJWK("ec", &ecKey.PublicKey))
+
+	a := New(NewJWKS(ks.URL), WithIssuer("https://issuer"), WithAudience("api"), WithLeeway(0))
+	now := time.Now().Unix()
+	valid := map[string]interface{}{"iss": "https://issuer", "sub": "alice", "aud": []string{"api", "other"}, "exp": now + 60}
+	with := func(key string, value interface{}) map[string]interface{} {
+		c := make(map[string]interface{})
+		for k, v := range valid {
+			c[k] = v
+		}
+		c[key] = value
+		return c
+	}
+	tampered := signRS256(t, rsaKey, "rsa", valid)
+	tampered = tampered[:strings.Index(tampered, ".")+1] + encode(t, with("sub", "mallory")) + tampered[strings.LastIndex(tampered, "."):]
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"RS256", signRS256(t, rsaKey, "rsa", valid), true},
+		{"ES256", signES256(t, ecKey, "ec", valid), true},
+		{"single audience", signRS256(t, rsaKey, "rsa", with("aud", "api")), true},
+		{"expired", signRS256(t, rsaKey, "rsa", with("exp", now-1)), false},
+		{"malformed expiry", signRS256(t, rsaKey, "rsa", with("exp", "1")), false},
+		{"malformed not before", signRS256(t, rsaKey, "rsa", with("nbf", "1")), false},
+		{"not before", signRS256(t, rsaKey, "rsa", with("nbf", now+60)), false},
+		{"issuer", signRS256(t, rsaKey, "rsa", with("iss", "https://evil")), false},
+		{"audience", signRS256(t, rsaKey, "rsa", with("aud", "other")), false},
+		{"tampered", tampered, false},
+		{"key mismatch", signRS256(t, rsaKey, "ec", valid), false},
+		{"malformed", "a.b", false},
+	} {
+		claims, err := a.Verify(context.Background(), tc.token)
+		if tc.ok != (err == nil) {
+			t.Errorf("%s: want ok %v, have error %v", tc.name, tc.ok, err)
+			continue
+		}
+		if tc.ok && claims.Subject != "alice" {
+			t.Errorf("%s: subject: want alice, have %q", tc.name, claims.Subject)
+		}
+	}
+}
+
+func TestRequireExpiry(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	keys := StaticKeys{"k": &key.PublicKey}
+	token := signES256(t, key, "k", map[string]interface{}{"sub": "alice"})
+	if _, err := New(keys).Verify(context.Background(), token); err != nil {
+		t.Fatalf("optional expiry: %v", err)
+	}
+	if _, err := New(keys, WithRequireExpiry()).Verify(context.Background(), token); err == nil {
+		t.Fatal("required expiry: token without exp accepted")
+	}
+}
+
+func TestJWKSUnknownKeyConcurrency(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ks := newKeyServer()
+	defer ks.Close()
+	ks.setKeys(ecJWK("k", &key.PublicKey))
+
+	a := New(NewJWKS(ks.URL, WithMinRefreshInterval(time.Hour)))
+	bogus := signES256(t, key, "bogus", map[string]interface{}{"sub": "mallory"})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.Verify(context.Background(), bogus)
+		}()
+	}
+	wg.Wait()
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if want, have := 1, ks.fetches; want != have {
+		t.Fatalf("fetches: want %d, have %d", want, have)
+	}
+}
+
+func TestJWKSRotation(t *testing.T) {
+	oldKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ks := newKeyServer()
+	defer ks.Close()
+	ks.setKeys(ecJWK("old", &oldKey.PublicKey))
+
+	a := New(NewJWKS(ks.URL, WithMinRefreshInterval(50*time.Millisecond)))
+	claims := map[string]interface{}{"sub": "alice"}
+	if _, err := a.Verify(context.Background(), signES256(t, oldKey, "old", claims)); err != nil {
+		t.Fatal(err)
+	}
+
+	ks.setKeys(ecJWK("old", &oldKey.PublicKey), ecJWK("new", &newKey.PublicKey))
+	// Unknown keys don't refetch more often than the minimum refresh interval.
+	if _, err := a.Verify(context.Background(), signES256(t, newKey, "new", claims)); err == nil {
+		t.Fatal("new key accepted before the minimum refresh interval")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := a.Verify(context.Background(), signES256(t, newKey, "new", claims)); err != nil {
+		t.Fatalf("rotated key: %v", err)
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if want, have := 2, ks.fetches; want != have {
+		t.Fatalf("fetches: want %d, have %d", want, have)
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	a := New(StaticKeys{"k": &key.PublicKey}, WithExemptMethods("/test.Service/Public"))
+	interceptor := a.UnaryServerInterceptor()
+	var subject string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		subject = ""
+		if claims, ok := FromContext(ctx); ok {
+			subject = claims.Subject
+		}
+		return nil, nil
+	}
+	token := signES256(t, key, "k", map[string]interface{}{"sub": "alice"})
+
+	for _, tc := range []struct {
+		method        string
+		authorization string
+		code          codes.Code
+		subject       string
+	}{
+		{"/test.Service/Get", "Bearer " + token, codes.OK, "alice"},
+		{"/test.Service/Get", "bearer " + token, codes.OK, "alice"},
+		{"/test.Service/Get", "", codes.Unauthenticated, ""},
+		{"/test.Service/Get", "Basic abc", codes.Unauthenticated, ""},
+		{"/test.Service/Get", "Bearer " + token + "x", codes.Unauthenticated, ""},
+		{"/test.Service/Public", "", codes.OK, ""},
+	} {
+		ctx := context.Background()
+		if tc.authorization != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tc.authorization))
+		}
+		subject = ""
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tc.method}, handler)
+		if want, have := tc.code, status.Code(err); want != have {
+			t.Errorf("%s %q: want %v, have %v", tc.method, tc.authorization, want, have)
+		}
+		if want, have := tc.subject, subject; want != have {
+			t.Errorf("%s %q: subject: want %q, have %q", tc.method, tc.authorization, want, have)
+		}
+	}
+}
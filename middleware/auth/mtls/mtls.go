@@ -0,0 +1,184 @@
+// Package mtls provides server interceptors extracting the identity of
+// clients from their verified TLS certificate and authorizing it.
+package mtls
+
+import (
+	"crypto/x509"
+	"path"
+	"strings"
+
+	"github.com/ipfans/grpctools/middleware"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Identity is the identity of a client certificate.
+type Identity struct {
+	// SPIFFEID is the spiffe:// URI SAN, if any.
+	SPIFFEID   string
+	URIs       []string
+	DNSNames   []string
+	Emails     []string
+	CommonName string
+}
+
+// Names returns all names of the identity: its URIs, including the SPIFFE ID,
+// DNS names, emails and common name.
+func (id *Identity) Names() []string {
+	names := make([]string, 0, len(id.URIs)+len(id.DNSNames)+len(id.Emails)+1)
+	names = append(names, id.URIs...)
+	names = append(names, id.DNSNames...)
+	names = append(names, id.Emails...)
+	if id.CommonName != "" {
+		names = append(names, id.CommonName)
+	}
+	return names
+}
+
+// identityOf returns the identity of cert.
+func identityOf(cert *x509.Certificate) *Identity {
+	id := &Identity{
+		DNSNames:   cert.DNSNames,
+		Emails:     cert.EmailAddresses,
+		CommonName: cert.Subject.CommonName,
+	}
+	for _, u := range cert.URIs {
+		s := u.String()
+		id.URIs = append(id.URIs, s)
+		if u.Scheme == "spiffe" && id.SPIFFEID == "" {
+			id.SPIFFEID = s
+		}
+	}
+	return id
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying id.
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the identity of the client of a request, false if it
+// has none.
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(contextKey{}).(*Identity)
+	return id, ok
+}
+
+// Policy lists the identities allowed per full method name, like
+// "/foo.v1.UserService/Get", or per service, like "foo.v1.UserService".
+// Patterns are matched against a single kind of name: URI patterns, like
+// "spiffe://example.org/ns/prod/x", against URI SANs; patterns with an "@"
+// against email SANs; "cn:" and a name against the free-form common name;
+// other patterns against DNS SANs. A trailing "*" matches any suffix, like
+// "spiffe://example.org/ns/prod/*". Methods without an entry are open to any
+// verified client.
+type Policy map[string][]string
+
+// allowed reports whether id may call method.
+func (p Policy) allowed(method string, id *Identity) bool {
+	allowed, ok := p[method]
+	if !ok {
+		allowed, ok = p[path.Dir(method)[1:]]
+	}
+	if !ok {
+		return true
+	}
+	for _, pattern := range allowed {
+		var names []string
+		switch {
+		case strings.Contains(pattern, "://"):
+			names = id.URIs
+		case strings.HasPrefix(pattern, "cn:"):
+			pattern = pattern[len("cn:"):]
+			if id.CommonName != "" {
+				names = []string{id.CommonName}
+			}
+		case strings.Contains(pattern, "@"):
+			names = id.Emails
+		default:
+			names = id.DNSNames
+		}
+		for _, name := range names {
+			if pattern == name || strings.HasSuffix(pattern, "*") && strings.HasPrefix(name, pattern[:len(pattern)-1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type options struct {
+	policy Policy
+}
+
+// Option for mtls interceptors.
+type Option func(o *options)
+
+// WithPolicy sets the identities allowed per method. Default allows any
+// verified client.
+func WithPolicy(p Policy) Option {
+	return func(o *options) {
+		o.policy = p
+	}
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// authorize returns the context of a request with the identity of its client.
+func (o options) authorize(ctx context.Context, method string) (context.Context, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "no peer")
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "no verified client certificate")
+	}
+	id := identityOf(info.State.VerifiedChains[0][0])
+	if !o.policy.allowed(method, id) {
+		return nil, status.Errorf(codes.PermissionDenied, "%s may not call %s", strings.Join(id.Names(), ", "), method)
+	}
+	return NewContext(ctx, id), nil
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor rejecting
+// clients without a verified certificate with Unauthenticated, and clients
+// not allowed by the policy with PermissionDenied. The server must request
+// client certificates, e.g. with tls.RequireAndVerifyClientCert.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := o.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor
+// authorizing clients like UnaryServerInterceptor.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := o.authorize(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		wrapped := middleware.WrapServerStream(stream)
+		wrapped.SetContext(ctx)
+		return handler(srv, wrapped)
+	}
+}
@@ -0,0 +1,76 @@
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func peerWith(cert *x509.Certificate) context.Context {
+	state := tls.ConnectionState{}
+	if cert != nil {
+		state.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+	return peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{State: state}})
+}
+
+func spiffeCert(id string) *x509.Certificate {
+	u, _ := url.Parse(id)
+	return &x509.Certificate{URIs: []*url.URL{u}, Subject: pkix.Name{CommonName: "client"}}
+}
+
+func cnCert(cn string) *x509.Certificate {
+	return &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := UnaryServerInterceptor(WithPolicy(Policy{
+		"test.v1.Admin":          {"spiffe://example.org/admin"},
+		"/test.v1.Service/Write": {"spiffe://example.org/ns/prod/*"},
+		"test.v1.Internal":       {"api.example.org"},
+		"test.v1.Legacy":         {"cn:legacy-client"},
+	}))
+	var identified bool
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		_, identified = FromContext(ctx)
+		return nil, nil
+	}
+
+	for _, tc := range []struct {
+		ctx    context.Context
+		method string
+		code   codes.Code
+	}{
+		{peerWith(spiffeCert("spiffe://example.org/ns/prod/api")), "/test.v1.Service/Read", codes.OK},
+		{peerWith(spiffeCert("spiffe://example.org/ns/prod/api")), "/test.v1.Service/Write", codes.OK},
+		{peerWith(spiffeCert("spiffe://example.org/ns/dev/api")), "/test.v1.Service/Write", codes.PermissionDenied},
+		{peerWith(spiffeCert("spiffe://example.org/admin")), "/test.v1.Admin/Reset", codes.OK},
+		{peerWith(spiffeCert("spiffe://example.org/ns/prod/api")), "/test.v1.Admin/Reset", codes.PermissionDenied},
+		// Names only match patterns of their kind: a common name can't pass
+		// for a URI or DNS SAN.
+		{peerWith(cnCert("spiffe://example.org/admin")), "/test.v1.Admin/Reset", codes.PermissionDenied},
+		{peerWith(cnCert("api.example.org")), "/test.v1.Internal/Get", codes.PermissionDenied},
+		{peerWith(&x509.Certificate{DNSNames: []string{"api.example.org"}}), "/test.v1.Internal/Get", codes.OK},
+		{peerWith(cnCert("legacy-client")), "/test.v1.Legacy/Get", codes.OK},
+		{peerWith(nil), "/test.v1.Service/Read", codes.Unauthenticated},
+		{context.Background(), "/test.v1.Service/Read", codes.Unauthenticated},
+	} {
+		identified = false
+		_, err := interceptor(tc.ctx, nil, &grpc.UnaryServerInfo{FullMethod: tc.method}, handler)
+		if want, have := tc.code, status.Code(err); want != have {
+			t.Errorf("%s: want %v, have %v (%v)", tc.method, want, have, err)
+		}
+		if err == nil && !identified {
+			t.Errorf("%s: no identity in context", tc.method)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// Package oauth provides per-RPC credentials attaching OAuth2 access tokens to
+// client calls, refreshing them before they expire.
+package oauth
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TokenFunc is an adapter to use a function as an oauth2.TokenSource, e.g.
+// the Token method of a clientcredentials.Config bound to a context.
+type TokenFunc func() (*oauth2.Token, error)
+
+// Token calls f.
+func (f TokenFunc) Token() (*oauth2.Token, error) {
+	return f()
+}
+
+type options struct {
+	refreshBefore time.Duration
+	retries       int
+	backoff       time.Duration
+	insecure      bool
+}
+
+// Option for Credentials instance.
+type Option func(o *options)
+
+// WithRefreshBefore sets how long before its expiry a token is refreshed.
+// Calls keep using the current token while the refresh runs in the
+// background. Default is one minute.
+func WithRefreshBefore(d time.Duration) Option {
+	return func(o *options) {
+		o.refreshBefore = d
+	}
+}
+
+// WithRetries sets how many times a failed token request is retried, the
+// first retry waiting for backoff and each next one twice as long. Only
+// network errors and 5xx or 429 responses of the token endpoint are retried.
+// Default is 3 retries from 100ms.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.retries = n
+		o.backoff = backoff
+	}
+}
+
+// WithInsecure allows tokens to be sent over connections without transport
+// security, e.g. inside a service mesh encrypting traffic itself.
+func WithInsecure() Option {
+	return func(o *options) {
+		o.insecure = true
+	}
+}
+
+// fetch is a token request, shared by the calls waiting for it.
+type fetch struct {
+	done  chan struct{}
+	token *oauth2.Token
+	err   error
+}
+
+// Credentials implements credentials.PerRPCCredentials with the tokens of an
+// oauth2.TokenSource, sent as the authorization metadata of every call.
+// Tokens are cached until they are about to expire, and concurrent calls
+// share a single token request.
+type Credentials struct {
+	source oauth2.TokenSource
+	opts   options
+	now    func() time.Time
+
+	mu       sync.Mutex
+	token    *oauth2.Token
+	fetching *fetch
+}
+
+// New returns Credentials of the tokens of source, like a client credentials
+// or workload identity token source. Caching is done by Credentials, so
+// source should request a new token on each call: sources returned by
+// oauth2.ReuseTokenSource or Config.TokenSource only do so 10 seconds before
+// expiry, defeating WithRefreshBefore.
+func New(source oauth2.TokenSource, opts ...Option) *Credentials {
+	o := options{
+		refreshBefore: time.Minute,
+		retries:       3,
+		backoff:       100 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Credentials{source: source, opts: o, now: time.Now}
+}
+
+// DialOption returns a grpc.DialOption sending the tokens of source with
+// every call of a connection.
+func DialOption(source oauth2.TokenSource, opts ...Option) grpc.DialOption {
+	return grpc.WithPerRPCCredentials(New(source, opts...))
+}
+
+// Token returns a valid token, requesting one if none is cached or the
+// cached one expired. Errors are Unauthenticated if the token endpoint
+// rejected the request, and Unavailable if it failed after retries.
+func (c *Credentials) Token(ctx context.Context) (*oauth2.Token, error) {
+	c.mu.Lock()
+	now := c.now()
+	if t := c.token; t != nil && (t.Expiry.IsZero() || now.Before(t.Expiry)) {
+		if !t.Expiry.IsZero() && !now.Add(c.opts.refreshBefore).Before(t.Expiry) {
+			c.refresh()
+		}
+		c.mu.Unlock()
+		return t, nil
+	}
+	f := c.refresh()
+	c.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	case <-f.done:
+		return f.token, f.err
+	}
+}
+
+// refresh starts a token request unless one is running, returning it. c.mu
+// must be held.
+func (c *Credentials) refresh() *fetch {
+	if c.fetching != nil {
+		return c.fetching
+	}
+	f := &fetch{done: make(chan struct{})}
+	c.fetching = f
+	go func() {
+		f.token, f.err = c.retrieve()
+		c.mu.Lock()
+		if f.err == nil {
+			c.token = f.token
+		}
+		c.fetching = nil
+		c.mu.Unlock()
+		close(f.done)
+	}()
+	return f
+}
+
+// retrieve requests a token from the source, retrying transient failures.
+func (c *Credentials) retrieve() (*oauth2.Token, error) {
+	backoff := c.opts.backoff
+	for attempt := 0; ; attempt++ {
+		t, err := c.source.Token()
+		if err == nil {
+			return t, nil
+		}
+		if !transient(err) {
+			return nil, status.Errorf(codes.Unauthenticated, "oauth: %v", err)
+		}
+		if attempt >= c.opts.retries {
+			return nil, status.Errorf(codes.Unavailable, "oauth: %v", err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// transient reports whether a token request failing with err may succeed if
+// retried.
+func transient(err error) bool {
+	rerr, ok := err.(*oauth2.RetrieveError)
+	if !ok || rerr.Response == nil {
+		return true
+	}
+	code := rerr.Response.StatusCode
+	return code >= 500 || code == http.StatusTooManyRequests
+}
+
+// GetRequestMetadata returns the authorization metadata of a call.
+func (c *Credentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	t, err := c.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"authorization": t.Type() + " " + t.AccessToken}, nil
+}
+
+// RequireTransportSecurity reports whether tokens may only be sent over
+// secure connections, which is the case unless WithInsecure is set.
+func (c *Credentials) RequireTransportSecurity() bool {
+	return !c.opts.insecure
+}
@@ -0,0 +1,142 @@
+package oauth
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeSource returns tokens named after the request count, valid for an
+// hour, after failing with the errors in fails.
+type fakeSource struct {
+	mu       sync.Mutex
+	now      time.Time
+	fails    []error
+	requests int
+}
+
+func (s *fakeSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if len(s.fails) > 0 {
+		err := s.fails[0]
+		s.fails = s.fails[1:]
+		return nil, err
+	}
+	return &oauth2.Token{AccessToken: "t" + strconv.Itoa(s.requests), Expiry: s.now.Add(time.Hour)}, nil
+}
+
+func (s *fakeSource) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func TestGetRequestMetadata(t *testing.T) {
+	now := time.Now()
+	source := &fakeSource{now: now}
+	c := New(source)
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		md, err := c.GetRequestMetadata(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := "Bearer t1", md["authorization"]; want != have {
+			t.Fatalf("authorization: want %q, have %q", want, have)
+		}
+	}
+	if want, have := 1, source.count(); want != have {
+		t.Fatalf("cached: want %d requests, have %d", want, have)
+	}
+
+	// Close to expiry, the current token is used while a new one is requested.
+	now = now.Add(time.Hour - 30*time.Second)
+	tok, err := c.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "t1", tok.AccessToken; want != have {
+		t.Fatalf("during refresh: want %q, have %q", want, have)
+	}
+	for deadline := time.Now().Add(time.Second); source.count() < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("token not refreshed before expiry")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for deadline := time.Now().Add(time.Second); ; {
+		if tok, _ = c.Token(context.Background()); tok.AccessToken == "t2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("refreshed token not used: have %q", tok.AccessToken)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	rejected := &oauth2.RetrieveError{Response: &http.Response{Status: "401 Unauthorized", StatusCode: http.StatusUnauthorized}}
+	overloaded := &oauth2.RetrieveError{Response: &http.Response{Status: "503 Service Unavailable", StatusCode: http.StatusServiceUnavailable}}
+	network := errors.New("connection refused")
+
+	for _, tc := range []struct {
+		name     string
+		fails    []error
+		code     codes.Code
+		requests int
+	}{
+		{"transient", []error{network, overloaded}, codes.OK, 3},
+		{"rejected", []error{rejected}, codes.Unauthenticated, 1},
+		{"retries exhausted", []error{network, network, network}, codes.Unavailable, 3},
+	} {
+		source := &fakeSource{now: time.Now(), fails: tc.fails}
+		c := New(source, WithRetries(2, time.Millisecond))
+		_, err := c.GetRequestMetadata(context.Background())
+		if want, have := tc.code, status.Code(err); want != have {
+			t.Errorf("%s: code: want %v, have %v (%v)", tc.name, want, have, err)
+		}
+		if want, have := tc.requests, source.count(); want != have {
+			t.Errorf("%s: requests: want %d, have %d", tc.name, want, have)
+		}
+	}
+}
+
+func TestConcurrentCalls(t *testing.T) {
+	source := &fakeSource{now: time.Now()}
+	c := New(source)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.GetRequestMetadata(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if want, have := 1, source.count(); want != have {
+		t.Fatalf("requests: want %d, have %d", want, have)
+	}
+}
+
+func TestRequireTransportSecurity(t *testing.T) {
+	if !New(&fakeSource{}).RequireTransportSecurity() {
+		t.Fatal("default: want transport security required")
+	}
+	if New(&fakeSource{}, WithInsecure()).RequireTransportSecurity() {
+		t.Fatal("WithInsecure: want transport security not required")
+	}
+}
@@ -0,0 +1,53 @@
+package signature
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Nonces remember the nonces of verified requests while their timestamps are
+// valid, so replays of captured requests are rejected.
+type Nonces interface {
+	// Add records key for ttl, returning false if it is already recorded.
+	Add(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// MemoryNonces are Nonces in memory, for single servers.
+type MemoryNonces struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+	sweep   time.Time
+}
+
+// NewMemoryNonces returns empty MemoryNonces.
+func NewMemoryNonces() *MemoryNonces {
+	return &MemoryNonces{expires: make(map[string]time.Time)}
+}
+
+// Add records key for ttl unless it is recorded.
+func (n *MemoryNonces) Add(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := time.Now()
+	n.expire(now)
+	if exp, ok := n.expires[key]; ok && now.Before(exp) {
+		return false, nil
+	}
+	n.expires[key] = now.Add(ttl)
+	return true, nil
+}
+
+// expire removes expired nonces, at most once a minute. n.mu must be held.
+func (n *MemoryNonces) expire(now time.Time) {
+	if now.Sub(n.sweep) < time.Minute {
+		return
+	}
+	n.sweep = now
+	for key, exp := range n.expires {
+		if !now.Before(exp) {
+			delete(n.expires, key)
+		}
+	}
+}
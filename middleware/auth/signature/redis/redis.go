@@ -0,0 +1,28 @@
+// Package redis provides signature.Nonces in Redis, shared by all servers.
+package redis
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ipfans/grpctools/middleware/auth/signature"
+	"golang.org/x/net/context"
+)
+
+// Nonces are signature.Nonces in Redis.
+type Nonces struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+var _ signature.Nonces = (*Nonces)(nil)
+
+// New returns Nonces recorded in client under keys starting with prefix.
+func New(client redis.UniversalClient, prefix string) *Nonces {
+	return &Nonces{client: client, prefix: prefix}
+}
+
+// Add records key for ttl unless it is recorded.
+func (n *Nonces) Add(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return n.client.SetNX(ctx, n.prefix+key, 1, ttl).Result()
+}
@@ -0,0 +1,52 @@
+package redis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/net/context"
+)
+
+// fakeClient keeps keys in a map, recording their expiration.
+type fakeClient struct {
+	redis.UniversalClient
+	ttls map[string]time.Duration
+	err  error
+}
+
+func (c *fakeClient) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
+	if c.err != nil {
+		return redis.NewBoolResult(false, c.err)
+	}
+	if _, ok := c.ttls[key]; ok {
+		return redis.NewBoolResult(false, nil)
+	}
+	c.ttls[key] = expiration
+	return redis.NewBoolResult(true, nil)
+}
+
+func TestNonces(t *testing.T) {
+	client := &fakeClient{ttls: make(map[string]time.Duration)}
+	n := New(client, "nonce:")
+	ctx := context.Background()
+
+	for _, want := range []bool{true, false} {
+		added, err := n.Add(ctx, "partner:abc", 10*time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := added; want != have {
+			t.Fatalf("added: want %v, have %v", want, have)
+		}
+	}
+	if want, have := 10*time.Minute, client.ttls["nonce:partner:abc"]; want != have {
+		t.Fatalf("ttl: want %v, have %v", want, have)
+	}
+
+	client.err = errors.New("connection refused")
+	if _, err := n.Add(ctx, "partner:def", time.Minute); err == nil {
+		t.Fatal("want error, have nil")
+	}
+}
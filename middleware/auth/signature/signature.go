@@ -0,0 +1,259 @@
+// Package signature provides interceptors signing requests with a shared
+// secret and verifying their HMAC signatures, for clients which can't use
+// mTLS.
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ipfans/grpctools/middleware"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Metadata keys of signed requests.
+const (
+	KeyIDKey     = "x-signature-key-id"
+	TimestampKey = "x-signature-timestamp"
+	NonceKey     = "x-signature-nonce"
+	SignatureKey = "x-signature"
+)
+
+// ErrUnknownKey is returned by Keys for key IDs they don't know.
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// Keys looks up the secrets of key IDs, returning ErrUnknownKey for unknown
+// ones.
+type Keys interface {
+	Secret(ctx context.Context, id string) ([]byte, error)
+}
+
+// KeysFunc is an adapter to use a function as Keys.
+type KeysFunc func(ctx context.Context, id string) ([]byte, error)
+
+// Secret calls f.
+func (f KeysFunc) Secret(ctx context.Context, id string) ([]byte, error) {
+	return f(ctx, id)
+}
+
+// StaticKeys are fixed secrets by key ID.
+type StaticKeys map[string][]byte
+
+// Secret returns the secret of id.
+func (k StaticKeys) Secret(ctx context.Context, id string) ([]byte, error) {
+	secret, ok := k[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return secret, nil
+}
+
+// sign returns the signature of a request to method: the HMAC-SHA256 of the
+// method, timestamp, nonce and SHA-256 of the deterministic encoding of req,
+// separated by newlines. Streams are signed without a request.
+func sign(secret []byte, method, timestamp, nonce string, req interface{}) (string, error) {
+	var body []byte
+	if m, ok := req.(proto.Message); ok {
+		b := proto.NewBuffer(nil)
+		b.SetDeterministic(true)
+		if err := b.Marshal(m); err != nil {
+			return "", err
+		}
+		body = b.Bytes()
+	}
+	digest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(digest[:])))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// signedContext returns a copy of ctx sending the signature metadata of a
+// request.
+func signedContext(ctx context.Context, keyID string, secret []byte, method string, req interface{}) (context.Context, error) {
+	var n [16]byte
+	if _, err := rand.Read(n[:]); err != nil {
+		return nil, err
+	}
+	timestamp, nonce := strconv.FormatInt(time.Now().Unix(), 10), hex.EncodeToString(n[:])
+	sig, err := sign(secret, method, timestamp, nonce, req)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.AppendToOutgoingContext(ctx, KeyIDKey, keyID, TimestampKey, timestamp, NonceKey, nonce, SignatureKey, sig), nil
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor signing
+// requests with the secret of keyID.
+func UnaryClientInterceptor(keyID string, secret []byte) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, err := signedContext(ctx, keyID, secret, method, req)
+		if err != nil {
+			return status.Errorf(codes.Internal, "signature: %v", err)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor returns a new streaming client interceptor signing
+// streams with the secret of keyID. Stream messages aren't signed.
+func StreamClientInterceptor(keyID string, secret []byte) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, err := signedContext(ctx, keyID, secret, method, nil)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "signature: %v", err)
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the ID of the key a request was
+// signed with.
+func NewContext(ctx context.Context, keyID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, keyID)
+}
+
+// FromContext returns the ID of the key a request was signed with, false if
+// it wasn't verified.
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(contextKey{}).(string)
+	return id, ok
+}
+
+type options struct {
+	skew   time.Duration
+	exempt map[string]bool
+	nonces Nonces
+	now    func() time.Time
+}
+
+// Option for signature server interceptors.
+type Option func(o *options)
+
+// WithSkew sets how far the timestamp of a request may be from the server
+// clock. Default is 5 minutes.
+func WithSkew(d time.Duration) Option {
+	return func(o *options) {
+		o.skew = d
+	}
+}
+
+// WithExemptMethods sets full method names served without a signature.
+func WithExemptMethods(methods ...string) Option {
+	return func(o *options) {
+		for _, m := range methods {
+			o.exempt[m] = true
+		}
+	}
+}
+
+// WithNonces rejects requests reusing the nonce of a request verified with the
+// same key while its timestamp is valid, recording nonces in n. Without it,
+// captured requests can be replayed within the skew.
+func WithNonces(n Nonces) Option {
+	return func(o *options) {
+		o.nonces = n
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		skew:   5 * time.Minute,
+		exempt: make(map[string]bool),
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func first(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func verify(ctx context.Context, keys Keys, o options, method string, req interface{}) (context.Context, error) {
+	if o.exempt[method] {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	keyID, timestamp, nonce, sig := first(md, KeyIDKey), first(md, TimestampKey), first(md, NonceKey), first(md, SignatureKey)
+	if keyID == "" || timestamp == "" || nonce == "" || sig == "" {
+		return nil, status.Error(codes.Unauthenticated, "missing request signature")
+	}
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "malformed signature timestamp")
+	}
+	if d := o.now().Sub(time.Unix(sec, 0)); d > o.skew || d < -o.skew {
+		return nil, status.Error(codes.Unauthenticated, "signature timestamp out of range")
+	}
+	secret, err := keys.Secret(ctx, keyID)
+	switch {
+	case err == ErrUnknownKey:
+		return nil, status.Error(codes.Unauthenticated, "invalid request signature")
+	case err != nil:
+		return nil, status.Errorf(codes.Unavailable, "looking up signing key: %v", err)
+	}
+	want, err := sign(secret, method, timestamp, nonce, req)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "signature: %v", err)
+	}
+	if !hmac.Equal([]byte(want), []byte(sig)) {
+		return nil, status.Error(codes.Unauthenticated, "invalid request signature")
+	}
+	if o.nonces != nil {
+		// Timestamps are valid for the skew on either side of the clock.
+		added, err := o.nonces.Add(ctx, keyID+":"+nonce, 2*o.skew)
+		if err != nil {
+			return nil, status.Errorf(codes.Unavailable, "recording request nonce: %v", err)
+		}
+		if !added {
+			return nil, status.Error(codes.Unauthenticated, "replayed request nonce")
+		}
+	}
+	return NewContext(ctx, keyID), nil
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor rejecting
+// requests without a valid signature with Unauthenticated.
+func UnaryServerInterceptor(keys Keys, opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := verify(ctx, keys, o, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor
+// rejecting streams without a valid signature with Unauthenticated.
+func StreamServerInterceptor(keys Keys, opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := verify(stream.Context(), keys, o, info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+		wrapped := middleware.WrapServerStream(stream)
+		wrapped.SetContext(ctx)
+		return handler(srv, wrapped)
+	}
+}
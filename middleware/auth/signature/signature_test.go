@@ -0,0 +1,138 @@
+package signature
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// signed returns the metadata the client interceptor sends with req.
+func signed(t *testing.T, keyID string, secret []byte, method string, req interface{}) metadata.MD {
+	var md metadata.MD
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+	if err := UnaryClientInterceptor(keyID, secret)(context.Background(), method, req, nil, nil, invoker); err != nil {
+		t.Fatal(err)
+	}
+	return md
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	const method = "/test.Service/Transfer"
+	keys := StaticKeys{"partner": []byte("s3cret")}
+	now := time.Now()
+	interceptor := UnaryServerInterceptor(keys, WithExemptMethods("/test.Service/Public"), func(o *options) {
+		o.now = func() time.Time { return now }
+	})
+	var keyID string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		keyID, _ = FromContext(ctx)
+		return nil, nil
+	}
+	req := &wrappers.StringValue{Value: "100 EUR"}
+	valid := signed(t, "partner", []byte("s3cret"), method, req)
+	with := func(key, value string) metadata.MD {
+		md := valid.Copy()
+		md.Set(key, value)
+		return md
+	}
+
+	for _, tc := range []struct {
+		name   string
+		md     metadata.MD
+		method string
+		req    interface{}
+		skew   time.Duration
+		code   codes.Code
+	}{
+		{"valid", valid, method, req, 0, codes.OK},
+		{"tampered request", valid, method, &wrappers.StringValue{Value: "1000 EUR"}, 0, codes.Unauthenticated},
+		{"other method", valid, "/test.Service/Refund", req, 0, codes.Unauthenticated},
+		{"wrong secret", signed(t, "partner", []byte("guess"), method, req), method, req, 0, codes.Unauthenticated},
+		{"unknown key", signed(t, "other", []byte("s3cret"), method, req), method, req, 0, codes.Unauthenticated},
+		{"tampered nonce", with(NonceKey, "00"), method, req, 0, codes.Unauthenticated},
+		{"malformed timestamp", with(TimestampKey, "yesterday"), method, req, 0, codes.Unauthenticated},
+		{"within skew", valid, method, req, 4 * time.Minute, codes.OK},
+		{"stale", valid, method, req, 6 * time.Minute, codes.Unauthenticated},
+		{"from the future", valid, method, req, -6 * time.Minute, codes.Unauthenticated},
+		{"missing", nil, method, req, 0, codes.Unauthenticated},
+		{"exempt", nil, "/test.Service/Public", req, 0, codes.OK},
+	} {
+		now = time.Now().Add(tc.skew)
+		ctx := context.Background()
+		if tc.md != nil {
+			ctx = metadata.NewIncomingContext(ctx, tc.md)
+		}
+		keyID = ""
+		_, err := interceptor(ctx, tc.req, &grpc.UnaryServerInfo{FullMethod: tc.method}, handler)
+		if want, have := tc.code, status.Code(err); want != have {
+			t.Errorf("%s: want %v, have %v (%v)", tc.name, want, have, err)
+		}
+		if tc.code == codes.OK && tc.md != nil && keyID != "partner" {
+			t.Errorf("%s: key ID: want partner, have %q", tc.name, keyID)
+		}
+	}
+}
+
+func TestNonces(t *testing.T) {
+	const method = "/test.Service/Transfer"
+	keys := StaticKeys{"partner": []byte("s3cret"), "other": []byte("s3cret")}
+	interceptor := UnaryServerInterceptor(keys, WithNonces(NewMemoryNonces()))
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	}
+	req := &wrappers.StringValue{Value: "100 EUR"}
+	first := signed(t, "partner", []byte("s3cret"), method, req)
+	// The same nonce signed with another key is a different request.
+	other := signed(t, "other", []byte("s3cret"), method, req)
+	other.Set(NonceKey, first.Get(NonceKey)[0])
+	other.Set(TimestampKey, first.Get(TimestampKey)[0])
+	other.Set(SignatureKey, first.Get(SignatureKey)[0])
+
+	for _, tc := range []struct {
+		name string
+		md   metadata.MD
+		code codes.Code
+	}{
+		{"first", first, codes.OK},
+		{"replayed", first, codes.Unauthenticated},
+		{"new nonce", signed(t, "partner", []byte("s3cret"), method, req), codes.OK},
+		{"other key", other, codes.OK},
+	} {
+		_, err := interceptor(metadata.NewIncomingContext(context.Background(), tc.md), req, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		if want, have := tc.code, status.Code(err); want != have {
+			t.Errorf("%s: want %v, have %v (%v)", tc.name, want, have, err)
+		}
+	}
+}
+
+func TestMemoryNonces(t *testing.T) {
+	n := NewMemoryNonces()
+	ctx := context.Background()
+	for _, tc := range []struct {
+		key   string
+		ttl   time.Duration
+		added bool
+	}{
+		{"a", time.Minute, true},
+		{"a", time.Minute, false},
+		{"b", -time.Second, true},
+		{"b", time.Minute, true},
+	} {
+		added, err := n.Add(ctx, tc.key, tc.ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := tc.added, added; want != have {
+			t.Errorf("%s: want %v, have %v", tc.key, want, have)
+		}
+	}
+}
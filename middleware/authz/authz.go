@@ -0,0 +1,214 @@
+// Package authz provides server interceptors authorizing authenticated
+// principals by role with a declarative policy.
+package authz
+
+import (
+	"path"
+	"strings"
+
+	"github.com/ipfans/grpctools/middleware/auth/apikey"
+	"github.com/ipfans/grpctools/middleware/auth/jwt"
+	"github.com/ipfans/grpctools/middleware/auth/mtls"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Principal is an authenticated caller.
+type Principal struct {
+	// Name identifies the caller, prefixed by how it was authenticated so
+	// names from different sources can't collide, e.g. "jwt:alice".
+	Name  string
+	Roles []string
+}
+
+// PrincipalFunc returns the principal of a request, false if it isn't
+// authenticated.
+type PrincipalFunc func(ctx context.Context) (*Principal, bool)
+
+// DefaultPrincipal returns the principal authenticated by the interceptors of
+// the auth packages: "jwt:" and the subject of a JWT, with the roles of its
+// "roles" claim; "apikey:" and the owner of an API key; or the SPIFFE ID of a
+// client certificate, else "x509:" and its first name. Only JWTs carry roles.
+func DefaultPrincipal(ctx context.Context) (*Principal, bool) {
+	if claims, ok := jwt.FromContext(ctx); ok {
+		p := &Principal{Name: "jwt:" + claims.Subject}
+		switch roles := claims.Raw["roles"].(type) {
+		case string:
+			p.Roles = strings.Fields(roles)
+		case []interface{}:
+			for _, r := range roles {
+				if s, ok := r.(string); ok {
+					p.Roles = append(p.Roles, s)
+				}
+			}
+		}
+		return p, true
+	}
+	if key, ok := apikey.FromContext(ctx); ok {
+		return &Principal{Name: "apikey:" + key.Owner}, true
+	}
+	if id, ok := mtls.FromContext(ctx); ok {
+		if id.SPIFFEID != "" {
+			return &Principal{Name: id.SPIFFEID}, true
+		}
+		if names := id.Names(); len(names) > 0 {
+			return &Principal{Name: "x509:" + names[0]}, true
+		}
+	}
+	return nil, false
+}
+
+// Authorizer decides whether a principal may call a method, returning a
+// PermissionDenied error if not.
+type Authorizer interface {
+	Authorize(ctx context.Context, method string, p *Principal) error
+}
+
+// Policy is an Authorizer of rules. A call is permitted if any rule matching its
+// method allows the principal; calls no rule matches are denied.
+type Policy struct {
+	Rules []Rule `yaml:"rules" json:"rules"`
+}
+
+// Rule allows principals by role or name to call methods.
+type Rule struct {
+	// Methods are full method names like "/foo.v1.UserService/Get", all
+	// methods of a service like "/foo.v1.UserService/*", or "*".
+	Methods []string `yaml:"methods" json:"methods"`
+	Roles   []string `yaml:"roles" json:"roles"`
+	// Principals are allowed by name, whatever their roles.
+	Principals []string `yaml:"principals" json:"principals"`
+}
+
+// ParsePolicy parses a policy in YAML, or JSON, like:
+//
+//	rules:
+//	- methods: ["/foo.v1.UserService/*"]
+//	  roles: [admin]
+//	- methods: ["/foo.v1.UserService/Get"]
+//	  roles: [reader]
+func ParsePolicy(data []byte) (Policy, error) {
+	var p Policy
+	err := yaml.Unmarshal(data, &p)
+	return p, err
+}
+
+func (r Rule) matches(method string) bool {
+	for _, m := range r.Methods {
+		if m == "*" || m == method || strings.HasSuffix(m, "/*") && path.Dir(method) == m[:len(m)-2] {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) allows(p *Principal) bool {
+	for _, name := range r.Principals {
+		if name == p.Name {
+			return true
+		}
+	}
+	for _, role := range r.Roles {
+		for _, have := range p.Roles {
+			if role == have {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authorize returns a PermissionDenied error naming the roles allowed, if p
+// may not call method.
+func (pol Policy) Authorize(ctx context.Context, method string, p *Principal) error {
+	var allowed []string
+	for _, r := range pol.Rules {
+		if !r.matches(method) {
+			continue
+		}
+		if r.allows(p) {
+			return nil
+		}
+		allowed = append(allowed, r.Roles...)
+	}
+	if len(allowed) == 0 {
+		return status.Errorf(codes.PermissionDenied, "%s may not call %s: no rule allows it", p.Name, method)
+	}
+	return status.Errorf(codes.PermissionDenied, "%s (roles %s) may not call %s: requires one of the roles %s",
+		p.Name, strings.Join(p.Roles, ", "), method, strings.Join(allowed, ", "))
+}
+
+type options struct {
+	principal PrincipalFunc
+	exempt    map[string]bool
+}
+
+// Option for authz interceptors.
+type Option func(o *options)
+
+// WithPrincipalFunc sets how principals are read from requests. Default is
+// DefaultPrincipal.
+func WithPrincipalFunc(f PrincipalFunc) Option {
+	return func(o *options) {
+		o.principal = f
+	}
+}
+
+// WithExemptMethods sets full method names served without authorization.
+func WithExemptMethods(methods ...string) Option {
+	return func(o *options) {
+		for _, m := range methods {
+			o.exempt[m] = true
+		}
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		principal: DefaultPrincipal,
+		exempt:    make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func (o options) authorize(ctx context.Context, a Authorizer, method string) error {
+	if o.exempt[method] {
+		return nil
+	}
+	p, ok := o.principal(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "request is not authenticated")
+	}
+	return a.Authorize(ctx, method, p)
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor rejecting
+// calls not permitted by a, like a Policy, with PermissionDenied. It must run
+// after the authentication interceptors.
+func UnaryServerInterceptor(a Authorizer, opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := o.authorize(ctx, a, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor rejecting
+// calls not permitted by a with PermissionDenied.
+func StreamServerInterceptor(a Authorizer, opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := o.authorize(stream.Context(), a, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
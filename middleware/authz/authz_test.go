@@ -0,0 +1,72 @@
+package authz
+
+import (
+	"testing"
+
+	"github.com/ipfans/grpctools/middleware/auth/apikey"
+	"github.com/ipfans/grpctools/middleware/auth/jwt"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var policy = Policy{Rules: []Rule{
+	{Methods: []string{"/test.v1.Users/*"}, Roles: []string{"admin"}},
+	{Methods: []string{"/test.v1.Users/Get"}, Roles: []string{"reader"}},
+	{Methods: []string{"*"}, Principals: []string{"apikey:root"}},
+}}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := UnaryServerInterceptor(policy, WithExemptMethods("/grpc.health.v1.Health/Check"))
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+	withJWT := func(sub string, roles ...interface{}) context.Context {
+		return jwt.NewContext(context.Background(), &jwt.Claims{Subject: sub, Raw: map[string]interface{}{"roles": roles}})
+	}
+	withKey := func(owner, tier string) context.Context {
+		return apikey.NewContext(context.Background(), &apikey.Key{Owner: owner, Tier: tier})
+	}
+
+	for _, tc := range []struct {
+		ctx    context.Context
+		method string
+		code   codes.Code
+	}{
+		{withJWT("alice", "admin"), "/test.v1.Users/Delete", codes.OK},
+		{withJWT("bob", "reader"), "/test.v1.Users/Get", codes.OK},
+		{withJWT("bob", "reader"), "/test.v1.Users/Delete", codes.PermissionDenied},
+		{withJWT("alice", "admin"), "/test.v1.Orders/List", codes.PermissionDenied},
+		{withKey("root", ""), "/test.v1.Orders/List", codes.OK},
+		{withJWT("root"), "/test.v1.Orders/List", codes.PermissionDenied},
+		{withKey("carol", "admin"), "/test.v1.Users/Delete", codes.PermissionDenied},
+		{context.Background(), "/test.v1.Users/Get", codes.Unauthenticated},
+		{context.Background(), "/grpc.health.v1.Health/Check", codes.OK},
+	} {
+		_, err := interceptor(tc.ctx, nil, &grpc.UnaryServerInfo{FullMethod: tc.method}, handler)
+		if want, have := tc.code, status.Code(err); want != have {
+			t.Errorf("%s: want %v, have %v (%v)", tc.method, want, have, err)
+		}
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy([]byte(`
+rules:
+- methods: ["/test.v1.Users/*"]
+  roles: [admin]
+- methods: ["*"]
+  principals: [root]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 2, len(p.Rules); want != have {
+		t.Fatalf("rules: want %d, have %d", want, have)
+	}
+	if want, have := "admin", p.Rules[0].Roles[0]; want != have {
+		t.Fatalf("role: want %q, have %q", want, have)
+	}
+	if want, have := "root", p.Rules[1].Principals[0]; want != have {
+		t.Fatalf("principal: want %q, have %q", want, have)
+	}
+}
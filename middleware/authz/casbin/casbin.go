@@ -0,0 +1,52 @@
+// Package casbin adapts a Casbin enforcer to authz.Authorizer, to reuse
+// Casbin models and policies for gRPC methods.
+package casbin
+
+import (
+	"path"
+
+	"github.com/ipfans/grpctools/middleware/authz"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Enforcer is the method of a *casbin.Enforcer used to authorize calls.
+type Enforcer interface {
+	Enforce(rvals ...interface{}) (bool, error)
+}
+
+// New returns an Authorizer enforcing requests of subject, object and action,
+// the object being the service and the action the method. The subject is
+// "user:" and the principal name, or "role:" and one of its roles, so a
+// principal named like a role doesn't get its permissions, e.g.
+// ("user:jwt:alice", "foo.v1.UserService", "Get") or ("role:admin",
+// "foo.v1.UserService", "Delete"). Matchers like keyMatch in the model allow
+// wildcards. Roles may also be resolved by the model's role definition from
+// the "user:" subject.
+func New(e Enforcer) authz.Authorizer {
+	return authorizer{e}
+}
+
+type authorizer struct {
+	e Enforcer
+}
+
+func (a authorizer) Authorize(ctx context.Context, method string, p *authz.Principal) error {
+	service, name := path.Dir(method)[1:], path.Base(method)
+	subjects := make([]string, 0, 1+len(p.Roles))
+	subjects = append(subjects, "user:"+p.Name)
+	for _, role := range p.Roles {
+		subjects = append(subjects, "role:"+role)
+	}
+	for _, sub := range subjects {
+		ok, err := a.e.Enforce(sub, service, name)
+		if err != nil {
+			return status.Errorf(codes.Internal, "enforcing policy: %v", err)
+		}
+		if ok {
+			return nil
+		}
+	}
+	return status.Errorf(codes.PermissionDenied, "%s may not call %s", p.Name, method)
+}
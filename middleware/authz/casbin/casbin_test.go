@@ -0,0 +1,48 @@
+package casbin
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ipfans/grpctools/middleware/authz"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// enforcerFunc enforces like a Casbin model with (sub, obj, act) requests.
+type enforcerFunc func(sub, obj, act string) (bool, error)
+
+func (f enforcerFunc) Enforce(rvals ...interface{}) (bool, error) {
+	return f(rvals[0].(string), rvals[1].(string), rvals[2].(string))
+}
+
+func TestAuthorize(t *testing.T) {
+	a := New(enforcerFunc(func(sub, obj, act string) (bool, error) {
+		switch {
+		case sub == "user:broken":
+			return false, errors.New("adapter failed")
+		case sub == "role:admin":
+			return true, nil
+		}
+		return sub == "user:alice" && obj == "test.v1.Users" && act == "Get", nil
+	}))
+
+	for _, tc := range []struct {
+		p      *authz.Principal
+		method string
+		code   codes.Code
+	}{
+		{&authz.Principal{Name: "alice"}, "/test.v1.Users/Get", codes.OK},
+		{&authz.Principal{Name: "alice"}, "/test.v1.Users/Delete", codes.PermissionDenied},
+		{&authz.Principal{Name: "bob", Roles: []string{"viewer", "admin"}}, "/test.v1.Users/Delete", codes.OK},
+		{&authz.Principal{Name: "broken"}, "/test.v1.Users/Get", codes.Internal},
+		// A principal named like a role doesn't get its permissions.
+		{&authz.Principal{Name: "admin"}, "/test.v1.Users/Delete", codes.PermissionDenied},
+	} {
+		err := a.Authorize(context.Background(), tc.method, tc.p)
+		if want, have := tc.code, status.Code(err); want != have {
+			t.Errorf("%s %s: want %v, have %v", tc.p.Name, tc.method, want, have)
+		}
+	}
+}
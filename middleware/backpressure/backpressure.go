@@ -0,0 +1,173 @@
+// Package backpressure provides a server interceptor bounding the messages a
+// streaming handler may have queued for a client, so a slow client can't
+// make the server buffer without limit.
+package backpressure
+
+import (
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ipfans/grpctools/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Policy decides what SendMsg does when the send queue is full.
+type Policy int
+
+const (
+	// Block waits for room in the queue, or for the end of the stream.
+	Block Policy = iota
+	// Drop discards the message, for streams of updates superseding each
+	// other.
+	Drop
+	// Fail returns ResourceExhausted, ending the stream.
+	Fail
+)
+
+// String returns the name of p.
+func (p Policy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case Drop:
+		return "drop"
+	case Fail:
+		return "fail"
+	}
+	return "unknown"
+}
+
+type options struct {
+	size    int
+	policy  Policy
+	metrics metrics.Provider
+}
+
+// Option for the backpressure interceptor.
+type Option func(o *options)
+
+// WithQueueSize sets the number of messages queued per stream. Default is 16.
+func WithQueueSize(n int) Option {
+	return func(o *options) {
+		o.size = n
+	}
+}
+
+// WithPolicy sets what happens to messages sent on a full queue. Default is
+// Block.
+func WithPolicy(p Policy) Option {
+	return func(o *options) {
+		o.policy = p
+	}
+}
+
+// WithMetrics reports messages dropped or failed on full queues through given
+// provider.
+func WithMetrics(p metrics.Provider) Option {
+	return func(o *options) {
+		o.metrics = p
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor sending
+// the messages of handlers from a bounded queue, in the order they were sent.
+// SendMsg returns once a message is queued, and handlers return once their
+// queue is sent. Queued messages are copies, so handlers may reuse them.
+//
+// Messages are sent from another goroutine, so handlers must not call
+// SendHeader after their first SendMsg.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	o := options{
+		size:    16,
+		metrics: metrics.Discard,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.size < 1 {
+		o.size = 1
+	}
+	overflows := o.metrics.NewCounter("grpc_backpressure_overflows_total", "Total number of messages sent on full stream queues and dropped or failed.", "method", "policy")
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		s := &queuedStream{
+			ServerStream: stream,
+			policy:       o.policy,
+			queue:        make(chan interface{}, o.size),
+			done:         make(chan struct{}),
+			overflows:    overflows.With(info.FullMethod, o.policy.String()),
+		}
+		go s.drain()
+		err := handler(srv, s)
+		close(s.queue)
+		<-s.done
+		if err != nil {
+			return err
+		}
+		return s.failed()
+	}
+}
+
+type queuedStream struct {
+	grpc.ServerStream
+	policy    Policy
+	queue     chan interface{}
+	done      chan struct{}
+	overflows metrics.Counter
+
+	mu  sync.Mutex
+	err error
+}
+
+// drain sends queued messages until the queue is closed or a send fails.
+func (s *queuedStream) drain() {
+	defer close(s.done)
+	for m := range s.queue {
+		if err := s.ServerStream.SendMsg(m); err != nil {
+			s.mu.Lock()
+			s.err = err
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+// failed returns the error of the last send, if it failed.
+func (s *queuedStream) failed() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *queuedStream) SendMsg(m interface{}) error {
+	if err := s.failed(); err != nil {
+		return err
+	}
+	if pm, ok := m.(proto.Message); ok {
+		m = proto.Clone(pm)
+	}
+	if s.policy == Block {
+		ctx := s.Context()
+		select {
+		case s.queue <- m:
+			return nil
+		case <-s.done:
+			return s.failed()
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	select {
+	case s.queue <- m:
+		return nil
+	case <-s.done:
+		return s.failed()
+	default:
+	}
+	s.overflows.Add(1)
+	if s.policy == Drop {
+		return nil
+	}
+	return status.Error(codes.ResourceExhausted, "backpressure: send queue full, client too slow")
+}
@@ -0,0 +1,134 @@
+package backpressure
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// slowStream is a client reading a message each time release is signaled.
+// Each send signals sending first.
+type slowStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	sending chan struct{}
+	release chan struct{}
+	sent    []string
+	err     error
+}
+
+func (s *slowStream) Context() context.Context { return s.ctx }
+func (s *slowStream) SendMsg(m interface{}) error {
+	s.sending <- struct{}{}
+	<-s.release
+	if s.err != nil {
+		return s.err
+	}
+	s.sent = append(s.sent, m.(*wrappers.StringValue).Value)
+	return nil
+}
+
+func newSlowStream() *slowStream {
+	return &slowStream{
+		ctx:     context.Background(),
+		sending: make(chan struct{}, 10),
+		release: make(chan struct{}),
+	}
+}
+
+// releaseAll lets the client read n messages in the background.
+func (s *slowStream) releaseAll(n int) {
+	go func() {
+		for i := 0; i < n; i++ {
+			s.release <- struct{}{}
+		}
+	}()
+}
+
+var info = &grpc.StreamServerInfo{FullMethod: "/test.Service/Watch", IsServerStream: true}
+
+func TestDrop(t *testing.T) {
+	stream := newSlowStream()
+	interceptor := StreamServerInterceptor(WithQueueSize(2), WithPolicy(Drop))
+	err := interceptor(nil, stream, info, func(srv interface{}, s grpc.ServerStream) error {
+		m := &wrappers.StringValue{Value: "a"}
+		s.SendMsg(m)
+		<-stream.sending
+		// "a" is being sent: "b" and "c" fill the queue, the rest is dropped.
+		for _, v := range []string{"b", "c", "d", "e"} {
+			m.Value = v
+			if err := s.SendMsg(m); err != nil {
+				return err
+			}
+		}
+		stream.releaseAll(3)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "a b c", strings.Join(stream.sent, " "); want != have {
+		t.Fatalf("sent: want %q, have %q", want, have)
+	}
+}
+
+func TestFail(t *testing.T) {
+	stream := newSlowStream()
+	interceptor := StreamServerInterceptor(WithQueueSize(1), WithPolicy(Fail))
+	err := interceptor(nil, stream, info, func(srv interface{}, s grpc.ServerStream) error {
+		defer stream.releaseAll(2)
+		s.SendMsg(&wrappers.StringValue{Value: "a"})
+		<-stream.sending
+		s.SendMsg(&wrappers.StringValue{Value: "b"})
+		return s.SendMsg(&wrappers.StringValue{Value: "c"})
+	})
+	if want, have := codes.ResourceExhausted, status.Code(err); want != have {
+		t.Fatalf("want %v, have %v", want, have)
+	}
+	if want, have := "a b", strings.Join(stream.sent, " "); want != have {
+		t.Fatalf("sent: want %q, have %q", want, have)
+	}
+}
+
+func TestBlock(t *testing.T) {
+	stream := newSlowStream()
+	ctx, cancel := context.WithCancel(context.Background())
+	stream.ctx = ctx
+	interceptor := StreamServerInterceptor(WithQueueSize(1))
+	err := interceptor(nil, stream, info, func(srv interface{}, s grpc.ServerStream) error {
+		defer stream.releaseAll(2)
+		s.SendMsg(&wrappers.StringValue{Value: "a"})
+		<-stream.sending
+		s.SendMsg(&wrappers.StringValue{Value: "b"})
+		// The queue is full: the next send blocks until the stream ends.
+		cancel()
+		return s.SendMsg(&wrappers.StringValue{Value: "c"})
+	})
+	if want, have := codes.Canceled, status.Code(err); want != have {
+		t.Fatalf("want %v, have %v", want, have)
+	}
+	if want, have := "a b", strings.Join(stream.sent, " "); want != have {
+		t.Fatalf("sent: want %q, have %q", want, have)
+	}
+}
+
+func TestSendError(t *testing.T) {
+	stream := newSlowStream()
+	errBroken := errors.New("broken pipe")
+	stream.err = errBroken
+	interceptor := StreamServerInterceptor()
+	err := interceptor(nil, stream, info, func(srv interface{}, s grpc.ServerStream) error {
+		s.SendMsg(&wrappers.StringValue{Value: "a"})
+		stream.release <- struct{}{}
+		return nil
+	})
+	if want, have := errBroken, err; want != have {
+		t.Fatalf("want %v, have %v", want, have)
+	}
+}
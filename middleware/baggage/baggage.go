@@ -0,0 +1,227 @@
+// Package baggage provides interceptors propagating W3C Baggage, lightweight
+// request-scoped key-value pairs, like a customer tier or a feature flag,
+// from the first service of a call chain to all the services it calls.
+package baggage
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/ipfans/grpctools/middleware"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// MetadataKey is the metadata key of the W3C baggage header.
+const MetadataKey = "baggage"
+
+// Baggage is a set of entries by key.
+type Baggage map[string]string
+
+// Parse returns the baggage of header, like "tier=gold,flag=new%20ui",
+// skipping malformed entries. Entry properties are dropped.
+func Parse(header string) Baggage {
+	b := Baggage{}
+	for _, member := range strings.Split(header, ",") {
+		if i := strings.IndexByte(member, ';'); i >= 0 {
+			member = member[:i]
+		}
+		i := strings.IndexByte(member, '=')
+		if i <= 0 {
+			continue
+		}
+		key := strings.TrimSpace(member[:i])
+		value, err := url.PathUnescape(strings.TrimSpace(member[i+1:]))
+		if key == "" || err != nil {
+			continue
+		}
+		b[key] = value
+	}
+	return b
+}
+
+// keys returns the keys of b in order.
+func (b Baggage) keys() []string {
+	keys := make([]string, 0, len(b))
+	for k := range b {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// String returns the header encoding b, its keys in order.
+func (b Baggage) String() string {
+	members := make([]string, 0, len(b))
+	for _, k := range b.keys() {
+		members = append(members, k+"="+url.PathEscape(b[k]))
+	}
+	return strings.Join(members, ",")
+}
+
+// Policy limits the baggage propagated.
+type Policy struct {
+	// Keys are the keys propagated, all if empty.
+	Keys []string
+	// MaxEntries is the maximum number of entries, unlimited if 0.
+	MaxEntries int
+	// MaxBytes is the maximum length of the header, unlimited if 0.
+	MaxBytes int
+}
+
+// DefaultPolicy propagates any key within the limits every W3C Baggage
+// implementation must accept: 64 entries and 8192 bytes.
+var DefaultPolicy = Policy{MaxEntries: 64, MaxBytes: 8192}
+
+// Apply returns the entries of b allowed by p, keys in order until a limit is
+// reached.
+func (p Policy) Apply(b Baggage) Baggage {
+	var allowed map[string]bool
+	if len(p.Keys) > 0 {
+		allowed = make(map[string]bool, len(p.Keys))
+		for _, k := range p.Keys {
+			allowed[k] = true
+		}
+	}
+	out := Baggage{}
+	size := 0
+	for _, k := range b.keys() {
+		if allowed != nil && !allowed[k] {
+			continue
+		}
+		if p.MaxEntries > 0 && len(out) >= p.MaxEntries {
+			break
+		}
+		n := len(k) + 1 + len(url.PathEscape(b[k]))
+		if len(out) > 0 {
+			n++ // separator
+		}
+		if p.MaxBytes > 0 && size+n > p.MaxBytes {
+			continue
+		}
+		size += n
+		out[k] = b[k]
+	}
+	return out
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying b.
+func NewContext(ctx context.Context, b Baggage) context.Context {
+	return context.WithValue(ctx, contextKey{}, b)
+}
+
+// FromContext returns the baggage of ctx, nil if it has none. It must not be
+// modified, With returns a context with more entries.
+func FromContext(ctx context.Context) Baggage {
+	b, _ := ctx.Value(contextKey{}).(Baggage)
+	return b
+}
+
+// Get returns the value of key in the baggage of ctx, "" if it has none.
+func Get(ctx context.Context, key string) string {
+	return FromContext(ctx)[key]
+}
+
+// With returns a copy of ctx whose baggage has key set to value, propagated
+// to the calls made with it.
+func With(ctx context.Context, key, value string) context.Context {
+	old := FromContext(ctx)
+	b := make(Baggage, len(old)+1)
+	for k, v := range old {
+		b[k] = v
+	}
+	b[key] = value
+	return NewContext(ctx, b)
+}
+
+type options struct {
+	policy Policy
+}
+
+// Option for baggage interceptors.
+type Option func(o *options)
+
+// WithPolicy sets the policy applied to incoming and outgoing baggage.
+// Default is DefaultPolicy.
+func WithPolicy(p Policy) Option {
+	return func(o *options) {
+		o.policy = p
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{policy: DefaultPolicy}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// incoming returns ctx carrying the allowed incoming baggage.
+func (o options) incoming(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	v := md.Get(MetadataKey)
+	if len(v) == 0 {
+		return ctx
+	}
+	return NewContext(ctx, o.policy.Apply(Parse(strings.Join(v, ","))))
+}
+
+// outgoing returns ctx with the allowed baggage of ctx as outgoing metadata.
+func (o options) outgoing(ctx context.Context) context.Context {
+	b := FromContext(ctx)
+	if b == nil {
+		return ctx
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	delete(md, MetadataKey)
+	if b = o.policy.Apply(b); len(b) > 0 {
+		md.Set(MetadataKey, b.String())
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor storing the
+// allowed incoming baggage in the context of handlers.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(o.incoming(ctx), req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor storing
+// the allowed incoming baggage in the context of handlers.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		wrapped := middleware.WrapServerStream(ss)
+		wrapped.SetContext(o.incoming(ss.Context()))
+		return handler(srv, wrapped)
+	}
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor sending the
+// allowed baggage of the context on outgoing calls, replacing any baggage
+// header, like the one copied by the propagation interceptors.
+func UnaryClientInterceptor(opts ...Option) grpc.UnaryClientInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		return invoker(o.outgoing(ctx), method, req, reply, cc, callOpts...)
+	}
+}
+
+// StreamClientInterceptor returns a new streaming client interceptor sending
+// the allowed baggage of the context on outgoing calls, replacing any baggage
+// header.
+func StreamClientInterceptor(opts ...Option) grpc.StreamClientInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(o.outgoing(ctx), desc, cc, method, callOpts...)
+	}
+}
@@ -0,0 +1,68 @@
+package baggage
+
+import (
+	"fmt"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestParse(t *testing.T) {
+	b := Parse("tier=gold, flag=new%20ui;ttl=60,malformed,=empty,bad=%zz")
+	if want, have := "flag=new%20ui,tier=gold", b.String(); want != have {
+		t.Fatalf("want %q, have %q", want, have)
+	}
+	if want, have := "new ui", b["flag"]; want != have {
+		t.Fatalf("want %q, have %q", want, have)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	b := Baggage{"a": "1", "b": "2", "c": "3", "long": "0123456789"}
+	for _, tc := range []struct {
+		name   string
+		policy Policy
+		want   string
+	}{
+		{"unlimited", Policy{}, "a=1,b=2,c=3,long=0123456789"},
+		{"keys", Policy{Keys: []string{"b", "long"}}, "b=2,long=0123456789"},
+		{"entries", Policy{MaxEntries: 2}, "a=1,b=2"},
+		{"bytes", Policy{MaxBytes: 12}, "a=1,b=2,c=3"},
+		{"bytes skip", Policy{Keys: []string{"long", "c"}, MaxBytes: 12}, "c=3"},
+	} {
+		if have := tc.policy.Apply(b).String(); tc.want != have {
+			t.Errorf("%s: want %q, have %q", tc.name, tc.want, have)
+		}
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	opts := []Option{WithPolicy(Policy{Keys: []string{"tier", "flag"}})}
+	server := UnaryServerInterceptor(opts...)
+	client := UnaryClientInterceptor(opts...)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKey, "tier=gold,secret=1"))
+	var sent metadata.MD
+	_, err := server(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		if want, have := "gold", Get(ctx, "tier"); want != have {
+			t.Fatalf("tier: want %q, have %q", want, have)
+		}
+		if have := Get(ctx, "secret"); have != "" {
+			t.Fatalf("secret: want none, have %q", have)
+		}
+		ctx = With(ctx, "flag", "on")
+		ctx = metadata.AppendToOutgoingContext(ctx, MetadataKey, "stale=1")
+		return nil, client(ctx, "/test.Other/Get", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			sent, _ = metadata.FromOutgoingContext(ctx)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "[flag=on,tier=gold]", fmt.Sprint(sent.Get(MetadataKey)); want != have {
+		t.Fatalf("sent: want %s, have %s", want, have)
+	}
+}
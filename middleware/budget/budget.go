@@ -0,0 +1,128 @@
+// Package budget provides a retry budget shared by the retry and hedging
+// interceptors of a client, capping the extra load they add.
+package budget
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ipfans/grpctools/metrics"
+)
+
+// buckets is the number of buckets the window is split into.
+const buckets = 10
+
+type options struct {
+	minPerSecond float64
+	ttl          time.Duration
+	metrics      metrics.Provider
+}
+
+// Option for Budget instance.
+type Option func(o *options)
+
+// WithMinPerSecond sets the number of retries per second allowed whatever
+// the ratio, so clients making few calls can still retry. Default is 10.
+func WithMinPerSecond(n float64) Option {
+	return func(o *options) {
+		o.minPerSecond = n
+	}
+}
+
+// WithTTL sets the window over which calls and retries are counted. Default
+// is 10 seconds.
+func WithTTL(d time.Duration) Option {
+	return func(o *options) {
+		o.ttl = d
+	}
+}
+
+// WithMetrics reports retries denied by the budget through given provider.
+func WithMetrics(p metrics.Provider) Option {
+	return func(o *options) {
+		o.metrics = p
+	}
+}
+
+type bucket struct {
+	epoch             int64
+	deposits, retries float64
+}
+
+// Budget allows retries and hedged requests up to a ratio of the calls made
+// recently, so that retry storms can't happen whatever the per-call
+// policies: with a ratio of 0.1, retries add at most 10% load. Share one
+// Budget between the retry and hedge interceptors of a client.
+type Budget struct {
+	ratio float64
+	opts  options
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets [buckets]bucket
+
+	denied metrics.Counter
+}
+
+// New returns a Budget allowing retries up to ratio of the calls.
+func New(ratio float64, opts ...Option) *Budget {
+	o := options{
+		minPerSecond: 10,
+		ttl:          10 * time.Second,
+		metrics:      metrics.Discard,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.ttl < buckets {
+		o.ttl = buckets
+	}
+	return &Budget{
+		ratio:  ratio,
+		opts:   o,
+		now:    time.Now,
+		denied: o.metrics.NewCounter("grpc_retry_budget_denied_total", "Total number of retries and hedged requests denied by the retry budget."),
+	}
+}
+
+// current returns the bucket of now, reset if it belonged to an older epoch,
+// and the sums of the window. Callers hold b.mu.
+func (b *Budget) current() (cur *bucket, deposits, retries float64) {
+	epoch := b.now().UnixNano() / (int64(b.opts.ttl) / buckets)
+	cur = &b.buckets[epoch%buckets]
+	if cur.epoch != epoch {
+		*cur = bucket{epoch: epoch}
+	}
+	for _, bk := range b.buckets {
+		if epoch-bk.epoch < buckets {
+			deposits += bk.deposits
+			retries += bk.retries
+		}
+	}
+	return cur, deposits, retries
+}
+
+// Deposit records a call, earning ratio of a retry.
+func (b *Budget) Deposit() {
+	b.mu.Lock()
+	cur, _, _ := b.current()
+	cur.deposits++
+	b.mu.Unlock()
+}
+
+// Withdraw reports whether a retry or hedged request may be sent, recording
+// it if so.
+func (b *Budget) Withdraw() bool {
+	b.mu.Lock()
+	cur, deposits, retries := b.current()
+	allowed := deposits*b.ratio + b.opts.minPerSecond*b.opts.ttl.Seconds()
+	ok := retries+1 <= allowed
+	if ok {
+		cur.retries++
+	}
+	b.mu.Unlock()
+	if !ok {
+		b.denied.Add(1)
+	}
+	return ok
+}
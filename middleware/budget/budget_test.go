@@ -0,0 +1,47 @@
+package budget
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBudget(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := New(0.1, WithMinPerSecond(0))
+	b.now = func() time.Time { return now }
+
+	if b.Withdraw() {
+		t.Fatal("retry allowed without calls")
+	}
+	for i := 0; i < 100; i++ {
+		b.Deposit()
+	}
+	var retries int
+	for i := 0; i < 100; i++ {
+		if b.Withdraw() {
+			retries++
+		}
+	}
+	if want, have := 10, retries; want != have {
+		t.Fatalf("retries for 100 calls: want %d, have %d", want, have)
+	}
+
+	// Calls and retries expire with the window.
+	now = now.Add(10 * time.Second)
+	if b.Withdraw() {
+		t.Fatal("retry allowed by expired calls")
+	}
+}
+
+func TestMinPerSecond(t *testing.T) {
+	b := New(0, WithMinPerSecond(1), WithTTL(10*time.Second))
+	var retries int
+	for i := 0; i < 20; i++ {
+		if b.Withdraw() {
+			retries++
+		}
+	}
+	if want, have := 10, retries; want != have {
+		t.Fatalf("retries: want %d, have %d", want, have)
+	}
+}
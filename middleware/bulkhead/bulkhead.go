@@ -0,0 +1,177 @@
+// Package bulkhead provides server interceptors running handlers in pools of
+// bounded concurrency, so a slow dependency saturating one pool doesn't take
+// down the methods of the others.
+package bulkhead
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/ipfans/grpctools/metrics"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Pool is a named partition of handler execution.
+type Pool struct {
+	Name string
+	// MaxConcurrent is the number of handlers of the pool running at once.
+	MaxConcurrent int
+	// MaxWait is how long requests wait for a slot of a full pool before they
+	// are rejected with ResourceExhausted. Zero rejects them right away.
+	MaxWait time.Duration
+	// Methods are full method names like "/foo.v1.UserService/Get", all
+	// methods of a service like "/foo.v1.UserService/*", or "*". A method in
+	// several pools runs in the one of its most specific pattern; methods in
+	// no pool aren't limited.
+	Methods []string
+}
+
+type options struct {
+	metrics metrics.Provider
+}
+
+// Option for Bulkhead instance.
+type Option func(o *options)
+
+// WithMetrics reports pool usage through given provider.
+func WithMetrics(p metrics.Provider) Option {
+	return func(o *options) {
+		o.metrics = p
+	}
+}
+
+type pool struct {
+	Pool
+	slots chan struct{}
+}
+
+// Bulkhead assigns methods to pools.
+type Bulkhead struct {
+	methods  map[string]*pool
+	services map[string]*pool
+	all      *pool
+
+	inFlight metrics.Gauge
+	rejected metrics.Counter
+}
+
+// New returns a Bulkhead of pools. Pool names and method patterns must be
+// unique.
+func New(pools []Pool, opts ...Option) (*Bulkhead, error) {
+	o := options{metrics: metrics.Discard}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	b := &Bulkhead{
+		methods:  make(map[string]*pool),
+		services: make(map[string]*pool),
+		inFlight: o.metrics.NewGauge("grpc_bulkhead_in_flight", "Number of handlers running per pool.", "pool"),
+		rejected: o.metrics.NewCounter("grpc_bulkhead_rejected_total", "Total number of requests rejected by full pools.", "pool"),
+	}
+	names := make(map[string]bool)
+	for _, p := range pools {
+		if names[p.Name] {
+			return nil, fmt.Errorf("bulkhead: duplicate pool %q", p.Name)
+		}
+		names[p.Name] = true
+		if p.MaxConcurrent < 1 {
+			return nil, fmt.Errorf("bulkhead: pool %q needs a positive MaxConcurrent, have %d", p.Name, p.MaxConcurrent)
+		}
+		pl := &pool{Pool: p, slots: make(chan struct{}, p.MaxConcurrent)}
+		for _, m := range p.Methods {
+			var taken bool
+			switch {
+			case m == "*":
+				taken = b.all != nil
+				b.all = pl
+			case len(m) > 2 && m[len(m)-2:] == "/*":
+				_, taken = b.services[m[:len(m)-2]]
+				b.services[m[:len(m)-2]] = pl
+			default:
+				_, taken = b.methods[m]
+				b.methods[m] = pl
+			}
+			if taken {
+				return nil, fmt.Errorf("bulkhead: method pattern %q in several pools", m)
+			}
+		}
+	}
+	return b, nil
+}
+
+// pool returns the pool of method, nil if it isn't limited.
+func (b *Bulkhead) pool(method string) *pool {
+	if p, ok := b.methods[method]; ok {
+		return p
+	}
+	if p, ok := b.services[path.Dir(method)]; ok {
+		return p
+	}
+	return b.all
+}
+
+// acquire waits for a slot in the pool of method, returning the function
+// releasing it.
+func (b *Bulkhead) acquire(ctx context.Context, method string) (func(), error) {
+	p := b.pool(method)
+	if p == nil {
+		return func() {}, nil
+	}
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		if p.MaxWait <= 0 {
+			return nil, b.reject(p)
+		}
+		t := time.NewTimer(p.MaxWait)
+		defer t.Stop()
+		select {
+		case p.slots <- struct{}{}:
+		case <-t.C:
+			return nil, b.reject(p)
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	b.inFlight.With(p.Name).Add(1)
+	return func() {
+		b.inFlight.With(p.Name).Add(-1)
+		<-p.slots
+	}, nil
+}
+
+func (b *Bulkhead) reject(p *pool) error {
+	b.rejected.With(p.Name).Add(1)
+	return status.Errorf(codes.ResourceExhausted, "bulkhead: pool %s is full", p.Name)
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor running
+// handlers in the pool of their method.
+func (b *Bulkhead) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		release, err := b.acquire(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor running
+// handlers in the pool of their method. Streams hold their slot until they
+// end.
+func (b *Bulkhead) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		release, err := b.acquire(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return handler(srv, stream)
+	}
+}
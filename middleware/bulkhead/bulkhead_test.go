@@ -0,0 +1,90 @@
+package bulkhead
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	b, err := New([]Pool{
+		{Name: "db-heavy", MaxConcurrent: 1, Methods: []string{"/test.Reports/*"}},
+		{Name: "cheap", MaxConcurrent: 1, MaxWait: time.Second, Methods: []string{"/test.Reports/Ping", "/test.Users/Get"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	interceptor := b.UnaryServerInterceptor()
+	call := func(method string, handler grpc.UnaryHandler) error {
+		_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+	noop := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+
+	// Saturate db-heavy.
+	started, unblock, finished := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		call("/test.Reports/Export", func(ctx context.Context, req interface{}) (interface{}, error) {
+			close(started)
+			<-unblock
+			return nil, nil
+		})
+		close(finished)
+	}()
+	<-started
+
+	for _, tc := range []struct {
+		method string
+		code   codes.Code
+	}{
+		{"/test.Reports/Summary", codes.ResourceExhausted},
+		{"/test.Reports/Ping", codes.OK},
+		{"/test.Users/Get", codes.OK},
+		{"/test.Users/List", codes.OK},
+	} {
+		if want, have := tc.code, status.Code(call(tc.method, noop)); want != have {
+			t.Errorf("%s: want %v, have %v", tc.method, want, have)
+		}
+	}
+	close(unblock)
+	<-finished
+	if err := call("/test.Reports/Summary", noop); err != nil {
+		t.Fatalf("after release: %v", err)
+	}
+}
+
+func TestMaxWait(t *testing.T) {
+	b, _ := New([]Pool{{Name: "p", MaxConcurrent: 1, MaxWait: time.Second, Methods: []string{"*"}}})
+	release, err := b.acquire(context.Background(), "/test.Service/Get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		release()
+	}()
+	if _, err := b.acquire(context.Background(), "/test.Service/Get"); err != nil {
+		t.Fatalf("waiting for a slot: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := b.acquire(ctx, "/test.Service/Get"); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("deadline while waiting: want DeadlineExceeded, have %v", err)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	for _, pools := range [][]Pool{
+		{{Name: "a", MaxConcurrent: 0}},
+		{{Name: "a", MaxConcurrent: 1}, {Name: "a", MaxConcurrent: 1}},
+		{{Name: "a", MaxConcurrent: 1, Methods: []string{"*"}}, {Name: "b", MaxConcurrent: 1, Methods: []string{"*"}}},
+	} {
+		if _, err := New(pools); err == nil {
+			t.Errorf("%+v: want error", pools)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Package cache provides interceptors caching responses of read-mostly
+// methods.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ipfans/grpctools/middleware/authz"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// HeaderKey is the response header telling whether a response was served from
+// the cache, "hit", or not, "miss".
+const HeaderKey = "x-cache"
+
+// Store stores encoded responses by key.
+type Store interface {
+	// Get returns the value of key, false if it is missing or expired.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value under key for ttl.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// KeyFunc returns the cache key of a request.
+type KeyFunc func(ctx context.Context, method string, req interface{}) (string, error)
+
+// DefaultKey hashes the method, the principal of authz.DefaultPrincipal and
+// the request, deterministically encoded, so callers never get responses
+// cached for others. Responses not depending on the caller can use SharedKey
+// to be cached once for all.
+func DefaultKey(ctx context.Context, method string, req interface{}) (string, error) {
+	var name string
+	if p, ok := authz.DefaultPrincipal(ctx); ok {
+		name = p.Name
+	}
+	return key(method, name, req)
+}
+
+// SharedKey hashes the method and the request, deterministically encoded.
+func SharedKey(ctx context.Context, method string, req interface{}) (string, error) {
+	return key(method, "", req)
+}
+
+func key(method, principal string, req interface{}) (string, error) {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return "", errNotProto
+	}
+	var buf proto.Buffer
+	buf.SetDeterministic(true)
+	if err := buf.Marshal(msg); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(principal))
+	h.Write([]byte{0})
+	h.Write(buf.Bytes())
+	return method + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+var errNotProto = errors.New("middleware/cache: request is not a protobuf message")
+
+type options struct {
+	store Store
+	ttls  map[string]time.Duration
+	key   KeyFunc
+}
+
+// Option for cache interceptors.
+type Option func(o *options)
+
+// WithStore sets where responses are stored. Default is an LRU of 1000
+// responses.
+func WithStore(s Store) Option {
+	return func(o *options) {
+		o.store = s
+	}
+}
+
+// WithMethodTTL sets how long responses are cached per full method name, like
+// "/foo.v1.UserService/Get". Only these methods are cached.
+func WithMethodTTL(ttls map[string]time.Duration) Option {
+	return func(o *options) {
+		o.ttls = ttls
+	}
+}
+
+// WithKeyFunc sets how cache keys are built. Default is DefaultKey.
+func WithKeyFunc(f KeyFunc) Option {
+	return func(o *options) {
+		o.key = f
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{key: DefaultKey}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.store == nil {
+		o.store = NewLRU(1000)
+	}
+	return o
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor serving
+// responses of the configured methods from the cache. A response is only
+// served from the cache once its type was learned from a handler, so a shared
+// store is filled by every server.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	var types sync.Map // method -> reflect.Type of responses
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ttl, ok := o.ttls[info.FullMethod]
+		if !ok {
+			return handler(ctx, req)
+		}
+		key, err := o.key(ctx, info.FullMethod, req)
+		if err != nil {
+			return handler(ctx, req)
+		}
+		if typ, ok := types.Load(info.FullMethod); ok {
+			if b, ok, err := o.store.Get(ctx, key); err == nil && ok {
+				resp := reflect.New(typ.(reflect.Type).Elem()).Interface().(proto.Message)
+				if proto.Unmarshal(b, resp) == nil {
+					grpc.SetHeader(ctx, metadata.Pairs(HeaderKey, "hit"))
+					return resp, nil
+				}
+			}
+		}
+
+		resp, err := handler(ctx, req)
+		grpc.SetHeader(ctx, metadata.Pairs(HeaderKey, "miss"))
+		if err != nil {
+			return resp, err
+		}
+		if msg, ok := resp.(proto.Message); ok {
+			if b, err := proto.Marshal(msg); err == nil {
+				types.Store(info.FullMethod, reflect.TypeOf(msg))
+				o.store.Set(ctx, key, b, ttl)
+			}
+		}
+		return resp, nil
+	}
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor answering
+// calls to the configured methods from the cache.
+func UnaryClientInterceptor(opts ...Option) grpc.UnaryClientInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		ttl, ok := o.ttls[method]
+		msg, isProto := reply.(proto.Message)
+		if !ok || !isProto {
+			return invoker(ctx, method, req, reply, cc, callOpts...)
+		}
+		key, err := o.key(ctx, method, req)
+		if err != nil {
+			return invoker(ctx, method, req, reply, cc, callOpts...)
+		}
+		if b, ok, err := o.store.Get(ctx, key); err == nil && ok {
+			msg.Reset()
+			if proto.Unmarshal(b, msg) == nil {
+				return nil
+			}
+		}
+
+		if err := invoker(ctx, method, req, reply, cc, callOpts...); err != nil {
+			return err
+		}
+		if b, err := proto.Marshal(msg); err == nil {
+			o.store.Set(ctx, key, b, ttl)
+		}
+		return nil
+	}
+}
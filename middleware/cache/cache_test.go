@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/ipfans/grpctools/middleware/auth/apikey"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := UnaryServerInterceptor(WithMethodTTL(map[string]time.Duration{"/test.Service/Get": time.Minute}))
+	var calls int
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+		return &wrappers.StringValue{Value: "hello " + req.(*wrappers.StringValue).Value}, nil
+	}
+
+	for i, tc := range []struct {
+		method string
+		req    string
+		calls  int
+	}{
+		{"/test.Service/Get", "alice", 1},
+		{"/test.Service/Get", "alice", 1},
+		{"/test.Service/Get", "bob", 2},
+		{"/test.Service/List", "alice", 3},
+		{"/test.Service/List", "alice", 4},
+	} {
+		resp, err := interceptor(context.Background(), &wrappers.StringValue{Value: tc.req}, &grpc.UnaryServerInfo{FullMethod: tc.method}, handler)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := "hello "+tc.req, resp.(*wrappers.StringValue).Value; want != have {
+			t.Errorf("%d: response: want %q, have %q", i, want, have)
+		}
+		if want, have := tc.calls, calls; want != have {
+			t.Errorf("%d: handler calls: want %d, have %d", i, want, have)
+		}
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	interceptor := UnaryClientInterceptor(WithMethodTTL(map[string]time.Duration{"/test.Service/Get": time.Minute}))
+	var calls int
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		reply.(*wrappers.StringValue).Value = "hello"
+		return nil
+	}
+	for i := 0; i < 3; i++ {
+		reply := &wrappers.StringValue{}
+		if err := interceptor(context.Background(), "/test.Service/Get", &wrappers.StringValue{}, reply, nil, invoker); err != nil {
+			t.Fatal(err)
+		}
+		if want, have := "hello", reply.Value; want != have {
+			t.Fatalf("%d: want %q, have %q", i, want, have)
+		}
+	}
+	if want, have := 1, calls; want != have {
+		t.Fatalf("calls: want %d, have %d", want, have)
+	}
+}
+
+func TestDefaultKey(t *testing.T) {
+	req := &structpb.Struct{Fields: make(map[string]*structpb.Value)}
+	for i := 0; i < 10; i++ {
+		req.Fields[strconv.Itoa(i)] = &structpb.Value{Kind: &structpb.Value_NumberValue{NumberValue: float64(i)}}
+	}
+	first, err := DefaultKey(context.Background(), "/test.Service/Get", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if have, _ := DefaultKey(context.Background(), "/test.Service/Get", req); first != have {
+			t.Fatalf("map encoding not deterministic: %q != %q", first, have)
+		}
+	}
+
+	keyOf := func(f KeyFunc, owner string) string {
+		ctx := apikey.NewContext(context.Background(), &apikey.Key{Owner: owner})
+		k, _ := f(ctx, "/test.Service/Get", req)
+		return k
+	}
+	if keyOf(DefaultKey, "alice") == keyOf(DefaultKey, "bob") {
+		t.Fatal("DefaultKey: same key for different callers")
+	}
+	if keyOf(SharedKey, "alice") != keyOf(SharedKey, "bob") {
+		t.Fatal("SharedKey: different keys for different callers")
+	}
+}
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(2)
+	c.Set(ctx, "a", []byte("1"), time.Minute)
+	c.Set(ctx, "b", []byte("2"), time.Minute)
+	c.Get(ctx, "a")
+	c.Set(ctx, "c", []byte("3"), time.Minute)
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, have, _ := c.Get(ctx, key); want != have {
+			t.Errorf("%s: want present %v, have %v", key, want, have)
+		}
+	}
+
+	c.Set(ctx, "d", []byte("4"), -time.Second)
+	if _, ok, _ := c.Get(ctx, "d"); ok {
+		t.Fatal("expired entry returned")
+	}
+	for i := 0; i < 10; i++ {
+		c.Set(ctx, strconv.Itoa(i), nil, time.Minute)
+	}
+	if want, have := 2, c.order.Len(); want != have {
+		t.Fatalf("size: want %d, have %d", want, have)
+	}
+}
@@ -0,0 +1,41 @@
+// Package redis provides a cache.Store in Redis, shared by all servers.
+package redis
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ipfans/grpctools/middleware/cache"
+	"golang.org/x/net/context"
+)
+
+// Store is a cache.Store in Redis.
+type Store struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+var _ cache.Store = (*Store)(nil)
+
+// New returns a Store keeping responses in client under keys starting with
+// prefix.
+func New(client redis.UniversalClient, prefix string) *Store {
+	return &Store{client: client, prefix: prefix}
+}
+
+// Get returns the value of key, false if it is missing or expired.
+func (s *Store) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	b, err := s.client.Get(ctx, s.prefix+key).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// Set stores value under key for ttl.
+func (s *Store) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, s.prefix+key, value, ttl).Err()
+}
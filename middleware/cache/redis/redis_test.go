@@ -0,0 +1,66 @@
+package redis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/net/context"
+)
+
+// fakeClient keeps values in a map, recording their expiration.
+type fakeClient struct {
+	redis.UniversalClient
+	values map[string]string
+	ttls   map[string]time.Duration
+	err    error
+}
+
+func (c *fakeClient) Get(ctx context.Context, key string) *redis.StringCmd {
+	if c.err != nil {
+		return redis.NewStringResult("", c.err)
+	}
+	v, ok := c.values[key]
+	if !ok {
+		return redis.NewStringResult("", redis.Nil)
+	}
+	return redis.NewStringResult(v, nil)
+}
+
+func (c *fakeClient) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	if c.err != nil {
+		return redis.NewStatusResult("", c.err)
+	}
+	c.values[key] = string(value.([]byte))
+	c.ttls[key] = expiration
+	return redis.NewStatusResult("OK", nil)
+}
+
+func TestStore(t *testing.T) {
+	client := &fakeClient{values: make(map[string]string), ttls: make(map[string]time.Duration)}
+	s := New(client, "cache:")
+	ctx := context.Background()
+
+	if _, ok, err := s.Get(ctx, "k"); ok || err != nil {
+		t.Fatalf("missing key: want miss, have ok %v, error %v", ok, err)
+	}
+	if err := s.Set(ctx, "k", []byte("v"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := time.Minute, client.ttls["cache:k"]; want != have {
+		t.Fatalf("ttl: want %v, have %v", want, have)
+	}
+	b, ok, err := s.Get(ctx, "k")
+	if !ok || err != nil {
+		t.Fatalf("stored key: want hit, have ok %v, error %v", ok, err)
+	}
+	if want, have := "v", string(b); want != have {
+		t.Fatalf("value: want %q, have %q", want, have)
+	}
+
+	client.err = errors.New("connection refused")
+	if _, ok, err := s.Get(ctx, "k"); ok || err == nil {
+		t.Fatalf("failing client: want error, have ok %v, error %v", ok, err)
+	}
+}
@@ -0,0 +1,116 @@
+// Package middleware provides helpers to compose the interceptors of its
+// subpackages.
+package middleware
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// ChainUnaryServer returns a unary server interceptor running interceptors in
+// order: the first is the outermost, seeing the request first and the
+// response last. Each interceptor gets the context passed on by the previous
+// one, and the handler the one passed on by the last.
+func ChainUnaryServer(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	switch len(interceptors) {
+	case 0:
+		return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			return handler(ctx, req)
+		}
+	case 1:
+		return interceptors[0]
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var next func(i int) grpc.UnaryHandler
+		next = func(i int) grpc.UnaryHandler {
+			if i == len(interceptors) {
+				return handler
+			}
+			return func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptors[i](ctx, req, info, next(i+1))
+			}
+		}
+		return next(0)(ctx, req)
+	}
+}
+
+// ChainStreamServer returns a streaming server interceptor running
+// interceptors in order, the first being the outermost. Each interceptor gets
+// the stream passed on by the previous one, so wrapped streams, e.g. with a
+// new context, reach the interceptors after it and the handler.
+func ChainStreamServer(interceptors ...grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	switch len(interceptors) {
+	case 0:
+		return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			return handler(srv, stream)
+		}
+	case 1:
+		return interceptors[0]
+	}
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		var next func(i int) grpc.StreamHandler
+		next = func(i int) grpc.StreamHandler {
+			if i == len(interceptors) {
+				return handler
+			}
+			return func(srv interface{}, stream grpc.ServerStream) error {
+				return interceptors[i](srv, stream, info, next(i+1))
+			}
+		}
+		return next(0)(srv, stream)
+	}
+}
+
+// ChainUnaryClient returns a unary client interceptor running interceptors in
+// order: the first is the outermost, seeing the call first and its result
+// last. Each interceptor gets the context and call options passed on by the
+// previous one, and the invoker those passed on by the last.
+func ChainUnaryClient(interceptors ...grpc.UnaryClientInterceptor) grpc.UnaryClientInterceptor {
+	switch len(interceptors) {
+	case 0:
+		return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+	case 1:
+		return interceptors[0]
+	}
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		var next func(i int) grpc.UnaryInvoker
+		next = func(i int) grpc.UnaryInvoker {
+			if i == len(interceptors) {
+				return invoker
+			}
+			return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				return interceptors[i](ctx, method, req, reply, cc, next(i+1), opts...)
+			}
+		}
+		return next(0)(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// ChainStreamClient returns a streaming client interceptor running
+// interceptors in order, the first being the outermost. Each interceptor gets
+// the context and call options passed on by the previous one, and the stream
+// returned by the next one.
+func ChainStreamClient(interceptors ...grpc.StreamClientInterceptor) grpc.StreamClientInterceptor {
+	switch len(interceptors) {
+	case 0:
+		return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+	case 1:
+		return interceptors[0]
+	}
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		var next func(i int) grpc.Streamer
+		next = func(i int) grpc.Streamer {
+			if i == len(interceptors) {
+				return streamer
+			}
+			return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+				return interceptors[i](ctx, desc, cc, method, next(i+1), opts...)
+			}
+		}
+		return next(0)(ctx, desc, cc, method, opts...)
+	}
+}
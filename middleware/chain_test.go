@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+type key string
+
+func TestChainUnaryServer(t *testing.T) {
+	var order []string
+	interceptor := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			order = append(order, name+" in")
+			resp, err := handler(context.WithValue(ctx, key(name), true), req)
+			order = append(order, name+" out")
+			return resp, err
+		}
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		order = append(order, "handler")
+		if ctx.Value(key("first")) == nil || ctx.Value(key("second")) == nil {
+			t.Error("handler: context values not passed on")
+		}
+		return "ok", nil
+	}
+
+	resp, err := ChainUnaryServer(interceptor("first"), interceptor("second"))(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+	if err != nil || resp != "ok" {
+		t.Fatalf("want ok, have %v, %v", resp, err)
+	}
+	if want, have := []string{"first in", "second in", "handler", "second out", "first out"}, order; !reflect.DeepEqual(want, have) {
+		t.Fatalf("order: want %v, have %v", want, have)
+	}
+
+	// The chain can be called again.
+	order = nil
+	ChainUnaryServer(interceptor("first"), interceptor("second"))(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+	if want, have := 5, len(order); want != have {
+		t.Fatalf("second call: want %d steps, have %d", want, have)
+	}
+}
+
+// backgroundStream is a stream with an empty context.
+type backgroundStream struct {
+	grpc.ServerStream
+}
+
+func (s *backgroundStream) Context() context.Context { return context.Background() }
+
+func TestChainStreamServer(t *testing.T) {
+	var order []string
+	interceptor := func(name string) grpc.StreamServerInterceptor {
+		return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			order = append(order, name)
+			wrapped := WrapServerStream(stream)
+			wrapped.SetContext(context.WithValue(stream.Context(), key(name), true))
+			return handler(srv, wrapped)
+		}
+	}
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		order = append(order, "handler")
+		if stream.Context().Value(key("first")) == nil || stream.Context().Value(key("second")) == nil {
+			t.Error("handler: stream not passed on")
+		}
+		return nil
+	}
+
+	if err := ChainStreamServer(interceptor("first"), interceptor("second"))(nil, &backgroundStream{}, &grpc.StreamServerInfo{}, handler); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := []string{"first", "second", "handler"}, order; !reflect.DeepEqual(want, have) {
+		t.Fatalf("order: want %v, have %v", want, have)
+	}
+}
+
+func TestChainUnaryClient(t *testing.T) {
+	var order []string
+	interceptor := func(name string) grpc.UnaryClientInterceptor {
+		return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			order = append(order, name)
+			return invoker(ctx, method, req, reply, cc, append(opts, grpc.EmptyCallOption{})...)
+		}
+	}
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		order = append(order, "invoker")
+		if want, have := 2, len(opts); want != have {
+			t.Errorf("call options: want %d, have %d", want, have)
+		}
+		return nil
+	}
+
+	if err := ChainUnaryClient(interceptor("first"), interceptor("second"))(context.Background(), "/test.Service/Get", nil, nil, nil, invoker); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := []string{"first", "second", "invoker"}, order; !reflect.DeepEqual(want, have) {
+		t.Fatalf("order: want %v, have %v", want, have)
+	}
+}
+
+func TestChainEmpty(t *testing.T) {
+	var called bool
+	ChainUnaryServer()(context.Background(), nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		called = true
+		return nil, nil
+	})
+	if !called {
+		t.Fatal("empty chain: handler not called")
+	}
+}
@@ -0,0 +1,187 @@
+// Package chaos provides server interceptors injecting faults, for resilience
+// testing in staging.
+package chaos
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"path"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Rule injects faults into a share of the requests to some methods. Each
+// fault is drawn independently.
+type Rule struct {
+	// Methods are full method names like "/foo.v1.UserService/Get", all
+	// methods of a service like "/foo.v1.UserService/*", or "*".
+	Methods []string `json:"methods"`
+
+	// Delay is added to a DelayFraction (0 to 1) of requests.
+	Delay         time.Duration `json:"delay_ns"`
+	DelayFraction float64       `json:"delay_fraction"`
+
+	// AbortCode is returned instead of serving an AbortFraction of requests.
+	// OK, the zero value, is replaced by Unavailable.
+	AbortCode     codes.Code `json:"abort_code"`
+	AbortFraction float64    `json:"abort_fraction"`
+
+	// CorruptFraction of responses get a random bit flipped in their
+	// encoding.
+	CorruptFraction float64 `json:"corrupt_fraction"`
+}
+
+func (r Rule) matches(method string) bool {
+	for _, m := range r.Methods {
+		if m == "*" || m == method || len(m) > 2 && m[len(m)-2:] == "/*" && path.Dir(method) == m[:len(m)-2] {
+			return true
+		}
+	}
+	return false
+}
+
+// Injector injects faults by rules which can be changed at runtime.
+type Injector struct {
+	rules atomic.Value // []Rule
+}
+
+// New returns an Injector of rules.
+func New(rules ...Rule) *Injector {
+	i := &Injector{}
+	i.SetRules(rules)
+	return i
+}
+
+// SetRules replaces the rules. No rules disables fault injection.
+func (i *Injector) SetRules(rules []Rule) {
+	rules = append([]Rule(nil), rules...)
+	for k := range rules {
+		// Aborting with OK would serve no response at all.
+		if rules[k].AbortCode == codes.OK {
+			rules[k].AbortCode = codes.Unavailable
+		}
+	}
+	i.rules.Store(rules)
+}
+
+// Rules returns the current rules.
+func (i *Injector) Rules() []Rule {
+	return i.rules.Load().([]Rule)
+}
+
+// ServeHTTP serves the rules as JSON, and replaces them with the JSON body of
+// PUT requests, so faults can be controlled at runtime, e.g.
+// http.Handle("/debug/chaos", injector).
+func (i *Injector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var rules []Rule
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		i.SetRules(rules)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(i.Rules())
+}
+
+// before delays or aborts a request to method, returning the rule matching it.
+func (i *Injector) before(ctx context.Context, method string) (*Rule, error) {
+	rules := i.Rules()
+	for k := range rules {
+		r := &rules[k]
+		if !r.matches(method) {
+			continue
+		}
+		if r.Delay > 0 && rand.Float64() < r.DelayFraction {
+			t := time.NewTimer(r.Delay)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return nil, status.FromContextError(ctx.Err()).Err()
+			case <-t.C:
+			}
+		}
+		if rand.Float64() < r.AbortFraction {
+			return nil, status.Errorf(r.AbortCode, "chaos: injected %v", r.AbortCode)
+		}
+		return r, nil
+	}
+	return nil, nil
+}
+
+// corrupt returns a copy of msg with a random bit of its encoding flipped. If
+// the result can't be decoded, an empty message is returned.
+func corrupt(msg interface{}) interface{} {
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return msg
+	}
+	b, err := proto.Marshal(m)
+	if err != nil || len(b) == 0 {
+		return msg
+	}
+	bit := rand.Intn(len(b) * 8)
+	b[bit/8] ^= 1 << uint(bit%8)
+	out := reflect.New(reflect.TypeOf(m).Elem()).Interface().(proto.Message)
+	if proto.Unmarshal(b, out) != nil {
+		out.Reset()
+	}
+	return out
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor injecting
+// faults by the rules.
+func (i *Injector) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		r, err := i.before(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := handler(ctx, req)
+		if err == nil && r != nil && rand.Float64() < r.CorruptFraction {
+			resp = corrupt(resp)
+		}
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor injecting
+// faults by the rules when streams open, and corrupting sent messages.
+func (i *Injector) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		r, err := i.before(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		if r != nil && r.CorruptFraction > 0 {
+			stream = &corruptingStream{ServerStream: stream, fraction: r.CorruptFraction}
+		}
+		return handler(srv, stream)
+	}
+}
+
+type corruptingStream struct {
+	grpc.ServerStream
+	fraction float64
+}
+
+func (s *corruptingStream) SendMsg(m interface{}) error {
+	if rand.Float64() < s.fraction {
+		m = corrupt(m)
+	}
+	return s.ServerStream.SendMsg(m)
+}
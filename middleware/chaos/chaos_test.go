@@ -0,0 +1,85 @@
+package chaos
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	i := New(
+		Rule{Methods: []string{"/test.Service/Get"}, AbortCode: codes.Unavailable, AbortFraction: 1},
+		Rule{Methods: []string{"/test.Service/*"}, Delay: 20 * time.Millisecond, DelayFraction: 1, CorruptFraction: 1},
+	)
+	interceptor := i.UnaryServerInterceptor()
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &wrappers.StringValue{Value: "hello"}, nil
+	}
+
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, handler)
+	if want, have := codes.Unavailable, status.Code(err); want != have {
+		t.Fatalf("abort: want %v, have %v", want, have)
+	}
+
+	start := time.Now()
+	resp, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/List"}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("delay: want at least 20ms, have %v", elapsed)
+	}
+	if proto.Equal(resp.(proto.Message), &wrappers.StringValue{Value: "hello"}) {
+		t.Fatal("response not corrupted")
+	}
+
+	resp, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/other.Service/Get"}, handler)
+	if err != nil || resp.(*wrappers.StringValue).Value != "hello" {
+		t.Fatalf("unmatched method: want hello, have %v, %v", resp, err)
+	}
+
+	i.SetRules(nil)
+	if _, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, handler); err != nil {
+		t.Fatalf("disabled: %v", err)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	i := New()
+	w := httptest.NewRecorder()
+	i.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/debug/chaos", strings.NewReader(`[{"methods":["*"],"abort_code":14,"abort_fraction":0.5}]`)))
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Fatalf("status: want %d, have %d: %s", want, have, w.Body)
+	}
+	rules := i.Rules()
+	if want, have := 1, len(rules); want != have {
+		t.Fatalf("rules: want %d, have %d", want, have)
+	}
+	if want, have := codes.Unavailable, rules[0].AbortCode; want != have {
+		t.Fatalf("abort code: want %v, have %v", want, have)
+	}
+}
+
+func TestAbortWithoutCode(t *testing.T) {
+	i := New()
+	w := httptest.NewRecorder()
+	i.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/debug/chaos", strings.NewReader(`[{"methods":["*"],"abort_fraction":1}]`)))
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Fatalf("PUT: want %d, have %d", want, have)
+	}
+	_, err := i.UnaryServerInterceptor()(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	if want, have := codes.Unavailable, status.Code(err); want != have {
+		t.Fatalf("abort without code: want %v, have %v", want, have)
+	}
+}
@@ -0,0 +1,435 @@
+// Package circuitbreaker provides interceptors failing fast while a target or
+// method keeps failing, giving it time to recover.
+package circuitbreaker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ipfans/grpctools/metrics"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// State of a breaker.
+type State int
+
+// States of a breaker. Closed breakers let calls through, open ones reject
+// them, and half-open ones let a few probes through to decide whether to
+// close again.
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// windowBuckets is the number of buckets the rolling window is split into.
+const windowBuckets = 10
+
+// KeyFunc returns the key of the breaker guarding calls to method of target.
+// Servers call it with an empty target.
+type KeyFunc func(target, method string) string
+
+// PerMethod keys breakers by target and method. It is the default.
+func PerMethod(target, method string) string {
+	return target + method
+}
+
+// PerTarget keys breakers by target, sharing one across all methods.
+func PerTarget(target, method string) string {
+	return target
+}
+
+type options struct {
+	failureRate float64
+	slowCall    time.Duration
+	slowRate    float64
+	minRequests int
+	window      time.Duration
+	openTimeout time.Duration
+	probes      int
+	failures    map[codes.Code]bool
+	key         KeyFunc
+	onChange    []func(key string, from, to State)
+	metrics     metrics.Provider
+}
+
+// Option for Group instance.
+type Option func(o *options)
+
+// WithFailureRate sets the share (0 to 1) of failed calls in the window above
+// which a breaker opens. Default is 0.5.
+func WithFailureRate(rate float64) Option {
+	return func(o *options) {
+		o.failureRate = rate
+	}
+}
+
+// WithSlowCalls makes a breaker open when the share (0 to 1) of calls taking
+// d or longer in the window reaches rate. Slow calls are not counted by
+// default.
+func WithSlowCalls(d time.Duration, rate float64) Option {
+	return func(o *options) {
+		o.slowCall = d
+		o.slowRate = rate
+	}
+}
+
+// WithMinRequests sets the number of calls in the window below which a
+// breaker never opens. Default is 20.
+func WithMinRequests(n int) Option {
+	return func(o *options) {
+		o.minRequests = n
+	}
+}
+
+// WithWindow sets the duration of the rolling window rates are computed
+// over. Default is 10 seconds.
+func WithWindow(d time.Duration) Option {
+	return func(o *options) {
+		o.window = d
+	}
+}
+
+// WithOpenTimeout sets how long a breaker stays open before letting probes
+// through. Default is 30 seconds.
+func WithOpenTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.openTimeout = d
+	}
+}
+
+// WithProbes sets the number of calls a half-open breaker lets through, all
+// of which must succeed for it to close; the first failing one opens it
+// again. Default is 5.
+func WithProbes(n int) Option {
+	return func(o *options) {
+		o.probes = n
+	}
+}
+
+// WithFailureCodes sets the status codes counted as failures. Default is
+// Unavailable, DeadlineExceeded, ResourceExhausted, Internal and Unknown:
+// errors caused by callers, like InvalidArgument or Canceled, say nothing of
+// the health of the target.
+func WithFailureCodes(cs ...codes.Code) Option {
+	return func(o *options) {
+		o.failures = make(map[codes.Code]bool)
+		for _, c := range cs {
+			o.failures[c] = true
+		}
+	}
+}
+
+// WithKeyFunc sets how calls are grouped into breakers. Default is
+// PerMethod.
+func WithKeyFunc(f KeyFunc) Option {
+	return func(o *options) {
+		o.key = f
+	}
+}
+
+// WithStateChangeHook adds a function called after a breaker changed state,
+// e.g. to log it. Hooks are called synchronously and must not block.
+func WithStateChangeHook(f func(key string, from, to State)) Option {
+	return func(o *options) {
+		o.onChange = append(o.onChange, f)
+	}
+}
+
+// WithMetrics reports breaker states and rejected calls through given
+// provider.
+func WithMetrics(p metrics.Provider) Option {
+	return func(o *options) {
+		o.metrics = p
+	}
+}
+
+// Group holds the breakers of a client or server, created on first use.
+type Group struct {
+	opts options
+	now  func() time.Time
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+
+	state       metrics.Gauge
+	transitions metrics.Counter
+	rejected    metrics.Counter
+}
+
+// New returns a Group of breakers.
+func New(opts ...Option) *Group {
+	o := options{
+		failureRate: 0.5,
+		minRequests: 20,
+		window:      10 * time.Second,
+		openTimeout: 30 * time.Second,
+		probes:      5,
+		failures: map[codes.Code]bool{
+			codes.Unavailable:       true,
+			codes.DeadlineExceeded:  true,
+			codes.ResourceExhausted: true,
+			codes.Internal:          true,
+			codes.Unknown:           true,
+		},
+		key:     PerMethod,
+		metrics: metrics.Discard,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.probes < 1 {
+		o.probes = 1
+	}
+	return &Group{
+		opts:        o,
+		now:         time.Now,
+		breakers:    make(map[string]*Breaker),
+		state:       o.metrics.NewGauge("grpc_circuitbreaker_state", "State of circuit breakers: 0 closed, 1 open, 2 half-open.", "key"),
+		transitions: o.metrics.NewCounter("grpc_circuitbreaker_transitions_total", "Total number of circuit breaker state changes.", "key", "state"),
+		rejected:    o.metrics.NewCounter("grpc_circuitbreaker_rejected_total", "Total number of calls rejected by open circuit breakers.", "key"),
+	}
+}
+
+// Breaker returns the breaker of key.
+func (g *Group) Breaker(key string) *Breaker {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b, ok := g.breakers[key]
+	if !ok {
+		b = &Breaker{g: g, key: key}
+		g.breakers[key] = b
+	}
+	return b
+}
+
+// changed reports a state change of the breaker of key.
+func (g *Group) changed(key string, from, to State) {
+	g.state.With(key).Set(float64(to))
+	g.transitions.With(key, to.String()).Add(1)
+	for _, f := range g.opts.onChange {
+		f(key, from, to)
+	}
+}
+
+// bucket counts the calls of a slice of the window.
+type bucket struct {
+	epoch                  int64
+	total, failures, slows int
+}
+
+// Breaker tracks the outcome of calls sharing a key.
+type Breaker struct {
+	g   *Group
+	key string
+
+	mu         sync.Mutex
+	state      State
+	generation int // incremented on state changes, so late outcomes are ignored
+	openedAt   time.Time
+	probes     int // probes let through while half-open
+	successes  int // successful probes
+	buckets    [windowBuckets]bucket
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == Open && b.g.now().Sub(b.openedAt) >= b.g.opts.openTimeout {
+		return HalfOpen
+	}
+	return b.state
+}
+
+// setState moves the breaker to state, returning the function reporting the
+// change, to be called once b.mu is released.
+func (b *Breaker) setState(state State, now time.Time) func() {
+	from := b.state
+	b.state = state
+	b.generation++
+	b.probes, b.successes = 0, 0
+	b.buckets = [windowBuckets]bucket{}
+	if state == Open {
+		b.openedAt = now
+	}
+	return func() { b.g.changed(b.key, from, state) }
+}
+
+// Allow reports whether a call may proceed. If it may, done must be called
+// with the outcome of the call and how long it took.
+func (b *Breaker) Allow() (done func(err error, elapsed time.Duration), err error) {
+	now := b.g.now()
+	b.mu.Lock()
+	var notify func()
+	if b.state == Open && now.Sub(b.openedAt) >= b.g.opts.openTimeout {
+		notify = b.setState(HalfOpen, now)
+	}
+	switch {
+	case b.state == Open, b.state == HalfOpen && b.probes >= b.g.opts.probes:
+		b.mu.Unlock()
+		if notify != nil {
+			notify()
+		}
+		b.g.rejected.With(b.key).Add(1)
+		return nil, status.Errorf(codes.Unavailable, "circuit breaker open for %s", b.key)
+	case b.state == HalfOpen:
+		b.probes++
+	}
+	generation := b.generation
+	b.mu.Unlock()
+	if notify != nil {
+		notify()
+	}
+	return func(err error, elapsed time.Duration) {
+		b.record(generation, err, elapsed)
+	}, nil
+}
+
+// record counts the outcome of a call allowed in generation.
+func (b *Breaker) record(generation int, err error, elapsed time.Duration) {
+	o := b.g.opts
+	failed := err != nil && o.failures[status.Code(err)]
+	slow := o.slowCall > 0 && elapsed >= o.slowCall
+	now := b.g.now()
+
+	b.mu.Lock()
+	if generation != b.generation {
+		b.mu.Unlock()
+		return
+	}
+	var notify func()
+	switch b.state {
+	case HalfOpen:
+		if failed || slow {
+			notify = b.setState(Open, now)
+			break
+		}
+		b.successes++
+		if b.successes >= o.probes {
+			notify = b.setState(Closed, now)
+		}
+	case Closed:
+		if b.add(now, failed, slow) {
+			notify = b.setState(Open, now)
+		}
+	}
+	b.mu.Unlock()
+	if notify != nil {
+		notify()
+	}
+}
+
+// add counts a call in the window, reporting whether the breaker should open.
+func (b *Breaker) add(now time.Time, failed, slow bool) bool {
+	width := int64(b.g.opts.window) / windowBuckets
+	if width <= 0 {
+		width = 1
+	}
+	epoch := now.UnixNano() / width
+	cur := &b.buckets[epoch%windowBuckets]
+	if cur.epoch != epoch {
+		*cur = bucket{epoch: epoch}
+	}
+	cur.total++
+	if failed {
+		cur.failures++
+	}
+	if slow {
+		cur.slows++
+	}
+
+	var total, failures, slows int
+	for _, bk := range b.buckets {
+		if epoch-bk.epoch < windowBuckets {
+			total += bk.total
+			failures += bk.failures
+			slows += bk.slows
+		}
+	}
+	o := b.g.opts
+	if total < o.minRequests {
+		return false
+	}
+	return float64(failures) >= o.failureRate*float64(total) ||
+		o.slowRate > 0 && float64(slows) >= o.slowRate*float64(total)
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor failing calls
+// with Unavailable while their breaker is open.
+func (g *Group) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		done, err := g.Breaker(g.opts.key(cc.Target(), method)).Allow()
+		if err != nil {
+			return err
+		}
+		start := time.Now()
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		done(err, time.Since(start))
+		return err
+	}
+}
+
+// StreamClientInterceptor returns a new streaming client interceptor failing
+// streams with Unavailable while their breaker is open. Only the opening of
+// streams is counted.
+func (g *Group) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		done, err := g.Breaker(g.opts.key(cc.Target(), method)).Allow()
+		if err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		done(err, time.Since(start))
+		return stream, err
+	}
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor failing
+// requests with Unavailable while the breaker of their method is open, e.g.
+// to shield a struggling dependency of the handlers.
+func (g *Group) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		done, err := g.Breaker(g.opts.key("", info.FullMethod)).Allow()
+		if err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		done(err, time.Since(start))
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor failing
+// streams with Unavailable while the breaker of their method is open. Streams
+// are counted once they end, so slow calls shouldn't be tracked for
+// long-lived streams.
+func (g *Group) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		done, err := g.Breaker(g.opts.key("", info.FullMethod)).Allow()
+		if err != nil {
+			return err
+		}
+		start := time.Now()
+		err = handler(srv, stream)
+		done(err, time.Since(start))
+		return err
+	}
+}
@@ -0,0 +1,144 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeClock is a clock advanced by tests.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func TestBreaker(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	var changes []State
+	g := New(
+		WithMinRequests(4),
+		WithOpenTimeout(time.Minute),
+		WithProbes(2),
+		WithStateChangeHook(func(key string, from, to State) { changes = append(changes, to) }),
+	)
+	g.now = clock.now
+	interceptor := g.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}
+	var calls int
+	call := func(err error) error {
+		_, err = interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			calls++
+			return nil, err
+		})
+		return err
+	}
+	unavailable := status.Error(codes.Unavailable, "down")
+
+	// Caller errors count as successes.
+	call(status.Error(codes.InvalidArgument, "bad"))
+	call(nil)
+	call(unavailable)
+	if want, have := Closed, g.Breaker("/test.Service/Get").State(); want != have {
+		t.Fatalf("below min requests: want %v, have %v", want, have)
+	}
+	call(unavailable)
+	if want, have := Open, g.Breaker("/test.Service/Get").State(); want != have {
+		t.Fatalf("at failure rate: want %v, have %v", want, have)
+	}
+
+	calls = 0
+	if err := call(nil); status.Code(err) != codes.Unavailable || calls != 0 {
+		t.Fatalf("open: want fast Unavailable, have %v after %d calls", err, calls)
+	}
+
+	// A failing probe opens the breaker again.
+	clock.t = clock.t.Add(time.Minute)
+	if err := call(unavailable); err != unavailable {
+		t.Fatalf("probe: want handler error, have %v", err)
+	}
+	if want, have := Open, g.Breaker("/test.Service/Get").State(); want != have {
+		t.Fatalf("failed probe: want %v, have %v", want, have)
+	}
+
+	// Successful probes close it.
+	clock.t = clock.t.Add(time.Minute)
+	call(nil)
+	call(nil)
+	if want, have := Closed, g.Breaker("/test.Service/Get").State(); want != have {
+		t.Fatalf("successful probes: want %v, have %v", want, have)
+	}
+
+	for i, want := range []State{Open, HalfOpen, Open, HalfOpen, Closed} {
+		if i >= len(changes) || changes[i] != want {
+			t.Fatalf("state changes: want %v at %d, have %v", want, i, changes)
+		}
+	}
+}
+
+func TestHalfOpenProbeLimit(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	g := New(WithMinRequests(1), WithProbes(1), WithOpenTimeout(time.Second))
+	g.now = clock.now
+	b := g.Breaker("k")
+	done, _ := b.Allow()
+	done(status.Error(codes.Unavailable, "down"), 0)
+
+	clock.t = clock.t.Add(time.Second)
+	probe, err := b.Allow()
+	if err != nil {
+		t.Fatalf("first probe: %v", err)
+	}
+	if _, err := b.Allow(); status.Code(err) != codes.Unavailable {
+		t.Fatalf("second probe: want Unavailable, have %v", err)
+	}
+	probe(nil, 0)
+	if want, have := Closed, b.State(); want != have {
+		t.Fatalf("want %v, have %v", want, have)
+	}
+}
+
+func TestSlowCalls(t *testing.T) {
+	g := New(WithMinRequests(2), WithSlowCalls(time.Second, 0.5))
+	b := g.Breaker("k")
+	for _, elapsed := range []time.Duration{10 * time.Millisecond, 2 * time.Second} {
+		done, err := b.Allow()
+		if err != nil {
+			t.Fatal(err)
+		}
+		done(nil, elapsed)
+	}
+	if want, have := Open, b.State(); want != have {
+		t.Fatalf("want %v, have %v", want, have)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	g := New(WithMinRequests(2), WithWindow(10*time.Second))
+	g.now = clock.now
+	b := g.Breaker("k")
+	record := func(err error) {
+		done, _ := b.Allow()
+		done(err, 0)
+	}
+	record(status.Error(codes.Unavailable, "down"))
+	clock.t = clock.t.Add(11 * time.Second)
+	record(nil)
+	record(nil)
+	record(status.Error(codes.Unavailable, "down"))
+	if want, have := Closed, b.State(); want != have {
+		t.Fatalf("failures out of the window counted: want %v, have %v", want, have)
+	}
+}
+
+func TestKeyFunc(t *testing.T) {
+	if want, have := "dns:///users/test.Service/Get", PerMethod("dns:///users", "/test.Service/Get"); want != have {
+		t.Fatalf("PerMethod: want %q, have %q", want, have)
+	}
+	if want, have := "dns:///users", PerTarget("dns:///users", "/test.Service/Get"); want != have {
+		t.Fatalf("PerTarget: want %q, have %q", want, have)
+	}
+}
@@ -0,0 +1,182 @@
+// Package compression provides client helpers compressing calls, and server
+// interceptors requiring compression of large messages per method.
+//
+// Compressors must be registered on both sides, e.g. by importing
+// google.golang.org/grpc/encoding/gzip.
+package compression
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/ipfans/grpctools/metrics"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+)
+
+// DialOption returns a DialOption compressing every call of a connection with
+// the registered compressor name, like "gzip".
+func DialOption(name string) grpc.DialOption {
+	return grpc.WithDefaultCallOptions(grpc.UseCompressor(name))
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor compressing
+// requests of at least minSize encoded bytes with the registered compressor
+// name, leaving smaller ones, which compression rarely shrinks, as is.
+func UnaryClientInterceptor(name string, minSize int) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if m, ok := req.(proto.Message); ok && proto.Size(m) >= minSize {
+			opts = append(opts, grpc.UseCompressor(name))
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// Encoding returns the compressor of the messages of the call of ctx on the
+// server, like "gzip", or "" if they aren't compressed.
+func Encoding(ctx context.Context) string {
+	s, ok := grpc.ServerTransportStreamFromContext(ctx).(interface {
+		RecvCompress() string
+	})
+	if !ok || s.RecvCompress() == encoding.Identity {
+		return ""
+	}
+	return s.RecvCompress()
+}
+
+// Rule sets the sizes in encoded bytes above which messages of a method must
+// be compressed. Zero disables a check.
+type Rule struct {
+	Request int
+	// Response applies to calls whose request isn't compressed, as gRPC
+	// compresses responses with the compressor of the request: clients
+	// expecting large responses must compress their requests.
+	Response int
+}
+
+type options struct {
+	rule    Rule
+	methods map[string]Rule
+	metrics metrics.Provider
+}
+
+// Option for compression interceptors.
+type Option func(o *options)
+
+// WithRule sets the rule of methods without a method rule.
+func WithRule(r Rule) Option {
+	return func(o *options) {
+		o.rule = r
+	}
+}
+
+// WithMethodRules sets rules per full method name, like
+// "/foo.v1.UserService/Upload".
+func WithMethodRules(rules map[string]Rule) Option {
+	return func(o *options) {
+		o.methods = rules
+	}
+}
+
+// WithMetrics reports rejected messages through given provider.
+func WithMetrics(p metrics.Provider) Option {
+	return func(o *options) {
+		o.metrics = p
+	}
+}
+
+type enforcer struct {
+	opts     options
+	rejected metrics.Counter
+}
+
+func newEnforcer(opts []Option) *enforcer {
+	o := options{metrics: metrics.Discard}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &enforcer{
+		opts:     o,
+		rejected: o.metrics.NewCounter("grpc_compression_rejected_total", "Total number of uncompressed messages rejected for their size.", "method", "direction"),
+	}
+}
+
+func (e *enforcer) rule(method string) Rule {
+	if r, ok := e.opts.methods[method]; ok {
+		return r
+	}
+	return e.opts.rule
+}
+
+// check returns a ResourceExhausted error if msg is larger than max.
+func (e *enforcer) check(method, direction string, msg interface{}, max int) error {
+	m, ok := msg.(proto.Message)
+	if max <= 0 || !ok {
+		return nil
+	}
+	size := proto.Size(m)
+	if size <= max {
+		return nil
+	}
+	e.rejected.With(method, direction).Add(1)
+	return status.Errorf(codes.ResourceExhausted, "compression: uncompressed %s message of %d bytes exceeds %d bytes, compress the call", direction, size, max)
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor rejecting
+// calls without compression whose request or response is above the sizes of
+// their rule with ResourceExhausted.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	e := newEnforcer(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if Encoding(ctx) != "" {
+			return handler(ctx, req)
+		}
+		r := e.rule(info.FullMethod)
+		if err := e.check(info.FullMethod, "request", req, r.Request); err != nil {
+			return nil, err
+		}
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return resp, err
+		}
+		if err := e.check(info.FullMethod, "response", resp, r.Response); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor failing
+// RecvMsg and SendMsg of streams without compression for messages above the
+// sizes of their rule with ResourceExhausted.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	e := newEnforcer(opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if Encoding(stream.Context()) != "" {
+			return handler(srv, stream)
+		}
+		return handler(srv, &enforcedStream{ServerStream: stream, e: e, method: info.FullMethod, rule: e.rule(info.FullMethod)})
+	}
+}
+
+type enforcedStream struct {
+	grpc.ServerStream
+	e      *enforcer
+	method string
+	rule   Rule
+}
+
+func (s *enforcedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return s.e.check(s.method, "request", m, s.rule.Request)
+}
+
+func (s *enforcedStream) SendMsg(m interface{}) error {
+	if err := s.e.check(s.method, "response", m, s.rule.Response); err != nil {
+		return err
+	}
+	return s.ServerStream.SendMsg(m)
+}
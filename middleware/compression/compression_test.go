@@ -0,0 +1,131 @@
+package compression
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// transportStream is a server transport stream receiving messages compressed
+// with encoding.
+type transportStream struct {
+	encoding string
+}
+
+func (s *transportStream) Method() string                  { return "/test.Service/Get" }
+func (s *transportStream) SetHeader(md metadata.MD) error  { return nil }
+func (s *transportStream) SendHeader(md metadata.MD) error { return nil }
+func (s *transportStream) SetTrailer(md metadata.MD) error { return nil }
+func (s *transportStream) RecvCompress() string            { return s.encoding }
+
+func TestEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"gzip", grpc.NewContextWithServerTransportStream(context.Background(), &transportStream{encoding: "gzip"}), "gzip"},
+		{"identity", grpc.NewContextWithServerTransportStream(context.Background(), &transportStream{encoding: "identity"}), ""},
+		{"none", grpc.NewContextWithServerTransportStream(context.Background(), &transportStream{}), ""},
+		{"no stream", context.Background(), ""},
+	} {
+		if have := Encoding(tc.ctx); tc.want != have {
+			t.Errorf("%s: want %q, have %q", tc.name, tc.want, have)
+		}
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := UnaryServerInterceptor(
+		WithRule(Rule{Request: 10, Response: 10}),
+		WithMethodRules(map[string]Rule{"/test.Service/Upload": {Request: 100}}),
+	)
+	echo := func(ctx context.Context, req interface{}) (interface{}, error) { return req, nil }
+	reply := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &wrappers.StringValue{Value: strings.Repeat("x", 50)}, nil
+	}
+
+	for _, tc := range []struct {
+		method   string
+		encoding string
+		size     int
+		handler  grpc.UnaryHandler
+		code     codes.Code
+	}{
+		{"/test.Service/Get", "", 5, echo, codes.OK},
+		{"/test.Service/Get", "", 50, echo, codes.ResourceExhausted},
+		{"/test.Service/Get", "gzip", 50, echo, codes.OK},
+		{"/test.Service/Get", "", 5, reply, codes.ResourceExhausted},
+		{"/test.Service/Get", "gzip", 5, reply, codes.OK},
+		{"/test.Service/Upload", "", 50, reply, codes.OK},
+		{"/test.Service/Upload", "", 500, echo, codes.ResourceExhausted},
+	} {
+		ctx := grpc.NewContextWithServerTransportStream(context.Background(), &transportStream{encoding: tc.encoding})
+		_, err := interceptor(ctx, &wrappers.StringValue{Value: strings.Repeat("x", tc.size)}, &grpc.UnaryServerInfo{FullMethod: tc.method}, tc.handler)
+		if want, have := tc.code, status.Code(err); want != have {
+			t.Errorf("%s with %d bytes and encoding %q: want %v, have %v", tc.method, tc.size, tc.encoding, want, have)
+		}
+	}
+}
+
+type testStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testStream) Context() context.Context    { return s.ctx }
+func (s *testStream) SendMsg(m interface{}) error { return nil }
+
+func TestStreamServerInterceptor(t *testing.T) {
+	interceptor := StreamServerInterceptor(WithRule(Rule{Response: 10}))
+	large := &wrappers.StringValue{Value: strings.Repeat("x", 50)}
+
+	for _, tc := range []struct {
+		encoding string
+		code     codes.Code
+	}{
+		{"", codes.ResourceExhausted},
+		{"gzip", codes.OK},
+	} {
+		stream := &testStream{ctx: grpc.NewContextWithServerTransportStream(context.Background(), &transportStream{encoding: tc.encoding})}
+		err := interceptor(nil, stream, &grpc.StreamServerInfo{FullMethod: "/test.Service/Watch"}, func(srv interface{}, stream grpc.ServerStream) error {
+			return stream.SendMsg(large)
+		})
+		if want, have := tc.code, status.Code(err); want != have {
+			t.Errorf("encoding %q: want %v, have %v", tc.encoding, want, have)
+		}
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	interceptor := UnaryClientInterceptor("gzip", 10)
+	for _, tc := range []struct {
+		size int
+		want string
+	}{
+		{5, ""},
+		{50, "gzip"},
+	} {
+		var have string
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			for _, opt := range opts {
+				if c, ok := opt.(grpc.CompressorCallOption); ok {
+					have = c.CompressorType
+				}
+			}
+			return nil
+		}
+		if err := interceptor(context.Background(), "/test.Service/Get", &wrappers.StringValue{Value: strings.Repeat("x", tc.size)}, nil, nil, invoker); err != nil {
+			t.Fatal(err)
+		}
+		if tc.want != have {
+			t.Errorf("%d bytes: want %q, have %q", tc.size, tc.want, have)
+		}
+	}
+}
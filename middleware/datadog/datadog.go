@@ -0,0 +1,248 @@
+// Package datadog provides interceptors tracing RPCs as Datadog APM spans
+// through dd-trace-go, for services reporting to a Datadog agent rather than
+// an OpenTelemetry collector. The tracer must be started with tracer.Start.
+package datadog
+
+import (
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/ipfans/grpctools/middleware"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+// CodeTag is the span tag of the status code of RPCs, like "NotFound".
+const CodeTag = "grpc.code"
+
+type options struct {
+	service string
+	errors  map[codes.Code]bool
+}
+
+// Option for datadog interceptors.
+type Option func(o *options)
+
+// WithServiceName sets the APM service of spans. Default is "grpc.server" for
+// server interceptors and "grpc.client" for client interceptors.
+func WithServiceName(name string) Option {
+	return func(o *options) {
+		o.service = name
+	}
+}
+
+// WithErrorCodes sets the status codes of RPCs marked as errors. Default is
+// codes caused by the server: Unknown, DeadlineExceeded, Unimplemented,
+// Internal, Unavailable and DataLoss.
+func WithErrorCodes(cs ...codes.Code) Option {
+	return func(o *options) {
+		o.errors = make(map[codes.Code]bool)
+		for _, c := range cs {
+			o.errors[c] = true
+		}
+	}
+}
+
+func newOptions(opts []Option, service string) options {
+	o := options{
+		service: service,
+		errors: map[codes.Code]bool{
+			codes.Unknown:          true,
+			codes.DeadlineExceeded: true,
+			codes.Unimplemented:    true,
+			codes.Internal:         true,
+			codes.Unavailable:      true,
+			codes.DataLoss:         true,
+		},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// start starts a span named operation of an RPC to method.
+func (o options) start(ctx context.Context, operation, kind, method string, parent ddtrace.SpanContext) (ddtrace.Span, context.Context) {
+	opts := []ddtrace.StartSpanOption{
+		tracer.ServiceName(o.service),
+		tracer.ResourceName(method),
+		tracer.SpanType(ext.AppTypeRPC),
+		tracer.Measured(),
+		tracer.Tag(ext.SpanKind, kind),
+		tracer.Tag(ext.RPCSystem, ext.RPCSystemGRPC),
+		tracer.Tag(ext.RPCService, path.Dir(method)[1:]),
+		tracer.Tag(ext.RPCMethod, path.Base(method)),
+		tracer.Tag(ext.GRPCFullMethod, method),
+	}
+	if parent != nil {
+		opts = append(opts, tracer.ChildOf(parent))
+	}
+	return tracer.StartSpanFromContext(ctx, operation, opts...)
+}
+
+// finish finishes span of an RPC which returned err.
+func (o options) finish(span ddtrace.Span, err error) {
+	code := status.Code(err)
+	span.SetTag(CodeTag, code.String())
+	if o.errors[code] {
+		span.Finish(tracer.WithError(err))
+		return
+	}
+	span.Finish()
+}
+
+// carrier reads and writes span contexts in metadata.
+type carrier metadata.MD
+
+func (c carrier) Set(key, val string) {
+	k := strings.ToLower(key)
+	c[k] = append(c[k], val)
+}
+
+func (c carrier) ForeachKey(handler func(key, val string) error) error {
+	for k, vs := range c {
+		for _, v := range vs {
+			if err := handler(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// serverSpan starts the span of an incoming RPC, child of the span of the
+// caller if any.
+func (o options) serverSpan(ctx context.Context, method string) (ddtrace.Span, context.Context) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	parent, err := tracer.Extract(carrier(md))
+	if err != nil {
+		parent = nil
+	}
+	return o.start(ctx, "grpc.server", ext.SpanKindServer, method, parent)
+}
+
+// clientSpan starts the span of an outgoing call, injecting it in the
+// outgoing metadata of the returned context.
+func (o options) clientSpan(ctx context.Context, method string) (ddtrace.Span, context.Context) {
+	span, ctx := o.start(ctx, "grpc.client", ext.SpanKindClient, method, nil)
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	if err := tracer.Inject(span.Context(), carrier(md)); err == nil {
+		ctx = metadata.NewOutgoingContext(ctx, md)
+	}
+	return span, ctx
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor tracing every
+// RPC, continuing the trace of the caller.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts, "grpc.server")
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		span, ctx := o.serverSpan(ctx, info.FullMethod)
+		resp, err := handler(ctx, req)
+		o.finish(span, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor tracing
+// every stream, continuing the trace of the caller.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts, "grpc.server")
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		span, ctx := o.serverSpan(stream.Context(), info.FullMethod)
+		wrapped := middleware.WrapServerStream(stream)
+		wrapped.SetContext(ctx)
+		err := handler(srv, wrapped)
+		o.finish(span, err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor tracing every
+// call and propagating its trace to the server.
+func UnaryClientInterceptor(opts ...Option) grpc.UnaryClientInterceptor {
+	o := newOptions(opts, "grpc.client")
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		span, ctx := o.clientSpan(ctx, method)
+		err := invoker(ctx, method, req, reply, cc, callOpts...)
+		o.finish(span, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor returns a new streaming client interceptor tracing
+// every stream and propagating its trace to the server. Spans finish once
+// RecvMsg returns an error, io.EOF counting as OK, once a client-streaming
+// call receives its response, or once the context of the call is done.
+func StreamClientInterceptor(opts ...Option) grpc.StreamClientInterceptor {
+	o := newOptions(opts, "grpc.client")
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		span, ctx := o.clientSpan(ctx, method)
+		stream, err := streamer(ctx, desc, cc, method, callOpts...)
+		if err != nil {
+			o.finish(span, err)
+			return nil, err
+		}
+		s := &clientStream{
+			ClientStream:  stream,
+			serverStreams: desc.ServerStreams,
+			ended:         make(chan struct{}),
+			done: func(err error) {
+				o.finish(span, err)
+			},
+		}
+		go s.watch(ctx)
+		return s, nil
+	}
+}
+
+// clientStream calls done when a stream ends.
+type clientStream struct {
+	grpc.ClientStream
+	serverStreams bool
+	done          func(err error)
+	once          sync.Once
+	ended         chan struct{}
+}
+
+// end calls done once.
+func (s *clientStream) end(err error) {
+	s.once.Do(func() {
+		close(s.ended)
+		s.done(err)
+	})
+}
+
+// watch ends the stream when ctx is done first, so abandoned streams are
+// traced as canceled.
+func (s *clientStream) watch(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		s.end(status.FromContextError(ctx.Err()).Err())
+	case <-s.ended:
+	}
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == nil:
+		if !s.serverStreams {
+			s.end(nil)
+		}
+	case err == io.EOF:
+		s.end(nil)
+	default:
+		s.end(err)
+	}
+	return err
+}
@@ -0,0 +1,66 @@
+package datadog
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+)
+
+func TestInterceptors(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	server := UnaryServerInterceptor(WithServiceName("users"))
+	client := UnaryClientInterceptor()
+	// The client call is received by the server as incoming metadata.
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		_, err := server(metadata.NewIncomingContext(context.Background(), md), req, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, status.Error(codes.NotFound, "no such user")
+		})
+		return err
+	}
+	if err := client(context.Background(), "/users.v1.UserService/Get", nil, nil, nil, invoker); status.Code(err) != codes.NotFound {
+		t.Fatalf("want %v, have %v", codes.NotFound, status.Code(err))
+	}
+
+	spans := mt.FinishedSpans()
+	if want, have := 2, len(spans); want != have {
+		t.Fatalf("want %d spans, have %d", want, have)
+	}
+	srv, cli := spans[0], spans[1]
+	for _, tc := range []struct {
+		name       string
+		have, want interface{}
+	}{
+		{"server operation", srv.OperationName(), "grpc.server"},
+		{"client operation", cli.OperationName(), "grpc.client"},
+		{"server service", srv.Tag(ext.ServiceName), "users"},
+		{"client service", cli.Tag(ext.ServiceName), "grpc.client"},
+		{"resource", srv.Tag(ext.ResourceName), "/users.v1.UserService/Get"},
+		{"rpc service", srv.Tag(ext.RPCService), "users.v1.UserService"},
+		{"kind", srv.Tag(ext.SpanKind), ext.SpanKindServer},
+		{"code", srv.Tag(CodeTag), "NotFound"},
+		{"error", srv.Tag(ext.Error), nil},
+		{"trace", srv.TraceID(), cli.TraceID()},
+		{"parent", srv.ParentID(), cli.SpanID()},
+	} {
+		if tc.want != tc.have {
+			t.Errorf("%s: want %v, have %v", tc.name, tc.want, tc.have)
+		}
+	}
+
+	mt.Reset()
+	server(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/users.v1.UserService/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.Unavailable, "database down")
+	})
+	if have := mt.FinishedSpans()[0].Tag(ext.Error); have == nil {
+		t.Fatalf("unavailable: want error tag")
+	}
+}
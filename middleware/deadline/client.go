@@ -0,0 +1,115 @@
+// Package deadline provides interceptors setting and enforcing call deadlines.
+package deadline
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type options struct {
+	timeout  time.Duration
+	timeouts map[string]time.Duration
+	reserve  time.Duration
+	fraction float64
+
+	max     time.Duration
+	require bool
+	clamp   bool
+	exempt  map[string]bool
+}
+
+// Option for deadline interceptors.
+type Option func(o *options)
+
+// WithTimeout sets the timeout the client interceptor applies to calls
+// without a deadline, for methods without a method timeout. Zero, the
+// default, leaves such calls alone.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithMethodTimeouts sets the timeouts the client interceptor applies per
+// full method name, like "/foo.v1.UserService/Get", to calls without a
+// deadline.
+func WithMethodTimeouts(timeouts map[string]time.Duration) Option {
+	return func(o *options) {
+		o.timeouts = timeouts
+	}
+}
+
+// WithReserve makes the client interceptor keep d of the remaining time of
+// calls with a deadline, typically inherited from the incoming call, so the
+// handler has time left to respond after a downstream call times out.
+func WithReserve(d time.Duration) Option {
+	return func(o *options) {
+		o.reserve = d
+	}
+}
+
+// WithReserveFraction makes the client interceptor keep the fraction f of the
+// remaining time of calls with a deadline. The larger of the reserves set by
+// WithReserve and WithReserveFraction is kept.
+func WithReserveFraction(f float64) Option {
+	return func(o *options) {
+		o.fraction = f
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{exempt: make(map[string]bool)}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Reserve returns a copy of ctx whose deadline keeps the larger of d and the
+// fraction f of the time remaining before the deadline of ctx, for the caller
+// to use after the returned context expires. A ctx without deadline is
+// returned as is.
+func Reserve(ctx context.Context, d time.Duration, f float64) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	remaining := time.Until(deadline)
+	if r := time.Duration(float64(remaining) * f); r > d {
+		d = r
+	}
+	return context.WithDeadline(ctx, deadline.Add(-d))
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor setting a
+// default deadline on calls made without one, so a forgotten deadline can't
+// hang forever. With a reserve, calls with a deadline get a shorter one so
+// they can't consume the whole budget of the caller.
+func UnaryClientInterceptor(opts ...Option) grpc.UnaryClientInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); ok && (o.reserve > 0 || o.fraction > 0) {
+			var cancel context.CancelFunc
+			ctx, cancel = Reserve(ctx, o.reserve, o.fraction)
+			defer cancel()
+			if ctx.Err() != nil {
+				return status.Errorf(codes.DeadlineExceeded, "middleware/deadline: no time left for %s after reserve", method)
+			}
+		} else if !ok {
+			timeout, ok := o.timeouts[method]
+			if !ok {
+				timeout = o.timeout
+			}
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+		}
+		return invoker(ctx, method, req, reply, cc, callOpts...)
+	}
+}
@@ -0,0 +1,127 @@
+package deadline
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// deadlineInvoker records the remaining time of the call deadline, zero if
+// there is none.
+func deadlineInvoker(remaining *time.Duration) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		*remaining = 0
+		if d, ok := ctx.Deadline(); ok {
+			*remaining = time.Until(d)
+		}
+		return nil
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	interceptor := UnaryClientInterceptor(WithTimeout(time.Second), WithMethodTimeouts(map[string]time.Duration{"/test.Service/Slow": time.Minute}))
+	short, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	for _, tc := range []struct {
+		ctx      context.Context
+		method   string
+		min, max time.Duration
+	}{
+		{context.Background(), "/test.Service/Get", 900 * time.Millisecond, time.Second},
+		{context.Background(), "/test.Service/Slow", 59 * time.Second, time.Minute},
+		{short, "/test.Service/Slow", 0, 10 * time.Millisecond},
+	} {
+		var remaining time.Duration
+		if err := interceptor(tc.ctx, tc.method, nil, nil, nil, deadlineInvoker(&remaining)); err != nil {
+			t.Fatal(err)
+		}
+		if remaining < tc.min || remaining > tc.max {
+			t.Errorf("%s: want deadline in %v to %v, have %v", tc.method, tc.min, tc.max, remaining)
+		}
+	}
+
+	var remaining time.Duration
+	UnaryClientInterceptor()(context.Background(), "/test.Service/Get", nil, nil, nil, deadlineInvoker(&remaining))
+	if want, have := time.Duration(0), remaining; want != have {
+		t.Fatalf("no timeout: want %v, have %v", want, have)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, tc := range []struct {
+		name     string
+		opts     []Option
+		min, max time.Duration
+	}{
+		{"absolute", []Option{WithReserve(100 * time.Millisecond)}, 800 * time.Millisecond, 900 * time.Millisecond},
+		{"fraction", []Option{WithReserveFraction(0.5)}, 400 * time.Millisecond, 500 * time.Millisecond},
+		{"larger wins", []Option{WithReserve(100 * time.Millisecond), WithReserveFraction(0.5)}, 400 * time.Millisecond, 500 * time.Millisecond},
+	} {
+		var remaining time.Duration
+		if err := UnaryClientInterceptor(tc.opts...)(ctx, "/test.Service/Get", nil, nil, nil, deadlineInvoker(&remaining)); err != nil {
+			t.Fatal(err)
+		}
+		if remaining < tc.min || remaining > tc.max {
+			t.Errorf("%s: want deadline in %v to %v, have %v", tc.name, tc.min, tc.max, remaining)
+		}
+	}
+
+	var called bool
+	err := UnaryClientInterceptor(WithReserve(time.Hour))(ctx, "/test.Service/Get", nil, nil, nil, func(context.Context, string, interface{}, interface{}, *grpc.ClientConn, ...grpc.CallOption) error {
+		called = true
+		return nil
+	})
+	if want, have := codes.DeadlineExceeded, status.Code(err); want != have {
+		t.Fatalf("budget exhausted: want %v, have %v", want, have)
+	}
+	if called {
+		t.Fatal("budget exhausted: call made")
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}
+	long, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	var remaining time.Duration
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		remaining = 0
+		if d, ok := ctx.Deadline(); ok {
+			remaining = time.Until(d)
+		}
+		return nil, nil
+	}
+
+	for _, tc := range []struct {
+		name     string
+		opts     []Option
+		ctx      context.Context
+		code     codes.Code
+		min, max time.Duration
+	}{
+		{"no policy", nil, context.Background(), codes.OK, 0, 0},
+		{"missing", []Option{WithRequireDeadline()}, context.Background(), codes.InvalidArgument, 0, 0},
+		{"missing clamped", []Option{WithRequireDeadline(), WithMaxDeadline(time.Second), WithClamp()}, context.Background(), codes.OK, 900 * time.Millisecond, time.Second},
+		{"missing allowed", []Option{WithMaxDeadline(time.Second)}, context.Background(), codes.OK, 0, 0},
+		{"too long", []Option{WithMaxDeadline(time.Second)}, long, codes.InvalidArgument, 0, 0},
+		{"too long clamped", []Option{WithMaxDeadline(time.Second), WithClamp()}, long, codes.OK, 900 * time.Millisecond, time.Second},
+		{"within", []Option{WithMaxDeadline(2 * time.Hour)}, long, codes.OK, 59 * time.Minute, time.Hour},
+		{"exempt", []Option{WithRequireDeadline(), WithExemptMethods(info.FullMethod)}, context.Background(), codes.OK, 0, 0},
+	} {
+		remaining = -1
+		_, err := UnaryServerInterceptor(tc.opts...)(tc.ctx, nil, info, handler)
+		if want, have := tc.code, status.Code(err); want != have {
+			t.Errorf("%s: want %v, have %v", tc.name, want, have)
+			continue
+		}
+		if err == nil && (remaining < tc.min || remaining > tc.max) {
+			t.Errorf("%s: want deadline in %v to %v, have %v", tc.name, tc.min, tc.max, remaining)
+		}
+	}
+}
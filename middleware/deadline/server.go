@@ -0,0 +1,99 @@
+package deadline
+
+import (
+	"time"
+
+	"github.com/ipfans/grpctools/middleware"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// WithMaxDeadline sets the longest deadline the server interceptors accept.
+// Zero, the default, accepts any deadline.
+func WithMaxDeadline(d time.Duration) Option {
+	return func(o *options) {
+		o.max = d
+	}
+}
+
+// WithRequireDeadline makes the server interceptors reject requests without a
+// deadline, or give them the maximum deadline if WithClamp is set.
+func WithRequireDeadline() Option {
+	return func(o *options) {
+		o.require = true
+	}
+}
+
+// WithClamp makes the server interceptors shorten deadlines above the maximum
+// to the maximum instead of rejecting the request.
+func WithClamp() Option {
+	return func(o *options) {
+		o.clamp = true
+	}
+}
+
+// WithExemptMethods sets full method names the server interceptors don't
+// check, like long-lived watch streams.
+func WithExemptMethods(methods ...string) Option {
+	return func(o *options) {
+		for _, m := range methods {
+			o.exempt[m] = true
+		}
+	}
+}
+
+// enforce checks the deadline of ctx, returning the context to serve the
+// request with and a cancel func to call when done.
+func (o options) enforce(ctx context.Context, method string) (context.Context, context.CancelFunc, error) {
+	if o.exempt[method] {
+		return ctx, func() {}, nil
+	}
+	d, ok := ctx.Deadline()
+	switch {
+	case !ok && o.require && !(o.clamp && o.max > 0):
+		return nil, nil, status.Error(codes.InvalidArgument, "request has no deadline")
+	case !ok && !o.require, o.max <= 0:
+		return ctx, func() {}, nil
+	case ok && time.Until(d) <= o.max:
+		return ctx, func() {}, nil
+	case !o.clamp:
+		return nil, nil, status.Errorf(codes.InvalidArgument, "request deadline exceeds the maximum of %v", o.max)
+	}
+	ctx, cancel := context.WithTimeout(ctx, o.max)
+	return ctx, cancel, nil
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor enforcing the
+// deadline policy, so clients can't pin server resources indefinitely.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, cancel, err := o.enforce(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor enforcing
+// the deadline policy.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, cancel, err := o.enforce(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		if ctx != stream.Context() {
+			wrapped := middleware.WrapServerStream(stream)
+			wrapped.SetContext(ctx)
+			stream = wrapped
+		}
+		return handler(srv, stream)
+	}
+}
@@ -0,0 +1,145 @@
+// Package deprecation provides server interceptors warning callers of
+// deprecated methods through response trailers, and counting their calls so
+// API owners know who still has to migrate.
+package deprecation
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/ipfans/grpctools/metrics"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Trailer keys set on the responses of deprecated methods, named after their
+// HTTP counterparts.
+const (
+	// DeprecationKey is "true".
+	DeprecationKey = "deprecation"
+	// SunsetKey is the date the method stops being served, as an HTTP date
+	// (RFC 8594).
+	SunsetKey = "sunset"
+	// LinkKey is the URL of the migration guide.
+	LinkKey = "link"
+	// WarningKey is a human readable warning with code 299 (RFC 7234), like
+	// `299 - "/foo.v1.UserService/Get is deprecated, use /foo.v2.UserService/Get"`.
+	WarningKey = "warning"
+)
+
+// Deprecation describes a deprecated method or service. All fields are
+// optional.
+type Deprecation struct {
+	// Sunset is the date the method stops being served.
+	Sunset time.Time
+	// Replacement names what to call instead, like "/foo.v2.UserService/Get".
+	Replacement string
+	// Link is the URL of the migration guide.
+	Link string
+}
+
+// Policy sets deprecations per full method name, like
+// "/foo.v1.UserService/Get", or per service, like "foo.v1.UserService", the
+// most specific winning.
+type Policy map[string]Deprecation
+
+func (p Policy) lookup(method string) (Deprecation, bool) {
+	if d, ok := p[method]; ok {
+		return d, true
+	}
+	d, ok := p[path.Dir(method)[1:]]
+	return d, ok
+}
+
+type options struct {
+	metrics metrics.Provider
+}
+
+// Option for deprecation interceptors.
+type Option func(o *options)
+
+// WithMetrics counts the calls to deprecated methods through given provider.
+func WithMetrics(p metrics.Provider) Option {
+	return func(o *options) {
+		o.metrics = p
+	}
+}
+
+type warner struct {
+	policy Policy
+	calls  metrics.Counter
+}
+
+func newWarner(policy Policy, opts []Option) *warner {
+	o := options{metrics: metrics.Discard}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &warner{
+		policy: policy,
+		calls:  o.metrics.NewCounter("grpc_deprecated_calls_total", "Total number of calls to deprecated methods.", "method", "sunset"),
+	}
+}
+
+// trailer returns the warning trailer of a call to method, nil if method
+// isn't deprecated.
+func (w *warner) trailer(method string) metadata.MD {
+	d, ok := w.policy.lookup(method)
+	if !ok {
+		return nil
+	}
+	sunset := ""
+	if !d.Sunset.IsZero() {
+		sunset = d.Sunset.UTC().Format("2006-01-02")
+	}
+	w.calls.With(method, sunset).Add(1)
+	return Trailer(method, d)
+}
+
+// Trailer returns the trailer the interceptors set on calls to a method
+// deprecated by d.
+func Trailer(method string, d Deprecation) metadata.MD {
+	md := metadata.Pairs(DeprecationKey, "true")
+	text := method + " is deprecated"
+	if !d.Sunset.IsZero() {
+		md.Set(SunsetKey, d.Sunset.UTC().Format(http.TimeFormat))
+		text += fmt.Sprintf(" and will be removed on %s", d.Sunset.UTC().Format("2006-01-02"))
+	}
+	if d.Replacement != "" {
+		text += ", use " + d.Replacement
+	}
+	if d.Link != "" {
+		md.Set(LinkKey, fmt.Sprintf("<%s>; rel=%q", d.Link, "deprecation"))
+		text += ", see " + d.Link
+	}
+	md.Set(WarningKey, "299 - "+strconv.Quote(text))
+	return md
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor adding
+// warning trailers to the responses of methods deprecated by policy.
+func UnaryServerInterceptor(policy Policy, opts ...Option) grpc.UnaryServerInterceptor {
+	w := newWarner(policy, opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if md := w.trailer(info.FullMethod); md != nil {
+			grpc.SetTrailer(ctx, md)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor adding
+// warning trailers to the streams of methods deprecated by policy.
+func StreamServerInterceptor(policy Policy, opts ...Option) grpc.StreamServerInterceptor {
+	w := newWarner(policy, opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if md := w.trailer(info.FullMethod); md != nil {
+			stream.SetTrailer(md)
+		}
+		return handler(srv, stream)
+	}
+}
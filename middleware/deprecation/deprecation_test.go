@@ -0,0 +1,130 @@
+package deprecation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ipfans/grpctools/metrics"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// trailerStream records the trailer set by unary interceptors.
+type trailerStream struct {
+	trailer metadata.MD
+}
+
+func (s *trailerStream) Method() string                  { return "/foo.v1.UserService/Get" }
+func (s *trailerStream) SetHeader(md metadata.MD) error  { return nil }
+func (s *trailerStream) SendHeader(md metadata.MD) error { return nil }
+func (s *trailerStream) SetTrailer(md metadata.MD) error {
+	s.trailer = metadata.Join(s.trailer, md)
+	return nil
+}
+
+// serverStream records the trailer set by streaming interceptors.
+type serverStream struct {
+	grpc.ServerStream
+	trailer metadata.MD
+}
+
+func (s *serverStream) Context() context.Context { return context.Background() }
+func (s *serverStream) SetTrailer(md metadata.MD) {
+	s.trailer = metadata.Join(s.trailer, md)
+}
+
+type counts map[string]float64
+
+func (c counts) NewCounter(string, string, ...string) metrics.Counter {
+	return counter{c: c}
+}
+func (c counts) NewGauge(string, string, ...string) metrics.Gauge {
+	return metrics.Discard.NewGauge("", "")
+}
+func (c counts) NewHistogram(string, string, ...string) metrics.Histogram {
+	return metrics.Discard.NewHistogram("", "")
+}
+
+type counter struct {
+	c      counts
+	labels string
+}
+
+func (c counter) With(labelValues ...string) metrics.Counter {
+	for _, v := range labelValues {
+		c.labels += v + ";"
+	}
+	return c
+}
+func (c counter) Add(delta float64) { c.c[c.labels] += delta }
+
+var policy = Policy{
+	"/foo.v1.UserService/Get": {
+		Sunset:      time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC),
+		Replacement: "/foo.v2.UserService/Get",
+		Link:        "https://example.com/migrate",
+	},
+	"foo.v1.LegacyService": {},
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	c := counts{}
+	interceptor := UnaryServerInterceptor(policy, WithMetrics(c))
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	for _, tc := range []struct {
+		method  string
+		warning string
+		sunset  string
+		link    string
+	}{
+		{"/foo.v1.UserService/Get", `299 - "/foo.v1.UserService/Get is deprecated and will be removed on 2018-06-01, use /foo.v2.UserService/Get, see https://example.com/migrate"`, "Fri, 01 Jun 2018 00:00:00 GMT", `<https://example.com/migrate>; rel="deprecation"`},
+		{"/foo.v1.LegacyService/List", `299 - "/foo.v1.LegacyService/List is deprecated"`, "", ""},
+		{"/foo.v1.UserService/Create", "", "", ""},
+	} {
+		stream := &trailerStream{}
+		ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+		if _, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tc.method}, handler); err != nil {
+			t.Fatalf("%s: %v", tc.method, err)
+		}
+		for key, want := range map[string]string{WarningKey: tc.warning, SunsetKey: tc.sunset, LinkKey: tc.link} {
+			have := ""
+			if v := stream.trailer.Get(key); len(v) > 0 {
+				have = v[0]
+			}
+			if want != have {
+				t.Errorf("%s: %s: want %q, have %q", tc.method, key, want, have)
+			}
+		}
+		if want, have := tc.warning != "", len(stream.trailer.Get(DeprecationKey)) > 0; want != have {
+			t.Errorf("%s: deprecation: want %v, have %v", tc.method, want, have)
+		}
+	}
+
+	if want, have := 1.0, c["/foo.v1.UserService/Get;2018-06-01;"]; want != have {
+		t.Fatalf("calls: want %v, have %v", want, have)
+	}
+	if want, have := 1.0, c["/foo.v1.LegacyService/List;;"]; want != have {
+		t.Fatalf("calls without sunset: want %v, have %v", want, have)
+	}
+	if want, have := 2, len(c); want != have {
+		t.Fatalf("series: want %d, have %d", want, have)
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	interceptor := StreamServerInterceptor(policy)
+	stream := &serverStream{}
+	err := interceptor(nil, stream, &grpc.StreamServerInfo{FullMethod: "/foo.v1.LegacyService/Watch"}, func(srv interface{}, stream grpc.ServerStream) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := `299 - "/foo.v1.LegacyService/Watch is deprecated"`, stream.trailer.Get(WarningKey); len(have) != 1 || want != have[0] {
+		t.Fatalf("warning: want %q, have %v", want, have)
+	}
+}
@@ -0,0 +1,172 @@
+// Package errmap provides server interceptors turning the domain errors of
+// handlers into gRPC statuses by a mapping table, so handlers can return
+// plain errors and still produce consistent statuses on the wire.
+package errmap
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+	grpcerrors "github.com/ipfans/grpctools/errors"
+	"github.com/ipfans/grpctools/middleware/locale"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type options struct {
+	hide      bool
+	localized bool
+}
+
+// Option for Mapper instance.
+type Option func(o *options)
+
+// WithHiddenUnmapped replaces errors matching no rule with Internal and a
+// generic message, so their text doesn't leak to clients. By default they
+// are returned as is, which gRPC sends as Unknown with the error text.
+func WithHiddenUnmapped() Option {
+	return func(o *options) {
+		o.hide = true
+	}
+}
+
+// WithLocalizedMessages adds a LocalizedMessage detail to the statuses of
+// requests with a localizer of the locale interceptors, which must run
+// first. The message is the catalog entry of the reason of the ErrorInfo
+// detail of the status, so the reason stays stable for programs while users
+// read the message in their language. Statuses without a reason, without a
+// catalog entry or already localized are left as is.
+func WithLocalizedMessages() Option {
+	return func(o *options) {
+		o.localized = true
+	}
+}
+
+// rule converts the errors it matches, returning nil for others.
+type rule func(err error) *status.Status
+
+// Mapper maps errors to statuses by rules, tried in the order they were
+// registered. Rules are registered before serving; a Mapper is not safe for
+// registration while serving.
+type Mapper struct {
+	opts  options
+	rules []rule
+}
+
+// New returns a Mapper without rules.
+func New(opts ...Option) *Mapper {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Mapper{opts: o}
+}
+
+// Is maps errors matching target by errors.Is, like ErrNotFound, to code,
+// with the error text as message and the details given.
+func (m *Mapper) Is(target error, code codes.Code, details ...proto.Message) {
+	m.rules = append(m.rules, func(err error) *status.Status {
+		if !errors.Is(err, target) {
+			return nil
+		}
+		return withDetails(status.New(code, err.Error()), details)
+	})
+}
+
+// As maps errors with an error of the type of target in their chain, found
+// by errors.As, to the status f returns for that error. target is a typed
+// nil, like (*ValidationError)(nil). As panics if target isn't an error type.
+func (m *Mapper) As(target error, f func(err error) *status.Status) {
+	typ := reflect.TypeOf(target)
+	if typ == nil {
+		panic("errmap: As target must be a typed error")
+	}
+	m.rules = append(m.rules, func(err error) *status.Status {
+		match := reflect.New(typ)
+		if !errors.As(err, match.Interface()) {
+			return nil
+		}
+		return f(match.Elem().Interface().(error))
+	})
+}
+
+func withDetails(st *status.Status, details []proto.Message) *status.Status {
+	if len(details) == 0 {
+		return st
+	}
+	if detailed, err := st.WithDetails(details...); err == nil {
+		return detailed
+	}
+	return st
+}
+
+// Map returns the status error of err. Status errors and nil are returned as
+// is, and context errors are converted to Canceled or DeadlineExceeded.
+func (m *Mapper) Map(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	for _, r := range m.rules {
+		if st := r(err); st != nil {
+			return st.Err()
+		}
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	if m.opts.hide {
+		return status.Error(codes.Internal, "internal error")
+	}
+	return err
+}
+
+// mapContext maps err like Map, localizing its message for the request of
+// ctx if enabled.
+func (m *Mapper) mapContext(ctx context.Context, err error) error {
+	err = m.Map(err)
+	if err == nil || !m.opts.localized {
+		return err
+	}
+	l, ok := locale.FromContext(ctx)
+	if !ok {
+		return err
+	}
+	info, ok := grpcerrors.ErrorInfo(err)
+	if !ok {
+		return err
+	}
+	if _, ok := grpcerrors.LocalizedMessage(err); ok {
+		return err
+	}
+	msg, ok := l.Format(info.Reason)
+	if !ok {
+		return err
+	}
+	return grpcerrors.From(err).Localized(l.Lang, msg).Err()
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor mapping the
+// errors of handlers.
+func (m *Mapper) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		return resp, m.mapContext(ctx, err)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor mapping
+// the errors of handlers.
+func (m *Mapper) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return m.mapContext(stream.Context(), handler(srv, stream))
+	}
+}
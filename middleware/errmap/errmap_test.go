@@ -0,0 +1,123 @@
+package errmap
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	grpcerrors "github.com/ipfans/grpctools/errors"
+	"github.com/ipfans/grpctools/middleware/locale"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var errNotFound = errors.New("user not found")
+
+type validationError struct {
+	field, reason string
+}
+
+func (e *validationError) Error() string { return e.field + ": " + e.reason }
+
+func TestMap(t *testing.T) {
+	m := New()
+	m.Is(errNotFound, codes.NotFound, &errdetails.ResourceInfo{ResourceType: "user"})
+	m.As((*validationError)(nil), func(err error) *status.Status {
+		v := err.(*validationError)
+		st, _ := status.New(codes.InvalidArgument, "invalid request").WithDetails(&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: v.field, Description: v.reason}},
+		})
+		return st
+	})
+
+	for _, tc := range []struct {
+		name    string
+		err     error
+		code    codes.Code
+		details int
+	}{
+		{"nil", nil, codes.OK, 0},
+		{"sentinel", errNotFound, codes.NotFound, 1},
+		{"wrapped sentinel", fmt.Errorf("loading profile: %w", errNotFound), codes.NotFound, 1},
+		{"type", &validationError{"email", "malformed"}, codes.InvalidArgument, 1},
+		{"wrapped type", fmt.Errorf("signup: %w", &validationError{"email", "malformed"}), codes.InvalidArgument, 1},
+		{"status", status.Error(codes.Aborted, "conflict"), codes.Aborted, 0},
+		{"context", fmt.Errorf("query: %w", context.DeadlineExceeded), codes.DeadlineExceeded, 0},
+		{"unmapped", errors.New("disk full"), codes.Unknown, 0},
+	} {
+		st := status.Convert(m.Map(tc.err))
+		if want, have := tc.code, st.Code(); want != have {
+			t.Errorf("%s: code: want %v, have %v", tc.name, want, have)
+		}
+		if want, have := tc.details, len(st.Details()); want != have {
+			t.Errorf("%s: details: want %d, have %d", tc.name, want, have)
+		}
+	}
+
+	if bad, ok := status.Convert(m.Map(&validationError{"email", "malformed"})).Details()[0].(*errdetails.BadRequest); !ok || bad.FieldViolations[0].Field != "email" {
+		t.Errorf("type: want BadRequest of email, have %v", bad)
+	}
+}
+
+func TestHiddenUnmapped(t *testing.T) {
+	interceptor := New(WithHiddenUnmapped()).UnaryServerInterceptor()
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, errors.New("pq: password authentication failed for user \"admin\"")
+	})
+	st := status.Convert(err)
+	if want, have := codes.Internal, st.Code(); want != have {
+		t.Fatalf("code: want %v, have %v", want, have)
+	}
+	if want, have := "internal error", st.Message(); want != have {
+		t.Fatalf("message: want %q, have %q", want, have)
+	}
+}
+
+func TestLocalizedMessages(t *testing.T) {
+	m := New(WithLocalizedMessages())
+	m.Is(errNotFound, codes.NotFound, &errdetails.ErrorInfo{Reason: "USER_NOT_FOUND", Domain: "users.example.com"})
+	interceptor := m.UnaryServerInterceptor()
+	catalog := locale.Messages{
+		"en": {"USER_NOT_FOUND": "This user doesn't exist."},
+		"fr": {"USER_NOT_FOUND": "Cet utilisateur n'existe pas."},
+	}
+
+	var ctx context.Context
+	locale.UnaryServerInterceptor(catalog, locale.WithLanguages("en", "fr"))(
+		metadata.NewIncomingContext(context.Background(), metadata.Pairs("accept-language", "fr-CH, en;q=0.5")),
+		nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"},
+		func(c context.Context, req interface{}) (interface{}, error) {
+			ctx = c
+			return nil, nil
+		})
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, errNotFound
+	})
+	msg, ok := grpcerrors.LocalizedMessage(err)
+	if !ok {
+		t.Fatal("no LocalizedMessage")
+	}
+	if want, have := "fr", msg.Locale; want != have {
+		t.Fatalf("locale: want %q, have %q", want, have)
+	}
+	if want, have := "Cet utilisateur n'existe pas.", msg.Message; want != have {
+		t.Fatalf("message: want %q, have %q", want, have)
+	}
+	if info, _ := grpcerrors.ErrorInfo(err); info == nil || info.Reason != "USER_NOT_FOUND" {
+		t.Fatalf("reason: want USER_NOT_FOUND, have %v", info)
+	}
+	if want, have := errNotFound.Error(), status.Convert(err).Message(); want != have {
+		t.Fatalf("status message: want %q, have %q", want, have)
+	}
+
+	_, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, errNotFound
+	})
+	if _, ok := grpcerrors.LocalizedMessage(err); ok {
+		t.Fatal("localized message without localizer")
+	}
+}
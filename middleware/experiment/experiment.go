@@ -0,0 +1,191 @@
+// Package experiment provides server interceptors assigning requests to the
+// variants of experiments by a stable key, like the user ID, for A/B testing
+// new code paths.
+package experiment
+
+import (
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	"github.com/ipfans/grpctools/metrics"
+	"github.com/ipfans/grpctools/middleware"
+	"github.com/ipfans/grpctools/middleware/auth/jwt"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// HeaderKey is the response header listing the assignments of a request, like
+// "checkout=new,search=control".
+const HeaderKey = "x-experiments"
+
+// Variant of an experiment, assigned to a share of keys proportional to its
+// weight.
+type Variant struct {
+	Name   string
+	Weight int
+}
+
+// Experiment splits keys between variants. The assignment of a key only
+// depends on the name of the experiment and the weights of its variants, so it
+// is stable across requests and servers.
+type Experiment struct {
+	Name     string
+	Variants []Variant
+}
+
+// Assign returns the variant of key, or "" if no variant has a weight.
+func (e Experiment) Assign(key string) string {
+	total := 0
+	for _, v := range e.Variants {
+		if v.Weight > 0 {
+			total += v.Weight
+		}
+	}
+	if total == 0 {
+		return ""
+	}
+	h := fnv.New64a()
+	h.Write([]byte(e.Name))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	n := int(h.Sum64() % uint64(total))
+	for _, v := range e.Variants {
+		if v.Weight <= 0 {
+			continue
+		}
+		if n < v.Weight {
+			return v.Name
+		}
+		n -= v.Weight
+	}
+	return ""
+}
+
+// Assignments are the variants of a request, by experiment name.
+type Assignments map[string]string
+
+// String formats a like HeaderKey, sorted by experiment.
+func (a Assignments) String() string {
+	pairs := make([]string, 0, len(a))
+	for name, variant := range a {
+		pairs = append(pairs, name+"="+variant)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying a.
+func NewContext(ctx context.Context, a Assignments) context.Context {
+	return context.WithValue(ctx, contextKey{}, a)
+}
+
+// FromContext returns the assignments of a request, nil if it has none.
+func FromContext(ctx context.Context) Assignments {
+	a, _ := ctx.Value(contextKey{}).(Assignments)
+	return a
+}
+
+// VariantOf returns the variant of the experiment name assigned to the
+// request of ctx, "" if it has none.
+func VariantOf(ctx context.Context, name string) string {
+	return FromContext(ctx)[name]
+}
+
+// SubjectKey returns the subject of the JWT a request was authenticated with
+// by the jwt interceptors, which must run first.
+func SubjectKey(ctx context.Context) (string, bool) {
+	claims, ok := jwt.FromContext(ctx)
+	if !ok || claims.Subject == "" {
+		return "", false
+	}
+	return claims.Subject, true
+}
+
+type options struct {
+	key     func(ctx context.Context) (string, bool)
+	metrics metrics.Provider
+}
+
+// Option for experiment interceptors.
+type Option func(o *options)
+
+// WithKey sets how the key of requests is found, false for requests
+// without one, which aren't assigned. Default is SubjectKey.
+func WithKey(f func(ctx context.Context) (string, bool)) Option {
+	return func(o *options) {
+		o.key = f
+	}
+}
+
+// WithMetrics counts assignments through given provider.
+func WithMetrics(p metrics.Provider) Option {
+	return func(o *options) {
+		o.metrics = p
+	}
+}
+
+type assigner struct {
+	experiments []Experiment
+	key         func(ctx context.Context) (string, bool)
+	assigned    metrics.Counter
+}
+
+func newAssigner(experiments []Experiment, opts []Option) *assigner {
+	o := options{
+		key:     SubjectKey,
+		metrics: metrics.Discard,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &assigner{
+		experiments: experiments,
+		key:         o.key,
+		assigned:    o.metrics.NewCounter("grpc_experiment_assignments_total", "Total number of requests assigned to experiment variants.", "experiment", "variant"),
+	}
+}
+
+// assign returns ctx with the assignments of its request, and sets the
+// HeaderKey header.
+func (a *assigner) assign(ctx context.Context) context.Context {
+	key, ok := a.key(ctx)
+	if !ok {
+		return ctx
+	}
+	assignments := make(Assignments, len(a.experiments))
+	for _, e := range a.experiments {
+		if v := e.Assign(key); v != "" {
+			assignments[e.Name] = v
+			a.assigned.With(e.Name, v).Add(1)
+		}
+	}
+	if len(assignments) == 0 {
+		return ctx
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(HeaderKey, assignments.String()))
+	return NewContext(ctx, assignments)
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor assigning
+// requests with a key to a variant of each experiment.
+func UnaryServerInterceptor(experiments []Experiment, opts ...Option) grpc.UnaryServerInterceptor {
+	a := newAssigner(experiments, opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(a.assign(ctx), req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor
+// assigning streams with a key to a variant of each experiment.
+func StreamServerInterceptor(experiments []Experiment, opts ...Option) grpc.StreamServerInterceptor {
+	a := newAssigner(experiments, opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		wrapped := middleware.WrapServerStream(stream)
+		wrapped.SetContext(a.assign(stream.Context()))
+		return handler(srv, wrapped)
+	}
+}
@@ -0,0 +1,91 @@
+package experiment
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/ipfans/grpctools/middleware/auth/jwt"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+var checkout = Experiment{Name: "checkout", Variants: []Variant{{"control", 80}, {"new", 20}}}
+
+func TestAssign(t *testing.T) {
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		v := checkout.Assign(key)
+		if again := checkout.Assign(key); v != again {
+			t.Fatalf("%s: assigned %q then %q", key, v, again)
+		}
+		counts[v]++
+	}
+	if share := float64(counts["new"]) / 10000; math.Abs(share-0.2) > 0.02 {
+		t.Fatalf("new: want 20%% of keys, have %.1f%%", share*100)
+	}
+	if want, have := "", (Experiment{Name: "empty", Variants: []Variant{{"a", 0}}}).Assign("user-1"); want != have {
+		t.Fatalf("no weight: want %q, have %q", want, have)
+	}
+
+	// Assignments of different experiments are independent.
+	other := Experiment{Name: "search", Variants: checkout.Variants}
+	same := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		if checkout.Assign(key) == "new" && other.Assign(key) == "new" {
+			same++
+		}
+	}
+	if same > 100 {
+		t.Fatalf("correlated experiments: %d keys in both new variants", same)
+	}
+}
+
+// headerStream records the header set by interceptors.
+type headerStream struct {
+	header metadata.MD
+}
+
+func (s *headerStream) Method() string { return "/test.Service/Get" }
+func (s *headerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+func (s *headerStream) SendHeader(md metadata.MD) error { return nil }
+func (s *headerStream) SetTrailer(md metadata.MD) error { return nil }
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	always := Experiment{Name: "search", Variants: []Variant{{"v2", 1}}}
+	interceptor := UnaryServerInterceptor([]Experiment{checkout, always})
+	var have Assignments
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		have = FromContext(ctx)
+		return nil, nil
+	}
+
+	stream := &headerStream{}
+	ctx := grpc.NewContextWithServerTransportStream(jwt.NewContext(context.Background(), &jwt.Claims{Subject: "alice"}), stream)
+	if _, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, handler); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := checkout.Assign("alice"), have["checkout"]; want != have {
+		t.Fatalf("checkout: want %q, have %q", want, have)
+	}
+	if want, have := "v2", have["search"]; want != have {
+		t.Fatalf("search: want %q, have %q", want, have)
+	}
+	if want, have := "checkout="+checkout.Assign("alice")+",search=v2", stream.header.Get(HeaderKey); len(have) != 1 || want != have[0] {
+		t.Fatalf("header: want %q, have %v", want, have)
+	}
+
+	have = nil
+	if _, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, handler); err != nil {
+		t.Fatal(err)
+	}
+	if have != nil {
+		t.Fatalf("without key: want no assignments, have %v", have)
+	}
+}
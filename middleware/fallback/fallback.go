@@ -0,0 +1,252 @@
+// Package fallback provides interceptors degrading gracefully: calls failing
+// because a handler or a downstream service is unavailable or too slow are
+// answered with a fallback response, like the last good one or a static
+// default, rather than an error.
+package fallback
+
+import (
+	"path"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ipfans/grpctools/metrics"
+	"github.com/ipfans/grpctools/middleware/cache"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// DegradedKey is the response header set to "true" on fallback responses, so
+// clients know they may be stale or partial.
+const DegradedKey = "x-degraded"
+
+// Fallback answers calls that failed.
+type Fallback interface {
+	// Fallback returns the response to req of a call to method that failed
+	// with err, or an error, like err, if it has none.
+	Fallback(ctx context.Context, method string, req interface{}, err error) (interface{}, error)
+}
+
+// Func is an adapter to use a function as a Fallback.
+type Func func(ctx context.Context, method string, req interface{}, err error) (interface{}, error)
+
+// Fallback calls f.
+func (f Func) Fallback(ctx context.Context, method string, req interface{}, err error) (interface{}, error) {
+	return f(ctx, method, req, err)
+}
+
+// Static returns a Fallback answering with a copy of resp.
+func Static(resp proto.Message) Fallback {
+	return Func(func(ctx context.Context, method string, req interface{}, err error) (interface{}, error) {
+		return proto.Clone(resp), nil
+	})
+}
+
+// First returns a Fallback answering with the first of fallbacks having a
+// response, like LastGood then Static.
+func First(fallbacks ...Fallback) Fallback {
+	return first(fallbacks)
+}
+
+type first []Fallback
+
+func (fs first) Fallback(ctx context.Context, method string, req interface{}, err error) (interface{}, error) {
+	for _, f := range fs {
+		resp, ferr := f.Fallback(ctx, method, req, err)
+		if ferr == nil {
+			return resp, nil
+		}
+	}
+	return nil, err
+}
+
+func (fs first) observe(ctx context.Context, method string, req, resp interface{}) {
+	for _, f := range fs {
+		if o, ok := f.(observer); ok {
+			o.observe(ctx, method, req, resp)
+		}
+	}
+}
+
+// observer is implemented by fallbacks learning from successful calls.
+type observer interface {
+	observe(ctx context.Context, method string, req, resp interface{})
+}
+
+// LastGood is a Fallback answering with the last successful response to an
+// equal request, kept in a cache.Store.
+type LastGood struct {
+	store cache.Store
+	key   cache.KeyFunc
+	ttl   time.Duration
+	types sync.Map // method to reflect.Type of responses
+}
+
+// NewLastGood returns a LastGood keeping responses in store for ttl, the
+// oldest response it may answer with, under keys of key, like
+// cache.DefaultKey.
+func NewLastGood(store cache.Store, key cache.KeyFunc, ttl time.Duration) *LastGood {
+	return &LastGood{store: store, key: key, ttl: ttl}
+}
+
+func (l *LastGood) observe(ctx context.Context, method string, req, resp interface{}) {
+	msg, ok := resp.(proto.Message)
+	if !ok {
+		return
+	}
+	key, err := l.key(ctx, method, req)
+	if err != nil {
+		return
+	}
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return
+	}
+	l.types.Store(method, reflect.TypeOf(msg))
+	l.store.Set(ctx, key, b, l.ttl)
+}
+
+// Fallback returns the last successful response to req, or err if there is
+// none.
+func (l *LastGood) Fallback(ctx context.Context, method string, req interface{}, err error) (interface{}, error) {
+	typ, ok := l.types.Load(method)
+	if !ok {
+		return nil, err
+	}
+	key, kerr := l.key(ctx, method, req)
+	if kerr != nil {
+		return nil, err
+	}
+	b, ok, serr := l.store.Get(ctx, key)
+	if serr != nil || !ok {
+		return nil, err
+	}
+	resp := reflect.New(typ.(reflect.Type).Elem()).Interface().(proto.Message)
+	if proto.Unmarshal(b, resp) != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+type options struct {
+	codes   map[codes.Code]bool
+	metrics metrics.Provider
+}
+
+// Option for fallback interceptors.
+type Option func(o *options)
+
+// WithCodes sets the codes of errors answered with fallbacks. Default is
+// Unavailable and DeadlineExceeded.
+func WithCodes(cs ...codes.Code) Option {
+	return func(o *options) {
+		o.codes = make(map[codes.Code]bool)
+		for _, c := range cs {
+			o.codes[c] = true
+		}
+	}
+}
+
+// WithMetrics counts fallback responses through given provider.
+func WithMetrics(p metrics.Provider) Option {
+	return func(o *options) {
+		o.metrics = p
+	}
+}
+
+type degrader struct {
+	fallbacks map[string]Fallback
+	codes     map[codes.Code]bool
+	served    metrics.Counter
+}
+
+func newDegrader(fallbacks map[string]Fallback, opts []Option, side string) *degrader {
+	o := options{
+		codes:   map[codes.Code]bool{codes.Unavailable: true, codes.DeadlineExceeded: true},
+		metrics: metrics.Discard,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &degrader{
+		fallbacks: fallbacks,
+		codes:     o.codes,
+		served:    o.metrics.NewCounter("grpc_"+side+"_fallback_total", "Total number of failed calls answered with a fallback response, or not if none was available.", "method", "result"),
+	}
+}
+
+// fallbackOf returns the fallback of method, by full method name or service.
+func (d *degrader) fallbackOf(method string) Fallback {
+	if f, ok := d.fallbacks[method]; ok {
+		return f
+	}
+	return d.fallbacks[path.Dir(method)[1:]]
+}
+
+// answer returns the fallback response of a call to method which returned
+// resp and err, or resp and err if it succeeded or has none.
+func (d *degrader) answer(ctx context.Context, method string, req, resp interface{}, err error) (interface{}, bool, error) {
+	f := d.fallbackOf(method)
+	if f == nil {
+		return resp, false, err
+	}
+	if err == nil {
+		if o, ok := f.(observer); ok {
+			o.observe(ctx, method, req, resp)
+		}
+		return resp, false, nil
+	}
+	if !d.codes[status.Code(err)] {
+		return resp, false, err
+	}
+	fresp, ferr := f.Fallback(ctx, method, req, err)
+	if ferr != nil {
+		d.served.With(method, "unavailable").Add(1)
+		return resp, false, err
+	}
+	d.served.With(method, "served").Add(1)
+	return fresp, true, nil
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor answering
+// calls whose handler failed with the fallback of their method, by full
+// method name or service, and setting the DegradedKey header. Fallbacks
+// learning from successful calls, like LastGood, observe the responses of
+// their methods.
+func UnaryServerInterceptor(fallbacks map[string]Fallback, opts ...Option) grpc.UnaryServerInterceptor {
+	d := newDegrader(fallbacks, opts, "server")
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		resp, degraded, err := d.answer(ctx, info.FullMethod, req, resp, err)
+		if degraded {
+			grpc.SetHeader(ctx, metadata.Pairs(DegradedKey, "true"))
+		}
+		return resp, err
+	}
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor answering
+// calls to downstream services that failed with the fallback of their
+// method, copied into the reply.
+func UnaryClientInterceptor(fallbacks map[string]Fallback, opts ...Option) grpc.UnaryClientInterceptor {
+	d := newDegrader(fallbacks, opts, "client")
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, callOpts...)
+		resp, degraded, err := d.answer(ctx, method, req, reply, err)
+		if !degraded {
+			return err
+		}
+		dst, ok := reply.(proto.Message)
+		src, ok2 := resp.(proto.Message)
+		if !ok || !ok2 || reflect.TypeOf(dst) != reflect.TypeOf(src) {
+			return status.Errorf(codes.Internal, "fallback: %T response for %T reply", resp, reply)
+		}
+		dst.Reset()
+		proto.Merge(dst, src)
+		return nil
+	}
+}
@@ -0,0 +1,86 @@
+package fallback
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/ipfans/grpctools/middleware/cache"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	lastGood := NewLastGood(cache.NewLRU(10), cache.SharedKey, time.Minute)
+	interceptor := UnaryServerInterceptor(map[string]Fallback{
+		"/test.Service/Get": First(lastGood, Static(&wrappers.StringValue{Value: "default"})),
+		"test.Service":      lastGood,
+	})
+	get := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}
+	list := &grpc.UnaryServerInfo{FullMethod: "/test.Service/List"}
+	other := &grpc.UnaryServerInfo{FullMethod: "/other.Service/Get"}
+	ok := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &wrappers.StringValue{Value: "fresh " + req.(*wrappers.StringValue).Value}, nil
+	}
+	fail := func(code codes.Code) grpc.UnaryHandler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, status.Error(code, "failed")
+		}
+	}
+
+	if _, err := interceptor(context.Background(), &wrappers.StringValue{Value: "a"}, get, ok); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name    string
+		req     string
+		info    *grpc.UnaryServerInfo
+		handler grpc.UnaryHandler
+		resp    string
+		code    codes.Code
+	}{
+		{"last good", "a", get, fail(codes.Unavailable), "fresh a", codes.OK},
+		{"static", "b", get, fail(codes.DeadlineExceeded), "default", codes.OK},
+		{"not degradable", "a", get, fail(codes.NotFound), "", codes.NotFound},
+		{"no last good", "a", list, fail(codes.Unavailable), "", codes.Unavailable},
+		{"no fallback", "a", other, fail(codes.Unavailable), "", codes.Unavailable},
+	} {
+		resp, err := interceptor(context.Background(), &wrappers.StringValue{Value: tc.req}, tc.info, tc.handler)
+		if want, have := tc.code, status.Code(err); want != have {
+			t.Fatalf("%s: want %v, have %v", tc.name, want, have)
+		}
+		if err != nil {
+			continue
+		}
+		if want, have := tc.resp, resp.(*wrappers.StringValue).Value; want != have {
+			t.Fatalf("%s: want %q, have %q", tc.name, want, have)
+		}
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	interceptor := UnaryClientInterceptor(map[string]Fallback{
+		"test.Service": Func(func(ctx context.Context, method string, req interface{}, err error) (interface{}, error) {
+			return &wrappers.StringValue{Value: method}, nil
+		}),
+	}, WithCodes(codes.ResourceExhausted))
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return status.Error(codes.ResourceExhausted, "overloaded")
+	}
+
+	reply := &wrappers.StringValue{Value: "partial"}
+	if err := interceptor(context.Background(), "/test.Service/Get", &wrappers.StringValue{}, reply, nil, invoker); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "/test.Service/Get", reply.Value; want != have {
+		t.Fatalf("want %q, have %q", want, have)
+	}
+
+	var other proto.Message = &wrappers.Int64Value{}
+	if err := interceptor(context.Background(), "/test.Service/Get", &wrappers.StringValue{}, other, nil, invoker); status.Code(err) != codes.Internal {
+		t.Fatalf("mismatched reply: want %v, have %v", codes.Internal, status.Code(err))
+	}
+}
@@ -0,0 +1,172 @@
+// Package featureflag provides server interceptors evaluating feature flags
+// once per request, for the caller and its tenant, and storing the results in
+// the context, so handlers and other interceptors can branch on them without
+// calling the flag service each.
+package featureflag
+
+import (
+	"os"
+
+	"github.com/ipfans/grpctools/middleware"
+	"github.com/ipfans/grpctools/middleware/auth/jwt"
+	"github.com/ipfans/grpctools/middleware/tenant"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/grpclog"
+)
+
+// Subject is who flags are evaluated for.
+type Subject struct {
+	// ID identifies the caller, like the subject of its JWT.
+	ID string
+	// Tenant is the tenant ID of the request, if any.
+	Tenant string
+	// Attributes hold any other targeting attributes, like "method".
+	Attributes map[string]string
+}
+
+// Provider evaluates flags. Its adapters, like the openfeature package, wrap
+// flag services.
+type Provider interface {
+	// Evaluate returns the value of flag for s, of the type of def, which is
+	// returned when the flag can't be evaluated.
+	Evaluate(ctx context.Context, flag string, def interface{}, s Subject) (interface{}, error)
+}
+
+// ProviderFunc is an adapter to use a function as a Provider.
+type ProviderFunc func(ctx context.Context, flag string, def interface{}, s Subject) (interface{}, error)
+
+// Evaluate calls f.
+func (f ProviderFunc) Evaluate(ctx context.Context, flag string, def interface{}, s Subject) (interface{}, error) {
+	return f(ctx, flag, def, s)
+}
+
+// Flags are the values of flags evaluated for a request, by name.
+type Flags map[string]interface{}
+
+// Bool returns the value of a boolean flag, false if it isn't one.
+func (f Flags) Bool(name string) bool {
+	v, _ := f[name].(bool)
+	return v
+}
+
+// String returns the value of a string flag, "" if it isn't one.
+func (f Flags) String(name string) string {
+	v, _ := f[name].(string)
+	return v
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying flags.
+func NewContext(ctx context.Context, flags Flags) context.Context {
+	return context.WithValue(ctx, contextKey{}, flags)
+}
+
+// FromContext returns the flags of a request, nil if it has none.
+func FromContext(ctx context.Context) Flags {
+	flags, _ := ctx.Value(contextKey{}).(Flags)
+	return flags
+}
+
+// Enabled reports whether the boolean flag name is on for the request of
+// ctx.
+func Enabled(ctx context.Context, name string) bool {
+	return FromContext(ctx).Bool(name)
+}
+
+// DefaultSubject is the subject of requests by default: the subject of the
+// JWT they were authenticated with and their tenant, if the jwt and tenant
+// interceptors ran first.
+func DefaultSubject(ctx context.Context) Subject {
+	var s Subject
+	if claims, ok := jwt.FromContext(ctx); ok {
+		s.ID = claims.Subject
+	}
+	s.Tenant = tenant.ID(ctx)
+	return s
+}
+
+type options struct {
+	subject func(ctx context.Context) Subject
+	logger  grpclog.LoggerV2
+}
+
+// Option for featureflag interceptors.
+type Option func(o *options)
+
+// WithSubject sets how the subject of requests is found. Default is
+// DefaultSubject.
+func WithSubject(f func(ctx context.Context) Subject) Option {
+	return func(o *options) {
+		o.subject = f
+	}
+}
+
+// WithLogger replaced built-in logger to given.
+func WithLogger(logger grpclog.LoggerV2) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+type evaluator struct {
+	provider Provider
+	defaults Flags
+	opts     options
+}
+
+func newEvaluator(p Provider, defaults Flags, opts []Option) *evaluator {
+	o := options{
+		subject: DefaultSubject,
+		logger:  grpclog.NewLoggerV2(os.Stdout, os.Stdout, os.Stderr),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &evaluator{provider: p, defaults: defaults, opts: o}
+}
+
+// evaluate returns ctx with the flags of its request. Flags failing to
+// evaluate take their default value.
+func (e *evaluator) evaluate(ctx context.Context, method string) context.Context {
+	s := e.opts.subject(ctx)
+	attrs := make(map[string]string, len(s.Attributes)+1)
+	for k, v := range s.Attributes {
+		attrs[k] = v
+	}
+	attrs["method"] = method
+	s.Attributes = attrs
+
+	flags := make(Flags, len(e.defaults))
+	for name, def := range e.defaults {
+		v, err := e.provider.Evaluate(ctx, name, def, s)
+		if err != nil {
+			e.opts.logger.Warningf("middleware/featureflag: evaluating %s: %v", name, err)
+			v = def
+		}
+		flags[name] = v
+	}
+	return NewContext(ctx, flags)
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor evaluating
+// the flags of defaults, by name with their default value, for each request
+// with p.
+func UnaryServerInterceptor(p Provider, defaults Flags, opts ...Option) grpc.UnaryServerInterceptor {
+	e := newEvaluator(p, defaults, opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(e.evaluate(ctx, info.FullMethod), req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor
+// evaluating the flags of defaults for each stream with p.
+func StreamServerInterceptor(p Provider, defaults Flags, opts ...Option) grpc.StreamServerInterceptor {
+	e := newEvaluator(p, defaults, opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		wrapped := middleware.WrapServerStream(stream)
+		wrapped.SetContext(e.evaluate(stream.Context(), info.FullMethod))
+		return handler(srv, wrapped)
+	}
+}
@@ -0,0 +1,94 @@
+package featureflag
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ipfans/grpctools/middleware/auth/jwt"
+	"github.com/ipfans/grpctools/middleware/tenant"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/grpclog"
+)
+
+// provider turns "new-checkout" on for the acme tenant and "beta" on for
+// alice, and fails on "broken".
+var provider = ProviderFunc(func(ctx context.Context, flag string, def interface{}, s Subject) (interface{}, error) {
+	switch {
+	case flag == "new-checkout" && s.Tenant == "acme":
+		return true, nil
+	case flag == "beta" && s.ID == "alice" && s.Attributes["method"] == "/test.Service/Get":
+		return true, nil
+	case flag == "theme":
+		return "dark", nil
+	case flag == "broken":
+		return nil, errors.New("flag service down")
+	}
+	return def, nil
+})
+
+var defaults = Flags{"new-checkout": false, "beta": false, "theme": "light", "broken": true}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := UnaryServerInterceptor(provider, defaults, WithLogger(grpclog.NewLoggerV2(ioutil.Discard, ioutil.Discard, ioutil.Discard)))
+	var have Flags
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		have = FromContext(ctx)
+		return nil, nil
+	}
+	alice := jwt.NewContext(context.Background(), &jwt.Claims{Subject: "alice"})
+	acme := tenant.NewContext(context.Background(), &tenant.Tenant{ID: "acme"})
+
+	for _, tc := range []struct {
+		name     string
+		ctx      context.Context
+		checkout bool
+		beta     bool
+	}{
+		{"anonymous", context.Background(), false, false},
+		{"user", alice, false, true},
+		{"tenant", acme, true, false},
+	} {
+		if _, err := interceptor(tc.ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, handler); err != nil {
+			t.Fatal(err)
+		}
+		if want, have := tc.checkout, have.Bool("new-checkout"); want != have {
+			t.Errorf("%s: new-checkout: want %v, have %v", tc.name, want, have)
+		}
+		if want, have := tc.beta, have.Bool("beta"); want != have {
+			t.Errorf("%s: beta: want %v, have %v", tc.name, want, have)
+		}
+		if want, have := "dark", have.String("theme"); want != have {
+			t.Errorf("%s: theme: want %q, have %q", tc.name, want, have)
+		}
+		if want, have := true, have.Bool("broken"); want != have {
+			t.Errorf("%s: broken: want default %v, have %v", tc.name, want, have)
+		}
+	}
+}
+
+type testStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testStream) Context() context.Context { return s.ctx }
+
+func TestStreamServerInterceptor(t *testing.T) {
+	interceptor := StreamServerInterceptor(provider, defaults, WithSubject(func(ctx context.Context) Subject {
+		return Subject{Tenant: "acme"}
+	}))
+	err := interceptor(nil, &testStream{ctx: context.Background()}, &grpc.StreamServerInfo{FullMethod: "/test.Service/Watch"}, func(srv interface{}, stream grpc.ServerStream) error {
+		if !Enabled(stream.Context(), "new-checkout") {
+			t.Fatal("new-checkout disabled")
+		}
+		if Enabled(stream.Context(), "beta") {
+			t.Fatal("beta enabled")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,47 @@
+// Package openfeature evaluates feature flags for the featureflag
+// interceptors with an OpenFeature client.
+package openfeature
+
+import (
+	"github.com/ipfans/grpctools/middleware/featureflag"
+	"github.com/open-feature/go-sdk/openfeature"
+	"golang.org/x/net/context"
+)
+
+// Provider evaluates flags with an OpenFeature client.
+type Provider struct {
+	client *openfeature.Client
+}
+
+// New returns a Provider evaluating flags with client. The ID of subjects is
+// the targeting key, and their tenant the "tenant" attribute.
+func New(client *openfeature.Client) *Provider {
+	return &Provider{client: client}
+}
+
+// Evaluate evaluates flag with the method of the client matching the type of
+// def: bool, string, int64 and int, float64, or any other type as an object.
+func (p *Provider) Evaluate(ctx context.Context, flag string, def interface{}, s featureflag.Subject) (interface{}, error) {
+	attrs := make(map[string]interface{}, len(s.Attributes)+1)
+	for k, v := range s.Attributes {
+		attrs[k] = v
+	}
+	if s.Tenant != "" {
+		attrs["tenant"] = s.Tenant
+	}
+	evalCtx := openfeature.NewEvaluationContext(s.ID, attrs)
+	switch def := def.(type) {
+	case bool:
+		return p.client.BooleanValue(ctx, flag, def, evalCtx)
+	case string:
+		return p.client.StringValue(ctx, flag, def, evalCtx)
+	case int64:
+		return p.client.IntValue(ctx, flag, def, evalCtx)
+	case int:
+		v, err := p.client.IntValue(ctx, flag, int64(def), evalCtx)
+		return int(v), err
+	case float64:
+		return p.client.FloatValue(ctx, flag, def, evalCtx)
+	}
+	return p.client.ObjectValue(ctx, flag, def, evalCtx)
+}
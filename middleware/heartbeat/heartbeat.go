@@ -0,0 +1,161 @@
+// Package heartbeat provides interceptors keeping long-lived server streams
+// alive through L7 proxies killing idle streams: servers send application
+// heartbeat messages on quiet streams, and clients detect streams missing
+// them.
+package heartbeat
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Func returns the heartbeat message of a full method name, a response
+// message recognized as a heartbeat by clients, like one with a heartbeat
+// oneof field set. It returns nil for methods without heartbeats.
+type Func func(method string) interface{}
+
+// StreamServerInterceptor returns a new streaming server interceptor sending
+// the heartbeat message of server streams after each interval without a
+// message sent by the handler.
+func StreamServerInterceptor(interval time.Duration, heartbeat Func) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !info.IsServerStream || interval <= 0 {
+			return handler(srv, stream)
+		}
+		msg := heartbeat(info.FullMethod)
+		if msg == nil {
+			return handler(srv, stream)
+		}
+		s := &serverStream{ServerStream: stream, last: time.Now()}
+		quit := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.beat(msg, interval, quit)
+		}()
+		err := handler(srv, s)
+		close(quit)
+		<-done
+		return err
+	}
+}
+
+// serverStream serializes the messages of the handler and heartbeats, as
+// SendMsg isn't safe for concurrent use.
+type serverStream struct {
+	grpc.ServerStream
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (s *serverStream) SendMsg(m interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.ServerStream.SendMsg(m)
+	s.last = time.Now()
+	return err
+}
+
+// beat sends msg after each interval without messages, until quit is closed
+// or a send fails.
+func (s *serverStream) beat(msg interface{}, interval time.Duration, quit chan struct{}) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-s.Context().Done():
+			return
+		case <-timer.C:
+		}
+		s.mu.Lock()
+		quiet := time.Since(s.last)
+		s.mu.Unlock()
+		if quiet < interval {
+			timer.Reset(interval - quiet)
+			continue
+		}
+		if err := s.SendMsg(msg); err != nil {
+			return
+		}
+		timer.Reset(interval)
+	}
+}
+
+type options struct {
+	filter func(m interface{}) bool
+}
+
+// Option for the client heartbeat interceptor.
+type Option func(o *options)
+
+// WithFilter skips the messages f reports as heartbeats, so RecvMsg only
+// returns the other messages to callers.
+func WithFilter(f func(m interface{}) bool) Option {
+	return func(o *options) {
+		o.filter = f
+	}
+}
+
+// StreamClientInterceptor returns a new streaming client interceptor
+// canceling server streams which received no message, heartbeat or other,
+// for timeout, typically a few heartbeat intervals. RecvMsg then returns
+// Unavailable, so callers can reconnect.
+func StreamClientInterceptor(timeout time.Duration, opts ...Option) grpc.StreamClientInterceptor {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if !desc.ServerStreams || timeout <= 0 {
+			return streamer(ctx, desc, cc, method, callOpts...)
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		stream, err := streamer(ctx, desc, cc, method, callOpts...)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		s := &clientStream{ClientStream: stream, timeout: timeout, filter: o.filter, cancel: cancel}
+		s.timer = time.AfterFunc(timeout, func() {
+			atomic.StoreInt32(&s.expired, 1)
+			cancel()
+		})
+		return s, nil
+	}
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	timeout time.Duration
+	filter  func(m interface{}) bool
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	expired int32 // accessed atomically
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	for {
+		err := s.ClientStream.RecvMsg(m)
+		if err != nil {
+			s.timer.Stop()
+			if atomic.LoadInt32(&s.expired) == 1 {
+				return status.Errorf(codes.Unavailable, "heartbeat: no message from server for %v", s.timeout)
+			}
+			s.cancel()
+			return err
+		}
+		s.timer.Reset(s.timeout)
+		if s.filter == nil || !s.filter(m) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package heartbeat
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testServerStream struct {
+	grpc.ServerStream
+	mu   sync.Mutex
+	sent []string
+}
+
+func (s *testServerStream) Context() context.Context { return context.Background() }
+func (s *testServerStream) SendMsg(m interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, m.(*wrappers.StringValue).Value)
+	return nil
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	interceptor := StreamServerInterceptor(40*time.Millisecond, func(method string) interface{} {
+		if method == "/test.Service/Watch" {
+			return &wrappers.StringValue{Value: "heartbeat"}
+		}
+		return nil
+	})
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		// Busy sending: no heartbeat is due.
+		for i := 0; i < 5; i++ {
+			stream.SendMsg(&wrappers.StringValue{Value: "update"})
+			time.Sleep(10 * time.Millisecond)
+		}
+		// Quiet: heartbeats are sent.
+		time.Sleep(150 * time.Millisecond)
+		return stream.SendMsg(&wrappers.StringValue{Value: "update"})
+	}
+
+	for _, tc := range []struct {
+		method string
+		server bool
+		beats  bool
+	}{
+		{"/test.Service/Watch", true, true},
+		{"/test.Service/Other", true, false},
+		{"/test.Service/Upload", false, false},
+	} {
+		stream := &testServerStream{}
+		if err := interceptor(nil, stream, &grpc.StreamServerInfo{FullMethod: tc.method, IsServerStream: tc.server}, handler); err != nil {
+			t.Fatal(err)
+		}
+		beats := 0
+		for i, v := range stream.sent {
+			if v == "heartbeat" {
+				beats++
+				if i < 5 {
+					t.Errorf("%s: heartbeat while busy: %v", tc.method, stream.sent)
+				}
+			}
+		}
+		if want, have := tc.beats, beats >= 2; want != have {
+			t.Errorf("%s: heartbeats: want %v, have %d", tc.method, want, beats)
+		}
+		if want, have := 6, len(stream.sent)-beats; want != have {
+			t.Errorf("%s: updates: want %d, have %d", tc.method, want, have)
+		}
+	}
+}
+
+// testClientStream receives msgs, then nothing until its context is done.
+type testClientStream struct {
+	grpc.ClientStream
+	ctx  context.Context
+	msgs []string
+}
+
+func (s *testClientStream) RecvMsg(m interface{}) error {
+	if len(s.msgs) == 0 {
+		<-s.ctx.Done()
+		return status.FromContextError(s.ctx.Err()).Err()
+	}
+	proto.Merge(m.(proto.Message), &wrappers.StringValue{Value: s.msgs[0]})
+	s.msgs = s.msgs[1:]
+	return nil
+}
+
+func TestStreamClientInterceptor(t *testing.T) {
+	interceptor := StreamClientInterceptor(50*time.Millisecond, WithFilter(func(m interface{}) bool {
+		return m.(*wrappers.StringValue).Value == "heartbeat"
+	}))
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &testClientStream{ctx: ctx, msgs: []string{"heartbeat", "update", "heartbeat"}}, nil
+	}
+	stream, err := interceptor(context.Background(), &grpc.StreamDesc{ServerStreams: true}, nil, "/test.Service/Watch", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &wrappers.StringValue{}
+	if err := stream.RecvMsg(m); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "update", m.Value; want != have {
+		t.Fatalf("message: want %q, have %q", want, have)
+	}
+	start := time.Now()
+	err = stream.RecvMsg(&wrappers.StringValue{})
+	if want, have := codes.Unavailable, status.Code(err); want != have {
+		t.Fatalf("missing heartbeats: want %v, have %v", want, have)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("detected after %v", elapsed)
+	}
+}
+
+func TestStreamClientInterceptorCanceled(t *testing.T) {
+	interceptor := StreamClientInterceptor(time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &testClientStream{ctx: ctx}, nil
+	}
+	stream, err := interceptor(ctx, &grpc.StreamDesc{ServerStreams: true}, nil, "/test.Service/Watch", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if want, have := codes.Canceled, status.Code(stream.RecvMsg(&wrappers.StringValue{})); want != have {
+		t.Fatalf("want %v, have %v", want, have)
+	}
+}
@@ -0,0 +1,259 @@
+// Package hedge provides a client interceptor sending hedged requests, cutting
+// the tail latency of idempotent calls.
+package hedge
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ipfans/grpctools/metrics"
+	"github.com/ipfans/grpctools/middleware/budget"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+const (
+	// samples is the number of latencies kept per method.
+	samples = 100
+	// minSamples is the number of latencies needed before the delay follows
+	// the percentile.
+	minSamples = 20
+)
+
+type options struct {
+	methods     map[string]bool
+	delay       time.Duration
+	percentile  float64
+	maxAttempts int
+	budget      *budget.Budget
+	metrics     metrics.Provider
+}
+
+// Option for Hedger instance.
+type Option func(o *options)
+
+// WithMethods sets the full method names hedged, like
+// "/foo.v1.UserService/Get". Only idempotent methods may be hedged; calls to
+// other methods pass through. Default is none.
+func WithMethods(methods ...string) Option {
+	return func(o *options) {
+		for _, m := range methods {
+			o.methods[m] = true
+		}
+	}
+}
+
+// WithDelay sets how long to wait for a response before sending a hedged
+// request, until enough latencies are recorded to follow the percentile.
+// Default is 100ms.
+func WithDelay(d time.Duration) Option {
+	return func(o *options) {
+		o.delay = d
+	}
+}
+
+// WithPercentile sets the percentile (0 to 1) of recent latencies of a method
+// after which a hedged request is sent. Default is 0.95; zero always uses the
+// delay set by WithDelay.
+func WithPercentile(p float64) Option {
+	return func(o *options) {
+		o.percentile = p
+	}
+}
+
+// WithMaxAttempts sets the number of requests sent per call, including the
+// first one. Default is 2.
+func WithMaxAttempts(n int) Option {
+	return func(o *options) {
+		o.maxAttempts = n
+	}
+}
+
+// WithBudget sets the retry budget shared with other interceptors of the
+// client: calls deposit into it, and hedged requests are only sent while it
+// allows.
+func WithBudget(b *budget.Budget) Option {
+	return func(o *options) {
+		o.budget = b
+	}
+}
+
+// WithMetrics reports hedging metrics through given provider.
+func WithMetrics(p metrics.Provider) Option {
+	return func(o *options) {
+		o.metrics = p
+	}
+}
+
+// Hedger sends another request when a call doesn't complete within a
+// percentile of recent latencies, and uses whichever response arrives first,
+// canceling the others. Each request is load balanced on its own, so with a
+// balancer like round_robin hedged requests go to other backends.
+type Hedger struct {
+	opts options
+
+	mu        sync.Mutex
+	latencies map[string]*latencies
+
+	hedged metrics.Counter
+	won    metrics.Counter
+}
+
+// New initializes a Hedger.
+func New(opts ...Option) *Hedger {
+	o := options{
+		methods:     make(map[string]bool),
+		delay:       100 * time.Millisecond,
+		percentile:  0.95,
+		maxAttempts: 2,
+		metrics:     metrics.Discard,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Hedger{
+		opts:      o,
+		latencies: make(map[string]*latencies),
+		hedged:    o.metrics.NewCounter("grpc_hedge_requests_total", "Total number of hedged requests sent.", "method"),
+		won:       o.metrics.NewCounter("grpc_hedge_wins_total", "Total number of calls completed by a hedged request.", "method"),
+	}
+}
+
+// Delay returns how long a call to method waits before sending a hedged
+// request.
+func (h *Hedger) Delay(method string) time.Duration {
+	if h.opts.percentile <= 0 {
+		return h.opts.delay
+	}
+	if d, ok := h.methodLatencies(method).percentile(h.opts.percentile); ok {
+		return d
+	}
+	return h.opts.delay
+}
+
+func (h *Hedger) methodLatencies(method string) *latencies {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	l, ok := h.latencies[method]
+	if !ok {
+		l = &latencies{}
+		h.latencies[method] = l
+	}
+	return l
+}
+
+type result struct {
+	reply   proto.Message
+	err     error
+	attempt int
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor hedging calls
+// to the configured methods. Replies must be protobuf messages.
+func (h *Hedger) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		msg, ok := reply.(proto.Message)
+		if !h.opts.methods[method] || h.opts.maxAttempts < 2 || !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		if h.opts.budget != nil {
+			h.opts.budget.Deposit()
+		}
+		start := time.Now()
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		results := make(chan result, h.opts.maxAttempts)
+		send := func(attempt int) {
+			r := reflect.New(reflect.TypeOf(msg).Elem()).Interface().(proto.Message)
+			go func() {
+				err := invoker(ctx, method, req, r, cc, opts...)
+				results <- result{reply: r, err: err, attempt: attempt}
+			}()
+		}
+
+		delay := h.Delay(method)
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		send(0)
+		sent, pending := 1, 1
+		for {
+			select {
+			case <-timer.C:
+				if h.opts.budget != nil && !h.opts.budget.Withdraw() {
+					// Out of budget: wait for the requests in flight.
+					continue
+				}
+				send(sent)
+				h.hedged.With(method).Add(1)
+				sent++
+				pending++
+				if sent < h.opts.maxAttempts {
+					timer.Reset(delay)
+				}
+			case r := <-results:
+				pending--
+				// Wait for other requests in flight before giving up on an
+				// error.
+				if r.err != nil && pending > 0 {
+					continue
+				}
+				if r.err != nil {
+					return r.err
+				}
+				// Record the latency of the call rather than of the winning
+				// request: a hedged request sent late would record a
+				// shortened latency and drag the delay down. The percentile
+				// of call latencies stays put once the delay reaches it.
+				h.methodLatencies(method).add(time.Since(start))
+				if r.attempt > 0 {
+					h.won.With(method).Add(1)
+				}
+				msg.Reset()
+				proto.Merge(msg, r.reply)
+				return nil
+			}
+		}
+	}
+}
+
+// latencies keeps the latest latencies of a method.
+type latencies struct {
+	mu   sync.Mutex
+	ring [samples]time.Duration
+	n    int
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.mu.Lock()
+	l.ring[l.n%samples] = d
+	l.n++
+	l.mu.Unlock()
+}
+
+// percentile returns the p-th percentile of the latencies, false if there are
+// too few of them.
+func (l *latencies) percentile(p float64) (time.Duration, bool) {
+	l.mu.Lock()
+	n := l.n
+	if n > samples {
+		n = samples
+	}
+	if n < minSamples {
+		l.mu.Unlock()
+		return 0, false
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, l.ring[:n])
+	l.mu.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(p * float64(n))
+	if i >= n {
+		i = n - 1
+	}
+	return sorted[i], true
+}
@@ -0,0 +1,123 @@
+package hedge
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/ipfans/grpctools/middleware/budget"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const method = "/test.Service/Get"
+
+// slowFirstInvoker blocks the first request until it is canceled and answers
+// the others with their attempt number.
+func slowFirstInvoker(calls *int32, canceled chan<- struct{}) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		n := atomic.AddInt32(calls, 1)
+		if n == 1 {
+			<-ctx.Done()
+			close(canceled)
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		reply.(*wrappers.StringValue).Value = "hedged"
+		return nil
+	}
+}
+
+func TestHedgedRequestWins(t *testing.T) {
+	h := New(WithMethods(method), WithDelay(time.Millisecond))
+	var calls int32
+	canceled := make(chan struct{})
+	reply := &wrappers.StringValue{}
+	if err := h.UnaryClientInterceptor()(context.Background(), method, nil, reply, nil, slowFirstInvoker(&calls, canceled)); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "hedged", reply.Value; want != have {
+		t.Fatalf("reply: want %q, have %q", want, have)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("first request not canceled")
+	}
+	if want, have := int32(2), atomic.LoadInt32(&calls); want != have {
+		t.Fatalf("requests: want %d, have %d", want, have)
+	}
+}
+
+func TestNotHedged(t *testing.T) {
+	h := New(WithMethods(method), WithDelay(time.Hour))
+	var calls int32
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		atomic.AddInt32(&calls, 1)
+		return status.Error(codes.NotFound, "not found")
+	}
+	for _, m := range []string{method, "/test.Service/Delete"} {
+		calls = 0
+		err := h.UnaryClientInterceptor()(context.Background(), m, nil, &wrappers.StringValue{}, nil, invoker)
+		if want, have := codes.NotFound, status.Code(err); want != have {
+			t.Fatalf("%s: want %v, have %v", m, want, have)
+		}
+		if want, have := int32(1), atomic.LoadInt32(&calls); want != have {
+			t.Fatalf("%s: requests: want %d, have %d", m, want, have)
+		}
+	}
+}
+
+func TestBudget(t *testing.T) {
+	h := New(WithMethods(method), WithDelay(time.Millisecond), WithBudget(budget.New(0, budget.WithMinPerSecond(0))))
+	var calls int32
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		reply.(*wrappers.StringValue).Value = "first"
+		return nil
+	}
+	reply := &wrappers.StringValue{}
+	if err := h.UnaryClientInterceptor()(context.Background(), method, nil, reply, nil, invoker); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "first", reply.Value; want != have {
+		t.Fatalf("reply: want %q, have %q", want, have)
+	}
+	if want, have := int32(1), atomic.LoadInt32(&calls); want != have {
+		t.Fatalf("requests out of budget: want %d, have %d", want, have)
+	}
+}
+
+func TestDelayPercentile(t *testing.T) {
+	h := New(WithDelay(time.Second), WithPercentile(0.9))
+	if want, have := time.Second, h.Delay(method); want != have {
+		t.Fatalf("no samples: want %v, have %v", want, have)
+	}
+	l := h.methodLatencies(method)
+	for i := 1; i <= samples; i++ {
+		l.add(time.Duration(i) * time.Millisecond)
+	}
+	if want, have := 91*time.Millisecond, h.Delay(method); want != have {
+		t.Fatalf("p90: want %v, have %v", want, have)
+	}
+}
+
+func TestDelayStableUnderHedging(t *testing.T) {
+	const delay = 5 * time.Millisecond
+	h := New(WithMethods(method), WithDelay(delay))
+	interceptor := h.UnaryClientInterceptor()
+	for i := 0; i < 2*minSamples; i++ {
+		var calls int32
+		if err := interceptor(context.Background(), method, nil, &wrappers.StringValue{}, nil, slowFirstInvoker(&calls, make(chan struct{}))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Every call was won by a hedged request answering at once; recording
+	// its own latency would have brought the delay down to about zero.
+	if d := h.Delay(method); d < delay {
+		t.Fatalf("delay drifted down: want at least %v, have %v", delay, d)
+	}
+}
@@ -0,0 +1,223 @@
+// Package idempotency provides a server interceptor deduplicating retried
+// requests by their idempotency key.
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ipfans/grpctools/middleware/authz"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// MetadataKey is the metadata key carrying the idempotency key.
+	MetadataKey = "idempotency-key"
+	// ReplayedKey is the response header set to "true" on responses
+	// replayed from the store.
+	ReplayedKey = "idempotent-replayed"
+)
+
+// Record is the state of an idempotency key.
+type Record struct {
+	// Fingerprint identifies the request first made with the key.
+	Fingerprint string
+	// Done is set once Response holds the response, of type Type.
+	Done     bool
+	Type     string
+	Response []byte
+}
+
+// Store keeps records of idempotency keys.
+type Store interface {
+	// Begin stores rec under key for ttl, the lease of the request, and
+	// returns nil if key has no record, or else returns the existing record.
+	Begin(ctx context.Context, key string, rec Record, ttl time.Duration) (*Record, error)
+	// Complete replaces the record of key.
+	Complete(ctx context.Context, key string, rec Record, ttl time.Duration) error
+	// Abort removes the record of key, so the request can be retried.
+	Abort(ctx context.Context, key string) error
+}
+
+type options struct {
+	store     Store
+	ttl       time.Duration
+	lease     time.Duration
+	methods   map[string]bool
+	principal authz.PrincipalFunc
+	logger    grpclog.LoggerV2
+}
+
+// Option for idempotency interceptors.
+type Option func(o *options)
+
+// WithStore sets where records are kept. Default is an in-memory store.
+func WithStore(s Store) Option {
+	return func(o *options) {
+		o.store = s
+	}
+}
+
+// WithTTL sets how long responses are kept for duplicates. Default is 24
+// hours.
+func WithTTL(d time.Duration) Option {
+	return func(o *options) {
+		o.ttl = d
+	}
+}
+
+// WithLease sets how long a key is held while its first request runs. A
+// request outliving it, or a server crashing, frees the key for retries after
+// the lease. Default is one minute.
+func WithLease(d time.Duration) Option {
+	return func(o *options) {
+		o.lease = d
+	}
+}
+
+// WithPrincipalFunc sets the function returning the caller of requests, whose
+// name scopes idempotency keys so callers can't replay each other's
+// responses. Default is authz.DefaultPrincipal; unauthenticated callers
+// share a scope.
+func WithPrincipalFunc(f authz.PrincipalFunc) Option {
+	return func(o *options) {
+		o.principal = f
+	}
+}
+
+// WithLogger replaced built-in logger to given.
+func WithLogger(logger grpclog.LoggerV2) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithMethods sets the full method names deduplicated. Default is every
+// method called with an idempotency key.
+func WithMethods(methods ...string) Option {
+	return func(o *options) {
+		if o.methods == nil {
+			o.methods = make(map[string]bool)
+		}
+		for _, m := range methods {
+			o.methods[m] = true
+		}
+	}
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor executing a
+// request once per idempotency key and caller: the response of the first
+// successful execution is stored and returned for duplicates within the TTL.
+// Duplicates arriving while the first request runs fail with Aborted, and
+// reusing a key for a different request fails with FailedPrecondition.
+// Failed or panicking requests aren't stored, so they may be retried.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	o := options{
+		ttl:       24 * time.Hour,
+		lease:     time.Minute,
+		principal: authz.DefaultPrincipal,
+		logger:    grpclog.NewLoggerV2(os.Stdout, os.Stdout, os.Stderr),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.store == nil {
+		o.store = NewMemoryStore()
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		v := md.Get(MetadataKey)
+		msg, ok := req.(proto.Message)
+		if len(v) == 0 || v[0] == "" || !ok || o.methods != nil && !o.methods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		var caller string
+		if p, ok := o.principal(ctx); ok {
+			caller = p.Name
+		}
+		key := caller + ":" + info.FullMethod + ":" + v[0]
+		fingerprint, ferr := fingerprintOf(msg)
+		if ferr != nil {
+			return handler(ctx, req)
+		}
+
+		rec, serr := o.store.Begin(ctx, key, Record{Fingerprint: fingerprint}, o.lease)
+		if serr != nil {
+			return nil, status.Errorf(codes.Unavailable, "idempotency store: %v", serr)
+		}
+		if rec != nil {
+			return replay(ctx, rec, fingerprint)
+		}
+
+		// Free the key unless the response was stored, including when the
+		// handler panics. The request context may be done by then.
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			if aerr := o.store.Abort(context.Background(), key); aerr != nil {
+				o.logger.Warningf("middleware/idempotency: error releasing key %q: %v", key, aerr)
+			}
+		}()
+
+		resp, err = handler(ctx, req)
+		if err != nil {
+			return resp, err
+		}
+		out, ok := resp.(proto.Message)
+		if !ok {
+			return resp, nil
+		}
+		b, merr := proto.Marshal(out)
+		if merr != nil {
+			o.logger.Warningf("middleware/idempotency: error encoding response of %s: %v", info.FullMethod, merr)
+			return resp, nil
+		}
+		if cerr := o.store.Complete(context.Background(), key, Record{Fingerprint: fingerprint, Done: true, Type: proto.MessageName(out), Response: b}, o.ttl); cerr != nil {
+			o.logger.Warningf("middleware/idempotency: error storing response of key %q: %v", key, cerr)
+			return resp, nil
+		}
+		completed = true
+		return resp, nil
+	}
+}
+
+// fingerprintOf returns the hash of the encoded request.
+func fingerprintOf(req proto.Message) (string, error) {
+	b, err := proto.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// replay returns the response stored in rec.
+func replay(ctx context.Context, rec *Record, fingerprint string) (interface{}, error) {
+	if rec.Fingerprint != fingerprint {
+		return nil, status.Error(codes.FailedPrecondition, "idempotency key was used for a different request")
+	}
+	if !rec.Done {
+		return nil, status.Error(codes.Aborted, "a request with this idempotency key is in progress")
+	}
+	typ := proto.MessageType(rec.Type)
+	if typ == nil {
+		return nil, status.Errorf(codes.Internal, "unknown response type %q", rec.Type)
+	}
+	resp := reflect.New(typ.Elem()).Interface().(proto.Message)
+	if err := proto.Unmarshal(rec.Response, resp); err != nil {
+		return nil, status.Errorf(codes.Internal, "decoding stored response: %v", err)
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(ReplayedKey, "true"))
+	return resp, nil
+}
@@ -0,0 +1,133 @@
+package idempotency
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/ipfans/grpctools/middleware/auth/apikey"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	store := NewMemoryStore()
+	interceptor := UnaryServerInterceptor(WithStore(store))
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Pay"}
+	var calls int
+	fail := false
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+		if fail {
+			return nil, status.Error(codes.Unavailable, "try again")
+		}
+		return &wrappers.Int64Value{Value: int64(calls)}, nil
+	}
+	call := func(key, req string) (int64, error) {
+		ctx := context.Background()
+		if key != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(MetadataKey, key))
+		}
+		resp, err := interceptor(ctx, &wrappers.StringValue{Value: req}, info, handler)
+		if err != nil {
+			return 0, err
+		}
+		return resp.(*wrappers.Int64Value).Value, nil
+	}
+
+	for i, tc := range []struct {
+		key, req string
+		fail     bool
+		resp     int64
+		code     codes.Code
+		calls    int
+	}{
+		{"k1", "pay 10", false, 1, codes.OK, 1},
+		{"k1", "pay 10", false, 1, codes.OK, 1},
+		{"k1", "pay 20", false, 0, codes.FailedPrecondition, 1},
+		{"k2", "pay 10", true, 0, codes.Unavailable, 2},
+		{"k2", "pay 10", false, 3, codes.OK, 3},
+		{"", "pay 10", false, 4, codes.OK, 4},
+		{"", "pay 10", false, 5, codes.OK, 5},
+	} {
+		fail = tc.fail
+		resp, err := call(tc.key, tc.req)
+		if want, have := tc.code, status.Code(err); want != have {
+			t.Errorf("%d: code: want %v, have %v", i, want, have)
+		}
+		if want, have := tc.resp, resp; want != have {
+			t.Errorf("%d: response: want %d, have %d", i, want, have)
+		}
+		if want, have := tc.calls, calls; want != have {
+			t.Errorf("%d: handler calls: want %d, have %d", i, want, have)
+		}
+	}
+
+	// A duplicate of a request still running is rejected.
+	fingerprint, _ := fingerprintOf(&wrappers.StringValue{Value: "pay 10"})
+	store.Begin(context.Background(), ":"+info.FullMethod+":k3", Record{Fingerprint: fingerprint}, time.Minute)
+	_, err := call("k3", "pay 10")
+	if want, have := codes.Aborted, status.Code(err); want != have {
+		t.Fatalf("in progress: want %v, have %v", want, have)
+	}
+}
+
+func TestPanicReleasesKey(t *testing.T) {
+	interceptor := UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Pay"}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKey, "k"))
+	req := &wrappers.StringValue{Value: "pay 10"}
+
+	func() {
+		defer func() { recover() }()
+		interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			panic("boom")
+		})
+	}()
+	resp, err := interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &wrappers.Int64Value{Value: 1}, nil
+	})
+	if err != nil {
+		t.Fatalf("retry after panic: %v", err)
+	}
+	if want, have := int64(1), resp.(*wrappers.Int64Value).Value; want != have {
+		t.Fatalf("retry after panic: want %d, have %d", want, have)
+	}
+}
+
+func TestCallerScope(t *testing.T) {
+	interceptor := UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Pay"}
+	call := func(owner string) int64 {
+		ctx := apikey.NewContext(context.Background(), &apikey.Key{Owner: owner})
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(MetadataKey, "k"))
+		resp, err := interceptor(ctx, &wrappers.StringValue{Value: "pay 10"}, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return &wrappers.Int64Value{Value: int64(len(owner))}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.(*wrappers.Int64Value).Value
+	}
+	call("alice")
+	if want, have := int64(len("mallory")), call("mallory"); want != have {
+		t.Fatalf("other caller with the same key: want %d, have %d", want, have)
+	}
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+	s.Begin(ctx, "k", Record{Fingerprint: "a"}, -time.Second)
+	rec, _ := s.Begin(ctx, "k", Record{Fingerprint: "b"}, time.Minute)
+	if rec != nil {
+		t.Fatalf("expired record returned: %+v", rec)
+	}
+	rec, _ = s.Begin(ctx, "k", Record{Fingerprint: "c"}, time.Minute)
+	if rec == nil || rec.Fingerprint != "b" {
+		t.Fatalf("want record b, have %+v", rec)
+	}
+}
@@ -0,0 +1,68 @@
+package idempotency
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// MemoryStore is a Store in memory, for single servers.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]memoryRecord
+	sweep   time.Time
+}
+
+type memoryRecord struct {
+	Record
+	expires time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]memoryRecord)}
+}
+
+// Begin stores rec under key unless it has a record.
+func (s *MemoryStore) Begin(ctx context.Context, key string, rec Record, ttl time.Duration) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.expire(now)
+	if r, ok := s.records[key]; ok && now.Before(r.expires) {
+		existing := r.Record
+		return &existing, nil
+	}
+	s.records[key] = memoryRecord{Record: rec, expires: now.Add(ttl)}
+	return nil, nil
+}
+
+// Complete replaces the record of key.
+func (s *MemoryStore) Complete(ctx context.Context, key string, rec Record, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[key] = memoryRecord{Record: rec, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+// Abort removes the record of key.
+func (s *MemoryStore) Abort(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+// expire removes expired records, at most once a minute. s.mu must be held.
+func (s *MemoryStore) expire(now time.Time) {
+	if now.Sub(s.sweep) < time.Minute {
+		return
+	}
+	s.sweep = now
+	for key, r := range s.records {
+		if !now.Before(r.expires) {
+			delete(s.records, key)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Package idle provides a server interceptor aborting streams which neither
+// receive nor send messages for a while, to reclaim the resources of streams
+// abandoned by their clients long before their deadline.
+package idle
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfans/grpctools/middleware"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type options struct {
+	timeouts map[string]time.Duration
+}
+
+// Option for the idle interceptor.
+type Option func(o *options)
+
+// WithMethodTimeouts sets idle timeouts per full method name, like
+// "/foo.v1.EventService/Watch". Zero disables the timeout of a method.
+func WithMethodTimeouts(timeouts map[string]time.Duration) Option {
+	return func(o *options) {
+		o.timeouts = timeouts
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor ending
+// streams with DeadlineExceeded once no RecvMsg or SendMsg completed for
+// timeout, or the timeout of their method. The context of the handler is
+// canceled, and handlers must return once it is done: the stream is closed
+// when the interceptor returns, so pending calls on it fail.
+func StreamServerInterceptor(timeout time.Duration, opts ...Option) grpc.StreamServerInterceptor {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		d, ok := o.timeouts[info.FullMethod]
+		if !ok {
+			d = timeout
+		}
+		if d <= 0 {
+			return handler(srv, stream)
+		}
+		ctx, cancel := context.WithCancel(stream.Context())
+		defer cancel()
+		s := &idleStream{WrappedServerStream: middleware.WrapServerStream(stream)}
+		s.SetContext(ctx)
+		s.touch()
+
+		errc := make(chan error, 1)
+		go func() {
+			errc <- handler(srv, s)
+		}()
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		for {
+			select {
+			case err := <-errc:
+				return err
+			case <-timer.C:
+				if idle := s.idle(); idle < d {
+					timer.Reset(d - idle)
+					continue
+				}
+				return status.Errorf(codes.DeadlineExceeded, "idle: no stream activity for %v", d)
+			}
+		}
+	}
+}
+
+type idleStream struct {
+	*middleware.WrappedServerStream
+	last int64 // unix nanoseconds, accessed atomically
+}
+
+// touch records activity on the stream.
+func (s *idleStream) touch() {
+	atomic.StoreInt64(&s.last, time.Now().UnixNano())
+}
+
+// idle returns the time since the last activity.
+func (s *idleStream) idle() time.Duration {
+	return time.Duration(time.Now().UnixNano() - atomic.LoadInt64(&s.last))
+}
+
+func (s *idleStream) RecvMsg(m interface{}) error {
+	err := s.WrappedServerStream.RecvMsg(m)
+	s.touch()
+	return err
+}
+
+func (s *idleStream) SendMsg(m interface{}) error {
+	err := s.WrappedServerStream.SendMsg(m)
+	s.touch()
+	return err
+}
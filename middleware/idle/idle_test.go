@@ -0,0 +1,63 @@
+package idle
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testStream struct {
+	grpc.ServerStream
+}
+
+func (s *testStream) Context() context.Context    { return context.Background() }
+func (s *testStream) SendMsg(m interface{}) error { return nil }
+
+func TestStreamServerInterceptor(t *testing.T) {
+	interceptor := StreamServerInterceptor(50*time.Millisecond, WithMethodTimeouts(map[string]time.Duration{
+		"/test.Service/Forever": 0,
+	}))
+	// active sends a message every 20ms for 200ms, well past the timeout.
+	active := func(srv interface{}, stream grpc.ServerStream) error {
+		for i := 0; i < 10; i++ {
+			time.Sleep(20 * time.Millisecond)
+			if err := stream.SendMsg(nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// abandoned waits for the end of the stream without activity.
+	abandoned := func(srv interface{}, stream grpc.ServerStream) error {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		method  string
+		handler grpc.StreamHandler
+		code    codes.Code
+	}{
+		{"active", "/test.Service/Watch", active, codes.OK},
+		{"abandoned", "/test.Service/Watch", abandoned, codes.DeadlineExceeded},
+		{"disabled", "/test.Service/Forever", abandoned, codes.OK},
+	} {
+		start := time.Now()
+		err := interceptor(nil, &testStream{}, &grpc.StreamServerInfo{FullMethod: tc.method}, tc.handler)
+		if want, have := tc.code, status.Code(err); want != have {
+			t.Errorf("%s: want %v, have %v", tc.name, want, have)
+		}
+		if tc.code == codes.DeadlineExceeded && time.Since(start) > 500*time.Millisecond {
+			t.Errorf("%s: aborted after %v", tc.name, time.Since(start))
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Package ipfilter provides server interceptors allowing or denying requests
+// by the network address of the client.
+package ipfilter
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultForwardedKey is the metadata key proxies list client addresses in
+// by default.
+const DefaultForwardedKey = "x-forwarded-for"
+
+// Rule lists networks, as CIDRs like "10.0.0.0/8" or single addresses.
+// Clients in a Deny network are rejected; when Allow is set, so are clients
+// in none of its networks.
+type Rule struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// Policy sets rules per full method name, like "/foo.v1.UserService/Get", or
+// per service, like "foo.v1.UserService", the most specific winning. "*"
+// sets the rule of other methods, which are open to any client without it.
+type Policy map[string]Rule
+
+type options struct {
+	proxies      []string
+	forwardedKey string
+}
+
+// Option for Filter instance.
+type Option func(o *options)
+
+// WithTrustedProxies sets the networks of the proxies in front of the
+// server. Requests through them are filtered by the client address they add
+// to the forwarded metadata key; the key is ignored for requests from other
+// peers, so clients can't spoof it.
+func WithTrustedProxies(networks ...string) Option {
+	return func(o *options) {
+		o.proxies = networks
+	}
+}
+
+// WithForwardedKey sets the metadata key trusted proxies list client
+// addresses in, comma separated with the client first. Default is
+// DefaultForwardedKey.
+func WithForwardedKey(key string) Option {
+	return func(o *options) {
+		o.forwardedKey = key
+	}
+}
+
+// networks is a list of parsed networks.
+type networks []*net.IPNet
+
+func parseNetworks(list []string) (networks, error) {
+	var n networks
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("ipfilter: invalid address %q", s)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			n = append(n, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("ipfilter: %v", err)
+		}
+		n = append(n, network)
+	}
+	return n, nil
+}
+
+func (n networks) contains(ip net.IP) bool {
+	for _, network := range n {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type rule struct {
+	allow, deny networks
+}
+
+func (r rule) allowed(ip net.IP) bool {
+	if r.deny.contains(ip) {
+		return false
+	}
+	return len(r.allow) == 0 || r.allow.contains(ip)
+}
+
+// Filter enforces a Policy.
+type Filter struct {
+	rules        map[string]rule
+	proxies      networks
+	forwardedKey string
+}
+
+// New returns a Filter enforcing policy, or an error if it lists invalid
+// networks.
+func New(policy Policy, opts ...Option) (*Filter, error) {
+	o := options{forwardedKey: DefaultForwardedKey}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	proxies, err := parseNetworks(o.proxies)
+	if err != nil {
+		return nil, err
+	}
+	f := &Filter{
+		rules:        make(map[string]rule, len(policy)),
+		proxies:      proxies,
+		forwardedKey: o.forwardedKey,
+	}
+	for pattern, r := range policy {
+		allow, err := parseNetworks(r.Allow)
+		if err != nil {
+			return nil, err
+		}
+		deny, err := parseNetworks(r.Deny)
+		if err != nil {
+			return nil, err
+		}
+		f.rules[pattern] = rule{allow: allow, deny: deny}
+	}
+	return f, nil
+}
+
+// ClientIP returns the address of the client of a request: the peer
+// address, or for requests through trusted proxies the last address they
+// forwarded which isn't a trusted proxy itself. It returns nil if the address
+// is unknown.
+func (f *Filter) ClientIP(ctx context.Context) net.IP {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return nil
+	}
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil || !f.proxies.contains(ip) {
+		return ip
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	var hops []string
+	for _, v := range md.Get(f.forwardedKey) {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	// Walk back from the nearest hop: addresses before the first untrusted
+	// one may have been set by the client.
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			return ip
+		}
+		ip = hop
+		if !f.proxies.contains(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+func (f *Filter) rule(method string) (rule, bool) {
+	if r, ok := f.rules[method]; ok {
+		return r, true
+	}
+	if r, ok := f.rules[path.Dir(method)[1:]]; ok {
+		return r, true
+	}
+	r, ok := f.rules["*"]
+	return r, ok
+}
+
+func (f *Filter) check(ctx context.Context, method string) error {
+	r, ok := f.rule(method)
+	if !ok {
+		return nil
+	}
+	ip := f.ClientIP(ctx)
+	if ip == nil {
+		return status.Error(codes.PermissionDenied, "ipfilter: unknown client address")
+	}
+	if !r.allowed(ip) {
+		return status.Errorf(codes.PermissionDenied, "ipfilter: address %s not allowed", ip)
+	}
+	return nil
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor rejecting
+// requests from clients the policy doesn't allow with PermissionDenied.
+func (f *Filter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := f.check(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor
+// rejecting streams from clients the policy doesn't allow with
+// PermissionDenied.
+func (f *Filter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := f.check(stream.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
@@ -0,0 +1,70 @@
+package ipfilter
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func from(addr string, forwarded ...string) context.Context {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(addr), Port: 4242}})
+	if len(forwarded) > 0 {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(DefaultForwardedKey, forwarded[0]))
+	}
+	return ctx
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	f, err := New(Policy{
+		"test.v1.Admin":          {Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.6.6.0/24"}},
+		"/test.v1.Admin/Version": {},
+		"*":                      {Deny: []string{"203.0.113.7", "2001:db8::/32"}},
+	}, WithTrustedProxies("192.168.1.0/24"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	interceptor := f.UnaryServerInterceptor()
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	}
+
+	for _, tc := range []struct {
+		name   string
+		ctx    context.Context
+		method string
+		code   codes.Code
+	}{
+		{"allowed", from("10.1.2.3"), "/test.v1.Admin/Reset", codes.OK},
+		{"not allowed", from("172.16.0.1"), "/test.v1.Admin/Reset", codes.PermissionDenied},
+		{"denied", from("10.6.6.6"), "/test.v1.Admin/Reset", codes.PermissionDenied},
+		{"method rule", from("172.16.0.1"), "/test.v1.Admin/Version", codes.OK},
+		{"default rule", from("203.0.113.7"), "/test.v1.Service/Get", codes.PermissionDenied},
+		{"default rule IPv6", from("2001:db8::1"), "/test.v1.Service/Get", codes.PermissionDenied},
+		{"default rule other", from("203.0.113.8"), "/test.v1.Service/Get", codes.OK},
+		{"through proxy", from("192.168.1.10", "10.1.2.3"), "/test.v1.Admin/Reset", codes.OK},
+		{"through proxies", from("192.168.1.10", "172.16.0.1, 192.168.1.11"), "/test.v1.Admin/Reset", codes.PermissionDenied},
+		{"spoofed by client", from("192.168.1.10", "10.1.2.3, 172.16.0.1"), "/test.v1.Admin/Reset", codes.PermissionDenied},
+		{"untrusted forwarding", from("172.16.0.1", "10.1.2.3"), "/test.v1.Admin/Reset", codes.PermissionDenied},
+		{"no peer", context.Background(), "/test.v1.Admin/Reset", codes.PermissionDenied},
+	} {
+		_, err := interceptor(tc.ctx, nil, &grpc.UnaryServerInfo{FullMethod: tc.method}, handler)
+		if want, have := tc.code, status.Code(err); want != have {
+			t.Errorf("%s: want %v, have %v (%v)", tc.name, want, have, err)
+		}
+	}
+}
+
+func TestInvalidNetwork(t *testing.T) {
+	if _, err := New(Policy{"*": {Allow: []string{"10.0.0.0/33"}}}); err == nil {
+		t.Fatal("invalid CIDR accepted")
+	}
+	if _, err := New(nil, WithTrustedProxies("proxy.local")); err == nil {
+		t.Fatal("invalid proxy address accepted")
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package loadshed
+
+// cpuSampler is a stub for platforms where CPU usage is not measured.
+type cpuSampler struct{}
+
+func newCPUSampler() *cpuSampler {
+	return &cpuSampler{}
+}
+
+func (c *cpuSampler) utilization() float64 {
+	return 0
+}
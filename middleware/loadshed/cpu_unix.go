@@ -0,0 +1,44 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package loadshed
+
+import (
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// cpuSampler measures the CPU time used by the process between samples.
+// Samples are taken from a single goroutine.
+type cpuSampler struct {
+	lastCPU  time.Duration
+	lastWall time.Time
+}
+
+func newCPUSampler() *cpuSampler {
+	return &cpuSampler{
+		lastCPU:  processCPU(),
+		lastWall: time.Now(),
+	}
+}
+
+// utilization returns the share of GOMAXPROCS used since the last call.
+func (c *cpuSampler) utilization() float64 {
+	cpu, wall := processCPU(), time.Now()
+	elapsed := wall.Sub(c.lastWall)
+	used := cpu - c.lastCPU
+	c.lastCPU, c.lastWall = cpu, wall
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(used) / float64(elapsed) / float64(runtime.GOMAXPROCS(0))
+}
+
+func processCPU() time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
@@ -0,0 +1,309 @@
+// Package loadshed rejects low-priority requests while the process is
+// overloaded.
+package loadshed
+
+import (
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfans/grpctools/metrics"
+	"github.com/ipfans/grpctools/middleware/retry"
+	"github.com/ipfans/grpctools/priority"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type options struct {
+	interval   time.Duration
+	cpu        float64
+	goroutines int
+	heap       uint64
+	inFlight   int64
+	standing   time.Duration
+	fraction   float64
+	policy     priority.Policy
+	sheddable  priority.Priority
+	throttle   time.Duration
+	metrics    metrics.Provider
+	now        func() time.Time
+}
+
+// Option for Shedder instance.
+type Option func(o *options)
+
+// WithSampleInterval sets how often load is sampled. Default is one second,
+// also used for zero or negative intervals.
+func WithSampleInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.interval = d
+	}
+}
+
+// WithCPUThreshold sets the share (0 to 1) of GOMAXPROCS the process may use
+// before it is overloaded. Default is 0.9; zero disables the check. CPU usage
+// is only measured on Unix systems.
+func WithCPUThreshold(utilization float64) Option {
+	return func(o *options) {
+		o.cpu = utilization
+	}
+}
+
+// WithGoroutineThreshold sets the number of goroutines above which the
+// process is overloaded. Zero, the default, disables the check.
+func WithGoroutineThreshold(n int) Option {
+	return func(o *options) {
+		o.goroutines = n
+	}
+}
+
+// WithMemoryThreshold sets the heap size in bytes above which the process is
+// overloaded. Zero, the default, disables the check. The heap is measured by
+// runtime.ReadMemStats, which stops the world briefly on every sample: keep
+// the sample interval in seconds when this check is enabled.
+func WithMemoryThreshold(bytes uint64) Option {
+	return func(o *options) {
+		o.heap = bytes
+	}
+}
+
+// WithInFlightThreshold sets the number of unary requests in flight in the
+// interceptors above which the process is overloaded once it has stayed above
+// for standing, like the target delay of CoDel: bursts the server absorbs
+// don't shed, standing queues do. Depth is tracked on every request rather
+// than sampled. Zero, the default, disables the check. Streams, which may be
+// long-lived, aren't counted.
+func WithInFlightThreshold(n int, standing time.Duration) Option {
+	return func(o *options) {
+		o.inFlight = int64(n)
+		o.standing = standing
+	}
+}
+
+// WithDropFraction sets the fraction (0 to 1) of lowest priority requests
+// rejected while overloaded. Each priority above sheds half as many requests
+// as the one below. Default is 0.5.
+func WithDropFraction(f float64) Option {
+	return func(o *options) {
+		o.fraction = f
+	}
+}
+
+// WithPriorityPolicy sets how request priorities are resolved. Default is
+// priority.DefaultPolicy.
+func WithPriorityPolicy(p priority.Policy) Option {
+	return func(o *options) {
+		o.policy = p
+	}
+}
+
+// WithSheddable sets the highest priority that may be shed. Default is
+// priority.Normal, so High and Critical requests are never shed.
+func WithSheddable(max priority.Priority) Option {
+	return func(o *options) {
+		o.sheddable = max
+	}
+}
+
+// WithRetryThrottle sets the retry.ThrottleKey trailer on shed requests, so
+// that clients using the retry interceptors stop retrying any call for d
+// instead of adding to the overload.
+func WithRetryThrottle(d time.Duration) Option {
+	return func(o *options) {
+		o.throttle = d
+	}
+}
+
+// WithMetrics reports shedding metrics through given provider.
+func WithMetrics(p metrics.Provider) Option {
+	return func(o *options) {
+		o.metrics = p
+	}
+}
+
+// Shedder samples process load in the background and rejects a fraction of
+// low-priority requests with Unavailable while any threshold is crossed.
+// Shedding stops as soon as a sample is back under all thresholds.
+type Shedder struct {
+	opts       options
+	overloaded int32 // accessed atomically
+
+	// inFlight counts unary requests in flight, and aboveSince is when it
+	// went above the threshold in Unix nanoseconds, zero while below. Both
+	// are accessed atomically.
+	inFlight   int64
+	aboveSince int64
+
+	cpu             *cpuSampler
+	shed            metrics.Counter
+	overloadedGauge metrics.Gauge
+	inFlightGauge   metrics.Gauge
+
+	quit      chan struct{}
+	closeOnce sync.Once
+}
+
+// New initializes a Shedder and starts sampling.
+func New(opts ...Option) *Shedder {
+	o := options{
+		interval:  time.Second,
+		cpu:       0.9,
+		fraction:  0.5,
+		policy:    priority.DefaultPolicy,
+		sheddable: priority.Normal,
+		metrics:   metrics.Discard,
+		now:       time.Now,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.interval <= 0 {
+		o.interval = time.Second
+	}
+
+	s := &Shedder{
+		opts:            o,
+		cpu:             newCPUSampler(),
+		shed:            o.metrics.NewCounter("grpc_loadshed_shed_total", "Total number of requests rejected by load shedding.", "method"),
+		overloadedGauge: o.metrics.NewGauge("grpc_loadshed_overloaded", "Whether the server is shedding load (1) or not (0)."),
+		inFlightGauge:   o.metrics.NewGauge("grpc_loadshed_in_flight", "Number of unary requests in flight."),
+		quit:            make(chan struct{}),
+	}
+	go s.sampler()
+	return s
+}
+
+// Close stops sampling.
+func (s *Shedder) Close() {
+	s.closeOnce.Do(func() {
+		close(s.quit)
+	})
+}
+
+// Overloaded reports whether the last sample crossed a threshold, or requests
+// in flight have stayed above theirs for the standing time.
+func (s *Shedder) Overloaded() bool {
+	return atomic.LoadInt32(&s.overloaded) == 1 || s.standingQueue()
+}
+
+// standingQueue reports whether requests in flight have stayed above the
+// threshold for the standing time.
+func (s *Shedder) standingQueue() bool {
+	if s.opts.inFlight <= 0 || atomic.LoadInt64(&s.inFlight) <= s.opts.inFlight {
+		return false
+	}
+	now := s.opts.now().UnixNano()
+	since := atomic.LoadInt64(&s.aboveSince)
+	if since == 0 {
+		atomic.CompareAndSwapInt64(&s.aboveSince, 0, now)
+		return false
+	}
+	return now-since >= int64(s.opts.standing)
+}
+
+// track counts a unary request in flight until the returned function is
+// called.
+func (s *Shedder) track() func() {
+	atomic.AddInt64(&s.inFlight, 1)
+	s.inFlightGauge.Add(1)
+	return func() {
+		if atomic.AddInt64(&s.inFlight, -1) <= s.opts.inFlight {
+			atomic.StoreInt64(&s.aboveSince, 0)
+		}
+		s.inFlightGauge.Add(-1)
+	}
+}
+
+func (s *Shedder) sampler() {
+	ticker := time.NewTicker(s.opts.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+			s.setOverloaded(s.sample())
+		}
+	}
+}
+
+func (s *Shedder) setOverloaded(overloaded bool) {
+	var v int32
+	if overloaded {
+		v = 1
+	}
+	atomic.StoreInt32(&s.overloaded, v)
+	s.overloadedGauge.Set(float64(v))
+}
+
+// sample reports whether any threshold is crossed.
+func (s *Shedder) sample() bool {
+	overloaded := false
+	if s.opts.cpu > 0 && s.cpu.utilization() > s.opts.cpu {
+		overloaded = true
+	}
+	if s.opts.goroutines > 0 && runtime.NumGoroutine() > s.opts.goroutines {
+		overloaded = true
+	}
+	if s.opts.heap > 0 {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		if ms.HeapAlloc > s.opts.heap {
+			overloaded = true
+		}
+	}
+	return overloaded
+}
+
+// dropProbability returns the probability of shedding a request of prio.
+func (s *Shedder) dropProbability(prio priority.Priority) float64 {
+	if !s.Overloaded() || prio > s.opts.sheddable {
+		return 0
+	}
+	steps := float64(prio - priority.Low)
+	if steps < 0 {
+		steps = 0
+	}
+	return s.opts.fraction / math.Pow(2, steps)
+}
+
+// admit returns an Unavailable error if the request should be shed.
+func (s *Shedder) admit(ctx context.Context, method string) error {
+	p := s.dropProbability(s.opts.policy.Resolve(ctx))
+	if p > 0 && rand.Float64() < p {
+		s.shed.With(method).Add(1)
+		if s.opts.throttle > 0 {
+			retry.Throttle(ctx, s.opts.throttle)
+		}
+		return status.Error(codes.Unavailable, "server is overloaded, try again later")
+	}
+	return nil
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor shedding load.
+func (s *Shedder) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := s.admit(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		if s.opts.inFlight > 0 {
+			defer s.track()()
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor shedding load.
+func (s *Shedder) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := s.admit(stream.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
@@ -0,0 +1,153 @@
+package loadshed
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfans/grpctools/middleware/retry"
+	"github.com/ipfans/grpctools/priority"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestDropProbability(t *testing.T) {
+	s := New(WithSampleInterval(time.Hour), WithDropFraction(0.8))
+	defer s.Close()
+
+	if p := s.dropProbability(priority.Low); p != 0 {
+		t.Fatalf("not overloaded: want 0, have %v", p)
+	}
+	s.setOverloaded(true)
+	for _, tc := range []struct {
+		prio priority.Priority
+		want float64
+	}{
+		{priority.Low, 0.8},
+		{priority.Normal, 0.4},
+		{priority.High, 0},
+		{priority.Critical, 0},
+	} {
+		if have := s.dropProbability(tc.prio); tc.want != have {
+			t.Errorf("dropProbability(%v): want %v, have %v", tc.prio, tc.want, have)
+		}
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	s := New(WithSampleInterval(time.Hour), WithDropFraction(1), WithSheddable(priority.Low), WithPriorityPolicy(priority.Policy{Default: priority.Low, Max: priority.Low}))
+	defer s.Close()
+	interceptor := s.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	if _, err := interceptor(context.Background(), nil, info, handler); err != nil {
+		t.Fatal(err)
+	}
+	s.setOverloaded(true)
+	_, err := interceptor(context.Background(), nil, info, handler)
+	if want, have := codes.Unavailable, status.Code(err); want != have {
+		t.Fatalf("overloaded: want %v, have %v", want, have)
+	}
+	s.setOverloaded(false)
+	if _, err := interceptor(context.Background(), nil, info, handler); err != nil {
+		t.Fatalf("recovered: %v", err)
+	}
+}
+
+func TestInFlightThreshold(t *testing.T) {
+	s := New(WithSampleInterval(time.Hour), WithInFlightThreshold(2, 100*time.Millisecond), WithDropFraction(1), WithSheddable(priority.Low), WithPriorityPolicy(priority.Policy{Default: priority.Low, Max: priority.Low}))
+	defer s.Close()
+	now := time.Now()
+	s.opts.now = func() time.Time { return now }
+	interceptor := s.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	started := make(chan struct{})
+	release := make([]chan struct{}, 3)
+	for i := range release {
+		ch := make(chan struct{})
+		release[i] = ch
+		go interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			started <- struct{}{}
+			<-ch
+			return "ok", nil
+		})
+		<-started
+	}
+
+	// A burst above the threshold is served until it stands.
+	if _, err := interceptor(context.Background(), nil, info, handler); err != nil {
+		t.Fatalf("burst: %v", err)
+	}
+	now = now.Add(50 * time.Millisecond)
+	if _, err := interceptor(context.Background(), nil, info, handler); err != nil {
+		t.Fatalf("burst: %v", err)
+	}
+	now = now.Add(100 * time.Millisecond)
+	_, err := interceptor(context.Background(), nil, info, handler)
+	if want, have := codes.Unavailable, status.Code(err); want != have {
+		t.Fatalf("standing queue: want %v, have %v", want, have)
+	}
+
+	close(release[0])
+	for i := 0; atomic.LoadInt64(&s.inFlight) != 2; i++ {
+		if i == 1000 {
+			t.Fatal("request still in flight")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := interceptor(context.Background(), nil, info, handler); err != nil {
+		t.Fatalf("drained: %v", err)
+	}
+	close(release[1])
+	close(release[2])
+}
+
+// trailerStream records the trailer set by interceptors.
+type trailerStream struct {
+	trailer metadata.MD
+}
+
+func (s *trailerStream) Method() string                  { return "/test.Service/Get" }
+func (s *trailerStream) SetHeader(md metadata.MD) error  { return nil }
+func (s *trailerStream) SendHeader(md metadata.MD) error { return nil }
+func (s *trailerStream) SetTrailer(md metadata.MD) error {
+	s.trailer = metadata.Join(s.trailer, md)
+	return nil
+}
+
+func TestRetryThrottle(t *testing.T) {
+	s := New(WithSampleInterval(time.Hour), WithDropFraction(1), WithSheddable(priority.Low), WithPriorityPolicy(priority.Policy{Default: priority.Low, Max: priority.Low}), WithRetryThrottle(5*time.Second))
+	defer s.Close()
+	s.setOverloaded(true)
+	stream := &trailerStream{}
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+	_, err := s.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	})
+	if want, have := codes.Unavailable, status.Code(err); want != have {
+		t.Fatalf("overloaded: want %v, have %v", want, have)
+	}
+	if want, have := []string{"5000"}, stream.trailer.Get(retry.ThrottleKey); len(have) != 1 || want[0] != have[0] {
+		t.Fatalf("trailer: want %v, have %v", want, have)
+	}
+}
+
+func TestInvalidSampleInterval(t *testing.T) {
+	for _, d := range []time.Duration{0, -time.Second} {
+		s := New(WithSampleInterval(d))
+		if want, have := time.Second, s.opts.interval; want != have {
+			t.Errorf("interval %v: want %v, have %v", d, want, have)
+		}
+		s.Close()
+	}
+}
@@ -0,0 +1,213 @@
+// Package locale provides server interceptors negotiating the language of
+// user-facing messages from request metadata, and localizers to write them.
+package locale
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ipfans/grpctools/middleware"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// DefaultMetadataKey is the metadata key listing the languages of the
+// client by default, formatted like the Accept-Language HTTP header:
+// "fr-CH, fr;q=0.9, en;q=0.8".
+const DefaultMetadataKey = "accept-language"
+
+// Catalog holds message formats by language and key.
+type Catalog interface {
+	// Format returns the fmt format of the message key in lang, false if
+	// there is none.
+	Format(lang, key string) (string, bool)
+}
+
+// Messages is a Catalog of fixed formats, by BCP 47 language tag like "en"
+// or "pt-BR", then by key.
+type Messages map[string]map[string]string
+
+// Format returns the format of key in lang.
+func (m Messages) Format(lang, key string) (string, bool) {
+	f, ok := m[lang][key]
+	return f, ok
+}
+
+// Localizer writes messages in the language of a request.
+type Localizer struct {
+	// Lang is the language negotiated, like "en".
+	Lang     string
+	fallback string
+	catalog  Catalog
+}
+
+// Format returns the format of the message key in the language of l, or in
+// the default language if the catalog doesn't have it, false if it has
+// neither.
+func (l *Localizer) Format(key string) (string, bool) {
+	if format, ok := l.catalog.Format(l.Lang, key); ok {
+		return format, true
+	}
+	return l.catalog.Format(l.fallback, key)
+}
+
+// Sprintf formats the message key in the language of l, or in the default
+// language if the catalog doesn't have it. Keys missing in both are used
+// as the format.
+func (l *Localizer) Sprintf(key string, a ...interface{}) string {
+	format, ok := l.Format(key)
+	if !ok {
+		format = key
+	}
+	if len(a) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, a...)
+}
+
+// LocalizedMessage returns the message key in the language of l as a
+// LocalizedMessage error detail.
+func (l *Localizer) LocalizedMessage(key string, a ...interface{}) *errdetails.LocalizedMessage {
+	return &errdetails.LocalizedMessage{Locale: l.Lang, Message: l.Sprintf(key, a...)}
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying l.
+func NewContext(ctx context.Context, l *Localizer) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the localizer of a request, false if it has none.
+func FromContext(ctx context.Context) (*Localizer, bool) {
+	l, ok := ctx.Value(contextKey{}).(*Localizer)
+	return l, ok
+}
+
+type options struct {
+	metadataKey string
+	fallback    string
+	languages   []string
+}
+
+// Option for locale interceptors.
+type Option func(o *options)
+
+// WithMetadataKey sets the metadata key listing the languages of clients.
+// Default is DefaultMetadataKey.
+func WithMetadataKey(key string) Option {
+	return func(o *options) {
+		o.metadataKey = key
+	}
+}
+
+// WithDefault sets the language of requests asking for no supported one.
+// Default is "en".
+func WithDefault(lang string) Option {
+	return func(o *options) {
+		o.fallback = lang
+	}
+}
+
+// WithLanguages sets the languages supported. Default is the default
+// language only.
+func WithLanguages(langs ...string) Option {
+	return func(o *options) {
+		o.languages = langs
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		metadataKey: DefaultMetadataKey,
+		fallback:    "en",
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Negotiate returns the supported language best matching an Accept-Language
+// style list, or "" if none does. Tags match exactly, ignoring case, or by
+// their base language: "fr-CH" matches a supported "fr", and "fr" a
+// supported "fr-FR".
+func Negotiate(accept string, supported []string) string {
+	type choice struct {
+		tag string
+		q   float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		c := choice{tag: strings.TrimSpace(fields[0]), q: 1}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					c.q = q
+				}
+			}
+		}
+		if c.tag != "" && c.tag != "*" && c.q > 0 {
+			choices = append(choices, c)
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	for _, c := range choices {
+		for _, lang := range supported {
+			if strings.EqualFold(c.tag, lang) {
+				return lang
+			}
+		}
+		for _, lang := range supported {
+			if strings.EqualFold(base(c.tag), base(lang)) {
+				return lang
+			}
+		}
+	}
+	return ""
+}
+
+// base returns the base language of a tag, like "fr" for "fr-CH".
+func base(tag string) string {
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		return tag[:i]
+	}
+	return tag
+}
+
+func (o options) localizer(ctx context.Context, catalog Catalog) *Localizer {
+	md, _ := metadata.FromIncomingContext(ctx)
+	lang := Negotiate(strings.Join(md.Get(o.metadataKey), ","), o.languages)
+	if lang == "" {
+		lang = o.fallback
+	}
+	return &Localizer{Lang: lang, fallback: o.fallback, catalog: catalog}
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor storing a
+// Localizer of catalog in the language of each request in the context of
+// handlers.
+func UnaryServerInterceptor(catalog Catalog, opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(NewContext(ctx, o.localizer(ctx, catalog)), req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor storing
+// a Localizer of catalog in the language of each stream in the context of
+// handlers.
+func StreamServerInterceptor(catalog Catalog, opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		wrapped := middleware.WrapServerStream(stream)
+		wrapped.SetContext(NewContext(stream.Context(), o.localizer(stream.Context(), catalog)))
+		return handler(srv, wrapped)
+	}
+}
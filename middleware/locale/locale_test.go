@@ -0,0 +1,113 @@
+package locale
+
+import (
+	"testing"
+
+	"github.com/ipfans/grpctools/middleware"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+var catalog = Messages{
+	"en": {
+		"QUOTA_EXCEEDED": "You have used all of your %d requests.",
+		"USER_NOT_FOUND": "This user doesn't exist.",
+	},
+	"fr": {
+		"QUOTA_EXCEEDED": "Vous avez utilisé vos %d requêtes.",
+	},
+}
+
+func TestNegotiate(t *testing.T) {
+	supported := []string{"en", "fr", "pt-BR"}
+	for _, tc := range []struct {
+		accept string
+		lang   string
+	}{
+		{"fr", "fr"},
+		{"FR", "fr"},
+		{"fr-CH, fr;q=0.9, en;q=0.8", "fr"},
+		{"de, en;q=0.5", "en"},
+		{"en;q=0.5, fr", "fr"},
+		{"pt", "pt-BR"},
+		{"fr;q=0, en", "en"},
+		{"de, *", ""},
+		{"", ""},
+	} {
+		if want, have := tc.lang, Negotiate(tc.accept, supported); want != have {
+			t.Errorf("%q: want %q, have %q", tc.accept, want, have)
+		}
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := UnaryServerInterceptor(catalog, WithLanguages("en", "fr"))
+	var have *Localizer
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		have, _ = FromContext(ctx)
+		return nil, nil
+	}
+
+	for _, tc := range []struct {
+		name   string
+		accept string
+		lang   string
+		quota  string
+		user   string
+	}{
+		{"french", "fr-CH, en;q=0.5", "fr", "Vous avez utilisé vos 10 requêtes.", "This user doesn't exist."},
+		{"english", "en", "en", "You have used all of your 10 requests.", "This user doesn't exist."},
+		{"unsupported", "de", "en", "You have used all of your 10 requests.", "This user doesn't exist."},
+	} {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(DefaultMetadataKey, tc.accept))
+		if _, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, handler); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if want, have := tc.lang, have.Lang; want != have {
+			t.Errorf("%s: lang: want %q, have %q", tc.name, want, have)
+		}
+		if want, have := tc.quota, have.Sprintf("QUOTA_EXCEEDED", 10); want != have {
+			t.Errorf("%s: quota: want %q, have %q", tc.name, want, have)
+		}
+		if want, have := tc.user, have.Sprintf("USER_NOT_FOUND"); want != have {
+			t.Errorf("%s: fallback: want %q, have %q", tc.name, want, have)
+		}
+	}
+
+	if want, have := "MISSING_KEY", have.Sprintf("MISSING_KEY"); want != have {
+		t.Fatalf("missing key: want %q, have %q", want, have)
+	}
+	msg := have.LocalizedMessage("USER_NOT_FOUND")
+	if want, have := "en", msg.Locale; want != have {
+		t.Fatalf("detail locale: want %q, have %q", want, have)
+	}
+}
+
+type testStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testStream) Context() context.Context { return s.ctx }
+
+func TestStreamServerInterceptor(t *testing.T) {
+	interceptor := StreamServerInterceptor(catalog, WithLanguages("en", "fr"), WithDefault("fr"), WithMetadataKey("x-lang"))
+	stream := &testStream{ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-lang", "de"))}
+	err := interceptor(nil, stream, &grpc.StreamServerInfo{FullMethod: "/test.Service/Watch"}, func(srv interface{}, stream grpc.ServerStream) error {
+		if _, ok := stream.(*middleware.WrappedServerStream); !ok {
+			t.Fatal("stream not wrapped")
+		}
+		l, ok := FromContext(stream.Context())
+		if !ok {
+			t.Fatal("no localizer")
+		}
+		if want, have := "fr", l.Lang; want != have {
+			t.Fatalf("lang: want %q, have %q", want, have)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,382 @@
+// Package logging provides interceptors logging every RPC through a pluggable
+// structured logger.
+package logging
+
+import (
+	"math/rand"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+// Levels, from least to most severe.
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+func (l Level) String() string {
+	switch l {
+	case Debug:
+		return "debug"
+	case Info:
+		return "info"
+	case Warn:
+		return "warn"
+	case Error:
+		return "error"
+	}
+	return "unknown"
+}
+
+// Field is a key-value pair attached to a log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger writes structured log entries. Adapters for slog, zap and logrus are
+// in the subpackages of this package.
+type Logger interface {
+	Log(ctx context.Context, level Level, msg string, fields ...Field)
+}
+
+// LoggerFunc is an adapter to use a function as a Logger.
+type LoggerFunc func(ctx context.Context, level Level, msg string, fields ...Field)
+
+// Log calls f.
+func (f LoggerFunc) Log(ctx context.Context, level Level, msg string, fields ...Field) {
+	f(ctx, level, msg, fields...)
+}
+
+// GRPCLogger returns a Logger writing to a grpclog.LoggerV2, fields appended to
+// the message as key=value.
+func GRPCLogger(logger grpclog.LoggerV2) Logger {
+	return LoggerFunc(func(ctx context.Context, level Level, msg string, fields ...Field) {
+		args := []interface{}{msg}
+		for _, f := range fields {
+			args = append(args, " ", f.Key, "=", f.Value)
+		}
+		switch level {
+		case Error:
+			logger.Error(args...)
+		case Warn:
+			logger.Warning(args...)
+		default:
+			logger.Info(args...)
+		}
+	})
+}
+
+// Fields selects the fields logged per RPC.
+type Fields uint
+
+// Fields logged per RPC.
+const (
+	FieldService  Fields = 1 << iota // "grpc.service"
+	FieldMethod                      // "grpc.method"
+	FieldPeer                        // "peer.address"
+	FieldDeadline                    // "grpc.deadline", if any
+	FieldCode                        // "grpc.code"
+	FieldDuration                    // "grpc.duration"
+	FieldError                       // "error", if any
+
+	AllFields = FieldService | FieldMethod | FieldPeer | FieldDeadline | FieldCode | FieldDuration | FieldError
+)
+
+// DefaultPayloadLimit is the size in bytes payloads are truncated at.
+const DefaultPayloadLimit = 4 << 10
+
+// Truncated ends payloads truncated at the payload limit.
+const Truncated = "...[TRUNCATED]"
+
+// DefaultLevel logs codes caused by clients at Info, and those pointing at
+// server problems at Error.
+func DefaultLevel(code codes.Code) Level {
+	switch code {
+	case codes.OK, codes.Canceled, codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.Unauthenticated:
+		return Info
+	case codes.PermissionDenied, codes.ResourceExhausted, codes.FailedPrecondition, codes.Aborted, codes.OutOfRange:
+		return Warn
+	}
+	return Error
+}
+
+type options struct {
+	logger Logger
+	fields Fields
+	level  func(codes.Code) Level
+	skip   map[string]bool
+	extra  []func(ctx context.Context) []Field
+
+	payloads     *Redactor
+	payloadLimit int
+	sample       func(ctx context.Context, method string) bool
+
+	sampling       int
+	methodSampling map[string]int
+	slow           time.Duration
+	calls          *sync.Map // method to *uint64 count of successful calls
+}
+
+// Option for logging interceptors.
+type Option func(o *options)
+
+// WithLogger sets the Logger RPCs are logged to. Default writes to a
+// grpclog.LoggerV2.
+func WithLogger(logger Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithFields sets the fields logged per RPC. Default is AllFields.
+func WithFields(fields Fields) Option {
+	return func(o *options) {
+		o.fields = fields
+	}
+}
+
+// WithLevels sets the level RPCs are logged at by status code. Default is
+// DefaultLevel.
+func WithLevels(level func(codes.Code) Level) Option {
+	return func(o *options) {
+		o.level = level
+	}
+}
+
+// WithSkipMethods sets full method names not logged, like health checks.
+func WithSkipMethods(methods ...string) Option {
+	return func(o *options) {
+		for _, m := range methods {
+			o.skip[m] = true
+		}
+	}
+}
+
+// WithContextFields adds the fields f returns for the context of each RPC,
+// like tenant.LogFields.
+func WithContextFields(f func(ctx context.Context) []Field) Option {
+	return func(o *options) {
+		o.extra = append(o.extra, f)
+	}
+}
+
+// WithPayloads logs unary requests and responses as JSON, "grpc.request" and
+// "grpc.response", with sensitive fields masked by r. Responses of failed calls
+// are not logged. Payloads are not logged by default.
+func WithPayloads(r *Redactor) Option {
+	return func(o *options) {
+		o.payloads = r
+	}
+}
+
+// WithPayloadLimit sets the size in bytes payloads are truncated at, 0 for no
+// limit. Default is DefaultPayloadLimit.
+func WithPayloadLimit(n int) Option {
+	return func(o *options) {
+		o.payloadLimit = n
+	}
+}
+
+// WithPayloadSampler only logs the payloads of successful calls f returns
+// true for; payloads of failed calls are always logged. Default logs the
+// payloads of every call.
+func WithPayloadSampler(f func(ctx context.Context, method string) bool) Option {
+	return func(o *options) {
+		o.sample = f
+	}
+}
+
+// SampleRate returns a payload sampler keeping given fraction of calls, at
+// random.
+func SampleRate(rate float64) func(ctx context.Context, method string) bool {
+	return func(ctx context.Context, method string) bool {
+		return rand.Float64() < rate
+	}
+}
+
+// WithSampling only logs one in n successful calls of each method, so methods
+// with high traffic don't flood logs. Failed calls, and those slower than
+// WithSlowThreshold, are always logged. Default logs every call.
+func WithSampling(n int) Option {
+	return func(o *options) {
+		o.sampling = n
+	}
+}
+
+// WithMethodSampling only logs one in n successful calls of given full method
+// names, overriding WithSampling.
+func WithMethodSampling(n int, methods ...string) Option {
+	return func(o *options) {
+		for _, m := range methods {
+			o.methodSampling[m] = n
+		}
+	}
+}
+
+// WithSlowThreshold logs calls taking longer than d even when they are not
+// sampled.
+func WithSlowThreshold(d time.Duration) Option {
+	return func(o *options) {
+		o.slow = d
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		logger:       GRPCLogger(grpclog.NewLoggerV2(os.Stdout, os.Stdout, os.Stderr)),
+		fields:       AllFields,
+		level:        DefaultLevel,
+		skip:         make(map[string]bool),
+		payloadLimit: DefaultPayloadLimit,
+
+		methodSampling: make(map[string]int),
+		calls:          &sync.Map{},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// log logs an RPC to method started at start. req and resp are nil for
+// streams.
+func (o options) log(ctx context.Context, msg, method string, start time.Time, err error, req, resp interface{}) {
+	if o.skip[method] {
+		return
+	}
+	duration := time.Since(start)
+	if err == nil && !(o.slow > 0 && duration > o.slow) && !o.sampled(method) {
+		return
+	}
+	code := status.Code(err)
+	fields := make([]Field, 0, 7)
+	if o.fields&FieldService != 0 {
+		fields = append(fields, Field{"grpc.service", path.Dir(method)[1:]})
+	}
+	if o.fields&FieldMethod != 0 {
+		fields = append(fields, Field{"grpc.method", path.Base(method)})
+	}
+	if o.fields&FieldPeer != 0 {
+		if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+			fields = append(fields, Field{"peer.address", p.Addr.String()})
+		}
+	}
+	if o.fields&FieldDeadline != 0 {
+		if d, ok := ctx.Deadline(); ok {
+			fields = append(fields, Field{"grpc.deadline", d})
+		}
+	}
+	if o.fields&FieldCode != 0 {
+		fields = append(fields, Field{"grpc.code", code.String()})
+	}
+	if o.fields&FieldDuration != 0 {
+		fields = append(fields, Field{"grpc.duration", duration})
+	}
+	if o.fields&FieldError != 0 && err != nil {
+		fields = append(fields, Field{"error", err.Error()})
+	}
+	for _, f := range o.extra {
+		fields = append(fields, f(ctx)...)
+	}
+	if o.payloads != nil && (err != nil || o.sample == nil || o.sample(ctx, method)) {
+		if b := o.payloads.JSON(req); b != nil {
+			fields = append(fields, Field{"grpc.request", o.truncate(b)})
+		}
+		if b := o.payloads.JSON(resp); b != nil && err == nil {
+			fields = append(fields, Field{"grpc.response", o.truncate(b)})
+		}
+	}
+	o.logger.Log(ctx, o.level(code), msg, fields...)
+}
+
+// sampled counts a successful call to method, and reports whether it is logged:
+// the first of every n calls is.
+func (o options) sampled(method string) bool {
+	n, ok := o.methodSampling[method]
+	if !ok {
+		n = o.sampling
+	}
+	if n <= 1 {
+		return true
+	}
+	c, _ := o.calls.LoadOrStore(method, new(uint64))
+	return (atomic.AddUint64(c.(*uint64), 1)-1)%uint64(n) == 0
+}
+
+// truncate returns payload b as a string of at most the payload limit, not
+// counting Truncated, without splitting characters.
+func (o options) truncate(b []byte) string {
+	if o.payloadLimit <= 0 || len(b) <= o.payloadLimit {
+		return string(b)
+	}
+	n := o.payloadLimit
+	for n > 0 && !utf8.RuneStart(b[n]) {
+		n--
+	}
+	return string(b[:n]) + Truncated
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor logging every
+// RPC.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		o.log(ctx, "finished unary call", info.FullMethod, start, err, req, resp)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor logging
+// every RPC.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, stream)
+		o.log(stream.Context(), "finished streaming call", info.FullMethod, start, err, nil, nil)
+		return err
+	}
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor logging every
+// call. The peer is not known to client interceptors and never logged.
+func UnaryClientInterceptor(opts ...Option) grpc.UnaryClientInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, callOpts...)
+		o.log(ctx, "finished client unary call", method, start, err, req, reply)
+		return err
+	}
+}
+
+// StreamClientInterceptor returns a new streaming client interceptor logging
+// the opening of every stream.
+func StreamClientInterceptor(opts ...Option) grpc.StreamClientInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		stream, err := streamer(ctx, desc, cc, method, callOpts...)
+		o.log(ctx, "started client stream", method, start, err, nil, nil)
+		return stream, err
+	}
+}
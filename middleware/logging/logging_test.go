@@ -0,0 +1,283 @@
+package logging
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type entry struct {
+	level  Level
+	msg    string
+	fields map[string]interface{}
+}
+
+func recorder(entries *[]entry) Logger {
+	return LoggerFunc(func(ctx context.Context, level Level, msg string, fields ...Field) {
+		e := entry{level: level, msg: msg, fields: make(map[string]interface{})}
+		for _, f := range fields {
+			e.fields[f.Key] = f.Value
+		}
+		*entries = append(*entries, e)
+	})
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	var entries []entry
+	interceptor := UnaryServerInterceptor(WithLogger(recorder(&entries)), WithSkipMethods("/grpc.health.v1.Health/Check"))
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}})
+
+	for _, tc := range []struct {
+		err   error
+		level Level
+	}{
+		{nil, Info},
+		{status.Error(codes.NotFound, "not found"), Info},
+		{status.Error(codes.ResourceExhausted, "throttled"), Warn},
+		{status.Error(codes.Internal, "boom"), Error},
+	} {
+		entries = nil
+		interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.v1.Service/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, tc.err
+		})
+		if want, have := 1, len(entries); want != have {
+			t.Fatalf("%v: entries: want %d, have %d", tc.err, want, have)
+		}
+		e := entries[0]
+		if want, have := tc.level, e.level; want != have {
+			t.Errorf("%v: level: want %v, have %v", tc.err, want, have)
+		}
+		for key, want := range map[string]interface{}{
+			"grpc.service": "test.v1.Service",
+			"grpc.method":  "Get",
+			"peer.address": "10.0.0.1:1234",
+			"grpc.code":    status.Code(tc.err).String(),
+		} {
+			if have := e.fields[key]; want != have {
+				t.Errorf("%v: %s: want %v, have %v", tc.err, key, want, have)
+			}
+		}
+		if _, ok := e.fields["grpc.duration"]; !ok {
+			t.Errorf("%v: no duration", tc.err)
+		}
+		if _, ok := e.fields["grpc.deadline"]; ok {
+			t.Errorf("%v: deadline logged without a deadline", tc.err)
+		}
+	}
+
+	entries = nil
+	interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	if want, have := 0, len(entries); want != have {
+		t.Fatalf("skipped method: want %d entries, have %d", want, have)
+	}
+}
+
+func TestWithFields(t *testing.T) {
+	var entries []entry
+	interceptor := UnaryClientInterceptor(WithLogger(recorder(&entries)), WithFields(FieldMethod|FieldCode))
+	interceptor(context.Background(), "/test.v1.Service/Get", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	})
+	if want, have := 2, len(entries[0].fields); want != have {
+		t.Fatalf("fields: want %d, have %d: %v", want, have, entries[0].fields)
+	}
+}
+
+type profile struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3"`
+	Ssn  string `protobuf:"bytes,2,opt,name=ssn,proto3"`
+}
+
+func (m *profile) Reset()         { *m = profile{} }
+func (m *profile) String() string { return proto.CompactTextString(m) }
+func (*profile) ProtoMessage()    {}
+
+type signup struct {
+	User     string     `protobuf:"bytes,1,opt,name=user,proto3"`
+	Password string     `protobuf:"bytes,2,opt,name=password,proto3"`
+	Profile  *profile   `protobuf:"bytes,3,opt,name=profile,proto3"`
+	Family   []*profile `protobuf:"bytes,4,rep,name=family,proto3"`
+	Pin      int32      `protobuf:"varint,5,opt,name=pin,proto3"`
+}
+
+func (m *signup) Reset()                  { *m = signup{} }
+func (m *signup) String() string          { return proto.CompactTextString(m) }
+func (*signup) ProtoMessage()             {}
+func (*signup) SensitiveFields() []string { return []string{"pin"} }
+
+func TestRedactor(t *testing.T) {
+	msg := &signup{
+		User:     "alice",
+		Password: "hunter2",
+		Profile:  &profile{Name: "Alice", Ssn: "123-45-6789"},
+		Family:   []*profile{{Name: "Bob", Ssn: "987-65-4321"}},
+		Pin:      1234,
+	}
+	redacted := NewRedactor("password", "*.ssn").Redact(msg).(*signup)
+
+	for _, tc := range []struct {
+		field      string
+		want, have interface{}
+	}{
+		{"user", "alice", redacted.User},
+		{"password", Redacted, redacted.Password},
+		{"profile.name", "Alice", redacted.Profile.Name},
+		{"profile.ssn", Redacted, redacted.Profile.Ssn},
+		{"family.ssn", Redacted, redacted.Family[0].Ssn},
+		{"pin", int32(0), redacted.Pin},
+		{"original password", "hunter2", msg.Password},
+		{"original ssn", "123-45-6789", msg.Profile.Ssn},
+	} {
+		if tc.want != tc.have {
+			t.Errorf("%s: want %v, have %v", tc.field, tc.want, tc.have)
+		}
+	}
+}
+
+type account struct {
+	Owner *profile `protobuf:"bytes,1,opt,name=owner,proto3"`
+}
+
+func (m *account) Reset()                  { *m = account{} }
+func (m *account) String() string          { return proto.CompactTextString(m) }
+func (*account) ProtoMessage()             {}
+func (*account) SensitiveFields() []string { return []string{"owner.ssn"} }
+
+func TestRedactorNestedSensitiveFields(t *testing.T) {
+	msg := &account{Owner: &profile{Name: "Alice", Ssn: "123-45-6789"}}
+	redacted := NewRedactor().Redact(msg).(*account)
+	if want, have := Redacted, redacted.Owner.Ssn; want != have {
+		t.Errorf("owner.ssn: want %v, have %v", want, have)
+	}
+	if want, have := "Alice", redacted.Owner.Name; want != have {
+		t.Errorf("owner.name: want %v, have %v", want, have)
+	}
+}
+
+func TestPayloads(t *testing.T) {
+	var entries []entry
+	interceptor := UnaryServerInterceptor(WithLogger(recorder(&entries)), WithPayloads(NewRedactor("password")))
+	req := &signup{User: "alice", Password: "hunter2"}
+	interceptor(context.Background(), req, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Signup"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &profile{Name: "Alice"}, nil
+	})
+	fields := entries[0].fields
+	if want, have := `{"user":"alice","password":"[REDACTED]"}`, fields["grpc.request"]; want != have {
+		t.Errorf("request: want %v, have %v", want, have)
+	}
+	if want, have := `{"name":"Alice"}`, fields["grpc.response"]; want != have {
+		t.Errorf("response: want %v, have %v", want, have)
+	}
+}
+
+func TestPayloadSampling(t *testing.T) {
+	var entries []entry
+	sampled := false
+	interceptor := UnaryServerInterceptor(
+		WithLogger(recorder(&entries)),
+		WithPayloads(NewRedactor()),
+		WithPayloadLimit(10),
+		WithPayloadSampler(func(ctx context.Context, method string) bool { return sampled }),
+	)
+	req := &signup{User: "alice"}
+
+	for _, tc := range []struct {
+		sampled bool
+		err     error
+		request interface{}
+	}{
+		{false, nil, nil},
+		{true, nil, `{"user":"a` + Truncated},
+		{false, status.Error(codes.Internal, "boom"), `{"user":"a` + Truncated},
+	} {
+		entries = nil
+		sampled = tc.sampled
+		interceptor(context.Background(), req, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Signup"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return &profile{Name: "Alice"}, tc.err
+		})
+		if want, have := tc.request, entries[0].fields["grpc.request"]; want != have {
+			t.Errorf("sampled %v, error %v: request: want %v, have %v", tc.sampled, tc.err, want, have)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	o := options{payloadLimit: 4}
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"abc", "abc"},
+		{"abcd", "abcd"},
+		{"abcde", "abcd" + Truncated},
+		{"abcé", "abc" + Truncated},
+	} {
+		if have := o.truncate([]byte(tc.in)); tc.want != have {
+			t.Errorf("%q: want %q, have %q", tc.in, tc.want, have)
+		}
+	}
+}
+
+func TestSampling(t *testing.T) {
+	var entries []entry
+	interceptor := UnaryServerInterceptor(
+		WithLogger(recorder(&entries)),
+		WithSampling(3),
+		WithMethodSampling(1, "/test.Service/Create"),
+		WithSlowThreshold(10*time.Millisecond),
+	)
+
+	for _, tc := range []struct {
+		method string
+		err    error
+		slow   bool
+		logged bool
+	}{
+		{"/test.Service/Get", nil, false, true},
+		{"/test.Service/Get", nil, false, false},
+		{"/test.Service/Get", status.Error(codes.Internal, "boom"), false, true},
+		{"/test.Service/Get", nil, true, true},
+		{"/test.Service/Get", nil, false, false},
+		{"/test.Service/List", nil, false, true},
+		{"/test.Service/Get", nil, false, true},
+		{"/test.Service/Create", nil, false, true},
+		{"/test.Service/Create", nil, false, true},
+	} {
+		entries = nil
+		interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: tc.method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			if tc.slow {
+				time.Sleep(20 * time.Millisecond)
+			}
+			return nil, tc.err
+		})
+		if want, have := tc.logged, len(entries) == 1; want != have {
+			t.Errorf("%s (error %v, slow %v): logged: want %v, have %v", tc.method, tc.err, tc.slow, want, have)
+		}
+	}
+}
+
+func TestContextFields(t *testing.T) {
+	var entries []entry
+	type key struct{}
+	interceptor := UnaryServerInterceptor(WithLogger(recorder(&entries)), WithContextFields(func(ctx context.Context) []Field {
+		if v, ok := ctx.Value(key{}).(string); ok {
+			return []Field{{"tenant.id", v}}
+		}
+		return nil
+	}))
+	ctx := context.WithValue(context.Background(), key{}, "acme")
+	interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.v1.Service/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	if want, have := "acme", entries[0].fields["tenant.id"]; want != have {
+		t.Fatalf("context field: want %v, have %v", want, have)
+	}
+}
@@ -0,0 +1,36 @@
+// Package logrus adapts a logrus Logger to logging.Logger.
+package logrus
+
+import (
+	"github.com/ipfans/grpctools/middleware/logging"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+var levels = map[logging.Level]logrus.Level{
+	logging.Debug: logrus.DebugLevel,
+	logging.Info:  logrus.InfoLevel,
+	logging.Warn:  logrus.WarnLevel,
+	logging.Error: logrus.ErrorLevel,
+}
+
+// New returns a logging.Logger writing to l.
+func New(l logrus.FieldLogger) logging.Logger {
+	return logging.LoggerFunc(func(ctx context.Context, level logging.Level, msg string, fields ...logging.Field) {
+		data := make(logrus.Fields, len(fields))
+		for _, f := range fields {
+			data[f.Key] = f.Value
+		}
+		entry := l.WithFields(data)
+		switch level {
+		case logging.Debug:
+			entry.Debug(msg)
+		case logging.Info:
+			entry.Info(msg)
+		case logging.Warn:
+			entry.Warn(msg)
+		default:
+			entry.Error(msg)
+		}
+	})
+}
@@ -0,0 +1,39 @@
+package logrus
+
+import (
+	"testing"
+
+	"github.com/ipfans/grpctools/middleware/logging"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"golang.org/x/net/context"
+)
+
+func TestNew(t *testing.T) {
+	l, hook := test.NewNullLogger()
+	l.SetLevel(logrus.DebugLevel)
+	logger := New(l)
+
+	for _, tc := range []struct {
+		level logging.Level
+		want  logrus.Level
+	}{
+		{logging.Debug, logrus.DebugLevel},
+		{logging.Info, logrus.InfoLevel},
+		{logging.Warn, logrus.WarnLevel},
+		{logging.Error, logrus.ErrorLevel},
+	} {
+		hook.Reset()
+		logger.Log(context.Background(), tc.level, "finished unary call", logging.Field{Key: "grpc.method", Value: "Get"})
+		e := hook.LastEntry()
+		if e == nil {
+			t.Fatalf("level %v: nothing logged", tc.level)
+		}
+		if have := e.Level; tc.want != have {
+			t.Errorf("level %v: want %v, have %v", tc.level, tc.want, have)
+		}
+		if want, have := "Get", e.Data["grpc.method"]; want != have {
+			t.Errorf("level %v: field: want %v, have %v", tc.level, want, have)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package logging
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+// Redacted replaces the value of redacted string fields.
+const Redacted = "[REDACTED]"
+
+// SensitiveFields is implemented by messages declaring their own sensitive
+// fields, as paths relative to the message.
+type SensitiveFields interface {
+	SensitiveFields() []string
+}
+
+// Redactor masks sensitive fields of messages before they are logged or
+// traced. Fields are selected by paths of protobuf field names separated by
+// dots, where "*" matches any field: "password" masks the password field of
+// requests, "*.ssn" the ssn field of any message field of requests. Repeated
+// and map fields are matched by the path of their elements.
+type Redactor struct {
+	paths [][]string
+}
+
+// NewRedactor returns a Redactor masking the fields of paths, and those
+// declared by messages implementing SensitiveFields.
+func NewRedactor(paths ...string) *Redactor {
+	r := &Redactor{}
+	for _, p := range paths {
+		r.paths = append(r.paths, strings.Split(p, "."))
+	}
+	return r
+}
+
+// Redact returns a copy of msg with the sensitive fields masked: strings are
+// replaced by Redacted, other fields cleared. msg itself isn't modified.
+func (r *Redactor) Redact(msg proto.Message) proto.Message {
+	clone := proto.Clone(msg)
+	r.walk(reflect.ValueOf(clone), nil, nil)
+	return clone
+}
+
+// JSON returns msg redacted and encoded as JSON, or nil if msg is not a
+// protobuf message, for payload logging.
+func (r *Redactor) JSON(msg interface{}) json.RawMessage {
+	m, ok := msg.(proto.Message)
+	if !ok || m == nil || reflect.ValueOf(m).IsNil() {
+		return nil
+	}
+	s, err := (&jsonpb.Marshaler{OrigName: true}).MarshalToString(r.Redact(m))
+	if err != nil {
+		return nil
+	}
+	return json.RawMessage(s)
+}
+
+// walk masks the fields of the message v at path. rel are the paths declared
+// by SensitiveFields of v and its parents, relative to v.
+func (r *Redactor) walk(v reflect.Value, path []string, rel [][]string) {
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return
+	}
+	if s, ok := v.Interface().(SensitiveFields); ok {
+		for _, p := range s.SensitiveFields() {
+			rel = append(rel[:len(rel):len(rel)], strings.Split(p, "."))
+		}
+	}
+	v = v.Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := v.Field(i)
+		sf := t.Field(i)
+		if sf.Tag.Get("protobuf_oneof") != "" {
+			// The field holds a wrapper struct of the chosen field.
+			if f.IsNil() {
+				continue
+			}
+			w := f.Elem()
+			wf := w.Elem().Type().Field(0)
+			r.field(w.Elem().Field(0), protoName(wf.Tag.Get("protobuf")), path, rel)
+			continue
+		}
+		name := protoName(sf.Tag.Get("protobuf"))
+		if name == "" {
+			continue
+		}
+		r.field(f, name, path, rel)
+	}
+}
+
+// field masks or walks the field f of a message at path.
+func (r *Redactor) field(f reflect.Value, name string, path []string, rel [][]string) {
+	fieldPath := append(append([]string(nil), path...), name)
+	if r.match(fieldPath) || matchAny(rel, []string{name}) {
+		mask(f)
+		return
+	}
+	// Declared paths going through the field apply to its messages with the
+	// field name trimmed.
+	var inner [][]string
+	for _, p := range rel {
+		if len(p) > 1 && (p[0] == "*" || p[0] == name) {
+			inner = append(inner, p[1:])
+		}
+	}
+	switch f.Kind() {
+	case reflect.Ptr:
+		r.walk(f, fieldPath, inner)
+	case reflect.Slice:
+		for i := 0; i < f.Len(); i++ {
+			r.walk(f.Index(i), fieldPath, inner)
+		}
+	case reflect.Map:
+		for _, k := range f.MapKeys() {
+			r.walk(f.MapIndex(k), fieldPath, inner)
+		}
+	}
+}
+
+func (r *Redactor) match(path []string) bool {
+	return matchAny(r.paths, path)
+}
+
+func matchAny(patterns [][]string, path []string) bool {
+	for _, p := range patterns {
+		if len(p) != len(path) {
+			continue
+		}
+		ok := true
+		for i := range p {
+			if p[i] != "*" && p[i] != path[i] {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// mask clears f, replacing strings with Redacted.
+func mask(f reflect.Value) {
+	switch {
+	case f.Kind() == reflect.String:
+		if f.Len() > 0 {
+			f.SetString(Redacted)
+		}
+	case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.String:
+		for i := 0; i < f.Len(); i++ {
+			f.Index(i).SetString(Redacted)
+		}
+	default:
+		f.Set(reflect.Zero(f.Type()))
+	}
+}
+
+// protoName returns the field name of a protobuf struct tag, like
+// "bytes,1,opt,name=password,proto3".
+func protoName(tag string) string {
+	for _, part := range strings.Split(tag, ",") {
+		if strings.HasPrefix(part, "name=") {
+			return part[len("name="):]
+		}
+	}
+	return ""
+}
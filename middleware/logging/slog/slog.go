@@ -0,0 +1,30 @@
+//go:build go1.21
+// +build go1.21
+
+// Package slog adapts a log/slog Logger to logging.Logger.
+package slog
+
+import (
+	"log/slog"
+
+	"github.com/ipfans/grpctools/middleware/logging"
+	"golang.org/x/net/context"
+)
+
+var levels = map[logging.Level]slog.Level{
+	logging.Debug: slog.LevelDebug,
+	logging.Info:  slog.LevelInfo,
+	logging.Warn:  slog.LevelWarn,
+	logging.Error: slog.LevelError,
+}
+
+// New returns a logging.Logger writing to l.
+func New(l *slog.Logger) logging.Logger {
+	return logging.LoggerFunc(func(ctx context.Context, level logging.Level, msg string, fields ...logging.Field) {
+		attrs := make([]slog.Attr, len(fields))
+		for i, f := range fields {
+			attrs[i] = slog.Any(f.Key, f.Value)
+		}
+		l.LogAttrs(ctx, levels[level], msg, attrs...)
+	})
+}
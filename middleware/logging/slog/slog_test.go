@@ -0,0 +1,42 @@
+//go:build go1.21
+// +build go1.21
+
+package slog
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/ipfans/grpctools/middleware/logging"
+	"golang.org/x/net/context"
+)
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	for _, tc := range []struct {
+		level logging.Level
+		want  string
+	}{
+		{logging.Debug, "DEBUG"},
+		{logging.Info, "INFO"},
+		{logging.Warn, "WARN"},
+		{logging.Error, "ERROR"},
+	} {
+		buf.Reset()
+		logger.Log(context.Background(), tc.level, "finished unary call", logging.Field{Key: "grpc.method", Value: "Get"})
+		var line map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+			t.Fatalf("%v: %s", err, buf.Bytes())
+		}
+		if have := line["level"]; tc.want != have {
+			t.Errorf("level %v: want %v, have %v", tc.level, tc.want, have)
+		}
+		if want, have := "Get", line["grpc.method"]; want != have {
+			t.Errorf("level %v: field: want %v, have %v", tc.level, want, have)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+// Package zap adapts a zap Logger to logging.Logger.
+package zap
+
+import (
+	"github.com/ipfans/grpctools/middleware/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/net/context"
+)
+
+var levels = map[logging.Level]zapcore.Level{
+	logging.Debug: zapcore.DebugLevel,
+	logging.Info:  zapcore.InfoLevel,
+	logging.Warn:  zapcore.WarnLevel,
+	logging.Error: zapcore.ErrorLevel,
+}
+
+// New returns a logging.Logger writing to l.
+func New(l *zap.Logger) logging.Logger {
+	return logging.LoggerFunc(func(ctx context.Context, level logging.Level, msg string, fields ...logging.Field) {
+		ce := l.Check(levels[level], msg)
+		if ce == nil {
+			return
+		}
+		zfields := make([]zap.Field, len(fields))
+		for i, f := range fields {
+			zfields[i] = zap.Any(f.Key, f.Value)
+		}
+		ce.Write(zfields...)
+	})
+}
@@ -0,0 +1,38 @@
+package zap
+
+import (
+	"testing"
+
+	"github.com/ipfans/grpctools/middleware/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/net/context"
+)
+
+func TestNew(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := New(zap.New(core))
+
+	for _, tc := range []struct {
+		level logging.Level
+		want  zapcore.Level
+	}{
+		{logging.Debug, zapcore.DebugLevel},
+		{logging.Info, zapcore.InfoLevel},
+		{logging.Warn, zapcore.WarnLevel},
+		{logging.Error, zapcore.ErrorLevel},
+	} {
+		logger.Log(context.Background(), tc.level, "finished unary call", logging.Field{Key: "grpc.method", Value: "Get"})
+		entries := logs.TakeAll()
+		if want, have := 1, len(entries); want != have {
+			t.Fatalf("level %v: entries: want %d, have %d", tc.level, want, have)
+		}
+		if have := entries[0].Level; tc.want != have {
+			t.Errorf("level %v: want %v, have %v", tc.level, tc.want, have)
+		}
+		if want, have := "Get", entries[0].ContextMap()["grpc.method"]; want != have {
+			t.Errorf("level %v: field: want %v, have %v", tc.level, want, have)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Package consul toggles maintenance mode from Consul KV.
+package consul
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/ipfans/grpctools/middleware/maintenance"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/grpclog"
+)
+
+// retryInterval is the delay between failed queries to Consul.
+const retryInterval = time.Second
+
+// ErrClosed is returned by Next after the source is closed.
+var ErrClosed = errors.New("middleware/maintenance/consul: source closed")
+
+// Source implements maintenance.Source by watching a JSON encoded
+// maintenance.State stored under a Consul KV key. A missing key disables
+// maintenance.
+type Source struct {
+	kv        *api.KV
+	key       string
+	logger    grpclog.LoggerV2
+	lastIndex uint64
+	last      *maintenance.State
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Option for Source instance.
+type Option func(s *Source)
+
+// WithLogger replaced built-in logger to given.
+func WithLogger(logger grpclog.LoggerV2) Option {
+	return func(s *Source) {
+		s.logger = logger
+	}
+}
+
+// NewSource initializes and returns a new Source watching key.
+func NewSource(client *api.Client, key string, opts ...Option) *Source {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Source{
+		kv:     client.KV(),
+		key:    key,
+		logger: grpclog.NewLoggerV2(os.Stdout, os.Stdout, os.Stderr),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+// Next blocks until the key holds a state different from the last one
+// returned. Query and decoding errors are logged and retried.
+func (s *Source) Next() (maintenance.State, error) {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return maintenance.State{}, ErrClosed
+		default:
+		}
+
+		opts := (&api.QueryOptions{WaitIndex: s.lastIndex}).WithContext(s.ctx)
+		pair, meta, err := s.kv.Get(s.key, opts)
+		if err != nil {
+			if s.ctx.Err() == nil {
+				s.logger.Infof("middleware/maintenance/consul: error retrieving %s from Consul: %v\n", s.key, err)
+				s.sleep(retryInterval)
+			}
+			continue
+		}
+		if meta.LastIndex == s.lastIndex {
+			// Blocking query timed out without changes.
+			continue
+		}
+		// Consul may reset the index; start over rather than block forever.
+		if meta.LastIndex < s.lastIndex {
+			s.lastIndex = 0
+			continue
+		}
+		s.lastIndex = meta.LastIndex
+
+		var state maintenance.State
+		if pair != nil {
+			if err := json.Unmarshal(pair.Value, &state); err != nil {
+				s.logger.Warningf("middleware/maintenance/consul: error decoding %s: %v\n", s.key, err)
+				continue
+			}
+		}
+		if s.last != nil && *s.last == state {
+			continue
+		}
+		s.last = &state
+		return state, nil
+	}
+}
+
+// Close closes the source.
+func (s *Source) Close() {
+	s.cancel()
+}
+
+func (s *Source) sleep(d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-s.ctx.Done():
+	case <-t.C:
+	}
+}
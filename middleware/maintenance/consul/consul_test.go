@@ -0,0 +1,63 @@
+package consul
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/ipfans/grpctools/middleware/maintenance"
+	"google.golang.org/grpc/grpclog"
+)
+
+// kvServer serves its values in order as successive versions of one Consul
+// KV key, an empty value meaning the key is missing, then blocks.
+func kvServer(values ...string) *httptest.Server {
+	n := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n == len(values) {
+			<-r.Context().Done()
+			return
+		}
+		n++
+		w.Header().Set("X-Consul-Index", strconv.Itoa(n))
+		if values[n-1] == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode([]*api.KVPair{{Key: "maintenance", Value: []byte(values[n-1]), ModifyIndex: uint64(n)}})
+	}))
+}
+
+func TestSourceNext(t *testing.T) {
+	srv := kvServer(
+		`{"enabled": true, "reason": "upgrade"}`,
+		`{"enabled":true,"reason":"upgrade"}`, // rewritten unchanged
+		`not json`,
+		``,
+	)
+	defer srv.Close()
+	client, err := api.NewClient(&api.Config{Address: srv.Listener.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSource(client, "maintenance", WithLogger(grpclog.NewLoggerV2(ioutil.Discard, ioutil.Discard, ioutil.Discard)))
+
+	for _, want := range []maintenance.State{{Enabled: true, Reason: "upgrade"}, {}} {
+		have, err := s.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want != have {
+			t.Fatalf("want %+v, have %+v", want, have)
+		}
+	}
+
+	s.Close()
+	if _, err := s.Next(); err != ErrClosed {
+		t.Fatalf("Next after Close: want ErrClosed, have %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+// Package maintenance provides server interceptors rejecting calls during
+// planned maintenance windows, toggled at runtime.
+package maintenance
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultReason is returned to clients when maintenance is enabled without a
+// reason.
+const DefaultReason = "service down for maintenance"
+
+// State is the maintenance mode of a server.
+type State struct {
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Source delivers maintenance state updates to a Switch.
+type Source interface {
+	// Next blocks until a state is available. The first call returns the
+	// current state; subsequent calls block until it changes.
+	//
+	// An error is returned if and only if the source cannot recover.
+	Next() (State, error)
+	// Close closes the source.
+	Close()
+}
+
+type options struct {
+	exempt map[string]bool
+}
+
+// Option for Switch instance.
+type Option func(o *options)
+
+// WithExemptMethods sets full method names served during maintenance, like
+// health checks.
+func WithExemptMethods(methods ...string) Option {
+	return func(o *options) {
+		for _, m := range methods {
+			o.exempt[m] = true
+		}
+	}
+}
+
+// Switch toggles maintenance mode. It can be set directly, through HTTP or by
+// watching a Source.
+type Switch struct {
+	opts options
+
+	mu    sync.RWMutex
+	state State
+}
+
+// New returns a Switch with maintenance disabled.
+func New(opts ...Option) *Switch {
+	o := options{exempt: make(map[string]bool)}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Switch{opts: o}
+}
+
+// Set changes the maintenance state.
+func (s *Switch) Set(state State) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state
+}
+
+// State returns the maintenance state.
+func (s *Switch) State() State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state
+}
+
+// Watch applies every state from src until src returns an error. Watch
+// blocks, so it is usually run in its own goroutine.
+func (s *Switch) Watch(src Source) error {
+	for {
+		state, err := src.Next()
+		if err != nil {
+			return err
+		}
+		s.Set(state)
+	}
+}
+
+// ServeHTTP returns the state as JSON on GET, and replaces it with the JSON
+// body of PUT requests, e.g. {"enabled": true, "reason": "database upgrade"}.
+func (s *Switch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var state State
+		if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.Set(state)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.State())
+}
+
+// check returns an Unavailable error with the reason if method is rejected.
+func (s *Switch) check(method string) error {
+	state := s.State()
+	if !state.Enabled || s.opts.exempt[method] {
+		return nil
+	}
+	reason := state.Reason
+	if reason == "" {
+		reason = DefaultReason
+	}
+	return status.Error(codes.Unavailable, reason)
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor rejecting
+// calls during maintenance.
+func (s *Switch) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := s.check(info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor
+// rejecting calls during maintenance. Streams already open are left alone.
+func (s *Switch) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := s.check(info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+// FileSource is a Source enabling maintenance while a file exists, its
+// content being the reason.
+type FileSource struct {
+	path     string
+	interval time.Duration
+	last     *State
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewFileSource returns a FileSource checking path every interval.
+func NewFileSource(path string, interval time.Duration) *FileSource {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &FileSource{path: path, interval: interval, ctx: ctx, cancel: cancel}
+}
+
+// Next blocks until the state differs from the last one returned.
+func (s *FileSource) Next() (State, error) {
+	for {
+		var state State
+		b, err := ioutil.ReadFile(s.path)
+		switch {
+		case err == nil:
+			state = State{Enabled: true, Reason: strings.TrimSpace(string(b))}
+		case !os.IsNotExist(err):
+			return State{}, err
+		}
+		if s.last == nil || *s.last != state {
+			s.last = &state
+			return state, nil
+		}
+
+		t := time.NewTimer(s.interval)
+		select {
+		case <-s.ctx.Done():
+			t.Stop()
+			return State{}, s.ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Close closes the source.
+func (s *FileSource) Close() {
+	s.cancel()
+}
@@ -0,0 +1,103 @@
+package maintenance
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	s := New(WithExemptMethods("/grpc.health.v1.Health/Check"))
+	interceptor := s.UnaryServerInterceptor()
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	call := func(method string) error {
+		_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	if err := call("/test.Service/Get"); err != nil {
+		t.Fatal(err)
+	}
+	s.Set(State{Enabled: true, Reason: "database upgrade until 10:00 UTC"})
+	err := call("/test.Service/Get")
+	if want, have := codes.Unavailable, status.Code(err); want != have {
+		t.Fatalf("maintenance: want %v, have %v", want, have)
+	}
+	if want, have := "database upgrade until 10:00 UTC", status.Convert(err).Message(); want != have {
+		t.Fatalf("reason: want %q, have %q", want, have)
+	}
+	if err := call("/grpc.health.v1.Health/Check"); err != nil {
+		t.Fatalf("exempt method: %v", err)
+	}
+	s.Set(State{Enabled: true})
+	if want, have := DefaultReason, status.Convert(call("/test.Service/Get")).Message(); want != have {
+		t.Fatalf("default reason: want %q, have %q", want, have)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	s := New()
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"enabled": true, "reason": "upgrade"}`)))
+	if want, have := http.StatusOK, rec.Code; want != have {
+		t.Fatalf("PUT: want %d, have %d", want, have)
+	}
+	if want, have := (State{Enabled: true, Reason: "upgrade"}), s.State(); want != have {
+		t.Fatalf("state: want %+v, have %+v", want, have)
+	}
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{`)))
+	if want, have := http.StatusBadRequest, rec.Code; want != have {
+		t.Fatalf("bad PUT: want %d, have %d", want, have)
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maintenance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "maintenance")
+	src := NewFileSource(path, time.Millisecond)
+	defer src.Close()
+
+	for _, tc := range []struct {
+		content *string
+		want    State
+	}{
+		{nil, State{}},
+		{strptr("upgrade\n"), State{Enabled: true, Reason: "upgrade"}},
+		{nil, State{}},
+	} {
+		if tc.content != nil {
+			if err := ioutil.WriteFile(path, []byte(*tc.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			os.Remove(path)
+		}
+		have, err := src.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.want != have {
+			t.Fatalf("want %+v, have %+v", tc.want, have)
+		}
+	}
+}
+
+func strptr(s string) *string { return &s }
@@ -0,0 +1,295 @@
+// Package metering provides server interceptors accounting the usage of
+// multi-tenant services per tenant, for quotas and billing.
+package metering
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ipfans/grpctools/middleware/tenant"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/grpclog"
+)
+
+// Usage is the resources used by the requests of a tenant.
+type Usage struct {
+	Requests      int64         `json:"requests"`
+	RequestBytes  int64         `json:"request_bytes"`
+	ResponseBytes int64         `json:"response_bytes"`
+	HandlerTime   time.Duration `json:"handler_time"`
+}
+
+func (u *Usage) add(o Usage) {
+	u.Requests += o.Requests
+	u.RequestBytes += o.RequestBytes
+	u.ResponseBytes += o.ResponseBytes
+	u.HandlerTime += o.HandlerTime
+}
+
+// Store accounts the usage of tenants, e.g. in a billing database.
+type Store interface {
+	// Record accounts usage by tenant of the requests which ended between
+	// start and end.
+	Record(ctx context.Context, start, end time.Time, usage map[string]Usage) error
+}
+
+// StoreFunc is an adapter to use a function as a Store.
+type StoreFunc func(ctx context.Context, start, end time.Time, usage map[string]Usage) error
+
+// Record calls f.
+func (f StoreFunc) Record(ctx context.Context, start, end time.Time, usage map[string]Usage) error {
+	return f(ctx, start, end, usage)
+}
+
+// NewWriterStore returns a Store writing the usage of each tenant to w as a
+// JSON line, for billing pipelines to import.
+func NewWriterStore(w io.Writer) Store {
+	enc := json.NewEncoder(w)
+	return StoreFunc(func(ctx context.Context, start, end time.Time, usage map[string]Usage) error {
+		for id, u := range usage {
+			err := enc.Encode(struct {
+				Tenant string    `json:"tenant"`
+				Start  time.Time `json:"start"`
+				End    time.Time `json:"end"`
+				Usage
+			}{id, start, end, u})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Enforcer decides whether a tenant may make a request, given its usage not
+// flushed yet, returning the error rejecting it otherwise, like
+// ResourceExhausted.
+type Enforcer func(ctx context.Context, tenant string, pending Usage) error
+
+type options struct {
+	interval time.Duration
+	tenant   func(ctx context.Context) string
+	enforcer Enforcer
+	logger   grpclog.LoggerV2
+}
+
+// Option for Meter instance.
+type Option func(o *options)
+
+// WithFlushInterval sets how often usage is flushed to the store. Default is
+// a minute.
+func WithFlushInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.interval = d
+	}
+}
+
+// WithTenantFunc sets the function returning the tenant of requests, those
+// without one not being metered. Default is tenant.ID, so the tenant
+// interceptors must run first.
+func WithTenantFunc(f func(ctx context.Context) string) Option {
+	return func(o *options) {
+		o.tenant = f
+	}
+}
+
+// WithEnforcer sets the hook deciding whether a tenant may make a request.
+// Rejected requests are not metered.
+func WithEnforcer(e Enforcer) Option {
+	return func(o *options) {
+		o.enforcer = e
+	}
+}
+
+// WithLogger replaced built-in logger to given.
+func WithLogger(logger grpclog.LoggerV2) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// Meter aggregates the usage of tenants in memory and flushes it to a store
+// in the background.
+type Meter struct {
+	store Store
+	opts  options
+
+	mu      sync.Mutex
+	pending map[string]Usage
+	since   time.Time
+
+	done   chan struct{}
+	closed chan struct{}
+	once   sync.Once
+}
+
+// New returns a Meter flushing to store. Close flushes the pending usage.
+func New(store Store, opts ...Option) *Meter {
+	o := options{
+		interval: time.Minute,
+		tenant:   tenant.ID,
+		logger:   grpclog.NewLoggerV2(os.Stdout, os.Stdout, os.Stderr),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	m := &Meter{
+		store:   store,
+		opts:    o,
+		pending: make(map[string]Usage),
+		since:   time.Now(),
+		done:    make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+func (m *Meter) run() {
+	defer close(m.closed)
+	ticker := time.NewTicker(m.opts.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.flush()
+		case <-m.done:
+			m.flush()
+			return
+		}
+	}
+}
+
+// flush records the pending usage, keeping it for the next flush if the store
+// fails.
+func (m *Meter) flush() {
+	m.mu.Lock()
+	pending, start, end := m.pending, m.since, time.Now()
+	m.pending, m.since = make(map[string]Usage), end
+	m.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	if err := m.store.Record(context.Background(), start, end, pending); err != nil {
+		m.opts.logger.Warningf("metering: flushing usage of %d tenants: %v", len(pending), err)
+		m.mu.Lock()
+		for id, u := range m.pending {
+			p := pending[id]
+			p.add(u)
+			pending[id] = p
+		}
+		m.pending, m.since = pending, start
+		m.mu.Unlock()
+	}
+}
+
+// Close stops the Meter after flushing the pending usage. Requests ending
+// afterwards are not flushed.
+func (m *Meter) Close() error {
+	m.once.Do(func() {
+		close(m.done)
+	})
+	<-m.closed
+	return nil
+}
+
+// Pending returns the usage of tenant not flushed yet.
+func (m *Meter) Pending(tenant string) Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.pending[tenant]
+}
+
+// admit returns the tenant of a request, or the error of the enforcer.
+func (m *Meter) admit(ctx context.Context) (string, error) {
+	id := m.opts.tenant(ctx)
+	if id == "" || m.opts.enforcer == nil {
+		return id, nil
+	}
+	return id, m.opts.enforcer(ctx, id, m.Pending(id))
+}
+
+// account adds u to the usage of tenant.
+func (m *Meter) account(tenant string, u Usage) {
+	m.mu.Lock()
+	p := m.pending[tenant]
+	p.add(u)
+	m.pending[tenant] = p
+	m.mu.Unlock()
+}
+
+func size(m interface{}) int64 {
+	if msg, ok := m.(proto.Message); ok {
+		return int64(proto.Size(msg))
+	}
+	return 0
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor metering
+// every request of a tenant.
+func (m *Meter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		id, err := m.admit(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if id == "" {
+			return handler(ctx, req)
+		}
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		u := Usage{Requests: 1, RequestBytes: size(req), HandlerTime: time.Since(start)}
+		if err == nil {
+			u.ResponseBytes = size(resp)
+		}
+		m.account(id, u)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor metering
+// every stream of a tenant as a request, with the bytes of all its messages.
+func (m *Meter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		id, err := m.admit(stream.Context())
+		if err != nil {
+			return err
+		}
+		if id == "" {
+			return handler(srv, stream)
+		}
+		start := time.Now()
+		s := &serverStream{ServerStream: stream}
+		err = handler(srv, s)
+		m.account(id, Usage{Requests: 1, RequestBytes: atomic.LoadInt64(&s.received), ResponseBytes: atomic.LoadInt64(&s.sent), HandlerTime: time.Since(start)})
+		return err
+	}
+}
+
+// serverStream counts the bytes of the messages of a stream.
+type serverStream struct {
+	grpc.ServerStream
+	sent, received int64 // accessed atomically
+}
+
+func (s *serverStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.sent, size(m))
+	}
+	return err
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.received, size(m))
+	}
+	return err
+}
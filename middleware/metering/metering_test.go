@@ -0,0 +1,98 @@
+package metering
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/ipfans/grpctools/middleware/tenant"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		recorded = map[string]Usage{}
+		fail     = true
+	)
+	store := StoreFunc(func(ctx context.Context, start, end time.Time, usage map[string]Usage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			fail = false
+			return errors.New("billing database down")
+		}
+		for id, u := range usage {
+			r := recorded[id]
+			r.add(u)
+			recorded[id] = r
+		}
+		return nil
+	})
+	m := New(store, WithFlushInterval(time.Hour), WithEnforcer(func(ctx context.Context, tenant string, pending Usage) error {
+		if pending.Requests >= 2 {
+			return status.Error(codes.ResourceExhausted, "quota exceeded")
+		}
+		return nil
+	}))
+	interceptor := m.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}
+	req := &wrappers.StringValue{Value: "hello"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &wrappers.StringValue{Value: "hello, world"}, nil
+	}
+
+	acme := tenant.NewContext(context.Background(), &tenant.Tenant{ID: "acme"})
+	for i, want := range []codes.Code{codes.OK, codes.OK, codes.ResourceExhausted} {
+		if _, err := interceptor(acme, req, info, handler); want != status.Code(err) {
+			t.Fatalf("request %d: want %v, have %v", i, want, status.Code(err))
+		}
+	}
+	if _, err := interceptor(context.Background(), req, info, handler); err != nil {
+		t.Fatalf("no tenant: %v", err)
+	}
+
+	// The failed flush is retried with the usage of the next period.
+	m.flush()
+	interceptor(tenant.NewContext(context.Background(), &tenant.Tenant{ID: "globex"}), req, info, handler)
+	m.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want, have := 2, len(recorded); want != have {
+		t.Fatalf("tenants: want %d, have %d", want, have)
+	}
+	u := recorded["acme"]
+	if want, have := int64(2), u.Requests; want != have {
+		t.Fatalf("requests: want %d, have %d", want, have)
+	}
+	if want, have := int64(2*proto.Size(req)), u.RequestBytes; want != have {
+		t.Fatalf("request bytes: want %d, have %d", want, have)
+	}
+	if want, have := int64(2*proto.Size(&wrappers.StringValue{Value: "hello, world"})), u.ResponseBytes; want != have {
+		t.Fatalf("response bytes: want %d, have %d", want, have)
+	}
+}
+
+func TestWriterStore(t *testing.T) {
+	var buf bytes.Buffer
+	start := time.Date(2017, 11, 1, 0, 0, 0, 0, time.UTC)
+	err := NewWriterStore(&buf).Record(context.Background(), start, start.Add(time.Minute), map[string]Usage{
+		"acme": {Requests: 3, RequestBytes: 10, ResponseBytes: 20, HandlerTime: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"tenant":"acme","start":"2017-11-01T00:00:00Z","end":"2017-11-01T00:01:00Z","requests":3,"request_bytes":10,"response_bytes":20,"handler_time":1000000}`
+	if have := strings.TrimSpace(buf.String()); want != have {
+		t.Fatalf("want %s, have %s", want, have)
+	}
+}
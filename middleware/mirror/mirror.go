@@ -0,0 +1,184 @@
+// Package mirror provides a server interceptor mirroring a share of requests
+// to a shadow target.
+package mirror
+
+import (
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/ipfans/grpctools/metrics"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// MirroredKey is the metadata key set to "true" on mirrored requests.
+const MirroredKey = "x-mirrored"
+
+type options struct {
+	fraction    float64
+	methods     map[string]bool
+	timeout     time.Duration
+	maxInFlight int64
+	metrics     metrics.Provider
+	keys        map[string]bool
+}
+
+// Option for Mirror instance.
+type Option func(o *options)
+
+// WithFraction sets the fraction (0 to 1) of requests mirrored. Default is
+// 0.1.
+func WithFraction(f float64) Option {
+	return func(o *options) {
+		o.fraction = f
+	}
+}
+
+// WithMethods sets the full method names mirrored. Default is all unary
+// methods.
+func WithMethods(methods ...string) Option {
+	return func(o *options) {
+		if o.methods == nil {
+			o.methods = make(map[string]bool)
+		}
+		for _, m := range methods {
+			o.methods[m] = true
+		}
+	}
+}
+
+// WithTimeout sets the deadline of mirrored requests. Default is 5 seconds.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithMaxInFlight sets the number of mirrored requests in flight above which
+// requests aren't mirrored, so a slow shadow can't pile up goroutines.
+// Default is 100.
+func WithMaxInFlight(n int) Option {
+	return func(o *options) {
+		o.maxInFlight = int64(n)
+	}
+}
+
+// WithForwardedKeys sets the only incoming metadata keys copied to mirrored
+// requests, credentials included if listed. Default copies all keys but the
+// transport keys and CredentialKeys.
+func WithForwardedKeys(keys ...string) Option {
+	return func(o *options) {
+		o.keys = make(map[string]bool)
+		for _, k := range keys {
+			o.keys[strings.ToLower(k)] = true
+		}
+	}
+}
+
+// WithMetrics reports mirroring metrics through given provider.
+func WithMetrics(p metrics.Provider) Option {
+	return func(o *options) {
+		o.metrics = p
+	}
+}
+
+// Mirror sends copies of requests to a shadow target, ignoring its responses
+// and errors, to validate a new version against production traffic.
+type Mirror struct {
+	cc       *grpc.ClientConn
+	opts     options
+	inFlight int64 // accessed atomically
+
+	mirrored metrics.Counter
+	dropped  metrics.Counter
+}
+
+// New returns a Mirror sending requests through cc.
+func New(cc *grpc.ClientConn, opts ...Option) *Mirror {
+	o := options{
+		fraction:    0.1,
+		timeout:     5 * time.Second,
+		maxInFlight: 100,
+		metrics:     metrics.Discard,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Mirror{
+		cc:       cc,
+		opts:     o,
+		mirrored: o.metrics.NewCounter("grpc_mirror_requests_total", "Total number of mirrored requests by shadow response code.", "method", "code"),
+		dropped:  o.metrics.NewCounter("grpc_mirror_dropped_total", "Total number of requests not mirrored as too many were in flight.", "method"),
+	}
+}
+
+// CredentialKeys are the metadata keys not copied to mirrored requests by
+// default, so the shadow target never sees production credentials.
+var CredentialKeys = []string{"authorization", "proxy-authorization", "cookie", "x-api-key", "x-auth-token", "x-csrf-token"}
+
+// forwarded reports whether the incoming metadata key is copied to mirrored
+// requests; transport keys are set by the client connection.
+func (m *Mirror) forwarded(key string) bool {
+	switch key {
+	case ":authority", "content-type", "user-agent", "te":
+		return false
+	}
+	if strings.HasPrefix(key, "grpc-") {
+		return false
+	}
+	if m.opts.keys != nil {
+		return m.opts.keys[key]
+	}
+	for _, k := range CredentialKeys {
+		if key == k {
+			return false
+		}
+	}
+	return true
+}
+
+// mirror sends a copy of req to method in the background.
+func (m *Mirror) mirror(ctx context.Context, method string, req proto.Message) {
+	if atomic.AddInt64(&m.inFlight, 1) > m.opts.maxInFlight {
+		atomic.AddInt64(&m.inFlight, -1)
+		m.dropped.With(method).Add(1)
+		return
+	}
+	out := metadata.MD{}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for k, v := range md {
+			if m.forwarded(k) {
+				out[k] = v
+			}
+		}
+	}
+	out.Set(MirroredKey, "true")
+	req = proto.Clone(req)
+
+	go func() {
+		defer atomic.AddInt64(&m.inFlight, -1)
+		ctx, cancel := context.WithTimeout(metadata.NewOutgoingContext(context.Background(), out), m.opts.timeout)
+		defer cancel()
+		err := m.cc.Invoke(ctx, method, req, &empty.Empty{})
+		m.mirrored.With(method, status.Code(err).String()).Add(1)
+	}()
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor mirroring a
+// share of requests to the shadow target. The request is copied before the
+// handler runs, so handlers may modify it.
+func (m *Mirror) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		msg, ok := req.(proto.Message)
+		if ok && (m.opts.methods == nil || m.opts.methods[info.FullMethod]) && rand.Float64() < m.opts.fraction {
+			m.mirror(ctx, info.FullMethod, msg)
+		}
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,114 @@
+package mirror
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// shadow starts a server recording mirrored requests on got.
+func shadow(t *testing.T, got chan<- metadata.MD) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		var req wrappers.StringValue
+		if err := stream.RecvMsg(&req); err != nil {
+			return err
+		}
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		got <- md
+		return stream.SendMsg(&wrappers.StringValue{Value: "shadow"})
+	}))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	cc, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return cc
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	got := make(chan metadata.MD, 1)
+	m := New(shadow(t, got), WithFraction(1), WithMethods("/test.Service/Get"))
+	interceptor := m.UnaryServerInterceptor()
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		req.(*wrappers.StringValue).Value = "modified"
+		return "ok", nil
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "token", "x-request-id", "r1", "grpc-timeout", "1S"))
+
+	resp, err := interceptor(ctx, &wrappers.StringValue{Value: "req"}, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "ok", resp; want != have {
+		t.Fatalf("response: want %v, have %v", want, have)
+	}
+	select {
+	case md := <-got:
+		if want, have := "r1", md.Get("x-request-id"); len(have) != 1 || have[0] != want {
+			t.Fatalf("forwarded metadata: want %v, have %v", want, have)
+		}
+		if have := md.Get("authorization"); len(have) != 0 {
+			t.Fatalf("credentials forwarded: %v", have)
+		}
+		if want, have := "true", md.Get(MirroredKey); len(have) != 1 || have[0] != want {
+			t.Fatalf("%s: want %v, have %v", MirroredKey, want, have)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request not mirrored")
+	}
+
+	if _, err := interceptor(ctx, &wrappers.StringValue{}, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Put"}, handler); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-got:
+		t.Fatal("unlisted method mirrored")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestForwardedKeys(t *testing.T) {
+	got := make(chan metadata.MD, 1)
+	m := New(shadow(t, got), WithFraction(1), WithForwardedKeys("Authorization"))
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "token", "x-request-id", "r1"))
+	m.UnaryServerInterceptor()(ctx, &wrappers.StringValue{}, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	})
+	select {
+	case md := <-got:
+		if want, have := "token", md.Get("authorization"); len(have) != 1 || have[0] != want {
+			t.Fatalf("allowed key: want %v, have %v", want, have)
+		}
+		if have := md.Get("x-request-id"); len(have) != 0 {
+			t.Fatalf("unlisted key forwarded: %v", have)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request not mirrored")
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	m := New(nil, WithFraction(1), WithMaxInFlight(0))
+	interceptor := m.UnaryServerInterceptor()
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	if _, err := interceptor(context.Background(), &wrappers.StringValue{}, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, handler); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := int64(0), m.inFlight; want != have {
+		t.Fatalf("in flight: want %d, have %d", want, have)
+	}
+}
@@ -0,0 +1,133 @@
+// Package msgsize provides interceptors recording the encoded sizes of
+// request and response messages per method, for capacity planning and to
+// spot clients suddenly sending huge payloads.
+package msgsize
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/ipfans/grpctools/metrics"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// Metrics records message sizes in bytes, labeled by full method name.
+type Metrics struct {
+	request  metrics.Histogram
+	response metrics.Histogram
+}
+
+// NewServerMetrics creates the grpc_server_request_bytes and
+// grpc_server_response_bytes histograms through p. Backends should bucket
+// them for sizes, e.g. exponentially from 64 bytes to 64 MiB.
+func NewServerMetrics(p metrics.Provider) *Metrics {
+	return newMetrics(p, "server", "received", "sent")
+}
+
+// NewClientMetrics creates the grpc_client_request_bytes and
+// grpc_client_response_bytes histograms through p.
+func NewClientMetrics(p metrics.Provider) *Metrics {
+	return newMetrics(p, "client", "sent", "received")
+}
+
+func newMetrics(p metrics.Provider, side, requests, responses string) *Metrics {
+	return &Metrics{
+		request:  p.NewHistogram("grpc_"+side+"_request_bytes", "Size of request messages "+requests+" by the "+side+".", "method"),
+		response: p.NewHistogram("grpc_"+side+"_response_bytes", "Size of response messages "+responses+" by the "+side+".", "method"),
+	}
+}
+
+func observe(h metrics.Histogram, method string, msg interface{}) {
+	if m, ok := msg.(proto.Message); ok {
+		h.With(method).Observe(float64(proto.Size(m)))
+	}
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor recording the
+// size of requests and of the responses of successful calls.
+func (m *Metrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		observe(m.request, info.FullMethod, req)
+		resp, err := handler(ctx, req)
+		if err == nil {
+			observe(m.response, info.FullMethod, resp)
+		}
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor
+// recording the size of every message received and sent.
+func (m *Metrics) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &serverStream{ServerStream: stream, m: m, method: info.FullMethod})
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	m      *Metrics
+	method string
+}
+
+func (s *serverStream) RecvMsg(msg interface{}) error {
+	err := s.ServerStream.RecvMsg(msg)
+	if err == nil {
+		observe(s.m.request, s.method, msg)
+	}
+	return err
+}
+
+func (s *serverStream) SendMsg(msg interface{}) error {
+	err := s.ServerStream.SendMsg(msg)
+	if err == nil {
+		observe(s.m.response, s.method, msg)
+	}
+	return err
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor recording the
+// size of requests and of the responses of successful calls.
+func (m *Metrics) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		observe(m.request, method, req)
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err == nil {
+			observe(m.response, method, reply)
+		}
+		return err
+	}
+}
+
+// StreamClientInterceptor returns a new streaming client interceptor
+// recording the size of every message sent and received.
+func (m *Metrics) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &clientStream{ClientStream: stream, m: m, method: method}, nil
+	}
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	m      *Metrics
+	method string
+}
+
+func (s *clientStream) SendMsg(msg interface{}) error {
+	err := s.ClientStream.SendMsg(msg)
+	if err == nil {
+		observe(s.m.request, s.method, msg)
+	}
+	return err
+}
+
+func (s *clientStream) RecvMsg(msg interface{}) error {
+	err := s.ClientStream.RecvMsg(msg)
+	if err == nil {
+		observe(s.m.response, s.method, msg)
+	}
+	return err
+}
@@ -0,0 +1,117 @@
+package msgsize
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/ipfans/grpctools/metrics"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// observations records histogram observations by name and label values.
+type observations map[string][]float64
+
+func (o observations) NewCounter(string, string, ...string) metrics.Counter {
+	return metrics.Discard.NewCounter("", "")
+}
+func (o observations) NewGauge(string, string, ...string) metrics.Gauge {
+	return metrics.Discard.NewGauge("", "")
+}
+func (o observations) NewHistogram(name, _ string, _ ...string) metrics.Histogram {
+	return histogram{o: o, key: name}
+}
+
+type histogram struct {
+	o   observations
+	key string
+}
+
+func (h histogram) With(labelValues ...string) metrics.Histogram {
+	h.key += "{" + strings.Join(labelValues, ",") + "}"
+	return h
+}
+func (h histogram) Observe(v float64) { h.o[h.key] = append(h.o[h.key], v) }
+
+func message(n int) proto.Message {
+	return &wrappers.StringValue{Value: strings.Repeat("x", n)}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	o := observations{}
+	interceptor := NewServerMetrics(o).UnaryServerInterceptor()
+	_, err := interceptor(context.Background(), message(10), &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return message(100), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]float64{
+		"grpc_server_request_bytes{/test.Service/Get}":  12,
+		"grpc_server_response_bytes{/test.Service/Get}": 102,
+	} {
+		if have := o[key]; len(have) != 1 || want != have[0] {
+			t.Errorf("%s: want [%v], have %v", key, want, have)
+		}
+	}
+}
+
+type testStream struct {
+	grpc.ServerStream
+	recv int
+}
+
+func (s *testStream) RecvMsg(m interface{}) error {
+	if s.recv == 2 {
+		return io.EOF
+	}
+	s.recv++
+	proto.Merge(m.(proto.Message), message(s.recv*10))
+	return nil
+}
+
+func (s *testStream) SendMsg(m interface{}) error { return nil }
+
+func TestStreamServerInterceptor(t *testing.T) {
+	o := observations{}
+	interceptor := NewServerMetrics(o).StreamServerInterceptor()
+	err := interceptor(nil, &testStream{}, &grpc.StreamServerInfo{FullMethod: "/test.Service/Upload"}, func(srv interface{}, stream grpc.ServerStream) error {
+		for {
+			if err := stream.RecvMsg(&wrappers.StringValue{}); err == io.EOF {
+				break
+			}
+		}
+		return stream.SendMsg(message(5))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := []float64{12, 22}, o["grpc_server_request_bytes{/test.Service/Upload}"]; len(have) != 2 || want[0] != have[0] || want[1] != have[1] {
+		t.Fatalf("requests: want %v, have %v", want, have)
+	}
+	if want, have := []float64{7}, o["grpc_server_response_bytes{/test.Service/Upload}"]; len(have) != 1 || want[0] != have[0] {
+		t.Fatalf("responses: want %v, have %v", want, have)
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	o := observations{}
+	interceptor := NewClientMetrics(o).UnaryClientInterceptor()
+	reply := &wrappers.StringValue{}
+	err := interceptor(context.Background(), "/test.Service/Get", message(3), reply, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		proto.Merge(reply.(proto.Message), message(20))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := []float64{5}, o["grpc_client_request_bytes{/test.Service/Get}"]; len(have) != 1 || want[0] != have[0] {
+		t.Fatalf("request: want %v, have %v", want, have)
+	}
+	if want, have := []float64{22}, o["grpc_client_response_bytes{/test.Service/Get}"]; len(have) != 1 || want[0] != have[0] {
+		t.Fatalf("response: want %v, have %v", want, have)
+	}
+}
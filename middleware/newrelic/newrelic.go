@@ -0,0 +1,229 @@
+// Package newrelic provides interceptors reporting RPCs to New Relic through
+// its Go agent: incoming RPCs as transactions, continuing the distributed
+// trace of their caller, and outgoing calls as external segments of the
+// transaction of their context.
+package newrelic
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/ipfans/grpctools/middleware"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// CodeAttribute is the transaction attribute of the status code of RPCs, like
+// "NotFound".
+const CodeAttribute = "grpcStatusCode"
+
+type options struct {
+	errors map[codes.Code]bool
+}
+
+// Option for newrelic interceptors.
+type Option func(o *options)
+
+// WithErrorCodes sets the status codes of RPCs noticed as errors. Default is
+// codes caused by the server: Unknown, DeadlineExceeded, Unimplemented,
+// Internal, Unavailable and DataLoss.
+func WithErrorCodes(cs ...codes.Code) Option {
+	return func(o *options) {
+		o.errors = make(map[codes.Code]bool)
+		for _, c := range cs {
+			o.errors[c] = true
+		}
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		errors: map[codes.Code]bool{
+			codes.Unknown:          true,
+			codes.DeadlineExceeded: true,
+			codes.Unimplemented:    true,
+			codes.Internal:         true,
+			codes.Unavailable:      true,
+			codes.DataLoss:         true,
+		},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// headers returns md as HTTP headers, where the agent reads and writes
+// distributed tracing headers.
+func headers(md metadata.MD) http.Header {
+	h := make(http.Header, len(md))
+	for k, vs := range md {
+		for _, v := range vs {
+			h.Add(k, v)
+		}
+	}
+	return h
+}
+
+// start starts the transaction of an incoming RPC to method.
+func start(ctx context.Context, app *newrelic.Application, method string) (*newrelic.Transaction, context.Context) {
+	txn := app.StartTransaction(method)
+	md, _ := metadata.FromIncomingContext(ctx)
+	txn.SetWebRequest(newrelic.WebRequest{
+		Header:    headers(md),
+		URL:       &url.URL{Path: method},
+		Method:    "POST",
+		Transport: newrelic.TransportHTTP,
+	})
+	return txn, newrelic.NewContext(ctx, txn)
+}
+
+// end ends txn of an RPC which returned err.
+func (o options) end(txn *newrelic.Transaction, err error) {
+	code := status.Code(err)
+	txn.AddAttribute(CodeAttribute, code.String())
+	if o.errors[code] {
+		txn.NoticeError(&newrelic.Error{
+			Message: status.Convert(err).Message(),
+			Class:   "gRPC Status: " + code.String(),
+		})
+	}
+	txn.End()
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor reporting
+// every RPC as a transaction of app named after its method, available to
+// handlers with newrelic.FromContext.
+func UnaryServerInterceptor(app *newrelic.Application, opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		txn, ctx := start(ctx, app, info.FullMethod)
+		resp, err := handler(ctx, req)
+		o.end(txn, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor
+// reporting every stream as a transaction of app named after its method.
+func StreamServerInterceptor(app *newrelic.Application, opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		txn, ctx := start(stream.Context(), app, info.FullMethod)
+		wrapped := middleware.WrapServerStream(stream)
+		wrapped.SetContext(ctx)
+		err := handler(srv, wrapped)
+		o.end(txn, err)
+		return err
+	}
+}
+
+// segment starts the external segment of an outgoing call to method in the
+// transaction of ctx, adding its distributed tracing headers to the outgoing
+// metadata of the returned context. It returns nil if ctx has no transaction.
+func segment(ctx context.Context, cc *grpc.ClientConn, method string) (*newrelic.ExternalSegment, context.Context) {
+	txn := newrelic.FromContext(ctx)
+	if txn == nil {
+		return nil, ctx
+	}
+	seg := &newrelic.ExternalSegment{
+		StartTime: txn.StartSegmentNow(),
+		Procedure: method,
+		Library:   "gRPC",
+	}
+	if cc != nil {
+		seg.Host = cc.Target()
+	}
+	h := http.Header{}
+	txn.InsertDistributedTraceHeaders(h)
+	if len(h) == 0 {
+		return seg, ctx
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	for k, vs := range h {
+		md.Set(strings.ToLower(k), vs...)
+	}
+	return seg, metadata.NewOutgoingContext(ctx, md)
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor reporting
+// calls made with the context of a transaction as its external segments, and
+// propagating the distributed trace to the server.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		seg, ctx := segment(ctx, cc, method)
+		err := invoker(ctx, method, req, reply, cc, callOpts...)
+		if seg != nil {
+			seg.End()
+		}
+		return err
+	}
+}
+
+// StreamClientInterceptor returns a new streaming client interceptor
+// reporting streams opened with the context of a transaction as its external
+// segments, and propagating the distributed trace to the server. Segments end
+// once RecvMsg returns an error, once a client-streaming call receives its
+// response, or once the context of the call is done.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		seg, ctx := segment(ctx, cc, method)
+		stream, err := streamer(ctx, desc, cc, method, callOpts...)
+		if seg == nil {
+			return stream, err
+		}
+		if err != nil {
+			seg.End()
+			return nil, err
+		}
+		s := &clientStream{
+			ClientStream:  stream,
+			serverStreams: desc.ServerStreams,
+			ended:         make(chan struct{}),
+			done:          seg.End,
+		}
+		go s.watch(ctx)
+		return s, nil
+	}
+}
+
+// clientStream calls done when a stream ends.
+type clientStream struct {
+	grpc.ClientStream
+	serverStreams bool
+	done          func()
+	once          sync.Once
+	ended         chan struct{}
+}
+
+// end calls done once.
+func (s *clientStream) end() {
+	s.once.Do(func() {
+		close(s.ended)
+		s.done()
+	})
+}
+
+// watch ends the stream when ctx is done first.
+func (s *clientStream) watch(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		s.end()
+	case <-s.ended:
+	}
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil || !s.serverStreams {
+		s.end()
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package newrelic
+
+import (
+	"testing"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestHeaders(t *testing.T) {
+	h := headers(metadata.Pairs("traceparent", "00-abc-def-01", "newrelic", "payload"))
+	if want, have := "00-abc-def-01", h.Get("Traceparent"); want != have {
+		t.Fatalf("traceparent: want %q, have %q", want, have)
+	}
+	if want, have := "payload", h.Get("Newrelic"); want != have {
+		t.Fatalf("newrelic: want %q, have %q", want, have)
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	app, err := newrelic.NewApplication(newrelic.ConfigAppName("test"), newrelic.ConfigEnabled(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := UnaryServerInterceptor(app)
+	client := UnaryClientInterceptor()
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return status.Error(codes.Unavailable, "downstream down")
+	}
+
+	_, err = server(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/users.v1.UserService/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		txn := newrelic.FromContext(ctx)
+		if txn == nil {
+			t.Fatal("no transaction")
+		}
+		if want, have := "/users.v1.UserService/Get", txn.Name(); want != have {
+			t.Fatalf("name: want %q, have %q", want, have)
+		}
+		return nil, client(ctx, "/accounts.v1.AccountService/Get", nil, nil, nil, invoker)
+	})
+	if want, have := codes.Unavailable, status.Code(err); want != have {
+		t.Fatalf("want %v, have %v", want, have)
+	}
+
+	// Calls without a transaction are left alone.
+	ctx := context.Background()
+	if err := client(ctx, "/accounts.v1.AccountService/Get", nil, nil, nil, func(have context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		if have != ctx {
+			t.Fatal("context replaced")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,135 @@
+// Package origin provides server interceptors validating the origin of
+// requests from browsers, served through a gRPC-Web wrapper forwarding their
+// HTTP headers as metadata, against an allowlist. It mitigates cross-site
+// requests to endpoints authenticated by cookies, which browsers send
+// whatever the page making the request.
+package origin
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Metadata keys of the browser headers checked, the referer only being used
+// without an origin.
+const (
+	OriginKey  = "origin"
+	RefererKey = "referer"
+)
+
+// Of returns the origin of a request, like "https://app.example.com", from its
+// origin or else its referer, "" if it has neither. Sandboxed pages send the
+// origin "null", matching no pattern but "null".
+func Of(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(OriginKey); len(v) > 0 {
+		return strings.ToLower(v[0])
+	}
+	if v := md.Get(RefererKey); len(v) > 0 {
+		if u, err := url.Parse(v[0]); err == nil && u.Scheme != "" && u.Host != "" {
+			return strings.ToLower(u.Scheme + "://" + u.Host)
+		}
+	}
+	return ""
+}
+
+// Allowlist matches origins against patterns, like "https://app.example.com",
+// or "https://*.example.com" for any subdomain of example.com.
+type Allowlist []string
+
+// Allows reports whether origin matches a pattern of l.
+func (l Allowlist) Allows(origin string) bool {
+	for _, p := range l {
+		p = strings.ToLower(p)
+		if i := strings.Index(p, "://*."); i >= 0 {
+			prefix, suffix := p[:i+3], p[i+4:]
+			if strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) && len(origin) > len(prefix)+len(suffix) {
+				return true
+			}
+			continue
+		}
+		if origin == p {
+			return true
+		}
+	}
+	return false
+}
+
+type options struct {
+	exempt   map[string]bool
+	required bool
+}
+
+// Option for origin interceptors.
+type Option func(o *options)
+
+// WithExemptMethods sets full method names served whatever the origin, like
+// public endpoints embedded by other sites.
+func WithExemptMethods(methods ...string) Option {
+	return func(o *options) {
+		for _, m := range methods {
+			o.exempt[m] = true
+		}
+	}
+}
+
+// WithRequired rejects requests without an origin. By default they are
+// served, as they don't come from browsers, which send the origin of
+// cross-site requests.
+func WithRequired() Option {
+	return func(o *options) {
+		o.required = true
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{exempt: make(map[string]bool)}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func check(ctx context.Context, allowed Allowlist, o options, method string) error {
+	if o.exempt[method] {
+		return nil
+	}
+	origin := Of(ctx)
+	switch {
+	case origin == "" && o.required:
+		return status.Error(codes.PermissionDenied, "origin: missing request origin")
+	case origin != "" && !allowed.Allows(origin):
+		return status.Errorf(codes.PermissionDenied, "origin: %s is not allowed", origin)
+	}
+	return nil
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor rejecting
+// requests from origins not allowed with PermissionDenied.
+func UnaryServerInterceptor(allowed Allowlist, opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := check(ctx, allowed, o, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor
+// rejecting streams from origins not allowed with PermissionDenied.
+func StreamServerInterceptor(allowed Allowlist, opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := check(stream.Context(), allowed, o, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
@@ -0,0 +1,64 @@
+package origin
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAllowlist(t *testing.T) {
+	l := Allowlist{"https://app.example.com", "https://*.example.org"}
+	for origin, want := range map[string]bool{
+		"https://app.example.com":      true,
+		"http://app.example.com":       false,
+		"https://app.example.com:8443": false,
+		"https://evil.com":             false,
+		"https://a.example.org":        true,
+		"https://a.b.example.org":      true,
+		"https://example.org":          false,
+		"https://.example.org":         false,
+		"https://evilexample.org":      false,
+		"http://a.example.org":         false,
+	} {
+		if have := l.Allows(origin); want != have {
+			t.Errorf("%s: want %v, have %v", origin, want, have)
+		}
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := UnaryServerInterceptor(Allowlist{"https://app.example.com"}, WithExemptMethods("/test.Service/Public"))
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	}
+
+	for _, tc := range []struct {
+		name   string
+		md     metadata.MD
+		method string
+		code   codes.Code
+	}{
+		{"allowed", metadata.Pairs(OriginKey, "https://APP.example.com"), "/test.Service/Get", codes.OK},
+		{"cross-site", metadata.Pairs(OriginKey, "https://evil.com"), "/test.Service/Get", codes.PermissionDenied},
+		{"referer", metadata.Pairs(RefererKey, "https://evil.com/page?q=1"), "/test.Service/Get", codes.PermissionDenied},
+		{"allowed referer", metadata.Pairs(RefererKey, "https://app.example.com/settings"), "/test.Service/Get", codes.OK},
+		{"null origin", metadata.Pairs(OriginKey, "null"), "/test.Service/Get", codes.PermissionDenied},
+		{"exempt", metadata.Pairs(OriginKey, "https://evil.com"), "/test.Service/Public", codes.OK},
+		{"not a browser", nil, "/test.Service/Get", codes.OK},
+	} {
+		ctx := metadata.NewIncomingContext(context.Background(), tc.md)
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tc.method}, handler)
+		if want, have := tc.code, status.Code(err); want != have {
+			t.Errorf("%s: want %v, have %v", tc.name, want, have)
+		}
+	}
+
+	required := UnaryServerInterceptor(Allowlist{"https://app.example.com"}, WithRequired())
+	if _, err := required(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, handler); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("required: want %v, have %v", codes.PermissionDenied, status.Code(err))
+	}
+}
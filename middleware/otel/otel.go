@@ -0,0 +1,249 @@
+// Package otel provides interceptors recording OpenTelemetry metrics of RPCs,
+// following the semantic conventions for gRPC.
+package otel
+
+import (
+	"io"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// instrumentationName is the name of the Meter of this package.
+const instrumentationName = "github.com/ipfans/grpctools/middleware/otel"
+
+type options struct {
+	provider   metric.MeterProvider
+	attributes func(ctx context.Context) []attribute.KeyValue
+}
+
+// Option for Instruments instance.
+type Option func(o *options)
+
+// WithMeterProvider sets the MeterProvider instruments are created with.
+// Default is the global provider.
+func WithMeterProvider(p metric.MeterProvider) Option {
+	return func(o *options) {
+		o.provider = p
+	}
+}
+
+// WithAttributes adds the attributes f returns for the context of each RPC,
+// like the tenant of the request. Keep their values few: every combination
+// is a time series.
+func WithAttributes(f func(ctx context.Context) []attribute.KeyValue) Option {
+	return func(o *options) {
+		o.attributes = f
+	}
+}
+
+// Instruments records the duration of RPCs and the number of messages per
+// RPC, as rpc.server.* or rpc.client.* metrics.
+type Instruments struct {
+	attributes func(ctx context.Context) []attribute.KeyValue
+	duration   metric.Float64Histogram
+	requests   metric.Int64Histogram
+	responses  metric.Int64Histogram
+}
+
+// NewServerInstruments creates the rpc.server.* instruments.
+func NewServerInstruments(opts ...Option) (*Instruments, error) {
+	return newInstruments("rpc.server", opts)
+}
+
+// NewClientInstruments creates the rpc.client.* instruments.
+func NewClientInstruments(opts ...Option) (*Instruments, error) {
+	return newInstruments("rpc.client", opts)
+}
+
+func newInstruments(prefix string, opts []Option) (*Instruments, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.provider == nil {
+		o.provider = otel.GetMeterProvider()
+	}
+	meter := o.provider.Meter(instrumentationName)
+
+	var (
+		i   = Instruments{attributes: o.attributes}
+		err error
+	)
+	if i.duration, err = meter.Float64Histogram(prefix+".duration", metric.WithUnit("ms"), metric.WithDescription("Duration of RPCs.")); err != nil {
+		return nil, err
+	}
+	if i.requests, err = meter.Int64Histogram(prefix+".requests_per_rpc", metric.WithUnit("{count}"), metric.WithDescription("Number of request messages per RPC.")); err != nil {
+		return nil, err
+	}
+	if i.responses, err = meter.Int64Histogram(prefix+".responses_per_rpc", metric.WithUnit("{count}"), metric.WithDescription("Number of response messages per RPC.")); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// record records an RPC to method started at start.
+func (i *Instruments) record(ctx context.Context, method string, start time.Time, requests, responses int64, err error) {
+	kvs := []attribute.KeyValue{
+		attribute.String("rpc.system", "grpc"),
+		attribute.String("rpc.service", path.Dir(method)[1:]),
+		attribute.String("rpc.method", path.Base(method)),
+		attribute.Int("rpc.grpc.status_code", int(status.Code(err))),
+	}
+	if i.attributes != nil {
+		kvs = append(kvs, i.attributes(ctx)...)
+	}
+	attrs := metric.WithAttributes(kvs...)
+	i.duration.Record(ctx, float64(time.Since(start))/float64(time.Millisecond), attrs)
+	i.requests.Record(ctx, requests, attrs)
+	i.responses.Record(ctx, responses, attrs)
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor recording
+// every RPC.
+func (i *Instruments) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		var responses int64
+		if err == nil {
+			responses = 1
+		}
+		i.record(ctx, info.FullMethod, start, 1, responses, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor
+// recording every RPC.
+func (i *Instruments) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		s := &serverStream{ServerStream: stream}
+		err := handler(srv, s)
+		i.record(stream.Context(), info.FullMethod, start, atomic.LoadInt64(&s.received), atomic.LoadInt64(&s.sent), err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor recording
+// every call.
+func (i *Instruments) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		var responses int64
+		if err == nil {
+			responses = 1
+		}
+		i.record(ctx, method, start, 1, responses, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor returns a new streaming client interceptor
+// recording every stream once RecvMsg returns an error, io.EOF counting as
+// OK, once a client-streaming call receives its response, or once the context
+// of the call is done.
+func (i *Instruments) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			i.record(ctx, method, start, 0, 0, err)
+			return nil, err
+		}
+		s := &clientStream{
+			ClientStream:  stream,
+			serverStreams: desc.ServerStreams,
+			ended:         make(chan struct{}),
+			done: func(sent, received int64, err error) {
+				i.record(ctx, method, start, sent, received, err)
+			},
+		}
+		go s.watch(ctx)
+		return s, nil
+	}
+}
+
+// serverStream counts the messages of a stream.
+type serverStream struct {
+	grpc.ServerStream
+	sent, received int64 // accessed atomically
+}
+
+func (s *serverStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.sent, 1)
+	}
+	return err
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.received, 1)
+	}
+	return err
+}
+
+// clientStream counts the messages of a stream and calls done when it ends.
+type clientStream struct {
+	grpc.ClientStream
+	serverStreams  bool
+	done           func(sent, received int64, err error)
+	sent, received int64 // accessed atomically
+	once           sync.Once
+	ended          chan struct{}
+}
+
+// end calls done once.
+func (s *clientStream) end(err error) {
+	s.once.Do(func() {
+		close(s.ended)
+		s.done(atomic.LoadInt64(&s.sent), atomic.LoadInt64(&s.received), err)
+	})
+}
+
+// watch ends the stream when ctx is done first, so abandoned streams are
+// recorded as canceled.
+func (s *clientStream) watch(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		s.end(status.FromContextError(ctx.Err()).Err())
+	case <-s.ended:
+	}
+}
+
+func (s *clientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.sent, 1)
+	}
+	return err
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == nil:
+		atomic.AddInt64(&s.received, 1)
+		if !s.serverStreams {
+			s.end(nil)
+		}
+	case err == io.EOF:
+		s.end(nil)
+	default:
+		s.end(err)
+	}
+	return err
+}
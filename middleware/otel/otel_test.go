@@ -0,0 +1,108 @@
+package otel
+
+import (
+	"testing"
+	"time"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// collect returns the number of data points recorded per metric name.
+func collect(t *testing.T, reader *sdkmetric.ManualReader) map[string]uint64 {
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]uint64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					counts[m.Name] += dp.Count
+				}
+			case metricdata.Histogram[int64]:
+				for _, dp := range data.DataPoints {
+					counts[m.Name] += dp.Count
+				}
+			}
+		}
+	}
+	return counts
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	i, err := NewServerInstruments(WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	interceptor := i.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.v1.Service/Get"}
+	for _, err := range []error{nil, status.Error(codes.NotFound, "not found")} {
+		interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, err
+		})
+	}
+
+	counts := collect(t, reader)
+	for _, name := range []string{"rpc.server.duration", "rpc.server.requests_per_rpc", "rpc.server.responses_per_rpc"} {
+		if want, have := uint64(2), counts[name]; want != have {
+			t.Errorf("%s: want %d, have %d", name, want, have)
+		}
+	}
+}
+
+// fakeStream is a client stream whose SendMsg and RecvMsg succeed.
+type fakeStream struct {
+	grpc.ClientStream
+}
+
+func (fakeStream) SendMsg(m interface{}) error { return nil }
+func (fakeStream) CloseSend() error            { return nil }
+func (fakeStream) RecvMsg(m interface{}) error { return nil }
+
+func TestStreamClientInterceptor(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	i, err := NewClientInstruments(WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	interceptor := i.StreamClientInterceptor()
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return fakeStream{}, nil
+	}
+
+	// A client-streaming call is recorded when its response is received.
+	cs, err := interceptor(context.Background(), &grpc.StreamDesc{ClientStreams: true}, nil, "/test.v1.Service/Upload", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.SendMsg(nil)
+	cs.SendMsg(nil)
+	cs.CloseSend()
+	cs.RecvMsg(nil)
+	if want, have := uint64(1), collect(t, reader)["rpc.client.duration"]; want != have {
+		t.Fatalf("client stream: want %d, have %d", want, have)
+	}
+
+	// An abandoned stream is recorded when its context is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := interceptor(ctx, &grpc.StreamDesc{ServerStreams: true}, nil, "/test.v1.Service/Watch", streamer); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	var have uint64
+	for n := 0; n < 100 && have < 2; n++ {
+		time.Sleep(time.Millisecond)
+		have = collect(t, reader)["rpc.client.duration"]
+	}
+	if want := uint64(2); want != have {
+		t.Fatalf("abandoned stream: want %d, have %d", want, have)
+	}
+}
@@ -0,0 +1,332 @@
+// Package prometheus provides interceptors exporting RPC metrics to
+// Prometheus, and a metrics.Provider for the other middlewares.
+package prometheus
+
+import (
+	"io"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfans/grpctools/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Unknown is the service and method label of RPCs to methods not counted
+// separately, see Metrics.Initialize and WithMaxMethods.
+const Unknown = "unknown"
+
+type options struct {
+	registerer prometheus.Registerer
+	buckets    []float64
+	maxMethods int
+}
+
+// Option for Metrics instance.
+type Option func(o *options)
+
+// WithRegistry sets the registry metrics are registered to. Default is
+// prometheus.DefaultRegisterer.
+func WithRegistry(r prometheus.Registerer) Option {
+	return func(o *options) {
+		o.registerer = r
+	}
+}
+
+// WithBuckets sets the buckets of the latency histograms, in seconds. Default
+// is prometheus.DefBuckets.
+func WithBuckets(buckets []float64) Option {
+	return func(o *options) {
+		o.buckets = buckets
+	}
+}
+
+// WithMaxMethods sets how many methods are counted separately, the first
+// seen, further methods being labeled Unknown. It bounds the series of client
+// metrics, or of servers handling unknown services, like proxies. Default is
+// unlimited.
+func WithMaxMethods(n int) Option {
+	return func(o *options) {
+		o.maxMethods = n
+	}
+}
+
+// Metrics counts RPCs, their latency and how many are in flight, labeled by
+// type, service, method and, once handled, code.
+type Metrics struct {
+	started  *prometheus.CounterVec
+	handled  *prometheus.CounterVec
+	seconds  *prometheus.HistogramVec
+	inFlight *prometheus.GaugeVec
+
+	maxMethods int
+	known      atomic.Value // map[string]bool of full methods, once initialized
+	mu         sync.Mutex
+	seen       map[string]bool
+}
+
+// NewServerMetrics registers the grpc_server_* metrics.
+func NewServerMetrics(opts ...Option) *Metrics {
+	return newMetrics("server", "handled", opts)
+}
+
+// NewClientMetrics registers the grpc_client_* metrics.
+func NewClientMetrics(opts ...Option) *Metrics {
+	return newMetrics("client", "completed", opts)
+}
+
+func newMetrics(side, done string, opts []Option) *Metrics {
+	o := options{
+		registerer: prometheus.DefaultRegisterer,
+		buckets:    prometheus.DefBuckets,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	labels := []string{"grpc_type", "grpc_service", "grpc_method"}
+	return &Metrics{
+		maxMethods: o.maxMethods,
+		seen:       make(map[string]bool),
+		started: register(o.registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_" + side + "_started_total",
+			Help: "Total number of RPCs started on the " + side + ".",
+		}, labels)).(*prometheus.CounterVec),
+		handled: register(o.registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_" + side + "_handled_total",
+			Help: "Total number of RPCs " + done + " on the " + side + ", regardless of success or failure.",
+		}, append(labels, "grpc_code"))).(*prometheus.CounterVec),
+		seconds: register(o.registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_" + side + "_handling_seconds",
+			Help:    "Latency of RPCs " + done + " on the " + side + ".",
+			Buckets: o.buckets,
+		}, labels)).(*prometheus.HistogramVec),
+		inFlight: register(o.registerer, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "grpc_" + side + "_in_flight",
+			Help: "Number of RPCs in flight on the " + side + ".",
+		}, labels)).(*prometheus.GaugeVec),
+	}
+}
+
+// register registers c, returning the collector registered before if there is
+// one, so metrics may be created more than once.
+func register(r prometheus.Registerer, c prometheus.Collector) prometheus.Collector {
+	if err := r.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+		panic(err)
+	}
+	return c
+}
+
+func rpcType(clientStreams, serverStreams bool) string {
+	switch {
+	case clientStreams && serverStreams:
+		return "bidi_stream"
+	case clientStreams:
+		return "client_stream"
+	case serverStreams:
+		return "server_stream"
+	}
+	return "unary"
+}
+
+// Initialize counts the RPCs to the methods registered on s separately, the
+// others being labeled Unknown, and creates their series, so they are
+// exported at zero before their first RPC. Call it once services are
+// registered, before s serves.
+func (m *Metrics) Initialize(s *grpc.Server) {
+	known := make(map[string]bool)
+	for service, info := range s.GetServiceInfo() {
+		for _, method := range info.Methods {
+			typ := rpcType(method.IsClientStream, method.IsServerStream)
+			known["/"+service+"/"+method.Name] = true
+			m.started.WithLabelValues(typ, service, method.Name)
+			m.seconds.WithLabelValues(typ, service, method.Name)
+			m.inFlight.WithLabelValues(typ, service, method.Name)
+		}
+	}
+	m.known.Store(known)
+}
+
+// labels returns the service and method labels of method.
+func (m *Metrics) labels(method string) (string, string) {
+	if known, ok := m.known.Load().(map[string]bool); ok && !known[method] {
+		return Unknown, Unknown
+	}
+	if m.maxMethods > 0 {
+		m.mu.Lock()
+		if !m.seen[method] {
+			if len(m.seen) >= m.maxMethods {
+				m.mu.Unlock()
+				return Unknown, Unknown
+			}
+			m.seen[method] = true
+		}
+		m.mu.Unlock()
+	}
+	return path.Dir(method)[1:], path.Base(method)
+}
+
+// start records the start of an RPC, returning a func recording its end.
+func (m *Metrics) start(typ, method string) func(err error) {
+	service, name := m.labels(method)
+	m.started.WithLabelValues(typ, service, name).Inc()
+	inFlight := m.inFlight.WithLabelValues(typ, service, name)
+	inFlight.Inc()
+	start := time.Now()
+	return func(err error) {
+		inFlight.Dec()
+		m.handled.WithLabelValues(typ, service, name, status.Code(err).String()).Inc()
+		m.seconds.WithLabelValues(typ, service, name).Observe(time.Since(start).Seconds())
+	}
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor recording
+// metrics of every RPC.
+func (m *Metrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		done := m.start("unary", info.FullMethod)
+		resp, err := handler(ctx, req)
+		done(err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor
+// recording metrics of every RPC.
+func (m *Metrics) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		done := m.start(rpcType(info.IsClientStream, info.IsServerStream), info.FullMethod)
+		err := handler(srv, stream)
+		done(err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor recording
+// metrics of every call.
+func (m *Metrics) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		done := m.start("unary", method)
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		done(err)
+		return err
+	}
+}
+
+// StreamClientInterceptor returns a new streaming client interceptor
+// recording metrics of every stream. A stream ends when RecvMsg returns an
+// error, io.EOF counting as OK, when a client-streaming call receives its
+// response, or when the context of the call is done.
+func (m *Metrics) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		done := m.start(rpcType(desc.ClientStreams, desc.ServerStreams), method)
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			done(err)
+			return nil, err
+		}
+		s := &clientStream{ClientStream: stream, serverStreams: desc.ServerStreams, done: done, ended: make(chan struct{})}
+		go s.watch(ctx)
+		return s, nil
+	}
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	serverStreams bool
+	done          func(err error)
+	once          sync.Once
+	ended         chan struct{}
+}
+
+// end records the end of the stream once.
+func (s *clientStream) end(err error) {
+	s.once.Do(func() {
+		close(s.ended)
+		s.done(err)
+	})
+}
+
+// watch ends the stream when ctx is done first, so abandoned streams are
+// recorded as canceled.
+func (s *clientStream) watch(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		s.end(status.FromContextError(ctx.Err()).Err())
+	case <-s.ended:
+	}
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == io.EOF || err == nil && !s.serverStreams:
+		s.end(nil)
+	case err != nil:
+		s.end(err)
+	}
+	return err
+}
+
+// NewProvider returns a metrics.Provider registering Prometheus metrics to r,
+// to plug other middlewares into Prometheus.
+func NewProvider(r prometheus.Registerer) metrics.Provider {
+	return provider{r}
+}
+
+type provider struct {
+	r prometheus.Registerer
+}
+
+func (p provider) NewCounter(name, help string, labelNames ...string) metrics.Counter {
+	return counter{register(p.r, prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labelNames)).(*prometheus.CounterVec), nil}
+}
+
+func (p provider) NewGauge(name, help string, labelNames ...string) metrics.Gauge {
+	return gauge{register(p.r, prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labelNames)).(*prometheus.GaugeVec), nil}
+}
+
+func (p provider) NewHistogram(name, help string, labelNames ...string) metrics.Histogram {
+	return histogram{register(p.r, prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help}, labelNames)).(*prometheus.HistogramVec), nil}
+}
+
+type counter struct {
+	vec    *prometheus.CounterVec
+	values []string
+}
+
+func (c counter) With(labelValues ...string) metrics.Counter {
+	return counter{c.vec, append(append([]string(nil), c.values...), labelValues...)}
+}
+
+func (c counter) Add(delta float64) { c.vec.WithLabelValues(c.values...).Add(delta) }
+
+type gauge struct {
+	vec    *prometheus.GaugeVec
+	values []string
+}
+
+func (g gauge) With(labelValues ...string) metrics.Gauge {
+	return gauge{g.vec, append(append([]string(nil), g.values...), labelValues...)}
+}
+
+func (g gauge) Set(value float64) { g.vec.WithLabelValues(g.values...).Set(value) }
+
+func (g gauge) Add(delta float64) { g.vec.WithLabelValues(g.values...).Add(delta) }
+
+type histogram struct {
+	vec    *prometheus.HistogramVec
+	values []string
+}
+
+func (h histogram) With(labelValues ...string) metrics.Histogram {
+	return histogram{h.vec, append(append([]string(nil), h.values...), labelValues...)}
+}
+
+func (h histogram) Observe(value float64) { h.vec.WithLabelValues(h.values...).Observe(value) }
@@ -0,0 +1,145 @@
+package prometheus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestRPCType(t *testing.T) {
+	for _, tc := range []struct {
+		client, server bool
+		want           string
+	}{
+		{false, false, "unary"},
+		{true, false, "client_stream"},
+		{false, true, "server_stream"},
+		{true, true, "bidi_stream"},
+	} {
+		if have := rpcType(tc.client, tc.server); tc.want != have {
+			t.Errorf("rpcType(%v, %v): want %s, have %s", tc.client, tc.server, tc.want, have)
+		}
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	m := NewServerMetrics(WithRegistry(prometheus.NewRegistry()))
+	interceptor := m.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.v1.Service/Get"}
+	for _, err := range []error{nil, nil, status.Error(codes.NotFound, "not found")} {
+		interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			if want, have := 1.0, testutil.ToFloat64(m.inFlight.WithLabelValues("unary", "test.v1.Service", "Get")); want != have {
+				t.Errorf("in flight: want %v, have %v", want, have)
+			}
+			return nil, err
+		})
+	}
+
+	for _, tc := range []struct {
+		c    prometheus.Collector
+		want float64
+	}{
+		{m.started.WithLabelValues("unary", "test.v1.Service", "Get"), 3},
+		{m.handled.WithLabelValues("unary", "test.v1.Service", "Get", "OK"), 2},
+		{m.handled.WithLabelValues("unary", "test.v1.Service", "Get", "NotFound"), 1},
+		{m.inFlight.WithLabelValues("unary", "test.v1.Service", "Get"), 0},
+	} {
+		if have := testutil.ToFloat64(tc.c); tc.want != have {
+			t.Errorf("want %v, have %v", tc.want, have)
+		}
+	}
+}
+
+func TestLabels(t *testing.T) {
+	s := grpc.NewServer()
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	m := NewServerMetrics(WithRegistry(prometheus.NewRegistry()))
+	m.Initialize(s)
+	for method, want := range map[string]string{
+		"/grpc.health.v1.Health/Check": "grpc.health.v1.Health Check",
+		"/grpc.health.v1.Health/Watch": "grpc.health.v1.Health Watch",
+		"/grpc.health.v1.Health/Typo":  "unknown unknown",
+		"/wp-admin.Scanner/Probe":      "unknown unknown",
+	} {
+		service, name := m.labels(method)
+		if have := service + " " + name; want != have {
+			t.Errorf("%s: want %s, have %s", method, want, have)
+		}
+	}
+
+	m = NewClientMetrics(WithRegistry(prometheus.NewRegistry()), WithMaxMethods(2))
+	for _, tc := range []struct{ method, want string }{
+		{"/test.v1.Service/A", "test.v1.Service A"},
+		{"/test.v1.Service/B", "test.v1.Service B"},
+		{"/test.v1.Service/C", "unknown unknown"},
+		{"/test.v1.Service/A", "test.v1.Service A"},
+	} {
+		service, name := m.labels(tc.method)
+		if have := service + " " + name; tc.want != have {
+			t.Errorf("%s: want %s, have %s", tc.method, tc.want, have)
+		}
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	r := prometheus.NewRegistry()
+	a, b := NewClientMetrics(WithRegistry(r)), NewClientMetrics(WithRegistry(r))
+	if a.handled != b.handled {
+		t.Fatal("metrics registered twice aren't shared")
+	}
+}
+
+// fakeStream is a client stream whose RecvMsg succeeds.
+type fakeStream struct {
+	grpc.ClientStream
+}
+
+func (fakeStream) RecvMsg(m interface{}) error { return nil }
+
+func TestStreamClientInterceptor(t *testing.T) {
+	m := NewClientMetrics(WithRegistry(prometheus.NewRegistry()))
+	interceptor := m.StreamClientInterceptor()
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return fakeStream{}, nil
+	}
+
+	// A client-streaming call ends with its response.
+	cs, err := interceptor(context.Background(), &grpc.StreamDesc{ClientStreams: true}, nil, "/test.v1.Service/Upload", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.RecvMsg(nil)
+	if want, have := 1.0, testutil.ToFloat64(m.handled.WithLabelValues("client_stream", "test.v1.Service", "Upload", "OK")); want != have {
+		t.Errorf("client stream handled: want %v, have %v", want, have)
+	}
+	if want, have := 0.0, testutil.ToFloat64(m.inFlight.WithLabelValues("client_stream", "test.v1.Service", "Upload")); want != have {
+		t.Errorf("client stream in flight: want %v, have %v", want, have)
+	}
+
+	// An abandoned stream ends when its context is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cs, err = interceptor(ctx, &grpc.StreamDesc{ServerStreams: true}, nil, "/test.v1.Service/Watch", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.RecvMsg(nil)
+	cancel()
+	canceled := m.handled.WithLabelValues("server_stream", "test.v1.Service", "Watch", "Canceled")
+	for i := 0; i < 100 && testutil.ToFloat64(canceled) == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if want, have := 1.0, testutil.ToFloat64(canceled); want != have {
+		t.Errorf("abandoned stream handled: want %v, have %v", want, have)
+	}
+	if want, have := 0.0, testutil.ToFloat64(m.inFlight.WithLabelValues("server_stream", "test.v1.Service", "Watch")); want != have {
+		t.Errorf("abandoned stream in flight: want %v, have %v", want, have)
+	}
+}
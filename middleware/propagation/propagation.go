@@ -0,0 +1,133 @@
+// Package propagation provides interceptors carrying allowlisted metadata
+// from incoming calls to the outgoing calls they make.
+package propagation
+
+import (
+	"strings"
+
+	"github.com/ipfans/grpctools/middleware"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// DefaultKeys are the metadata keys propagated by default: request ID,
+// tenant, locale and trace baggage.
+var DefaultKeys = []string{"x-request-id", "x-tenant-id", "accept-language", "baggage"}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying md for outgoing calls.
+func NewContext(ctx context.Context, md metadata.MD) context.Context {
+	return context.WithValue(ctx, contextKey{}, md)
+}
+
+// FromContext returns the metadata propagated by ctx, false if it has none.
+func FromContext(ctx context.Context) (metadata.MD, bool) {
+	md, ok := ctx.Value(contextKey{}).(metadata.MD)
+	return md, ok
+}
+
+type options struct {
+	keys []string
+}
+
+// Option for propagation interceptors.
+type Option func(o *options)
+
+// WithKeys sets the metadata keys propagated. Default is DefaultKeys.
+func WithKeys(keys ...string) Option {
+	return func(o *options) {
+		o.keys = make([]string, len(keys))
+		for i, k := range keys {
+			o.keys[i] = strings.ToLower(k)
+		}
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{keys: DefaultKeys}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// filter returns the allowlisted keys of md.
+func (o options) filter(md metadata.MD) metadata.MD {
+	out := metadata.MD{}
+	for _, k := range o.keys {
+		if v := md.Get(k); len(v) > 0 {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// propagated returns the metadata ctx propagates: the one stored by the
+// server interceptors, or else the allowlisted incoming metadata.
+func (o options) propagated(ctx context.Context) metadata.MD {
+	if md, ok := FromContext(ctx); ok {
+		return md
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	return o.filter(md)
+}
+
+// outgoing returns ctx with the propagated metadata added to the outgoing
+// metadata, leaving keys set by the caller alone.
+func (o options) outgoing(ctx context.Context) context.Context {
+	md := o.propagated(ctx)
+	if len(md) == 0 {
+		return ctx
+	}
+	out, _ := metadata.FromOutgoingContext(ctx)
+	out = out.Copy()
+	for k, v := range md {
+		if len(out.Get(k)) == 0 {
+			out[k] = v
+		}
+	}
+	return metadata.NewOutgoingContext(ctx, out)
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor storing the
+// allowlisted incoming metadata in the context of handlers.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		return handler(NewContext(ctx, o.filter(md)), req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor storing
+// the allowlisted incoming metadata in the context of handlers.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		md, _ := metadata.FromIncomingContext(ctx)
+		wrapped := middleware.WrapServerStream(ss)
+		wrapped.SetContext(NewContext(ctx, o.filter(md)))
+		return handler(srv, wrapped)
+	}
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor copying the
+// propagated metadata onto outgoing calls.
+func UnaryClientInterceptor(opts ...Option) grpc.UnaryClientInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		return invoker(o.outgoing(ctx), method, req, reply, cc, callOpts...)
+	}
+}
+
+// StreamClientInterceptor returns a new streaming client interceptor copying
+// the propagated metadata onto outgoing calls.
+func StreamClientInterceptor(opts ...Option) grpc.StreamClientInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(o.outgoing(ctx), desc, cc, method, callOpts...)
+	}
+}
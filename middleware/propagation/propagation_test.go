@@ -0,0 +1,52 @@
+package propagation
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// outgoingInvoker records the outgoing metadata of the call.
+func outgoingInvoker(md *metadata.MD) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		*md, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"x-request-id", "abc",
+		"x-tenant-id", "acme",
+		"authorization", "secret",
+	))
+	client := UnaryClientInterceptor()
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-tenant-id", "other")
+		var md metadata.MD
+		err := client(ctx, "/test.Downstream/Get", nil, nil, nil, outgoingInvoker(&md))
+		return md, err
+	}
+
+	resp, err := UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := metadata.Pairs("x-request-id", "abc", "x-tenant-id", "other"), resp.(metadata.MD); !reflect.DeepEqual(want, have) {
+		t.Fatalf("want %v, have %v", want, have)
+	}
+}
+
+func TestWithKeys(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-request-id", "abc", "x-user", "bob"))
+	var md metadata.MD
+	if err := UnaryClientInterceptor(WithKeys("X-User"))(ctx, "/test.Downstream/Get", nil, nil, nil, outgoingInvoker(&md)); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := metadata.Pairs("x-user", "bob"), md; !reflect.DeepEqual(want, have) {
+		t.Fatalf("want %v, have %v", want, have)
+	}
+}
@@ -0,0 +1,232 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// minRetryWait bounds how often a blocked Take polls its bucket.
+const minRetryWait = time.Millisecond
+
+// Bucket is a rate limiting algorithm guarding a single limit. Requests
+// costing more tokens than a bucket can ever hold, such as more than its
+// burst, are charged the whole bucket instead of waiting forever.
+type Bucket interface {
+	// Take blocks until n tokens are available and takes them.
+	Take(n int)
+	// TryTake takes n tokens if they are available now. Otherwise it takes
+	// nothing and reports how long to wait before they may be.
+	TryTake(n int) (ok bool, wait time.Duration)
+}
+
+// Algorithm creates the Bucket enforcing a limit from Config.
+type Algorithm func(limit int) Bucket
+
+// LeakyBucket is the default Algorithm. Limits are requests per second and
+// requests are spaced evenly, without bursts.
+func LeakyBucket() Algorithm {
+	return func(limit int) Bucket {
+		return NewLeakyBucket(limit)
+	}
+}
+
+// TokenBucket returns an Algorithm whose limits are requests per second with
+// bursts of up to burst requests.
+func TokenBucket(burst int) Algorithm {
+	return func(limit int) Bucket {
+		return NewTokenBucket(limit, burst)
+	}
+}
+
+// refunder is implemented by buckets able to give back tokens taken by
+// TryTake.
+type refunder interface {
+	refund(n int)
+}
+
+// inspector is implemented by buckets able to report their state without
+// taking tokens.
+type inspector interface {
+	// inspect returns the tokens available at now and how long until one
+	// token is.
+	inspect(now time.Time) (tokens float64, wait time.Duration)
+}
+
+// tryTakeAll takes n tokens from every bucket or from none: when a bucket
+// rejects, the tokens already taken from the buckets before it are given back,
+// so a request rejected by its own limit doesn't drain the shared ones. Buckets
+// of other packages can't give tokens back.
+func tryTakeAll(buckets []Bucket, n int) (bool, time.Duration) {
+	for i, b := range buckets {
+		if ok, wait := b.TryTake(n); !ok {
+			refundAll(buckets[:i], n)
+			return false, wait
+		}
+	}
+	return true, 0
+}
+
+// refundAll gives n tokens back to the buckets able to.
+func refundAll(buckets []Bucket, n int) {
+	for _, b := range buckets {
+		if r, ok := b.(refunder); ok {
+			r.refund(n)
+		}
+	}
+}
+
+// takeBlocking implements Take on top of TryTake.
+func takeBlocking(b Bucket, c Clock, n int) {
+	for {
+		ok, wait := b.TryTake(n)
+		if ok {
+			return
+		}
+		if wait < minRetryWait {
+			wait = minRetryWait
+		}
+		c.Sleep(wait)
+	}
+}
+
+type leakyBucket struct {
+	per   time.Duration
+	clock Clock
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// NewLeakyBucket returns a leaky bucket passing rate requests per second.
+func NewLeakyBucket(rate int) Bucket {
+	return &leakyBucket{per: time.Second / time.Duration(rate), clock: SystemClock}
+}
+
+func (b *leakyBucket) setClock(c Clock) { b.clock = c }
+
+// Take reserves the next free slots and sleeps until they are reached, so
+// concurrent callers are served in arrival order.
+func (b *leakyBucket) Take(n int) {
+	b.mu.Lock()
+	now := b.clock.Now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	wait := b.next.Sub(now)
+	b.next = b.next.Add(time.Duration(n) * b.per)
+	b.mu.Unlock()
+
+	b.clock.Sleep(wait)
+}
+
+func (b *leakyBucket) refund(n int) {
+	b.mu.Lock()
+	b.next = b.next.Add(-time.Duration(n) * b.per)
+	b.mu.Unlock()
+}
+
+func (b *leakyBucket) inspect(now time.Time) (float64, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.next.After(now) {
+		return 0, b.next.Sub(now)
+	}
+	return 1, 0
+}
+
+func (b *leakyBucket) TryTake(n int) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.clock.Now()
+	if b.next.After(now) {
+		return false, b.next.Sub(now)
+	}
+	b.next = now.Add(time.Duration(n) * b.per)
+	return true, 0
+}
+
+type tokenBucket struct {
+	per   time.Duration
+	burst float64
+	clock Clock
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a token bucket refilled with rate tokens per second
+// and holding at most burst tokens. It starts full.
+func NewTokenBucket(rate, burst int) Bucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		per:    time.Second / time.Duration(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		clock:  SystemClock,
+	}
+}
+
+func (b *tokenBucket) setClock(c Clock) { b.clock = c }
+
+// advance refills the bucket up to now. b.mu must be held.
+func (b *tokenBucket) advance(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens += float64(now.Sub(b.last)) / float64(b.per)
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+// Take may drive the bucket into debt, making later callers wait for it to
+// be paid back first.
+func (b *tokenBucket) Take(n int) {
+	b.mu.Lock()
+	b.advance(b.clock.Now())
+	b.tokens -= b.fit(n)
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens * float64(b.per))
+	}
+	b.mu.Unlock()
+
+	b.clock.Sleep(wait)
+}
+
+func (b *tokenBucket) TryTake(n int) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance(b.clock.Now())
+	want := b.fit(n)
+	if b.tokens >= want {
+		b.tokens -= want
+		return true, 0
+	}
+	return false, time.Duration((want - b.tokens) * float64(b.per))
+}
+
+func (b *tokenBucket) refund(n int) {
+	b.mu.Lock()
+	b.tokens = math.Min(b.tokens+b.fit(n), b.burst)
+	b.mu.Unlock()
+}
+
+func (b *tokenBucket) inspect(now time.Time) (float64, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance(now)
+	if b.tokens >= 1 {
+		return b.tokens, 0
+	}
+	return math.Max(0, b.tokens), time.Duration((1 - b.tokens) * float64(b.per))
+}
+
+// fit clamps n to the burst of the bucket.
+func (b *tokenBucket) fit(n int) float64 {
+	return math.Min(float64(n), b.burst)
+}
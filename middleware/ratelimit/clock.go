@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"time"
+)
+
+// Clock tells the time and sleeps for limiters. Tests substitute a fake
+// clock, such as the one in package ratelimittest, to control time without
+// sleeping.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	// After returns a channel receiving the time once d has elapsed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock is the Clock backed by package time.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time        { return time.Now() }
+func (systemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// clockSetter is implemented by the buckets of this package.
+type clockSetter interface {
+	setClock(c Clock)
+}
+
+// WithClock makes the limiter and its buckets use c instead of SystemClock.
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// Clocked wraps alg so its buckets use c instead of SystemClock. Buckets of
+// other packages are returned unchanged.
+func Clocked(alg Algorithm, c Clock) Algorithm {
+	return func(limit int) Bucket {
+		b := alg(limit)
+		if cs, ok := b.(clockSetter); ok {
+			cs.setClock(c)
+		}
+		return b
+	}
+}
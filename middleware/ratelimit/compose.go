@@ -0,0 +1,170 @@
+package ratelimit
+
+import (
+	grpcerrors "github.com/ipfans/grpctools/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Rule is a single limit enforced by a Composite.
+type Rule interface {
+	// Name identifies the rule in errors.
+	Name() string
+	// Acquire admits the request or returns an error. On success, release is
+	// called once the request finished.
+	Acquire(ctx context.Context, fullMethod string, req interface{}) (release func(), err error)
+}
+
+// RateRule returns a Rule enforcing the limits of l. Composite limits streams
+// when they open, before any message is received, so the RequestKeyFunc of l
+// doesn't apply to streams and their keys come from its KeyFunc only.
+func RateRule(name string, l *Limiter) Rule {
+	return &rateRule{name: name, limiter: l}
+}
+
+type rateRule struct {
+	name    string
+	limiter *Limiter
+}
+
+func (r *rateRule) Name() string { return r.name }
+
+func (r *rateRule) Acquire(ctx context.Context, fullMethod string, req interface{}) (func(), error) {
+	if err := r.limiter.take(ctx, fullMethod, req); err != nil {
+		return nil, err
+	}
+	return noop, nil
+}
+
+// acquireUndo admits the request like Acquire, also returning how to give the
+// tokens back if a later rule rejects it.
+func (r *rateRule) acquireUndo(ctx context.Context, fullMethod string, req interface{}) (release, undo func(), err error) {
+	refund, err := r.limiter.acquire(ctx, fullMethod, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	return noop, refund, nil
+}
+
+// undoer is implemented by rules whose admission is undone differently from
+// its release, when a later rule rejects the request.
+type undoer interface {
+	acquireUndo(ctx context.Context, fullMethod string, req interface{}) (release, undo func(), err error)
+}
+
+// ConcurrencyRule returns a Rule allowing at most max in-flight requests per
+// key. A nil key defaults to PeerKey.
+func ConcurrencyRule(name string, max int, key KeyFunc) Rule {
+	if key == nil {
+		key = PeerKey
+	}
+	return &concurrencyRule{name: name, key: key, counter: newCounter(max)}
+}
+
+type concurrencyRule struct {
+	name    string
+	key     KeyFunc
+	counter *counter
+}
+
+func (r *concurrencyRule) Name() string { return r.name }
+
+func (r *concurrencyRule) Acquire(ctx context.Context, fullMethod string, req interface{}) (func(), error) {
+	k := r.key(ctx)
+	if !r.counter.acquire(k) {
+		return nil, status.Errorf(codes.ResourceExhausted, "too many concurrent requests, limit is %d", r.counter.max)
+	}
+	return func() { r.counter.release(k) }, nil
+}
+
+func noop() {}
+
+// Composite enforces several rules at once, in order, stopping at the first
+// one violated.
+type Composite struct {
+	rules []Rule
+}
+
+// Compose returns a Composite enforcing rules in the given order. Cheap rules
+// should come first, and blocking rate rules last so that requests don't wait
+// for tokens only to be rejected by a later rule.
+func Compose(rules ...Rule) *Composite {
+	return &Composite{rules: rules}
+}
+
+// acquire admits the request against every rule. When a rule rejects it, the
+// rules before it are undone: concurrency slots are released and rate tokens
+// given back. Rejections with ResourceExhausted carry a
+// google.rpc.QuotaFailure detail whose subject is the name of the rule that
+// fired.
+func (c *Composite) acquire(ctx context.Context, fullMethod string, req interface{}) (func(), error) {
+	releases := make([]func(), 0, len(c.rules))
+	undos := make([]func(), 0, len(c.rules))
+	for _, r := range c.rules {
+		var rel, undo func()
+		var err error
+		if u, ok := r.(undoer); ok {
+			rel, undo, err = u.acquireUndo(ctx, fullMethod, req)
+		} else {
+			rel, err = r.Acquire(ctx, fullMethod, req)
+			undo = rel
+		}
+		if err != nil {
+			for i := len(undos) - 1; i >= 0; i-- {
+				undos[i]()
+			}
+			return nil, annotate(err, r.Name())
+		}
+		releases = append(releases, rel)
+		undos = append(undos, undo)
+	}
+	return func() {
+		for i := len(releases) - 1; i >= 0; i-- {
+			releases[i]()
+		}
+	}, nil
+}
+
+// annotate adds the name of the rule that rejected a request to err.
+func annotate(err error, rule string) error {
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		return err
+	}
+	return grpcerrors.From(err).QuotaViolation(rule, st.Message()).Err()
+}
+
+// RuleFromError returns the name of the rule of a Composite that rejected a
+// request, or an empty string.
+func RuleFromError(err error) string {
+	if v := grpcerrors.QuotaViolations(err); len(v) > 0 {
+		return v[0].Subject
+	}
+	return ""
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor enforcing the rules of c.
+func (c *Composite) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		release, err := c.acquire(ctx, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor enforcing the rules of c.
+func (c *Composite) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		release, err := c.acquire(stream.Context(), info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return handler(srv, stream)
+	}
+}
@@ -0,0 +1,178 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Config describes the limits enforced by a Limiter. Rates are requests per
+// second, or per window for the sliding window algorithms, and zero means
+// unlimited. A request must pass every limit that applies to it: the global
+// one, the one of its method or service, the one of its caller's tier and the
+// one of its key.
+//
+// A method listed in Methods has its own bucket and does not count against
+// the bucket of its service in Services; listing a method with rate zero
+// exempts it from the service limit.
+type Config struct {
+	Global int `json:"global"`
+	// Services are keyed by fully qualified service name, e.g.
+	// "foo.v1.UserService". All methods of a service share one bucket.
+	Services map[string]int `json:"services,omitempty"`
+	// Methods are keyed by full method name, e.g. "/foo.v1.UserService/List".
+	Methods map[string]int `json:"methods,omitempty"`
+	Tiers   map[string]int `json:"tiers,omitempty"`
+	// Keys is the rate of each distinct key returned by the KeyFunc.
+	Keys int `json:"keys,omitempty"`
+}
+
+// Validate reports whether c can be used by a Limiter.
+func (c Config) Validate() error {
+	if c.Global < 0 {
+		return fmt.Errorf("ratelimit: negative global rate %d", c.Global)
+	}
+	if c.Keys < 0 {
+		return fmt.Errorf("ratelimit: negative per-key rate %d", c.Keys)
+	}
+	for service, rate := range c.Services {
+		if service == "" || strings.Contains(service, "/") {
+			return fmt.Errorf("ratelimit: invalid service name %q, want package.Service", service)
+		}
+		if rate < 0 {
+			return fmt.Errorf("ratelimit: negative rate %d for service %s", rate, service)
+		}
+	}
+	for method, rate := range c.Methods {
+		if !strings.HasPrefix(method, "/") || strings.Count(method, "/") != 2 {
+			return fmt.Errorf("ratelimit: invalid method name %q, want /package.Service/Method", method)
+		}
+		if rate < 0 {
+			return fmt.Errorf("ratelimit: negative rate %d for method %s", rate, method)
+		}
+	}
+	for tier, rate := range c.Tiers {
+		if tier == "" {
+			return fmt.Errorf("ratelimit: empty tier name")
+		}
+		if rate < 0 {
+			return fmt.Errorf("ratelimit: negative rate %d for tier %s", rate, tier)
+		}
+	}
+	return nil
+}
+
+// Source delivers configuration updates to a Limiter.
+type Source interface {
+	// Next blocks until a configuration is available. The first call returns
+	// the current configuration; subsequent calls block until it changes.
+	//
+	// An error is returned if and only if the source cannot recover.
+	Next() (Config, error)
+	// Close closes the source.
+	Close()
+}
+
+// Watch applies every configuration from src until src returns an error.
+// Invalid configurations are logged and skipped, leaving the current limits in
+// place. Watch blocks, so it is usually run in its own goroutine.
+func (l *Limiter) Watch(src Source) error {
+	for {
+		cfg, err := src.Next()
+		if err != nil {
+			return err
+		}
+		if err := l.Update(cfg); err != nil {
+			l.opts.logger.Warningf("middleware/ratelimit: ignoring invalid config: %v\n", err)
+		}
+	}
+}
+
+// bucketSet is an immutable set of buckets built from a Config.
+type bucketSet struct {
+	cfg      Config
+	names    map[Bucket]string // global, service, method and tier buckets
+	global   Bucket
+	services map[string]Bucket
+	methods  map[string]Bucket
+	tiers    map[string]Bucket
+	keys     *keyBuckets
+}
+
+func newBucketSet(cfg Config, alg Algorithm, clock Clock) *bucketSet {
+	s := &bucketSet{
+		cfg:      cfg,
+		global:   newBucket(alg, cfg.Global),
+		services: make(map[string]Bucket, len(cfg.Services)),
+		methods:  make(map[string]Bucket, len(cfg.Methods)),
+		tiers:    make(map[string]Bucket, len(cfg.Tiers)),
+	}
+	for service, rate := range cfg.Services {
+		s.services[service] = newBucket(alg, rate)
+	}
+	for method, rate := range cfg.Methods {
+		s.methods[method] = newBucket(alg, rate)
+	}
+	for tier, rate := range cfg.Tiers {
+		s.tiers[tier] = newBucket(alg, rate)
+	}
+	s.names = make(map[Bucket]string)
+	s.name(s.global, "global")
+	for service, b := range s.services {
+		s.name(b, "service:"+service)
+	}
+	for method, b := range s.methods {
+		s.name(b, "method:"+method)
+	}
+	for tier, b := range s.tiers {
+		s.name(b, "tier:"+tier)
+	}
+	if cfg.Keys > 0 {
+		s.keys = newKeyBuckets(alg, cfg.Keys, clock)
+	}
+	return s
+}
+
+func (s *bucketSet) name(b Bucket, name string) {
+	if b != nil {
+		s.names[b] = name
+	}
+}
+
+// match returns the buckets that apply to a request.
+func (s *bucketSet) match(method, tier, key string) []Bucket {
+	buckets := make([]Bucket, 0, 4)
+	if s.global != nil {
+		buckets = append(buckets, s.global)
+	}
+	if rl, ok := s.methods[method]; ok {
+		if rl != nil {
+			buckets = append(buckets, rl)
+		}
+	} else if rl := s.services[serviceName(method)]; rl != nil {
+		buckets = append(buckets, rl)
+	}
+	if rl := s.tiers[tier]; rl != nil {
+		buckets = append(buckets, rl)
+	}
+	if s.keys != nil && key != "" {
+		buckets = append(buckets, s.keys.get(key))
+	}
+	return buckets
+}
+
+// newBucket returns a bucket for rate, or nil if rate is unlimited.
+func newBucket(alg Algorithm, rate int) Bucket {
+	if rate == 0 {
+		return nil
+	}
+	return alg(rate)
+}
+
+// serviceName returns the service of a full method name.
+func serviceName(fullMethod string) string {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i]
+	}
+	return ""
+}
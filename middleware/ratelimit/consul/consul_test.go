@@ -0,0 +1,58 @@
+package consul
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/grpclog"
+)
+
+// kvServer serves its values in order as successive versions of one Consul
+// KV key, then blocks.
+func kvServer(values ...string) *httptest.Server {
+	n := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n == len(values) {
+			<-r.Context().Done()
+			return
+		}
+		n++
+		w.Header().Set("X-Consul-Index", strconv.Itoa(n))
+		json.NewEncoder(w).Encode([]*api.KVPair{{Key: "ratelimit", Value: []byte(values[n-1]), ModifyIndex: uint64(n)}})
+	}))
+}
+
+func TestSourceNext(t *testing.T) {
+	srv := kvServer(
+		`{"global": 10}`,
+		`{"global":10}`, // rewritten unchanged
+		`not json`,
+		`{"global": 20}`,
+	)
+	defer srv.Close()
+	client, err := api.NewClient(&api.Config{Address: srv.Listener.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSource(client, "ratelimit", WithLogger(grpclog.NewLoggerV2(ioutil.Discard, ioutil.Discard, ioutil.Discard)))
+
+	for _, want := range []int{10, 20} {
+		cfg, err := s.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := cfg.Global; want != have {
+			t.Fatalf("global rate: want %d, have %d", want, have)
+		}
+	}
+
+	s.Close()
+	if _, err := s.Next(); err != ErrClosed {
+		t.Fatalf("Next after Close: want ErrClosed, have %v", err)
+	}
+}
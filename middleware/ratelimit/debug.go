@@ -0,0 +1,244 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// snapshotKeys is the number of keys listed per Snapshot.
+const snapshotKeys = 100
+
+// Snapshot is the configuration and live state of a Limiter.
+type Snapshot struct {
+	Config Config `json:"config"`
+	// Mode is one of "blocking", "non-blocking", "queue" or "dry-run".
+	Mode string `json:"mode"`
+	// RateShare is the share of the configured rates in effect, below 1
+	// while warming up or reduced by error feedback.
+	RateShare float64 `json:"rate_share"`
+	// Waiting is the number of requests blocked waiting for tokens.
+	Waiting int64          `json:"waiting"`
+	Queue   *QueueSnapshot `json:"queue,omitempty"`
+	// Buckets is the state of the global, service, method and tier buckets,
+	// named like "global" or "method:/foo.v1.UserService/List".
+	Buckets map[string]BucketState `json:"buckets,omitempty"`
+	// Keys lists at most 100 keys in order. KeysTotal is the number of keys
+	// with a bucket; the next keys are listed by SnapshotAfter.
+	Keys      []KeySnapshot `json:"keys,omitempty"`
+	KeysTotal int           `json:"keys_total"`
+	// Throttled counts throttled requests per method over the last full
+	// minute.
+	Throttled map[string]int64 `json:"throttled_last_minute"`
+}
+
+// QueueSnapshot is the state of the queue set up by WithQueue.
+type QueueSnapshot struct {
+	Size   int `json:"size"`
+	Length int `json:"length"`
+	// Classes is the number of queued requests per priority class.
+	Classes map[int]int `json:"classes"`
+}
+
+// BucketState is the state of a bucket. Buckets of other packages report
+// zero values.
+type BucketState struct {
+	// Tokens is the number of tokens available now.
+	Tokens float64 `json:"tokens"`
+	// Wait is how long until a request costing one token passes, zero if
+	// one would pass now.
+	Wait time.Duration `json:"wait_ns"`
+}
+
+// KeySnapshot is the state of the bucket of a key.
+type KeySnapshot struct {
+	Key      string    `json:"key"`
+	LastUsed time.Time `json:"last_used"`
+	BucketState
+}
+
+// Snapshot returns the current configuration and state of l, listing the
+// first keys.
+func (l *Limiter) Snapshot() Snapshot {
+	return l.SnapshotAfter("")
+}
+
+// SnapshotAfter is like Snapshot, but lists the keys following after.
+func (l *Limiter) SnapshotAfter(after string) Snapshot {
+	now := l.opts.clock.Now()
+	set := l.set.Load().(*bucketSet)
+	snap := Snapshot{
+		Config:    set.cfg,
+		Mode:      "blocking",
+		RateShare: l.fraction(now),
+		Waiting:   atomic.LoadInt64(&l.nwaiting),
+		Buckets:   make(map[string]BucketState, len(set.names)),
+		Throttled: l.recent.previous(),
+	}
+	switch {
+	case l.opts.dryRun:
+		snap.Mode = "dry-run"
+	case l.opts.nonBlocking:
+		snap.Mode = "non-blocking"
+	case l.queue != nil:
+		snap.Mode = "queue"
+	}
+
+	if q := l.queue; q != nil {
+		q.mu.Lock()
+		qs := &QueueSnapshot{Size: q.size, Length: q.n, Classes: make(map[int]int, len(q.classes))}
+		for _, c := range q.classes {
+			qs.Classes[c.prio] = len(c.waiters)
+		}
+		q.mu.Unlock()
+		snap.Queue = qs
+	}
+
+	for b, name := range set.names {
+		snap.Buckets[name] = bucketState(b, now)
+	}
+
+	if k := set.keys; k != nil {
+		k.mu.Lock()
+		snap.KeysTotal = len(k.buckets)
+		keys := make([]string, 0, len(k.buckets))
+		for key := range k.buckets {
+			if key > after {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		if len(keys) > snapshotKeys {
+			keys = keys[:snapshotKeys]
+		}
+		buckets := make([]Bucket, len(keys))
+		snap.Keys = make([]KeySnapshot, len(keys))
+		for i, key := range keys {
+			b := k.buckets[key]
+			buckets[i] = b
+			snap.Keys[i] = KeySnapshot{Key: key, LastUsed: b.last}
+		}
+		k.mu.Unlock()
+
+		for i, b := range buckets {
+			snap.Keys[i].BucketState = bucketState(b, now)
+		}
+	}
+	return snap
+}
+
+// KeySnapshot returns the state of the bucket of key, false if key has no
+// bucket.
+func (l *Limiter) KeySnapshot(key string) (KeySnapshot, bool) {
+	k := l.set.Load().(*bucketSet).keys
+	if k == nil {
+		return KeySnapshot{}, false
+	}
+	k.mu.Lock()
+	b, ok := k.buckets[key]
+	var last time.Time
+	if ok {
+		last = b.last
+	}
+	k.mu.Unlock()
+	if !ok {
+		return KeySnapshot{}, false
+	}
+	return KeySnapshot{Key: key, LastUsed: last, BucketState: bucketState(b, l.opts.clock.Now())}, true
+}
+
+func bucketState(b Bucket, now time.Time) BucketState {
+	in, ok := b.(inspector)
+	if !ok {
+		return BucketState{}
+	}
+	tokens, wait := in.inspect(now)
+	return BucketState{Tokens: tokens, Wait: wait}
+}
+
+// ServeHTTP writes the Snapshot of l as JSON, so a Limiter can be mounted as
+// a debug handler, e.g. http.Handle("/debug/ratelimit", l). The "key" query
+// parameter lists only the given key, and "after" the keys following it.
+func (l *Limiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	snap := l.SnapshotAfter(query.Get("after"))
+	if key := query.Get("key"); key != "" {
+		snap.Keys = nil
+		if ks, ok := l.KeySnapshot(key); ok {
+			snap.Keys = []KeySnapshot{ks}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(snap)
+}
+
+// recordThrottled counts a throttled request of method.
+func (l *Limiter) recordThrottled(method string) {
+	l.throttled.With(method).Add(1)
+	l.recent.add(method)
+}
+
+// addWaiting tracks the number of requests waiting for tokens.
+func (l *Limiter) addWaiting(delta int64) {
+	atomic.AddInt64(&l.nwaiting, delta)
+	l.waiting.Add(float64(delta))
+}
+
+// recentCounter counts events per name over fixed windows, keeping the
+// counts of the current and the previous window.
+type recentCounter struct {
+	window time.Duration
+	clock  Clock
+
+	mu    sync.Mutex
+	start time.Time
+	cur   map[string]int64
+	prev  map[string]int64
+}
+
+func newRecentCounter(window time.Duration, clock Clock) *recentCounter {
+	return &recentCounter{
+		window: window,
+		clock:  clock,
+		start:  clock.Now(),
+		cur:    make(map[string]int64),
+		prev:   make(map[string]int64),
+	}
+}
+
+// roll moves to the window containing now. c.mu must be held.
+func (c *recentCounter) roll(now time.Time) {
+	switch elapsed := now.Sub(c.start) / c.window; {
+	case elapsed == 1:
+		c.prev, c.cur = c.cur, make(map[string]int64)
+		c.start = c.start.Add(c.window)
+	case elapsed > 1:
+		c.prev, c.cur = make(map[string]int64), make(map[string]int64)
+		c.start = c.start.Add(elapsed * c.window)
+	}
+}
+
+func (c *recentCounter) add(name string) {
+	c.mu.Lock()
+	c.roll(c.clock.Now())
+	c.cur[name]++
+	c.mu.Unlock()
+}
+
+// previous returns a copy of the counts of the last full window.
+func (c *recentCounter) previous() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.roll(c.clock.Now())
+	counts := make(map[string]int64, len(c.prev))
+	for name, n := range c.prev {
+		counts[name] = n
+	}
+	return counts
+}
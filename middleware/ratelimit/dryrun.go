@@ -0,0 +1,32 @@
+package ratelimit
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// dryRunLogInterval bounds how often would-be-throttled requests are logged.
+const dryRunLogInterval = time.Second
+
+// WithDryRun puts the limiter in shadow mode: decisions are computed as in
+// non-blocking mode and would-be-throttled requests are counted and logged,
+// but requests are never delayed or rejected. Use it to validate new limits
+// against production traffic before enforcing them.
+func WithDryRun() Option {
+	return func(o *options) {
+		o.dryRun = true
+	}
+}
+
+// dryRun checks buckets without enforcing them.
+func (l *Limiter) dryRun(method string, cost int, buckets []Bucket) {
+	if ok, wait := tryTakeAll(buckets, cost); !ok {
+		l.dryRunThrottled.With(method).Add(1)
+		now := l.opts.clock.Now().UnixNano()
+		last := atomic.LoadInt64(&l.dryRunLogged)
+		if now-last >= int64(dryRunLogInterval) && atomic.CompareAndSwapInt64(&l.dryRunLogged, last, now) {
+			l.opts.logger.Infof("middleware/ratelimit: dry run: would throttle %s for %v\n", method, wait)
+		}
+	}
+	l.allowed.With(method).Add(1)
+}
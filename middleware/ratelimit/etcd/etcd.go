@@ -0,0 +1,145 @@
+// Package etcd loads ratelimit configuration from etcd.
+package etcd
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/ipfans/grpctools/middleware/ratelimit"
+	"go.etcd.io/etcd/clientv3"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/grpclog"
+)
+
+// retryInterval is the delay between failed requests to etcd.
+const retryInterval = time.Second
+
+// ErrClosed is returned by Next after the source is closed.
+var ErrClosed = errors.New("middleware/ratelimit/etcd: source closed")
+
+// Source implements ratelimit.Source by watching a JSON encoded
+// ratelimit.Config stored under an etcd key.
+type Source struct {
+	kv      clientv3.KV
+	watcher clientv3.Watcher
+	key     string
+	logger  grpclog.LoggerV2
+	last    *ratelimit.Config
+
+	watchCh clientv3.WatchChan
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Option for Source instance.
+type Option func(s *Source)
+
+// WithLogger replaced built-in logger to given.
+func WithLogger(logger grpclog.LoggerV2) Option {
+	return func(s *Source) {
+		s.logger = logger
+	}
+}
+
+// NewSource initializes and returns a new Source watching key.
+func NewSource(client *clientv3.Client, key string, opts ...Option) *Source {
+	return newSource(client, client, key, opts...)
+}
+
+func newSource(kv clientv3.KV, watcher clientv3.Watcher, key string, opts ...Option) *Source {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Source{
+		kv:      kv,
+		watcher: watcher,
+		key:     key,
+		logger:  grpclog.NewLoggerV2(os.Stdout, os.Stdout, os.Stderr),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+// Next blocks until the key holds a configuration different from the last
+// one returned. Request and decoding errors are logged and retried.
+func (s *Source) Next() (ratelimit.Config, error) {
+	for {
+		value, err := s.nextValue()
+		if err != nil {
+			return ratelimit.Config{}, err
+		}
+		var cfg ratelimit.Config
+		if err := json.Unmarshal(value, &cfg); err != nil {
+			s.logger.Warningf("middleware/ratelimit/etcd: error decoding %s: %v\n", s.key, err)
+			continue
+		}
+		if s.last != nil && reflect.DeepEqual(*s.last, cfg) {
+			continue
+		}
+		s.last = &cfg
+		return cfg, nil
+	}
+}
+
+// nextValue returns the next value written to the key. The first call reads
+// the current value and starts watching from the revision it was read at.
+func (s *Source) nextValue() ([]byte, error) {
+	for {
+		if s.ctx.Err() != nil {
+			return nil, ErrClosed
+		}
+
+		if s.watchCh == nil {
+			resp, err := s.kv.Get(s.ctx, s.key)
+			if err != nil {
+				s.retry(err)
+				continue
+			}
+			s.watchCh = s.watcher.Watch(s.ctx, s.key, clientv3.WithRev(resp.Header.Revision+1))
+			if len(resp.Kvs) > 0 {
+				return resp.Kvs[0].Value, nil
+			}
+			continue
+		}
+
+		resp, ok := <-s.watchCh
+		if !ok || resp.Err() != nil {
+			// Watch was cancelled or compacted; read the key again.
+			if ok {
+				s.retry(resp.Err())
+			}
+			s.watchCh = nil
+			continue
+		}
+		// Only the latest put in a batch matters.
+		for i := len(resp.Events) - 1; i >= 0; i-- {
+			if ev := resp.Events[i]; ev.Type == clientv3.EventTypePut {
+				return ev.Kv.Value, nil
+			}
+		}
+	}
+}
+
+func (s *Source) retry(err error) {
+	if s.ctx.Err() != nil {
+		return
+	}
+	s.logger.Infof("middleware/ratelimit/etcd: error watching %s: %v\n", s.key, err)
+	t := time.NewTimer(retryInterval)
+	defer t.Stop()
+	select {
+	case <-s.ctx.Done():
+	case <-t.C:
+	}
+}
+
+// Close closes the source.
+func (s *Source) Close() {
+	s.cancel()
+}
@@ -0,0 +1,70 @@
+package etcd
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/etcdserver/etcdserverpb"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/grpclog"
+)
+
+// fakeKV returns value on Get.
+type fakeKV struct {
+	clientv3.KV
+	value string
+}
+
+func (kv fakeKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	return &clientv3.GetResponse{
+		Header: &etcdserverpb.ResponseHeader{Revision: 1},
+		Kvs:    []*mvccpb.KeyValue{{Key: []byte(key), Value: []byte(kv.value)}},
+	}, nil
+}
+
+// fakeWatcher delivers puts of its values, one per response.
+type fakeWatcher struct {
+	clientv3.Watcher
+	values []string
+}
+
+func (w fakeWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	ch := make(chan clientv3.WatchResponse, len(w.values))
+	for _, v := range w.values {
+		ch <- clientv3.WatchResponse{Events: []*clientv3.Event{{
+			Type: clientv3.EventTypePut,
+			Kv:   &mvccpb.KeyValue{Key: []byte(key), Value: []byte(v)},
+		}}}
+	}
+	return ch
+}
+
+func TestSourceNext(t *testing.T) {
+	s := newSource(
+		fakeKV{value: `{"global": 10}`},
+		fakeWatcher{values: []string{
+			`{"global":10}`, // rewritten unchanged
+			`not json`,
+			`{"global": 20}`,
+		}},
+		"ratelimit",
+		WithLogger(grpclog.NewLoggerV2(ioutil.Discard, ioutil.Discard, ioutil.Discard)),
+	)
+
+	for _, want := range []int{10, 20} {
+		cfg, err := s.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := cfg.Global; want != have {
+			t.Fatalf("global rate: want %d, have %d", want, have)
+		}
+	}
+
+	s.Close()
+	if _, err := s.Next(); err != ErrClosed {
+		t.Fatalf("Next after Close: want ErrClosed, have %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// ErrorFeedback configures WithErrorFeedback. Zero fields take their
+// defaults.
+type ErrorFeedback struct {
+	// Codes counted as errors. Default is Unavailable and DeadlineExceeded.
+	Codes []codes.Code
+	// Threshold is the error ratio above which rates are reduced. Default is 0.1.
+	Threshold float64
+	// Window is how often the error ratio is evaluated. Default is one second.
+	Window time.Duration
+	// MinSamples is the number of requests a window needs to be evaluated.
+	// Default is 20.
+	MinSamples int
+	// Decrease multiplies the rates after an unhealthy window. Default is 0.5.
+	Decrease float64
+	// Increase is added to the share of the rates after a healthy window.
+	// Default is 0.1.
+	Increase float64
+	// Floor is the lowest share of the rates. Default is 0.1.
+	Floor float64
+}
+
+// WithErrorFeedback reduces the effective rates while handlers return a high
+// ratio of errors such as Unavailable or DeadlineExceeded, and restores them
+// gradually once errors subside. Windows without any handler result, e.g.
+// because every request was rejected, count as healthy.
+func WithErrorFeedback(fb ErrorFeedback) Option {
+	if len(fb.Codes) == 0 {
+		fb.Codes = []codes.Code{codes.Unavailable, codes.DeadlineExceeded}
+	}
+	if fb.Threshold <= 0 {
+		fb.Threshold = 0.1
+	}
+	if fb.Window <= 0 {
+		fb.Window = time.Second
+	}
+	if fb.MinSamples <= 0 {
+		fb.MinSamples = 20
+	}
+	if fb.Decrease <= 0 || fb.Decrease >= 1 {
+		fb.Decrease = 0.5
+	}
+	if fb.Increase <= 0 {
+		fb.Increase = 0.1
+	}
+	if fb.Floor <= 0 {
+		fb.Floor = 0.1
+	}
+	return func(o *options) {
+		o.feedback = &fb
+	}
+}
+
+// feedback adjusts a share of the rates from handler results, decreasing it
+// multiplicatively and increasing it additively.
+type feedback struct {
+	cfg    ErrorFeedback
+	counts map[codes.Code]bool
+	clock  Clock
+
+	mu     sync.Mutex
+	start  time.Time
+	total  int
+	errors int
+	share  float64
+}
+
+func newFeedback(cfg ErrorFeedback, clock Clock) *feedback {
+	f := &feedback{
+		cfg:    cfg,
+		counts: make(map[codes.Code]bool, len(cfg.Codes)),
+		clock:  clock,
+		start:  clock.Now(),
+		share:  1,
+	}
+	for _, c := range cfg.Codes {
+		f.counts[c] = true
+	}
+	return f
+}
+
+// record adds a handler result, evaluating the window once it is over.
+func (f *feedback) record(code codes.Code) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.total++
+	if f.counts[code] {
+		f.errors++
+	}
+	f.roll(f.clock.Now())
+}
+
+// roll evaluates the windows that ended by now. Windows without any handler
+// result count as healthy: requests may all have been rejected at a low
+// share, and nothing would raise it again otherwise. f.mu must be held.
+func (f *feedback) roll(now time.Time) {
+	ended := int(now.Sub(f.start) / f.cfg.Window)
+	if ended == 0 {
+		return
+	}
+	switch {
+	case f.total >= f.cfg.MinSamples && float64(f.errors)/float64(f.total) > f.cfg.Threshold:
+		f.share = math.Max(f.cfg.Floor, f.share*f.cfg.Decrease)
+	case f.total >= f.cfg.MinSamples || f.total == 0:
+		f.share = math.Min(1, f.share+f.cfg.Increase)
+	}
+	for i := 1; i < ended && f.share < 1; i++ {
+		f.share = math.Min(1, f.share+f.cfg.Increase)
+	}
+	f.start = f.start.Add(time.Duration(ended) * f.cfg.Window)
+	f.total, f.errors = 0, 0
+}
+
+// fraction returns the share of the rates at now.
+func (f *feedback) fraction(now time.Time) float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.roll(now)
+	return f.share
+}
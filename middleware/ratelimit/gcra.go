@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// GCRA returns an Algorithm implementing the Generic Cell Rate Algorithm.
+// Limits are requests per second with bursts of up to burst requests. It
+// limits as smoothly as LeakyBucket while tolerating bursts like
+// TokenBucket, and keeps a single timestamp of state.
+func GCRA(burst int) Algorithm {
+	return func(limit int) Bucket {
+		return NewGCRA(limit, burst)
+	}
+}
+
+type gcra struct {
+	// interval is the emission interval of one request and tolerance how far
+	// ahead of the current time the theoretical arrival time may run.
+	interval  time.Duration
+	tolerance time.Duration
+	burst     int
+	clock     Clock
+
+	mu  sync.Mutex
+	tat time.Time // theoretical arrival time of the next request
+}
+
+// NewGCRA returns a Bucket passing rate requests per second with bursts of
+// up to burst requests.
+func NewGCRA(rate, burst int) Bucket {
+	if burst < 1 {
+		burst = 1
+	}
+	interval := time.Second / time.Duration(rate)
+	return &gcra{
+		interval:  interval,
+		tolerance: time.Duration(burst) * interval,
+		burst:     burst,
+		clock:     SystemClock,
+	}
+}
+
+func (g *gcra) setClock(c Clock) { g.clock = c }
+
+// next returns the theoretical arrival time after n requests and the time
+// at which they conform. g.mu must be held.
+func (g *gcra) next(now time.Time, n int) (tat, allowAt time.Time) {
+	if n > g.burst {
+		n = g.burst
+	}
+	tat = g.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	tat = tat.Add(time.Duration(n) * g.interval)
+	return tat, tat.Add(-g.tolerance)
+}
+
+func (g *gcra) Take(n int) {
+	g.mu.Lock()
+	now := g.clock.Now()
+	tat, allowAt := g.next(now, n)
+	g.tat = tat
+	g.mu.Unlock()
+
+	if wait := allowAt.Sub(now); wait > 0 {
+		g.clock.Sleep(wait)
+	}
+}
+
+func (g *gcra) refund(n int) {
+	if n > g.burst {
+		n = g.burst
+	}
+	g.mu.Lock()
+	g.tat = g.tat.Add(-time.Duration(n) * g.interval)
+	g.mu.Unlock()
+}
+
+func (g *gcra) inspect(now time.Time) (float64, time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ahead := time.Duration(0)
+	if g.tat.After(now) {
+		ahead = g.tat.Sub(now)
+	}
+	tokens := float64(g.tolerance-ahead) / float64(g.interval)
+	if tokens >= 1 {
+		return tokens, 0
+	}
+	return math.Max(0, tokens), ahead + g.interval - g.tolerance
+}
+
+func (g *gcra) TryTake(n int) (bool, time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.clock.Now()
+	tat, allowAt := g.next(now, n)
+	if allowAt.After(now) {
+		return false, allowAt.Sub(now)
+	}
+	g.tat = tat
+	return true, 0
+}
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// keyIdleTimeout is how long a key's bucket is kept after its last request.
+const keyIdleTimeout = 10 * time.Minute
+
+// DefaultWeight is the fair queueing weight of keys without one configured.
+const DefaultWeight = 1.0
+
+// WithKeyFunc sets how requests are grouped for Config.Keys limits and fair
+// queueing, e.g. PeerKey or a tenant extracted from metadata. Without it,
+// per-key limits are never applied.
+func WithKeyFunc(f KeyFunc) Option {
+	return func(o *options) {
+		o.keyFunc = f
+	}
+}
+
+// WithFairQueue makes the queue set up by WithQueue serve requests of the
+// same priority class in weighted fair order across keys: while over the
+// limit, each key with queued requests gets a share of the budget
+// proportional to its weight, so large tenants can't crowd out small ones.
+// Keys missing from weights have DefaultWeight.
+func WithFairQueue(weights map[string]float64) Option {
+	return func(o *options) {
+		o.fair = true
+		o.weights = weights
+	}
+}
+
+type keyBucket struct {
+	Bucket
+	last time.Time
+}
+
+func (b *keyBucket) inspect(now time.Time) (float64, time.Duration) {
+	if in, ok := b.Bucket.(inspector); ok {
+		return in.inspect(now)
+	}
+	return 0, 0
+}
+
+func (b *keyBucket) refund(n int) {
+	if r, ok := b.Bucket.(refunder); ok {
+		r.refund(n)
+	}
+}
+
+// keyBuckets lazily creates one bucket per key, dropping idle ones.
+type keyBuckets struct {
+	alg   Algorithm
+	rate  int
+	clock Clock
+
+	mu      sync.Mutex
+	buckets map[string]*keyBucket
+	swept   time.Time
+}
+
+func newKeyBuckets(alg Algorithm, rate int, clock Clock) *keyBuckets {
+	return &keyBuckets{
+		alg:     alg,
+		rate:    rate,
+		clock:   clock,
+		buckets: make(map[string]*keyBucket),
+		swept:   clock.Now(),
+	}
+}
+
+// get returns the bucket of key.
+func (k *keyBuckets) get(key string) Bucket {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := k.clock.Now()
+	if now.Sub(k.swept) >= keyIdleTimeout {
+		for key, b := range k.buckets {
+			if now.Sub(b.last) >= keyIdleTimeout {
+				delete(k.buckets, key)
+			}
+		}
+		k.swept = now
+	}
+
+	b, ok := k.buckets[key]
+	if !ok {
+		b = &keyBucket{Bucket: k.alg(k.rate)}
+		k.buckets[key] = b
+	}
+	b.last = now
+	return b
+}
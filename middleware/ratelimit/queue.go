@@ -0,0 +1,381 @@
+package ratelimit
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfans/grpctools/priority"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Discipline is the order in which queued requests of the same priority are
+// served.
+type Discipline int
+
+const (
+	// FIFO serves the oldest request first.
+	FIFO Discipline = iota
+	// LIFO serves the newest request first, which is the one most likely to
+	// still have a caller waiting for it.
+	LIFO
+	// AdaptiveLIFO serves FIFO while the queue is at most half full and LIFO
+	// once it is congested.
+	AdaptiveLIFO
+)
+
+// PriorityFunc returns the priority class of a request. Higher classes are
+// served first and evict lower ones from a full queue.
+type PriorityFunc func(ctx context.Context) int
+
+// RequestPriority is a PriorityFunc using the conventional request priority of
+// package priority, clamped by policy.
+func RequestPriority(policy priority.Policy) PriorityFunc {
+	return func(ctx context.Context) int {
+		return int(policy.Resolve(ctx))
+	}
+}
+
+// WithQueue makes requests over the limit wait in a queue holding at most
+// size requests, served in the order given by d. Requests arriving at a full
+// queue are rejected with ResourceExhausted unless they have a higher priority
+// than a queued request, which is rejected in their place.
+func WithQueue(size int, d Discipline) Option {
+	return func(o *options) {
+		o.queueSize = size
+		o.discipline = d
+	}
+}
+
+// WithPriorityFunc sets how the priority class of queued requests is
+// resolved. Default is RequestPriority(priority.DefaultPolicy).
+//
+// Priority only orders the queue of WithQueue: without a queue, requests of
+// all classes would wait for or be rejected by the same buckets alike, so New
+// rejects WithPriorityFunc unless WithQueue is given too.
+func WithPriorityFunc(f PriorityFunc) Option {
+	return func(o *options) {
+		o.priorityFunc = f
+	}
+}
+
+// waiter is a request waiting for its buckets.
+type waiter struct {
+	key     string
+	prio    int
+	cost    int
+	buckets []Bucket
+	result  chan error
+
+	// finish is the virtual finish time of the request in fair mode.
+	finish float64
+}
+
+// tryPass takes the tokens of w from all its buckets, or from none so a
+// waiter doesn't hold tokens others could use while it waits for the rest.
+func (w *waiter) tryPass() (bool, time.Duration) {
+	return tryTakeAll(w.buckets, w.cost)
+}
+
+// class holds the waiters of a priority, in arrival order, or by virtual
+// finish time in fair mode.
+type class struct {
+	prio    int
+	waiters []*waiter
+}
+
+// tag assigns w its virtual finish time. q.mu must be held.
+func (q *queue) tag(w *waiter) {
+	weight, ok := q.weights[w.key]
+	if !ok || weight <= 0 {
+		weight = DefaultWeight
+	}
+	start := q.virtual
+	if f := q.finish[w.key]; f > start {
+		start = f
+	}
+	w.finish = start + float64(w.cost)/weight
+	q.finish[w.key] = w.finish
+}
+
+// queue is a bounded priority queue of waiters, drained by a dispatcher
+// goroutine running only while the queue is non-empty.
+type queue struct {
+	size       int
+	discipline Discipline
+	clock      Clock
+	wake       chan struct{} // interrupts the dispatcher waiting for tokens
+
+	mu       sync.Mutex
+	classes  []*class // sorted by descending priority
+	n        int
+	running  bool
+	lastWait time.Duration
+
+	// Weighted fair queueing state, finish is nil unless enabled. virtual
+	// is the finish time of the last served request and finish the latest
+	// finish time assigned to each key.
+	weights map[string]float64
+	virtual float64
+	finish  map[string]float64
+}
+
+func newQueue(size int, d Discipline, clock Clock) *queue {
+	return &queue{
+		size:       size,
+		discipline: d,
+		clock:      clock,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// lifo reports whether the newest waiter of a class is served first.
+func (q *queue) lifo() bool {
+	switch q.discipline {
+	case LIFO:
+		return true
+	case AdaptiveLIFO:
+		return q.n > q.size/2
+	}
+	return false
+}
+
+// each calls f with the waiters in the order they are served, until f
+// returns false. q.mu must be held.
+func (q *queue) each(f func(w *waiter) bool) {
+	lifo := q.finish == nil && q.lifo()
+	for _, c := range q.classes {
+		for i := range c.waiters {
+			w := c.waiters[i]
+			if lifo {
+				w = c.waiters[len(c.waiters)-1-i]
+			}
+			if !f(w) {
+				return
+			}
+		}
+	}
+}
+
+// head returns the waiter served first. q.mu must be held.
+func (q *queue) head() *waiter {
+	var head *waiter
+	q.each(func(w *waiter) bool {
+		head = w
+		return false
+	})
+	return head
+}
+
+// signal wakes the dispatcher if it is waiting for tokens.
+func (q *queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// last returns the waiter that would be served last. q.mu must be held.
+func (q *queue) last() *waiter {
+	for i := len(q.classes) - 1; i >= 0; i-- {
+		c := q.classes[i]
+		if len(c.waiters) == 0 {
+			continue
+		}
+		if q.finish == nil && q.lifo() {
+			return c.waiters[0]
+		}
+		return c.waiters[len(c.waiters)-1]
+	}
+	return nil
+}
+
+// push adds w to its class. q.mu must be held.
+func (q *queue) push(w *waiter) {
+	i := sort.Search(len(q.classes), func(i int) bool { return q.classes[i].prio <= w.prio })
+	if i == len(q.classes) || q.classes[i].prio != w.prio {
+		q.classes = append(q.classes, nil)
+		copy(q.classes[i+1:], q.classes[i:])
+		q.classes[i] = &class{prio: w.prio}
+	}
+	c := q.classes[i]
+	j := len(c.waiters)
+	if q.finish != nil {
+		// Keep the class ordered by finish time, after equal ones.
+		j = sort.Search(len(c.waiters), func(k int) bool { return c.waiters[k].finish > w.finish })
+	}
+	c.waiters = append(c.waiters, nil)
+	copy(c.waiters[j+1:], c.waiters[j:])
+	c.waiters[j] = w
+	q.n++
+	q.signal()
+}
+
+// remove deletes w from the queue, reporting whether it was queued. q.mu must
+// be held.
+func (q *queue) remove(w *waiter) bool {
+	for ci, c := range q.classes {
+		if c.prio != w.prio {
+			continue
+		}
+		for i, cw := range c.waiters {
+			if cw == w {
+				c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+				if len(c.waiters) == 0 {
+					q.classes = append(q.classes[:ci], q.classes[ci+1:]...)
+				}
+				q.n--
+				q.signal()
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wait blocks until w passed all its buckets. It returns a non-nil error if
+// the request was rejected or ctx is done first; retry is the suggested
+// delay before trying again.
+func (q *queue) wait(ctx context.Context, w *waiter) (retry time.Duration, err error) {
+	q.mu.Lock()
+	if q.n == 0 {
+		ok, wait := w.tryPass()
+		if ok {
+			q.mu.Unlock()
+			return 0, nil
+		}
+		q.lastWait = wait
+	}
+	if q.n >= q.size {
+		victim := q.last()
+		if victim == nil || victim.prio >= w.prio {
+			retry = q.lastWait
+			q.mu.Unlock()
+			return retry, errQueueFull
+		}
+		q.remove(victim)
+		victim.result <- errQueueFull
+	}
+	w.result = make(chan error, 1)
+	if q.finish != nil {
+		q.tag(w)
+	}
+	q.push(w)
+	if !q.running {
+		q.running = true
+		go q.dispatch()
+	}
+	q.mu.Unlock()
+
+	select {
+	case err = <-w.result:
+	case <-ctx.Done():
+		q.mu.Lock()
+		removed := q.remove(w)
+		q.mu.Unlock()
+		if removed {
+			return 0, contextError(ctx.Err())
+		}
+		// Granted or evicted concurrently.
+		err = <-w.result
+	}
+	if err != nil {
+		q.mu.Lock()
+		retry = q.lastWait
+		q.mu.Unlock()
+	}
+	return retry, err
+}
+
+// dispatch grants queued waiters as their buckets allow until the queue is
+// empty. Waiters are tried in serving order and the first one passing is
+// granted. A waiter held back doesn't block those behind it that use none of
+// its buckets, like requests of other keys, but those sharing one wait for it
+// to pass, so cheap requests can't keep draining a bucket an earlier, more
+// expensive or more important one is waiting for. When none passes, dispatch
+// waits for the earliest one to be able to, or for the queue to change.
+func (q *queue) dispatch() {
+	for {
+		q.mu.Lock()
+		if q.n == 0 {
+			q.running = false
+			if q.finish != nil {
+				// Every key is idle, start over.
+				q.virtual = 0
+				q.finish = make(map[string]float64)
+			}
+			q.mu.Unlock()
+			return
+		}
+		var (
+			granted *waiter
+			held    []Bucket
+		)
+		wait := time.Duration(-1)
+		q.each(func(w *waiter) bool {
+			if sharesBucket(w.buckets, held) {
+				return true
+			}
+			ok, d := w.tryPass()
+			if ok {
+				granted = w
+				return false
+			}
+			held = append(held, w.buckets...)
+			if wait < 0 || d < wait {
+				wait = d
+			}
+			return true
+		})
+		if granted != nil {
+			q.remove(granted)
+			if q.finish != nil {
+				q.virtual = granted.finish
+			}
+			granted.result <- nil
+		} else {
+			q.lastWait = wait
+		}
+		q.mu.Unlock()
+		if granted != nil {
+			continue
+		}
+
+		if wait < minRetryWait {
+			wait = minRetryWait
+		}
+		select {
+		case <-q.clock.After(wait):
+		case <-q.wake:
+		}
+	}
+}
+
+// sharesBucket reports whether any of buckets is in held. Buckets are
+// stateful, so their identity is compared.
+func sharesBucket(buckets, held []Bucket) bool {
+	for _, b := range buckets {
+		for _, h := range held {
+			if b == h {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// errQueueFull marks requests rejected by a full queue.
+var errQueueFull = status.Error(codes.ResourceExhausted, "rate limit queue is full")
+
+// contextError converts a context error into a gRPC status error.
+func contextError(err error) error {
+	switch err {
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case context.Canceled:
+		return status.Error(codes.Canceled, err.Error())
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
@@ -1,25 +1,426 @@
 package ratelimit
 
 import (
-	"go.uber.org/ratelimit"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	grpcerrors "github.com/ipfans/grpctools/errors"
+	"github.com/ipfans/grpctools/metrics"
+	"github.com/ipfans/grpctools/priority"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
-// UnaryServerInterceptor returns a new unary server interceptor for lucky-bucket ratelimit.
-func UnaryServerInterceptor(rate int) grpc.UnaryServerInterceptor {
-	token := ratelimit.New(rate)
+// RetryPushbackKey is the trailer gRPC clients read to delay their next retry
+// attempt, in milliseconds.
+const RetryPushbackKey = "grpc-retry-pushback-ms"
+
+// throttleThreshold is the shortest wait counted as throttled. Shorter waits
+// are scheduling noise rather than the limiter holding a request back.
+const throttleThreshold = time.Millisecond
+
+type options struct {
+	algorithm      Algorithm
+	metrics        metrics.Provider
+	logger         grpclog.LoggerV2
+	name           string
+	clock          Clock
+	costs          map[string]int
+	costFunc       CostFunc
+	tierFunc       TierFunc
+	keyFunc        KeyFunc
+	requestKeyFunc RequestKeyFunc
+
+	nonBlocking   bool
+	retryPushback bool
+	dryRun        bool
+
+	queueSize    int
+	discipline   Discipline
+	priorityFunc PriorityFunc
+	fair         bool
+	weights      map[string]float64
+
+	warmupInitial float64
+	warmupWindow  time.Duration
+	feedback      *ErrorFeedback
+}
+
+// CostFunc returns the number of tokens a request consumes. req is nil for
+// streaming RPCs, unless WithRequestKeyFunc defers limiting streams to their
+// first message.
+type CostFunc func(ctx context.Context, fullMethod string, req interface{}) int
+
+// TierFunc returns the tier of the caller, used to pick per-tier limits.
+type TierFunc func(ctx context.Context) string
+
+// Option for ratelimit interceptors.
+type Option func(o *options)
+
+// WithAlgorithm selects the rate limiting algorithm. Default is LeakyBucket.
+func WithAlgorithm(alg Algorithm) Option {
+	return func(o *options) {
+		o.algorithm = alg
+	}
+}
+
+// WithMetrics reports limiter metrics through given provider.
+func WithMetrics(p metrics.Provider) Option {
+	return func(o *options) {
+		o.metrics = p
+	}
+}
+
+// WithName names the limiter in its metrics, which become
+// grpc_ratelimit_<name>_*, so several limiters can report through one
+// provider, e.g. the rules of a Composite.
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithLogger replaced built-in logger to given.
+func WithLogger(logger grpclog.LoggerV2) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithMethodCost sets the number of tokens consumed by each method, keyed by
+// full method name (e.g. "/foo.v1.UserService/List"). Methods not listed cost 1.
+// A cost above the burst of a bucket is charged as the whole bucket, so such
+// requests still pass once the bucket is full.
+func WithMethodCost(costs map[string]int) Option {
+	return func(o *options) {
+		o.costs = costs
+	}
+}
+
+// WithCostFunc computes the cost of each request from its message. It takes
+// precedence over WithMethodCost; a non-positive result falls back to the
+// method cost.
+func WithCostFunc(f CostFunc) Option {
+	return func(o *options) {
+		o.costFunc = f
+	}
+}
+
+// WithTierFunc sets how the caller's tier is resolved for Config.Tiers.
+// Without it, tier limits are never applied.
+func WithTierFunc(f TierFunc) Option {
+	return func(o *options) {
+		o.tierFunc = f
+	}
+}
+
+// WithNonBlocking rejects requests over the limit with ResourceExhausted
+// instead of making them wait. Rejections carry a google.rpc.RetryInfo detail
+// telling the client when the limit allows it again.
+func WithNonBlocking() Option {
+	return func(o *options) {
+		o.nonBlocking = true
+	}
+}
+
+// WithRetryPushback also sets the RetryPushbackKey trailer on rejections, so
+// the built-in retry support of gRPC clients backs off without inspecting
+// error details.
+func WithRetryPushback() Option {
+	return func(o *options) {
+		o.retryPushback = true
+	}
+}
+
+// Limiter enforces the limits of a Config. The Config can be replaced at any
+// time with Update or Watch; in-flight requests finish against the buckets
+// they started with.
+type Limiter struct {
+	opts     options
+	set      atomic.Value // *bucketSet
+	queue    *queue
+	created  time.Time
+	feedback *feedback
+
+	allowed   metrics.Counter
+	throttled metrics.Counter
+	wait      metrics.Histogram
+	waiting   metrics.Gauge
+	available metrics.Gauge
+	observed  int64 // unix nanoseconds, accessed atomically
+
+	dryRunThrottled metrics.Counter
+	dryRunLogged    int64 // unix nanoseconds, accessed atomically
+
+	nwaiting int64 // accessed atomically
+	recent   *recentCounter
+}
+
+// New initializes and returns a new Limiter.
+func New(cfg Config, opts ...Option) (*Limiter, error) {
+	o := options{
+		algorithm: LeakyBucket(),
+		metrics:   metrics.Discard,
+		logger:    grpclog.NewLoggerV2(os.Stdout, os.Stdout, os.Stderr),
+		clock:     SystemClock,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.priorityFunc != nil && o.queueSize <= 0 {
+		return nil, fmt.Errorf("ratelimit: WithPriorityFunc requires WithQueue")
+	}
+	if o.priorityFunc == nil {
+		o.priorityFunc = RequestPriority(priority.DefaultPolicy)
+	}
+
+	prefix := "grpc_ratelimit_"
+	if o.name != "" {
+		prefix += o.name + "_"
+	}
+	l := &Limiter{
+		opts:      o,
+		created:   o.clock.Now(),
+		recent:    newRecentCounter(time.Minute, o.clock),
+		allowed:   o.metrics.NewCounter(prefix+"allowed_total", "Total number of requests passed by the rate limiter.", "method"),
+		throttled: o.metrics.NewCounter(prefix+"throttled_total", "Total number of requests delayed by the rate limiter.", "method"),
+		wait:      o.metrics.NewHistogram(prefix+"wait_seconds", "Time requests spent waiting in the rate limiter.", "method"),
+		waiting:   o.metrics.NewGauge(prefix+"waiting_requests", "Number of requests currently waiting for tokens."),
+		available: o.metrics.NewGauge(prefix+"available_tokens", "Tokens currently available in the global, service, method and tier buckets.", "bucket"),
+
+		dryRunThrottled: o.metrics.NewCounter(prefix+"dry_run_throttled_total", "Total number of requests the rate limiter would have throttled in dry run mode.", "method"),
+	}
+	if o.feedback != nil {
+		l.feedback = newFeedback(*o.feedback, o.clock)
+	}
+	if o.queueSize > 0 {
+		l.queue = newQueue(o.queueSize, o.discipline, o.clock)
+		if o.fair {
+			l.queue.weights = o.weights
+			l.queue.finish = make(map[string]float64)
+		}
+	}
+	if err := l.Update(cfg); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Update validates cfg and atomically replaces the current limits with it.
+// On error the current limits are kept. Replacing the limits starts every
+// bucket over, so a cfg equal to the current one is ignored.
+func (l *Limiter) Update(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if set, ok := l.set.Load().(*bucketSet); ok && reflect.DeepEqual(set.cfg, cfg) {
+		return nil
+	}
+	alg := l.opts.algorithm
+	if l.opts.warmupWindow > 0 || l.feedback != nil {
+		alg = scaled(alg, l.fraction)
+	}
+	if l.opts.clock != SystemClock {
+		alg = Clocked(alg, l.opts.clock)
+	}
+	l.set.Store(newBucketSet(cfg, alg, l.opts.clock))
+	return nil
+}
+
+// fraction returns the share of the configured rates currently in effect.
+func (l *Limiter) fraction(now time.Time) float64 {
+	f := l.warmupFraction(now)
+	if l.feedback != nil {
+		f *= l.feedback.fraction(now)
+	}
+	return f
+}
+
+// cost returns the number of tokens the request consumes.
+func (l *Limiter) cost(ctx context.Context, method string, req interface{}) int {
+	if l.opts.costFunc != nil {
+		if n := l.opts.costFunc(ctx, method, req); n > 0 {
+			return n
+		}
+	}
+	if n, ok := l.opts.costs[method]; ok && n > 0 {
+		return n
+	}
+	return 1
+}
+
+// take blocks until the tokens for req of method are available. In
+// non-blocking mode, or when the queue is full, it returns a
+// ResourceExhausted error instead of waiting.
+func (l *Limiter) take(ctx context.Context, method string, req interface{}) error {
+	_, err := l.acquire(ctx, method, req)
+	return err
+}
+
+// acquire is take returning a function giving the tokens back, for requests
+// rejected by something else after they were admitted.
+func (l *Limiter) acquire(ctx context.Context, method string, req interface{}) (refund func(), err error) {
+	l.observe()
+	cost := l.cost(ctx, method, req)
+	var tier string
+	if l.opts.tierFunc != nil {
+		tier = l.opts.tierFunc(ctx)
+	}
+	key := l.key(ctx, req)
+	buckets := l.set.Load().(*bucketSet).match(method, tier, key)
+
+	if l.opts.dryRun {
+		l.dryRun(method, cost, buckets)
+		return noop, nil
+	}
+	refund = func() { refundAll(buckets, cost) }
+
+	if l.opts.nonBlocking {
+		if ok, wait := tryTakeAll(buckets, cost); !ok {
+			l.recordThrottled(method)
+			return nil, l.reject(ctx, wait)
+		}
+		l.allowed.With(method).Add(1)
+		return refund, nil
+	}
+
+	if l.queue != nil {
+		if err := l.enqueue(ctx, method, key, cost, buckets); err != nil {
+			return nil, err
+		}
+		return refund, nil
+	}
+
+	l.addWaiting(1)
+	start := l.opts.clock.Now()
+	for _, b := range buckets {
+		b.Take(cost)
+	}
+	waited := l.opts.clock.Now().Sub(start)
+	l.addWaiting(-1)
+
+	l.wait.With(method).Observe(waited.Seconds())
+	if waited >= throttleThreshold {
+		l.recordThrottled(method)
+	}
+	l.allowed.With(method).Add(1)
+	return refund, nil
+}
+
+// enqueue waits for buckets in the queue.
+func (l *Limiter) enqueue(ctx context.Context, method, key string, cost int, buckets []Bucket) error {
+	w := &waiter{key: key, cost: cost, buckets: buckets}
+	w.prio = l.opts.priorityFunc(ctx)
+
+	l.addWaiting(1)
+	start := l.opts.clock.Now()
+	retry, err := l.queue.wait(ctx, w)
+	waited := l.opts.clock.Now().Sub(start)
+	l.addWaiting(-1)
+
+	l.wait.With(method).Observe(waited.Seconds())
+	switch {
+	case err == errQueueFull:
+		l.recordThrottled(method)
+		return l.reject(ctx, retry)
+	case err != nil:
+		return err
+	}
+	if waited >= throttleThreshold {
+		l.recordThrottled(method)
+	}
+	l.allowed.With(method).Add(1)
+	return nil
+}
+
+// observeInterval is how often the available tokens are reported.
+const observeInterval = time.Second
+
+// observe reports the available tokens of the named buckets, at most once per
+// observeInterval so buckets aren't locked twice per request.
+func (l *Limiter) observe() {
+	if l.opts.metrics == metrics.Discard {
+		return
+	}
+	now := l.opts.clock.Now()
+	last := atomic.LoadInt64(&l.observed)
+	if now.UnixNano()-last < int64(observeInterval) || !atomic.CompareAndSwapInt64(&l.observed, last, now.UnixNano()) {
+		return
+	}
+	set := l.set.Load().(*bucketSet)
+	for b, name := range set.names {
+		if in, ok := b.(inspector); ok {
+			tokens, _ := in.inspect(now)
+			l.available.With(name).Set(tokens)
+		}
+	}
+}
+
+// reject returns the error for a request that may be retried after wait.
+func (l *Limiter) reject(ctx context.Context, wait time.Duration) error {
+	if l.opts.retryPushback {
+		ms := strconv.FormatInt(int64((wait+time.Millisecond-1)/time.Millisecond), 10)
+		grpc.SetTrailer(ctx, metadata.Pairs(RetryPushbackKey, ms))
+	}
+	return grpcerrors.New(codes.ResourceExhausted, "rate limit exceeded").RetryAfter(wait).Err()
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor enforcing the limits of l.
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		token.Take()
-		return handler(ctx, req)
+		if err := l.take(ctx, info.FullMethod, req); err != nil {
+			return nil, err
+		}
+		resp, err := handler(ctx, req)
+		if l.feedback != nil {
+			l.feedback.record(status.Code(err))
+		}
+		return resp, err
 	}
 }
 
-// StreamServerInterceptor returns a new streaming server interceptor for lucky-bucket ratelimit.
-func StreamServerInterceptor(rate int) grpc.StreamServerInterceptor {
-	token := ratelimit.New(rate)
+// StreamServerInterceptor returns a new streaming server interceptor enforcing the limits of l.
+func (l *Limiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
 	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-		token.Take()
-		return handler(srv, stream)
+		var err error
+		if l.opts.requestKeyFunc != nil {
+			err = handler(srv, &firstMessageStream{ServerStream: stream, limiter: l, method: info.FullMethod})
+		} else {
+			if err := l.take(stream.Context(), info.FullMethod, nil); err != nil {
+				return err
+			}
+			err = handler(srv, stream)
+		}
+		if l.feedback != nil {
+			l.feedback.record(status.Code(err))
+		}
+		return err
 	}
 }
+
+// mustNew creates a Limiter with a single global rate, panicking on invalid rates.
+func mustNew(rate int, opts []Option) *Limiter {
+	l, err := New(Config{Global: rate}, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor for leaky-bucket ratelimit.
+func UnaryServerInterceptor(rate int, opts ...Option) grpc.UnaryServerInterceptor {
+	return mustNew(rate, opts).UnaryServerInterceptor()
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor for leaky-bucket ratelimit.
+func StreamServerInterceptor(rate int, opts ...Option) grpc.StreamServerInterceptor {
+	return mustNew(rate, opts).StreamServerInterceptor()
+}
@@ -1,2 +1,1088 @@
-// TODO: add unit tests.
 package ratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/ipfans/grpctools/metrics"
+	"github.com/ipfans/grpctools/middleware/ratelimit/ratelimittest"
+	"github.com/ipfans/grpctools/priority"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/tap"
+)
+
+// recorder is a metrics.Provider that keeps the last value of every series.
+type recorder struct {
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newRecorder() *recorder {
+	return &recorder{values: make(map[string]float64)}
+}
+
+func (r *recorder) add(key string, delta float64) {
+	r.mu.Lock()
+	r.values[key] += delta
+	r.mu.Unlock()
+}
+
+func (r *recorder) set(key string, v float64) {
+	r.mu.Lock()
+	r.values[key] = v
+	r.mu.Unlock()
+}
+
+func (r *recorder) get(key string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.values[key]
+}
+
+func (r *recorder) NewCounter(name, _ string, _ ...string) metrics.Counter {
+	return testCounter{series{r: r, name: name}}
+}
+
+func (r *recorder) NewGauge(name, _ string, _ ...string) metrics.Gauge {
+	return testGauge{series{r: r, name: name}}
+}
+
+func (r *recorder) NewHistogram(name, _ string, _ ...string) metrics.Histogram {
+	return testHistogram{series{r: r, name: name}}
+}
+
+type series struct {
+	r      *recorder
+	name   string
+	labels []string
+}
+
+func (s series) key() string {
+	return s.name + "{" + strings.Join(s.labels, ",") + "}"
+}
+
+func (s series) with(lvs []string) series {
+	return series{r: s.r, name: s.name, labels: append(append([]string(nil), s.labels...), lvs...)}
+}
+
+type testCounter struct{ series }
+
+func (c testCounter) With(lvs ...string) metrics.Counter { return testCounter{c.with(lvs)} }
+func (c testCounter) Add(delta float64)                  { c.r.add(c.key(), delta) }
+
+type testGauge struct{ series }
+
+func (g testGauge) With(lvs ...string) metrics.Gauge { return testGauge{g.with(lvs)} }
+func (g testGauge) Add(delta float64)                { g.r.add(g.key(), delta) }
+func (g testGauge) Set(v float64)                    { g.r.set(g.key(), v) }
+
+// testHistogram counts observations, which is all the tests need.
+type testHistogram struct{ series }
+
+func (h testHistogram) With(lvs ...string) metrics.Histogram { return testHistogram{h.with(lvs)} }
+func (h testHistogram) Observe(float64)                      { h.r.add(h.key(), 1) }
+
+func TestUnaryServerInterceptorMetrics(t *testing.T) {
+	rec := newRecorder()
+	interceptor := UnaryServerInterceptor(1000, WithMetrics(rec))
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := interceptor(context.Background(), nil, info, handler); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if want, have := 3.0, rec.get("grpc_ratelimit_allowed_total{/test.Service/Get}"); want != have {
+		t.Fatalf("allowed: want %v, have %v", want, have)
+	}
+	if want, have := 3.0, rec.get("grpc_ratelimit_wait_seconds{/test.Service/Get}"); want != have {
+		t.Fatalf("wait observations: want %v, have %v", want, have)
+	}
+	if want, have := 0.0, rec.get("grpc_ratelimit_waiting_requests{}"); want != have {
+		t.Fatalf("waiting: want %v, have %v", want, have)
+	}
+}
+
+func TestAvailableTokensMetric(t *testing.T) {
+	rec := newRecorder()
+	clock := ratelimittest.NewFakeClock(time.Unix(0, 0))
+	l, err := New(Config{Global: 10, Methods: map[string]int{"/test.Service/Get": 5}},
+		WithAlgorithm(TokenBucket(10)),
+		WithNonBlocking(),
+		WithClock(clock),
+		WithMetrics(rec),
+		WithName("api"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := l.UnaryServerInterceptor()
+	ratelimittest.Burst(context.Background(), i, "/test.Service/Get", 4)
+	clock.Advance(observeInterval)
+	// The burst refilled one second worth of tokens, up to the burst.
+	ratelimittest.Call(context.Background(), i, "/test.Service/Get")
+
+	if want, have := 10.0, rec.get("grpc_ratelimit_api_available_tokens{global}"); want != have {
+		t.Fatalf("global tokens: want %v, have %v", want, have)
+	}
+	if want, have := 10.0, rec.get("grpc_ratelimit_api_available_tokens{method:/test.Service/Get}"); want != have {
+		t.Fatalf("method tokens: want %v, have %v", want, have)
+	}
+	if want, have := 5.0, rec.get("grpc_ratelimit_api_allowed_total{/test.Service/Get}"); want != have {
+		t.Fatalf("allowed: want %v, have %v", want, have)
+	}
+}
+
+func TestCost(t *testing.T) {
+	l := mustNew(1000, []Option{
+		WithMethodCost(map[string]int{"/test.Service/List": 10}),
+		WithCostFunc(func(ctx context.Context, fullMethod string, req interface{}) int {
+			if n, ok := req.(int); ok {
+				return n
+			}
+			return 0
+		}),
+	})
+
+	for _, tc := range []struct {
+		method string
+		req    interface{}
+		want   int
+	}{
+		{"/test.Service/Get", nil, 1},
+		{"/test.Service/List", nil, 10},
+		{"/test.Service/List", 3, 3},
+		{"/test.Service/Get", -1, 1},
+	} {
+		if have := l.cost(context.Background(), tc.method, tc.req); tc.want != have {
+			t.Errorf("cost(%s, %v): want %d, have %d", tc.method, tc.req, tc.want, have)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, tc := range []struct {
+		cfg   Config
+		valid bool
+	}{
+		{Config{}, true},
+		{Config{Global: 100, Methods: map[string]int{"/test.Service/List": 10}, Tiers: map[string]int{"free": 1}}, true},
+		{Config{Global: -1}, false},
+		{Config{Methods: map[string]int{"List": 10}}, false},
+		{Config{Methods: map[string]int{"/test.Service/List": -1}}, false},
+		{Config{Tiers: map[string]int{"": 1}}, false},
+	} {
+		if err := tc.cfg.Validate(); (err == nil) != tc.valid {
+			t.Errorf("Validate(%+v): want valid %v, have %v", tc.cfg, tc.valid, err)
+		}
+	}
+}
+
+func TestLimiterUpdate(t *testing.T) {
+	l, err := New(Config{Global: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := l.set.Load()
+	if err := l.Update(Config{Global: -1}); err == nil {
+		t.Fatal("Update with invalid config: want error")
+	}
+	if l.set.Load() != old {
+		t.Fatal("Update with invalid config replaced the buckets")
+	}
+
+	if err := l.Update(Config{Methods: map[string]int{"/test.Service/List": 10}, Tiers: map[string]int{"free": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	s := l.set.Load().(*bucketSet)
+	if want, have := 2, len(s.match("/test.Service/List", "free", "")); want != have {
+		t.Fatalf("matched buckets: want %d, have %d", want, have)
+	}
+	if want, have := 0, len(s.match("/test.Service/Get", "", "")); want != have {
+		t.Fatalf("matched buckets: want %d, have %d", want, have)
+	}
+}
+
+// fakeServerStream is a grpc.ServerStream carrying only a context.
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// messageStream is a fakeServerStream receiving empty messages forever.
+type messageStream struct{ *fakeServerStream }
+
+func (messageStream) RecvMsg(interface{}) error { return nil }
+
+// fakeSource returns its configs in order, then errSourceDone.
+type fakeSource struct {
+	configs []Config
+}
+
+var errSourceDone = errors.New("source done")
+
+func (s *fakeSource) Next() (Config, error) {
+	if len(s.configs) == 0 {
+		return Config{}, errSourceDone
+	}
+	cfg := s.configs[0]
+	s.configs = s.configs[1:]
+	return cfg, nil
+}
+
+func (s *fakeSource) Close() {}
+
+func TestLimiterWatch(t *testing.T) {
+	l, err := New(Config{Global: 100}, WithLogger(grpclog.NewLoggerV2(ioutil.Discard, ioutil.Discard, ioutil.Discard)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sets []interface{}
+	src := &fakeSource{configs: []Config{
+		{Global: 10},
+		{Global: -1}, // invalid, skipped
+		{Global: 10}, // unchanged, keeps the buckets
+	}}
+	if err := l.Watch(&recordingSource{fakeSource: src, l: l, sets: &sets}); err != errSourceDone {
+		t.Fatalf("Watch: want source error, have %v", err)
+	}
+	if want, have := 10, l.set.Load().(*bucketSet).cfg.Global; want != have {
+		t.Fatalf("global rate: want %d, have %d", want, have)
+	}
+	if sets[1] != sets[2] || sets[2] != sets[3] {
+		t.Fatal("invalid or unchanged config replaced the buckets")
+	}
+}
+
+// recordingSource records the bucket set of l before every Next.
+type recordingSource struct {
+	*fakeSource
+	l    *Limiter
+	sets *[]interface{}
+}
+
+func (s *recordingSource) Next() (Config, error) {
+	*s.sets = append(*s.sets, s.l.set.Load())
+	return s.fakeSource.Next()
+}
+
+func TestStreamCapServerInterceptor(t *testing.T) {
+	interceptor := StreamCapServerInterceptor(1, func(ctx context.Context) string { return "peer" })
+	stream := &fakeServerStream{ctx: context.Background()}
+	info := &grpc.StreamServerInfo{FullMethod: "/test.Service/Watch"}
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- interceptor(nil, stream, info, func(srv interface{}, stream grpc.ServerStream) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	noop := func(srv interface{}, stream grpc.ServerStream) error { return nil }
+	if want, have := codes.ResourceExhausted, status.Code(interceptor(nil, stream, info, noop)); want != have {
+		t.Fatalf("stream above cap: want %v, have %v", want, have)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := interceptor(nil, stream, info, noop); err != nil {
+		t.Fatalf("stream after release: %v", err)
+	}
+}
+
+func TestSlidingWindows(t *testing.T) {
+	for name, b := range map[string]Bucket{
+		"log":     NewSlidingWindowLog(3, time.Minute),
+		"counter": NewSlidingWindowCounter(3, time.Minute),
+	} {
+		for i := 0; i < 3; i++ {
+			if ok, _ := b.TryTake(1); !ok {
+				t.Fatalf("%s: request %d rejected within limit", name, i)
+			}
+		}
+		ok, wait := b.TryTake(1)
+		if ok {
+			t.Fatalf("%s: request above limit allowed", name)
+		}
+		if wait <= 0 || wait > time.Minute {
+			t.Fatalf("%s: wait %v outside of window", name, wait)
+		}
+	}
+}
+
+func TestTokenBucketBurst(t *testing.T) {
+	b := NewTokenBucket(1, 5)
+	if ok, _ := b.TryTake(5); !ok {
+		t.Fatal("burst rejected")
+	}
+	ok, wait := b.TryTake(1)
+	if ok {
+		t.Fatal("request after burst allowed")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Fatalf("wait %v, want within one second", wait)
+	}
+}
+
+func TestNonBlockingRetryInfo(t *testing.T) {
+	interceptor := UnaryServerInterceptor(1, WithNonBlocking())
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	if _, err := interceptor(context.Background(), nil, info, handler); err != nil {
+		t.Fatal(err)
+	}
+	_, err := interceptor(context.Background(), nil, info, handler)
+	st := status.Convert(err)
+	if want, have := codes.ResourceExhausted, st.Code(); want != have {
+		t.Fatalf("request over limit: want %v, have %v", want, have)
+	}
+	if want, have := 1, len(st.Details()); want != have {
+		t.Fatalf("details: want %d, have %d", want, have)
+	}
+	ri, ok := st.Details()[0].(*errdetails.RetryInfo)
+	if !ok {
+		t.Fatalf("details: want RetryInfo, have %T", st.Details()[0])
+	}
+	delay, err := ptypes.Duration(ri.RetryDelay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delay <= 0 || delay > time.Second {
+		t.Fatalf("retry delay %v, want within one second", delay)
+	}
+}
+
+// waitQueued waits until q holds n waiters.
+func waitQueued(q *queue, n int) {
+	for {
+		q.mu.Lock()
+		queued := q.n
+		q.mu.Unlock()
+		if queued == n {
+			return
+		}
+		runtime.Gosched()
+	}
+}
+
+func TestQueuePriority(t *testing.T) {
+	// A bucket that never has tokens keeps every waiter queued.
+	var b closedBucket
+	q := newQueue(1, FIFO, ratelimittest.NewFakeClock(time.Unix(0, 0)))
+
+	low := &waiter{prio: 0, cost: 1, buckets: []Bucket{b}}
+	lowErr := make(chan error, 1)
+	go func() {
+		_, err := q.wait(context.Background(), low)
+		lowErr <- err
+	}()
+	waitQueued(q, 1)
+
+	// Same priority cannot evict.
+	if _, err := q.wait(context.Background(), &waiter{prio: 0, cost: 1, buckets: []Bucket{b}}); err != errQueueFull {
+		t.Fatalf("same priority on full queue: want errQueueFull, have %v", err)
+	}
+
+	// Higher priority evicts the queued request, then gives up when its
+	// caller does.
+	ctx, cancel := context.WithCancel(context.Background())
+	highErr := make(chan error, 1)
+	go func() {
+		_, err := q.wait(ctx, &waiter{prio: 1, cost: 1, buckets: []Bucket{b}})
+		highErr <- err
+	}()
+	if err := <-lowErr; err != errQueueFull {
+		t.Fatalf("evicted request: want errQueueFull, have %v", err)
+	}
+	waitQueued(q, 1)
+	cancel()
+	if want, have := codes.Canceled, status.Code(<-highErr); want != have {
+		t.Fatalf("high priority: want %v, have %v", want, have)
+	}
+}
+
+// closedBucket never has tokens.
+type closedBucket struct{}
+
+func (closedBucket) Take(n int) { select {} }
+
+func (closedBucket) TryTake(n int) (bool, time.Duration) { return false, time.Hour }
+
+func TestCostAboveBurst(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Unix(0, 0))
+	for name, alg := range map[string]Algorithm{
+		"leaky":   LeakyBucket(),
+		"token":   TokenBucket(2),
+		"gcra":    GCRA(2),
+		"sharded": ShardedTokenBucket(2),
+		"log":     SlidingWindowLog(time.Second),
+		"counter": SlidingWindowCounter(time.Second),
+	} {
+		b := Clocked(alg, clock)(2)
+		passed := false
+		for i := 0; i < 3 && !passed; i++ {
+			var wait time.Duration
+			if passed, wait = b.TryTake(10); !passed {
+				if wait <= 0 || wait > 2*time.Second {
+					t.Fatalf("%s: wait %v for cost above burst, want at most the bucket's refill", name, wait)
+				}
+				clock.Advance(wait)
+			}
+		}
+		if !passed {
+			t.Fatalf("%s: cost above burst never passed", name)
+		}
+	}
+}
+
+func TestStackedLimitsRefund(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Unix(0, 0))
+	tenant := "noisy"
+	l, err := New(Config{Global: 2, Keys: 1},
+		WithAlgorithm(SlidingWindowLog(time.Minute)),
+		WithNonBlocking(),
+		WithClock(clock),
+		WithKeyFunc(func(context.Context) string { return tenant }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := l.UnaryServerInterceptor()
+	ctx := context.Background()
+	if want, have := 1, ratelimittest.Burst(ctx, i, "/test.Service/Get", 10); want != have {
+		t.Fatalf("noisy tenant: want %d passed, have %d", want, have)
+	}
+	tenant = "quiet"
+	if want, have := 1, ratelimittest.Burst(ctx, i, "/test.Service/Get", 1); want != have {
+		t.Fatalf("quiet tenant after noisy one was rejected: want %d passed, have %d", want, have)
+	}
+}
+
+func TestQueueSkipsBlockedWaiter(t *testing.T) {
+	q := newQueue(10, FIFO, ratelimittest.NewFakeClock(time.Unix(0, 0)))
+	blocked := &waiter{cost: 1, buckets: []Bucket{closedBucket{}}}
+	go q.wait(context.Background(), blocked)
+
+	// The queue is only entered while busy, so wait for the blocked waiter.
+	waitQueued(q, 1)
+	if _, err := q.wait(context.Background(), &waiter{cost: 1, buckets: []Bucket{NewTokenBucket(1, 1)}}); err != nil {
+		t.Fatalf("waiter behind a blocked one: %v", err)
+	}
+}
+
+func TestQueueNoPriorityInversion(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Unix(0, 0))
+	b := Clocked(TokenBucket(5), clock)(5)
+	b.TryTake(5)
+	q := newQueue(10, FIFO, clock)
+
+	// An expensive important request waits for the whole burst.
+	highErr := make(chan error, 1)
+	go func() {
+		_, err := q.wait(context.Background(), &waiter{prio: 1, cost: 5, buckets: []Bucket{b}})
+		highErr <- err
+	}()
+	waitQueued(q, 1)
+	lowErr := make(chan error, 1)
+	go func() {
+		_, err := q.wait(context.Background(), &waiter{prio: 0, cost: 1, buckets: []Bucket{b}})
+		lowErr <- err
+	}()
+	waitQueued(q, 2)
+
+	// The tokens refilled meanwhile are kept for it rather than handed to
+	// the cheap request behind it.
+	clock.Advance(200 * time.Millisecond)
+	select {
+	case err := <-lowErr:
+		t.Fatalf("low priority request passed ahead of a waiting high priority one: %v", err)
+	case err := <-highErr:
+		t.Fatalf("high priority request passed without its tokens: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	for i := 0; i < 20; i++ {
+		clock.Advance(200 * time.Millisecond)
+		select {
+		case err := <-highErr:
+			if err != nil {
+				t.Fatal(err)
+			}
+			return
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	t.Fatal("high priority request never passed")
+}
+
+func TestWarmup(t *testing.T) {
+	l := mustNew(1000, []Option{WithWarmup(0.5, time.Hour)})
+	if f := l.fraction(l.created); f != 0.5 {
+		t.Fatalf("fraction at start: want 0.5, have %v", f)
+	}
+	if f := l.fraction(l.created.Add(30 * time.Minute)); f != 0.75 {
+		t.Fatalf("fraction half way: want 0.75, have %v", f)
+	}
+	if f := l.fraction(l.created.Add(2 * time.Hour)); f != 1 {
+		t.Fatalf("fraction after window: want 1, have %v", f)
+	}
+
+	b := scaled(LeakyBucket(), func(time.Time) float64 { return 0.5 })(1000).(*scaledBucket)
+	b.mu.Lock()
+	k, _ := b.scale(3)
+	b.mu.Unlock()
+	if want, have := 6, k; want != have {
+		t.Fatalf("tokens charged for 3 at half rate: want %d, have %d", want, have)
+	}
+}
+
+func TestFairQueueOrder(t *testing.T) {
+	q := newQueue(10, FIFO, SystemClock)
+	q.weights = map[string]float64{"big": 3}
+	q.finish = make(map[string]float64)
+
+	for i := 0; i < 3; i++ {
+		for _, key := range []string{"small", "big"} {
+			w := &waiter{key: key, cost: 1}
+			q.tag(w)
+			q.push(w)
+		}
+	}
+
+	var order []string
+	for q.n > 0 {
+		w := q.head()
+		q.remove(w)
+		q.virtual = w.finish
+		order = append(order, w.key)
+	}
+	if want, have := "big,big,small,big,small,small", strings.Join(order, ","); want != have {
+		t.Fatalf("serving order: want %s, have %s", want, have)
+	}
+}
+
+type tenantKey struct{}
+
+func TestFairQueueKeyBuckets(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Unix(0, 0))
+	l, err := New(Config{Keys: 1},
+		WithAlgorithm(TokenBucket(1)),
+		WithClock(clock),
+		WithQueue(10, FIFO),
+		WithFairQueue(map[string]float64{"big": 3}),
+		WithKeyFunc(func(ctx context.Context) string { return ctx.Value(tenantKey{}).(string) }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := context.WithValue(context.Background(), tenantKey{}, "big")
+	small := context.WithValue(context.Background(), tenantKey{}, "small")
+
+	// Empty the bucket of the big tenant and queue another of its requests.
+	if err := l.take(big, "/test.Service/Get", nil); err != nil {
+		t.Fatal(err)
+	}
+	bigErr := make(chan error, 1)
+	go func() { bigErr <- l.take(big, "/test.Service/Get", nil) }()
+	clock.BlockUntil(1)
+
+	// The small tenant still has tokens and must not wait behind it.
+	if err := l.take(small, "/test.Service/Get", nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-bigErr:
+		t.Fatalf("big tenant passed with an empty bucket: %v", err)
+	default:
+	}
+	clock.Advance(time.Second)
+	if err := <-bigErr; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyBuckets(t *testing.T) {
+	s := newBucketSet(Config{Keys: 1}, LeakyBucket(), SystemClock)
+	a := s.match("/test.Service/Get", "", "a")
+	b := s.match("/test.Service/Get", "", "b")
+	if len(a) != 1 || len(b) != 1 || a[0] == b[0] {
+		t.Fatal("want one distinct bucket per key")
+	}
+	if want, have := 0, len(s.match("/test.Service/Get", "", "")); want != have {
+		t.Fatalf("buckets without key: want %d, have %d", want, have)
+	}
+}
+
+func TestTapHandle(t *testing.T) {
+	handle := TapHandle(1)
+	info := &tap.Info{FullMethodName: "/test.Service/Get"}
+	if _, err := handle(context.Background(), info); err != nil {
+		t.Fatal(err)
+	}
+	_, err := handle(context.Background(), info)
+	if want, have := codes.ResourceExhausted, status.Code(err); want != have {
+		t.Fatalf("RPC over limit: want %v, have %v", want, have)
+	}
+}
+
+func TestServicePrecedence(t *testing.T) {
+	s := newBucketSet(Config{
+		Services: map[string]int{"test.Service": 10},
+		Methods:  map[string]int{"/test.Service/List": 1, "/test.Service/Health": 0},
+	}, LeakyBucket(), SystemClock)
+
+	get := s.match("/test.Service/Get", "", "")
+	watch := s.match("/test.Service/Watch", "", "")
+	if len(get) != 1 || len(watch) != 1 || get[0] != watch[0] {
+		t.Fatal("methods without own limit: want shared service bucket")
+	}
+	list := s.match("/test.Service/List", "", "")
+	if len(list) != 1 || list[0] == get[0] {
+		t.Fatal("method with own limit: want its own bucket only")
+	}
+	if want, have := 0, len(s.match("/test.Service/Health", "", "")); want != have {
+		t.Fatalf("exempted method: want %d buckets, have %d", want, have)
+	}
+	if want, have := 0, len(s.match("/other.Service/Get", "", "")); want != have {
+		t.Fatalf("other service: want %d buckets, have %d", want, have)
+	}
+}
+
+type testParent struct {
+	TenantId string `protobuf:"bytes,1,opt,name=tenant_id,json=tenantId,proto3" json:"tenant_id,omitempty"`
+}
+
+type testRequest struct {
+	Parent *testParent `protobuf:"bytes,1,opt,name=parent,proto3" json:"parent,omitempty"`
+	Page   int32       `protobuf:"varint,2,opt,name=page,proto3" json:"page,omitempty"`
+}
+
+func TestFirstMessageStreamRejection(t *testing.T) {
+	l, err := New(Config{Keys: 1},
+		WithAlgorithm(TokenBucket(1)),
+		WithNonBlocking(),
+		WithRequestKeyFunc(func(context.Context, interface{}) string { return "tenant" }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received int
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		for i := 0; i < 3; i++ {
+			// Ignore the error like a careless handler.
+			if stream.RecvMsg(new(testRequest)) == nil {
+				received++
+			}
+		}
+		return nil
+	}
+	info := &grpc.StreamServerInfo{FullMethod: "/test.Service/Watch"}
+	for i := 0; i < 2; i++ {
+		l.StreamServerInterceptor()(nil, messageStream{&fakeServerStream{ctx: context.Background()}}, info, handler)
+	}
+	if want, have := 3, received; want != have {
+		t.Fatalf("messages received: want %d of the first stream only, have %d", want, have)
+	}
+}
+
+func TestFieldKey(t *testing.T) {
+	req := &testRequest{Parent: &testParent{TenantId: "acme"}, Page: 3}
+	for _, tc := range []struct {
+		path string
+		req  interface{}
+		want string
+	}{
+		{"parent.tenant_id", req, "acme"},
+		{"page", req, "3"},
+		{"parent.missing", req, ""},
+		{"parent.tenant_id", &testRequest{}, ""},
+		{"page", "not a message", ""},
+	} {
+		if have := FieldKey(tc.path)(context.Background(), tc.req); tc.want != have {
+			t.Errorf("FieldKey(%q): want %q, have %q", tc.path, tc.want, have)
+		}
+	}
+}
+
+func TestCompose(t *testing.T) {
+	global, err := New(Config{Global: 1000}, WithNonBlocking(), WithAlgorithm(TokenBucket(100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	perKey, err := New(Config{Keys: 1}, WithNonBlocking(), WithKeyFunc(func(context.Context) string { return "tenant" }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Compose(
+		ConcurrencyRule("concurrency", 10, func(context.Context) string { return "tenant" }),
+		RateRule("global", global),
+		RateRule("per-key", perKey),
+	)
+
+	release, err := c.acquire(context.Background(), "/test.Service/Get", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	_, err = c.acquire(context.Background(), "/test.Service/Get", nil)
+	if want, have := codes.ResourceExhausted, status.Code(err); want != have {
+		t.Fatalf("second request: want %v, have %v", want, have)
+	}
+	if want, have := "per-key", RuleFromError(err); want != have {
+		t.Fatalf("rule fired: want %q, have %q", want, have)
+	}
+	if want, have := 0, len(c.rules[0].(*concurrencyRule).counter.open); want != have {
+		t.Fatalf("concurrency slots after rejection: want %d, have %d", want, have)
+	}
+}
+
+// rejectRule rejects every request.
+type rejectRule struct{}
+
+func (rejectRule) Name() string { return "reject" }
+
+func (rejectRule) Acquire(ctx context.Context, fullMethod string, req interface{}) (func(), error) {
+	return nil, status.Error(codes.ResourceExhausted, "rejected")
+}
+
+func TestComposeRefundsRateRules(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Unix(0, 0))
+	global, err := New(Config{Global: 1}, WithNonBlocking(), WithAlgorithm(TokenBucket(2)), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Compose(RateRule("global", global), rejectRule{})
+	for i := 0; i < 5; i++ {
+		if _, err := c.acquire(context.Background(), "/test.Service/Get", nil); RuleFromError(err) != "reject" {
+			t.Fatalf("%d: want rejection by later rule, have %v", i, err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := global.take(context.Background(), "/test.Service/Get", nil); err != nil {
+			t.Fatalf("burst token %d after rejections: %v", i, err)
+		}
+	}
+}
+
+func TestShardedTokenBucket(t *testing.T) {
+	b := NewShardedTokenBucket(1, 10)
+	taken := 0
+	for i := 0; i < 20; i++ {
+		if ok, _ := b.TryTake(1); ok {
+			taken++
+		}
+	}
+	if want := 10; taken != want {
+		t.Fatalf("tokens taken from full bucket: want %d, have %d", want, taken)
+	}
+
+	// With batches cached in shards, the bucket still holds at most burst,
+	// and at least the central share of it once shards ran empty.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+	clock := ratelimittest.NewFakeClock(time.Unix(0, 0))
+	b = Clocked(ShardedTokenBucket(16), clock)(1)
+	for round, min := range []int{16, 12} {
+		taken = 0
+		for i := 0; i < 40; i++ {
+			if ok, _ := b.TryTake(1); ok {
+				taken++
+			}
+			clock.Advance(stealInterval)
+		}
+		if taken < min || taken > 16 {
+			t.Fatalf("round %d: tokens taken from full bucket: want %d to 16, have %d", round, min, taken)
+		}
+		clock.Advance(time.Hour)
+	}
+}
+
+func TestShardedTokenBucketNeverExceedsBurst(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+	clock := ratelimittest.NewFakeClock(time.Unix(0, 0))
+	b := Clocked(ShardedTokenBucket(16), clock)(100)
+	in := b.(inspector)
+	// Requests larger than a batch leave tokens behind in the shard they
+	// refill, while the central bucket refills to its own share.
+	for i := 0; i < 50; i++ {
+		b.TryTake(5)
+		b.TryTake(5)
+		clock.Advance(time.Hour)
+		if tokens, _ := in.inspect(clock.Now()); tokens > 16 {
+			t.Fatalf("%d: tokens held: want at most 16, have %v", i, tokens)
+		}
+	}
+}
+
+func benchmarkBucket(b *testing.B, bucket Bucket) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			bucket.TryTake(1)
+		}
+	})
+}
+
+func BenchmarkTokenBucket(b *testing.B) {
+	benchmarkBucket(b, NewTokenBucket(1e9, 1e6))
+}
+
+func BenchmarkShardedTokenBucket(b *testing.B) {
+	benchmarkBucket(b, NewShardedTokenBucket(1e9, 1e6))
+}
+
+func TestDryRun(t *testing.T) {
+	rec := newRecorder()
+	clock := ratelimittest.NewFakeClock(time.Unix(0, 0))
+	interceptor := UnaryServerInterceptor(1, WithDryRun(), WithClock(clock), WithMetrics(rec), WithLogger(grpclog.NewLoggerV2(ioutil.Discard, ioutil.Discard, ioutil.Discard)))
+
+	// The clock never moves, so a delayed request would block forever.
+	for i := 0; i < 3; i++ {
+		if err := ratelimittest.Call(context.Background(), interceptor, "/test.Service/Get"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want, have := 2.0, rec.get("grpc_ratelimit_dry_run_throttled_total{/test.Service/Get}"); want != have {
+		t.Fatalf("would-be throttled: want %v, have %v", want, have)
+	}
+}
+
+func TestGCRA(t *testing.T) {
+	b := NewGCRA(10, 3)
+	for i := 0; i < 3; i++ {
+		if ok, _ := b.TryTake(1); !ok {
+			t.Fatalf("request %d within burst rejected", i)
+		}
+	}
+	ok, wait := b.TryTake(1)
+	if ok {
+		t.Fatal("request after burst allowed")
+	}
+	if wait <= 0 || wait > 100*time.Millisecond {
+		t.Fatalf("wait %v, want within one emission interval", wait)
+	}
+}
+
+func TestErrorFeedback(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Unix(0, 0))
+	f := newFeedback(ErrorFeedback{
+		Codes:      []codes.Code{codes.Unavailable},
+		Threshold:  0.1,
+		Window:     time.Second,
+		MinSamples: 1,
+		Decrease:   0.5,
+		Increase:   0.25,
+		Floor:      0.2,
+	}, clock)
+
+	for _, tc := range []struct {
+		code codes.Code
+		want float64
+	}{
+		{codes.Unavailable, 0.5},
+		{codes.Unavailable, 0.25},
+		{codes.Unavailable, 0.2},
+		{codes.NotFound, 0.45},
+		{codes.OK, 0.7},
+		{codes.OK, 0.95},
+		{codes.OK, 1},
+	} {
+		clock.Advance(time.Second)
+		f.record(tc.code)
+		if have := f.fraction(clock.Now()); math.Abs(tc.want-have) > 1e-9 {
+			t.Fatalf("after %v: want share %v, have %v", tc.code, tc.want, have)
+		}
+	}
+
+	// Windows without results recover the share.
+	f.record(codes.Unavailable)
+	clock.Advance(time.Second)
+	if want, have := 0.5, f.fraction(clock.Now()); math.Abs(want-have) > 1e-9 {
+		t.Fatalf("after failing window: want share %v, have %v", want, have)
+	}
+	clock.Advance(2 * time.Second)
+	if want, have := 1.0, f.fraction(clock.Now()); math.Abs(want-have) > 1e-9 {
+		t.Fatalf("after idle windows: want share %v, have %v", want, have)
+	}
+}
+
+func TestErrorFeedbackRecovers(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Unix(0, 0))
+	i := UnaryServerInterceptor(10,
+		WithAlgorithm(TokenBucket(5)),
+		WithNonBlocking(),
+		WithClock(clock),
+		WithErrorFeedback(ErrorFeedback{MinSamples: 5}),
+	)
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}
+	var handlerErr error
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, handlerErr
+	}
+
+	// A burst of failures drives the share to the floor.
+	handlerErr = status.Error(codes.Unavailable, "overloaded")
+	for n := 0; n < 10; n++ {
+		clock.Advance(100 * time.Millisecond)
+		for k := 0; k < 10; k++ {
+			i(context.Background(), nil, info, handler)
+		}
+	}
+
+	// Healthy traffic gets through again even though the charge at the floor
+	// exceeds the burst.
+	handlerErr = nil
+	passed := 0
+	for n := 0; n < 600; n++ {
+		clock.Advance(100 * time.Millisecond)
+		if _, err := i(context.Background(), nil, info, handler); err == nil {
+			passed++
+		}
+	}
+	if passed < 500 {
+		t.Fatalf("healthy requests after failures: want most of 600 passed, have %d", passed)
+	}
+}
+
+func TestPriorityFuncRequiresQueue(t *testing.T) {
+	f := RequestPriority(priority.DefaultPolicy)
+	if _, err := New(Config{Global: 1}, WithPriorityFunc(f)); err == nil {
+		t.Fatal("WithPriorityFunc without WithQueue accepted")
+	}
+	if _, err := New(Config{Global: 1}, WithPriorityFunc(f), WithQueue(1, FIFO)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Unix(0, 0))
+	key := "tenant"
+	l, err := New(Config{Keys: 1},
+		WithAlgorithm(TokenBucket(1)),
+		WithClock(clock),
+		WithQueue(5, FIFO),
+		WithKeyFunc(func(context.Context) string { return key }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < snapshotKeys+1; n++ {
+		key = fmt.Sprintf("tenant-%03d", n)
+		if err := l.take(context.Background(), "/test.Service/Get", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snap := l.Snapshot()
+	if want, have := "queue", snap.Mode; want != have {
+		t.Fatalf("mode: want %q, have %q", want, have)
+	}
+	if want, have := 1, snap.Config.Keys; want != have {
+		t.Fatalf("config keys: want %d, have %d", want, have)
+	}
+	if snap.Queue == nil || snap.Queue.Size != 5 {
+		t.Fatalf("queue: want size 5, have %+v", snap.Queue)
+	}
+	if want, have := snapshotKeys+1, snap.KeysTotal; want != have {
+		t.Fatalf("keys total: want %d, have %d", want, have)
+	}
+	if want, have := snapshotKeys, len(snap.Keys); want != have {
+		t.Fatalf("keys listed: want %d, have %d", want, have)
+	}
+	next := l.SnapshotAfter(snap.Keys[len(snap.Keys)-1].Key)
+	if len(next.Keys) != 1 || next.Keys[0].Key != "tenant-100" {
+		t.Fatalf("next page: want [tenant-100], have %+v", next.Keys)
+	}
+
+	rec := httptest.NewRecorder()
+	l.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/ratelimit?key=tenant-007", nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &snap); err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Keys) != 1 || snap.Keys[0].Key != "tenant-007" {
+		t.Fatalf("keys: want [tenant-007], have %+v", snap.Keys)
+	}
+	if want, have := 0.0, snap.Keys[0].Tokens; want != have {
+		t.Fatalf("tokens of exhausted key: want %v, have %v", want, have)
+	}
+	if want, have := time.Second, snap.Keys[0].Wait; want != have {
+		t.Fatalf("wait of exhausted key: want %v, have %v", want, have)
+	}
+
+	g, err := New(Config{Global: 10}, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 1.0, g.Snapshot().Buckets["global"].Tokens; want != have {
+		t.Fatalf("global bucket tokens: want %v, have %v", want, have)
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Unix(0, 0))
+	ctx := context.Background()
+
+	i := UnaryServerInterceptor(10, WithAlgorithm(TokenBucket(2)), WithNonBlocking(), WithClock(clock))
+	if want, have := 2, ratelimittest.Burst(ctx, i, "/test.Service/Get", 5); want != have {
+		t.Fatalf("burst: want %d passed, have %d", want, have)
+	}
+	clock.Advance(100 * time.Millisecond)
+	if want, have := 1, ratelimittest.Burst(ctx, i, "/test.Service/Get", 5); want != have {
+		t.Fatalf("after 100ms: want %d passed, have %d", want, have)
+	}
+
+	stream := StreamServerInterceptor(10, WithAlgorithm(TokenBucket(1)), WithNonBlocking(), WithClock(clock))
+	if err := ratelimittest.CallStream(ctx, stream, "/test.Service/Watch"); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := codes.ResourceExhausted, status.Code(ratelimittest.CallStream(ctx, stream, "/test.Service/Watch")); want != have {
+		t.Fatalf("stream over limit: want %v, have %v", want, have)
+	}
+
+	i = UnaryServerInterceptor(10, WithClock(clock))
+	done := make(chan error, 2)
+	for n := 0; n < 2; n++ {
+		go func() { done <- ratelimittest.Call(ctx, i, "/test.Service/Get") }()
+	}
+	<-done
+	clock.BlockUntil(1)
+	select {
+	case <-done:
+		t.Fatal("second request passed before the clock advanced")
+	default:
+	}
+	clock.Advance(100 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,137 @@
+// Package ratelimittest provides utilities for testing code using package
+// ratelimit without depending on the wall clock.
+package ratelimittest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// FakeClock is a ratelimit.Clock whose time only moves when Advance is
+// called. Sleep blocks until the clock has been advanced past its deadline.
+type FakeClock struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	now      time.Time
+	sleepers []*sleeper
+}
+
+type sleeper struct {
+	until time.Time
+	ch    chan time.Time
+}
+
+// NewFakeClock returns a FakeClock set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the current time of c.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Sleep blocks until c is advanced by at least d.
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// After returns a channel receiving the time of c once it is advanced by at
+// least d.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.sleepers = append(c.sleepers, &sleeper{until: c.now.Add(d), ch: ch})
+	c.cond.Broadcast()
+	return ch
+}
+
+// Advance moves c forward by d, waking the sleepers whose deadline passed.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	sort.Slice(c.sleepers, func(i, j int) bool { return c.sleepers[i].until.Before(c.sleepers[j].until) })
+	i := 0
+	for i < len(c.sleepers) && !c.sleepers[i].until.After(c.now) {
+		c.sleepers[i].ch <- c.now
+		i++
+	}
+	c.sleepers = c.sleepers[i:]
+}
+
+// Sleepers returns the number of pending Sleep and After calls.
+func (c *FakeClock) Sleepers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.sleepers)
+}
+
+// BlockUntil blocks until n Sleep or After calls are pending, so a test can
+// advance the clock knowing the limiter is waiting.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.sleepers) < n {
+		c.cond.Wait()
+	}
+}
+
+// Call invokes interceptor for method with a handler returning nil, and
+// returns the error of the interceptor. It is the shortest way to check
+// whether a rate limiting interceptor lets a request through.
+func Call(ctx context.Context, interceptor grpc.UnaryServerInterceptor, method string) error {
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	return err
+}
+
+// CallStream invokes interceptor for a stream of method whose handler
+// receives one message, and returns the error of the interceptor or of
+// receiving. Received messages are left empty.
+func CallStream(ctx context.Context, interceptor grpc.StreamServerInterceptor, method string) error {
+	info := &grpc.StreamServerInfo{FullMethod: method, IsClientStream: true, IsServerStream: true}
+	return interceptor(nil, &serverStream{ctx: ctx}, info, func(srv interface{}, stream grpc.ServerStream) error {
+		var m struct{}
+		return stream.RecvMsg(&m)
+	})
+}
+
+// serverStream is a grpc.ServerStream whose messages are always empty.
+type serverStream struct {
+	ctx context.Context
+}
+
+func (s *serverStream) SetHeader(metadata.MD) error  { return nil }
+func (s *serverStream) SendHeader(metadata.MD) error { return nil }
+func (s *serverStream) SetTrailer(metadata.MD)       {}
+func (s *serverStream) Context() context.Context     { return s.ctx }
+func (s *serverStream) SendMsg(m interface{}) error  { return nil }
+func (s *serverStream) RecvMsg(m interface{}) error  { return nil }
+
+// Burst invokes interceptor n times for method and returns how many calls
+// were let through.
+func Burst(ctx context.Context, interceptor grpc.UnaryServerInterceptor, method string, n int) int {
+	passed := 0
+	for i := 0; i < n; i++ {
+		if Call(ctx, interceptor, method) == nil {
+			passed++
+		}
+	}
+	return passed
+}
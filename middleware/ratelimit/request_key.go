@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// RequestKeyFunc returns the key of a request from its decoded message.
+type RequestKeyFunc func(ctx context.Context, req interface{}) string
+
+// WithRequestKeyFunc groups requests for per-key limits and fair queueing by
+// a key computed from the request message, e.g. FieldKey("tenant_id"). When
+// it returns an empty key, the KeyFunc set by WithKeyFunc is used instead.
+//
+// Streams are limited when the handler receives their first message, which is
+// used as the request; streams whose handler never receives a message are not
+// limited.
+func WithRequestKeyFunc(f RequestKeyFunc) Option {
+	return func(o *options) {
+		o.requestKeyFunc = f
+	}
+}
+
+// key returns the key of the request.
+func (l *Limiter) key(ctx context.Context, req interface{}) string {
+	if l.opts.requestKeyFunc != nil && req != nil {
+		if key := l.opts.requestKeyFunc(ctx, req); key != "" {
+			return key
+		}
+	}
+	if l.opts.keyFunc != nil {
+		return l.opts.keyFunc(ctx)
+	}
+	return ""
+}
+
+// firstMessageStream limits a stream once its first message is received. A
+// rejected stream keeps failing every later RecvMsg, so handlers ignoring the
+// error can't read past the limit.
+type firstMessageStream struct {
+	grpc.ServerStream
+	limiter *Limiter
+	method  string
+	once    sync.Once
+	err     error
+}
+
+func (s *firstMessageStream) RecvMsg(m interface{}) error {
+	if s.err != nil {
+		return s.err
+	}
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	s.once.Do(func() {
+		s.err = s.limiter.take(s.Context(), s.method, m)
+	})
+	return s.err
+}
+
+// FieldKey returns a RequestKeyFunc reading a field of generated protobuf
+// messages by its proto name. Nested fields are separated by dots, e.g.
+// "parent.tenant_id". Missing fields and non-message requests yield an empty
+// key.
+func FieldKey(path string) RequestKeyFunc {
+	names := strings.Split(path, ".")
+	return func(ctx context.Context, req interface{}) string {
+		v := reflect.ValueOf(req)
+		for _, name := range names {
+			for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+				if v.IsNil() {
+					return ""
+				}
+				v = v.Elem()
+			}
+			if v.Kind() != reflect.Struct {
+				return ""
+			}
+			i, ok := protoFieldIndex(v.Type(), name)
+			if !ok {
+				return ""
+			}
+			v = v.Field(i)
+		}
+		if !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+			return ""
+		}
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+type fieldIndexKey struct {
+	t    reflect.Type
+	name string
+}
+
+// fieldIndexes caches the struct field index of proto field names.
+var fieldIndexes sync.Map // fieldIndexKey -> int
+
+// protoFieldIndex returns the index of the struct field of t generated for
+// the proto field name.
+func protoFieldIndex(t reflect.Type, name string) (int, bool) {
+	key := fieldIndexKey{t, name}
+	if i, ok := fieldIndexes.Load(key); ok {
+		return i.(int), i.(int) >= 0
+	}
+	index := -1
+	for i := 0; i < t.NumField(); i++ {
+		for _, opt := range strings.Split(t.Field(i).Tag.Get("protobuf"), ",") {
+			if opt == "name="+name {
+				index = i
+			}
+		}
+	}
+	fieldIndexes.Store(key, index)
+	return index, index >= 0
+}
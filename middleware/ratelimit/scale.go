@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// minFraction is the lowest share of its rate a scaled bucket runs at.
+const minFraction = 0.01
+
+// scaled wraps alg so its buckets run at fraction(now) of their rate.
+func scaled(alg Algorithm, fraction func(now time.Time) float64) Algorithm {
+	return func(limit int) Bucket {
+		return &scaledBucket{
+			Bucket:   alg(limit),
+			fraction: fraction,
+			clock:    SystemClock,
+		}
+	}
+}
+
+// scaledBucket reduces the rate of a Bucket by charging requests more tokens.
+// Fractions of tokens are carried over to later requests, so the reduced rate
+// is exact on average.
+type scaledBucket struct {
+	Bucket
+	fraction func(now time.Time) float64
+	clock    Clock
+
+	mu    sync.Mutex
+	carry float64
+}
+
+func (b *scaledBucket) setClock(c Clock) {
+	b.clock = c
+	if cs, ok := b.Bucket.(clockSetter); ok {
+		cs.setClock(c)
+	}
+}
+
+// scale returns the tokens charged for n and the carry left afterwards.
+// b.mu must be held.
+func (b *scaledBucket) scale(n int) (int, float64) {
+	f := b.fraction(b.clock.Now())
+	if f >= 1 {
+		return n, 0
+	}
+	if f < minFraction {
+		f = minFraction
+	}
+	owed := b.carry + float64(n)/f
+	k := int(owed)
+	return k, owed - float64(k)
+}
+
+// refund gives back what n is charged at the current share, which is close
+// to the charge of a request taken just before.
+func (b *scaledBucket) refund(n int) {
+	if r, ok := b.Bucket.(refunder); ok {
+		b.mu.Lock()
+		k, _ := b.scale(n)
+		b.mu.Unlock()
+		r.refund(k)
+	}
+}
+
+// inspect reports the tokens of the wrapped bucket at the current share.
+func (b *scaledBucket) inspect(now time.Time) (float64, time.Duration) {
+	in, ok := b.Bucket.(inspector)
+	if !ok {
+		return 0, 0
+	}
+	tokens, wait := in.inspect(now)
+	f := math.Max(minFraction, math.Min(1, b.fraction(now)))
+	return tokens * f, wait
+}
+
+func (b *scaledBucket) Take(n int) {
+	b.mu.Lock()
+	k, carry := b.scale(n)
+	b.carry = carry
+	b.mu.Unlock()
+
+	b.Bucket.Take(k)
+}
+
+func (b *scaledBucket) TryTake(n int) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	k, carry := b.scale(n)
+	ok, wait := b.Bucket.TryTake(k)
+	if ok {
+		b.carry = carry
+	}
+	return ok, wait
+}
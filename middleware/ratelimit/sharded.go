@@ -0,0 +1,173 @@
+package ratelimit
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// stealInterval bounds how often a sharded bucket collects the tokens of
+// other shards, which touches the cache lines of every shard.
+const stealInterval = time.Millisecond
+
+// ShardedTokenBucket returns an Algorithm like TokenBucket with lower lock
+// contention, for services serving more than about 100k requests per second.
+func ShardedTokenBucket(burst int) Algorithm {
+	return func(limit int) Bucket {
+		return NewShardedTokenBucket(limit, burst)
+	}
+}
+
+// shard caches tokens taken in batches from the central bucket.
+type shard struct {
+	tokens int64
+	_      [56]byte // keep shards on separate cache lines
+}
+
+func (s *shard) take(n int64) bool {
+	for {
+		cur := atomic.LoadInt64(&s.tokens)
+		if cur < n {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&s.tokens, cur, cur-n) {
+			return true
+		}
+	}
+}
+
+// shardedBucket is a token bucket whose tokens are handed out in batches to
+// per-P shards, so most requests only do an atomic operation on a shard
+// local to their P. Tokens are never created by shards, so the rate is exact;
+// only their distribution may lag, which is rebalanced by stealing tokens
+// from other shards when the central bucket runs dry. The central bucket
+// holds the burst less what shards may cache, and refills never let shards
+// cache more than that in total, so the bucket never holds more than the
+// burst.
+type shardedBucket struct {
+	central *tokenBucket
+	batch   int64
+	shards  []shard
+	stolen  int64 // unix nanoseconds of the last steal, accessed atomically
+
+	// mu serializes refills and steals, which add tokens to shards, so that
+	// the room left in shards can't be used twice.
+	mu sync.Mutex
+
+	// hints hands out shard indexes. sync.Pool keeps objects per P, which
+	// gives requests affinity to a shard without knowing their P.
+	hints sync.Pool
+	next  uint32
+}
+
+// NewShardedTokenBucket returns a token bucket refilled with rate tokens per
+// second and holding at most burst tokens, sharded by GOMAXPROCS. Up to a
+// quarter of the burst is cached in shards, so once they ran empty bursts are
+// smaller until traffic spreads tokens to them again. Bursts below four tokens
+// per shard leave no room for batches, and all requests go through the central
+// bucket.
+func NewShardedTokenBucket(rate, burst int) Bucket {
+	if burst < 1 {
+		burst = 1
+	}
+	n := runtime.GOMAXPROCS(0)
+	batch := burst / (4 * n)
+	b := &shardedBucket{
+		central: NewTokenBucket(rate, burst-n*batch).(*tokenBucket),
+		batch:   int64(batch),
+		shards:  make([]shard, n),
+	}
+	// Start full.
+	for i := range b.shards {
+		b.shards[i].tokens = int64(batch)
+	}
+	b.hints.New = func() interface{} {
+		i := int(atomic.AddUint32(&b.next, 1)) % len(b.shards)
+		return &i
+	}
+	return b
+}
+
+func (b *shardedBucket) setClock(c Clock) { b.central.setClock(c) }
+
+// room returns how many more tokens the shards may cache. Callers hold mu:
+// shards only lose tokens meanwhile.
+func (b *shardedBucket) room() int64 {
+	room := int64(len(b.shards)) * b.batch
+	for i := range b.shards {
+		room -= atomic.LoadInt64(&b.shards[i].tokens)
+	}
+	return room
+}
+
+func (b *shardedBucket) refund(n int) { b.central.refund(n) }
+
+func (b *shardedBucket) inspect(now time.Time) (float64, time.Duration) {
+	tokens, wait := b.central.inspect(now)
+	for i := range b.shards {
+		tokens += float64(atomic.LoadInt64(&b.shards[i].tokens))
+	}
+	if tokens >= 1 {
+		wait = 0
+	}
+	return tokens, wait
+}
+
+func (b *shardedBucket) Take(n int) {
+	takeBlocking(b, b.central.clock, n)
+}
+
+func (b *shardedBucket) TryTake(n int) (bool, time.Duration) {
+	hint := b.hints.Get().(*int)
+	defer b.hints.Put(hint)
+	s := &b.shards[*hint]
+	want := int64(n)
+
+	if s.take(want) {
+		return true, 0
+	}
+	// Refill the shard with a batch on top of this request, capped at the
+	// room left in shards. Requests too large to leave room for a batch go
+	// to the central bucket alone, which would otherwise clamp the sum and
+	// hand out free tokens.
+	if b.batch > 0 && float64(want+b.batch) <= b.central.burst {
+		b.mu.Lock()
+		refill := b.batch
+		if room := b.room(); room < refill {
+			refill = room
+		}
+		if refill > 0 {
+			if ok, _ := b.central.TryTake(n + int(refill)); ok {
+				atomic.AddInt64(&s.tokens, refill)
+				b.mu.Unlock()
+				return true, 0
+			}
+		}
+		b.mu.Unlock()
+	}
+	ok, wait := b.central.TryTake(n)
+	if ok || b.batch == 0 {
+		return ok, wait
+	}
+
+	// Rebalance: collect tokens idling in other shards, at most once per
+	// stealInterval so over-limit traffic doesn't keep touching all shards.
+	now := b.central.clock.Now().UnixNano()
+	last := atomic.LoadInt64(&b.stolen)
+	if now-last < int64(stealInterval) || !atomic.CompareAndSwapInt64(&b.stolen, last, now) {
+		return false, wait
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var stolen int64
+	for i := range b.shards {
+		stolen += atomic.SwapInt64(&b.shards[i].tokens, 0)
+	}
+	if stolen >= want {
+		atomic.AddInt64(&s.tokens, stolen-want)
+		return true, 0
+	}
+	atomic.AddInt64(&s.tokens, stolen)
+	return false, wait
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"net"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// KeyFunc returns the key requests are grouped by, such as the peer address
+// or the authenticated identity of the caller.
+type KeyFunc func(ctx context.Context) string
+
+// PeerKey is a KeyFunc returning the host of the peer, so that all connections
+// from one client share a key.
+func PeerKey(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// counter counts in-flight requests per key.
+type counter struct {
+	max int
+
+	mu   sync.Mutex
+	open map[string]int
+}
+
+func newCounter(max int) *counter {
+	return &counter{
+		max:  max,
+		open: make(map[string]int),
+	}
+}
+
+func (c *counter) acquire(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.open[key] >= c.max {
+		return false
+	}
+	c.open[key]++
+	return true
+}
+
+func (c *counter) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.open[key]--; c.open[key] <= 0 {
+		delete(c.open, key)
+	}
+}
+
+// StreamCapServerInterceptor returns a new streaming server interceptor that
+// allows at most max simultaneously open streams per key. Streams above the
+// cap are rejected with ResourceExhausted. A nil key defaults to PeerKey.
+func StreamCapServerInterceptor(max int, key KeyFunc) grpc.StreamServerInterceptor {
+	if key == nil {
+		key = PeerKey
+	}
+	c := newCounter(max)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		k := key(stream.Context())
+		if !c.acquire(k) {
+			return status.Errorf(codes.ResourceExhausted, "too many open streams, limit is %d", max)
+		}
+		defer c.release(k)
+		return handler(srv, stream)
+	}
+}
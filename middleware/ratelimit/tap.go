@@ -0,0 +1,41 @@
+package ratelimit
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/tap"
+)
+
+// TapHandle returns a tap.ServerInHandle, installed with grpc.InTapHandle,
+// rejecting RPCs over the global and per-method limits of l before their
+// messages are read. This is far cheaper than interceptors during severe
+// overload, so l should hold hard limits above those enforced by
+// interceptors. Tier and key limits don't apply since the caller is not known
+// yet; the cost function is called with a nil request.
+//
+// Rejected RPCs never reach the server; depending on the gRPC version,
+// clients see ResourceExhausted or Unavailable (REFUSED_STREAM).
+func (l *Limiter) TapHandle() tap.ServerInHandle {
+	return func(ctx context.Context, info *tap.Info) (context.Context, error) {
+		l.observe()
+		method := info.FullMethodName
+		cost := l.cost(ctx, method, nil)
+		buckets := l.set.Load().(*bucketSet).match(method, "", "")
+		if l.opts.dryRun {
+			l.dryRun(method, cost, buckets)
+			return ctx, nil
+		}
+		if ok, _ := tryTakeAll(buckets, cost); !ok {
+			l.recordThrottled(method)
+			return nil, status.Error(codes.ResourceExhausted, "server is over its hard rate limit")
+		}
+		l.allowed.With(method).Add(1)
+		return ctx, nil
+	}
+}
+
+// TapHandle returns a new tap.ServerInHandle rejecting RPCs above rate.
+func TapHandle(rate int, opts ...Option) tap.ServerInHandle {
+	return mustNew(rate, opts).TapHandle()
+}
@@ -0,0 +1,25 @@
+package ratelimit
+
+import (
+	"time"
+)
+
+// WithWarmup starts the limiter at initial (between 0 and 1) of the
+// configured rates and ramps them linearly up to 100% over window after the
+// Limiter is created, so cold caches don't take full traffic right away.
+func WithWarmup(initial float64, window time.Duration) Option {
+	return func(o *options) {
+		o.warmupInitial = initial
+		o.warmupWindow = window
+	}
+}
+
+// warmupFraction returns the share of the rate available at now.
+func (l *Limiter) warmupFraction(now time.Time) float64 {
+	elapsed := now.Sub(l.created)
+	if l.opts.warmupWindow <= 0 || elapsed >= l.opts.warmupWindow {
+		return 1
+	}
+	initial := l.opts.warmupInitial
+	return initial + (1-initial)*float64(elapsed)/float64(l.opts.warmupWindow)
+}
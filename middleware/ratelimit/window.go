@@ -0,0 +1,194 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// SlidingWindowLog returns an Algorithm whose limits are requests per rolling
+// window. It is exact but keeps a timestamp for every request in the window.
+func SlidingWindowLog(window time.Duration) Algorithm {
+	return func(limit int) Bucket {
+		return NewSlidingWindowLog(limit, window)
+	}
+}
+
+// SlidingWindowCounter returns an Algorithm whose limits are requests per
+// rolling window. It approximates the window from the counts of the current
+// and previous fixed windows, using constant memory.
+func SlidingWindowCounter(window time.Duration) Algorithm {
+	return func(limit int) Bucket {
+		return NewSlidingWindowCounter(limit, window)
+	}
+}
+
+type slidingWindowLog struct {
+	limit  int
+	window time.Duration
+	clock  Clock
+
+	mu  sync.Mutex
+	log []time.Time
+}
+
+// NewSlidingWindowLog returns a Bucket passing at most limit requests in any
+// window.
+func NewSlidingWindowLog(limit int, window time.Duration) Bucket {
+	return &slidingWindowLog{
+		limit:  limit,
+		window: window,
+		log:    make([]time.Time, 0, limit),
+		clock:  SystemClock,
+	}
+}
+
+func (w *slidingWindowLog) setClock(c Clock) { w.clock = c }
+
+func (w *slidingWindowLog) Take(n int) {
+	takeBlocking(w, w.clock, n)
+}
+
+func (w *slidingWindowLog) refund(n int) {
+	w.mu.Lock()
+	if n > len(w.log) {
+		n = len(w.log)
+	}
+	w.log = w.log[:len(w.log)-n]
+	w.mu.Unlock()
+}
+
+func (w *slidingWindowLog) inspect(now time.Time) (float64, time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	cutoff := now.Add(-w.window)
+	used := 0
+	for _, t := range w.log {
+		if t.After(cutoff) {
+			used++
+		}
+	}
+	if used < w.limit {
+		return float64(w.limit - used), 0
+	}
+	if used == 0 {
+		return 0, w.window
+	}
+	return 0, w.log[len(w.log)-used].Add(w.window).Sub(now)
+}
+
+func (w *slidingWindowLog) TryTake(n int) (bool, time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.clock.Now()
+
+	// Drop requests that left the window.
+	cutoff := now.Add(-w.window)
+	i := 0
+	for i < len(w.log) && !w.log[i].After(cutoff) {
+		i++
+	}
+	w.log = w.log[i:]
+
+	if n > w.limit {
+		n = w.limit
+	}
+	if len(w.log)+n <= w.limit {
+		for j := 0; j < n; j++ {
+			w.log = append(w.log, now)
+		}
+		return true, 0
+	}
+	// Wait until enough of the oldest requests leave the window.
+	return false, w.log[len(w.log)+n-w.limit-1].Add(w.window).Sub(now)
+}
+
+type slidingWindowCounter struct {
+	limit  float64
+	window time.Duration
+	clock  Clock
+
+	mu    sync.Mutex
+	start time.Time
+	prev  float64
+	cur   float64
+}
+
+// NewSlidingWindowCounter returns a Bucket passing approximately limit
+// requests in any window.
+func NewSlidingWindowCounter(limit int, window time.Duration) Bucket {
+	return &slidingWindowCounter{
+		limit:  float64(limit),
+		window: window,
+		clock:  SystemClock,
+	}
+}
+
+func (w *slidingWindowCounter) setClock(c Clock) { w.clock = c }
+
+// advance moves the fixed windows up to now. w.mu must be held.
+func (w *slidingWindowCounter) advance(now time.Time) {
+	if w.start.IsZero() {
+		w.start = now
+		return
+	}
+	switch elapsed := now.Sub(w.start) / w.window; {
+	case elapsed == 1:
+		w.prev, w.cur = w.cur, 0
+		w.start = w.start.Add(w.window)
+	case elapsed > 1:
+		w.prev, w.cur = 0, 0
+		w.start = w.start.Add(elapsed * w.window)
+	}
+}
+
+func (w *slidingWindowCounter) Take(n int) {
+	takeBlocking(w, w.clock, n)
+}
+
+func (w *slidingWindowCounter) refund(n int) {
+	w.mu.Lock()
+	w.cur = math.Max(0, w.cur-math.Min(float64(n), w.limit))
+	w.mu.Unlock()
+}
+
+func (w *slidingWindowCounter) inspect(now time.Time) (float64, time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.advance(now)
+	overlap := 1 - float64(now.Sub(w.start))/float64(w.window)
+	tokens := w.limit - w.prev*overlap - w.cur
+	if tokens >= 1 {
+		return tokens, 0
+	}
+	return math.Max(0, tokens), w.start.Add(w.window).Sub(now)
+}
+
+func (w *slidingWindowCounter) TryTake(n int) (bool, time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.clock.Now()
+	w.advance(now)
+	if float64(n) > w.limit {
+		n = int(w.limit)
+	}
+
+	// Weight the previous window by how much of it still overlaps.
+	overlap := 1 - float64(now.Sub(w.start))/float64(w.window)
+	if w.prev*overlap+w.cur+float64(n) <= w.limit {
+		w.cur += float64(n)
+		return true, 0
+	}
+
+	untilNext := w.start.Add(w.window).Sub(now)
+	room := w.limit - w.cur - float64(n)
+	if w.prev == 0 || room < 0 {
+		return false, untilNext
+	}
+	// The previous window's weight shrinks linearly; find when it fits.
+	wait := time.Duration((1-room/w.prev)*float64(w.window)) - now.Sub(w.start)
+	if wait > untilNext {
+		wait = untilNext
+	}
+	return false, wait
+}
@@ -0,0 +1,222 @@
+// Package record provides interceptors recording the requests and responses
+// of unary calls to files, and a server replaying them, for golden tests of
+// clients without live backends.
+package record
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
+	"golang.org/x/net/context"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/status"
+)
+
+// Entry is a recorded call. Request and response are stored as Any, so they
+// are decoded as the types of the recorded messages, which must be
+// registered, as generated code does.
+type Entry struct {
+	Method   string      `protobuf:"bytes,1,opt,name=method,proto3" json:"method,omitempty"`
+	Request  *any.Any    `protobuf:"bytes,2,opt,name=request,proto3" json:"request,omitempty"`
+	Response *any.Any    `protobuf:"bytes,3,opt,name=response,proto3" json:"response,omitempty"`
+	Status   *spb.Status `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"`
+}
+
+func (m *Entry) Reset()         { *m = Entry{} }
+func (m *Entry) String() string { return proto.CompactTextString(m) }
+func (*Entry) ProtoMessage()    {}
+
+// Format of recording files.
+type Format int
+
+// Formats of recording files.
+const (
+	JSON  Format = iota // ".json" files, for review in diffs
+	Proto               // ".pb" files, in protobuf wire format
+)
+
+func (f Format) ext() string {
+	if f == Proto {
+		return ".pb"
+	}
+	return ".json"
+}
+
+func (f Format) marshal(e *Entry) ([]byte, error) {
+	if f == Proto {
+		return proto.Marshal(e)
+	}
+	s, err := (&jsonpb.Marshaler{OrigName: true, Indent: "  "}).MarshalToString(e)
+	return []byte(s + "\n"), err
+}
+
+// ReadFile reads the entry recorded in the file name, in the format of its
+// extension.
+func ReadFile(name string) (*Entry, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{}
+	switch filepath.Ext(name) {
+	case ".pb":
+		err = proto.Unmarshal(b, e)
+	case ".json":
+		err = jsonpb.Unmarshal(bytes.NewReader(b), e)
+	default:
+		return nil, fmt.Errorf("record: unknown format of %s", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("record: %s: %v", name, err)
+	}
+	return e, nil
+}
+
+// Load reads the entries recorded in dir, in the order they were recorded.
+func Load(dir string) ([]*Entry, error) {
+	var entries []*Entry
+	err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ext := filepath.Ext(name); info.IsDir() || (ext != ".json" && ext != ".pb") {
+			return nil
+		}
+		e, err := ReadFile(name)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
+
+type options struct {
+	format  Format
+	methods map[string]bool
+	logger  grpclog.LoggerV2
+}
+
+// Option for recorders.
+type Option func(o *options)
+
+// WithFormat sets the format of recording files. Default is JSON.
+func WithFormat(f Format) Option {
+	return func(o *options) {
+		o.format = f
+	}
+}
+
+// WithMethods sets the full method names recorded. Default is all unary
+// methods.
+func WithMethods(methods ...string) Option {
+	return func(o *options) {
+		if o.methods == nil {
+			o.methods = make(map[string]bool)
+		}
+		for _, m := range methods {
+			o.methods[m] = true
+		}
+	}
+}
+
+// WithLogger replaced built-in logger to given.
+func WithLogger(logger grpclog.LoggerV2) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// Recorder writes the calls of selected methods to a directory, one file per
+// call named after its method and sequence, like
+// "foo.v1.UserService/Get/000001.json". A new Recorder numbers files from 1
+// again, overwriting those of earlier recordings to the same directory.
+type Recorder struct {
+	dir  string
+	opts options
+
+	mu  sync.Mutex
+	seq map[string]int
+}
+
+// NewRecorder returns a Recorder writing to dir.
+func NewRecorder(dir string, opts ...Option) *Recorder {
+	o := options{
+		logger: grpclog.NewLoggerV2(os.Stdout, os.Stdout, os.Stderr),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Recorder{dir: dir, opts: o, seq: make(map[string]int)}
+}
+
+// Record writes a call to method. Errors are returned, and logged by the
+// interceptors without failing calls.
+func (r *Recorder) Record(method string, req, resp interface{}, err error) error {
+	e := &Entry{Method: method, Status: status.Convert(err).Proto()}
+	var merr error
+	if m, ok := req.(proto.Message); ok {
+		e.Request, merr = ptypes.MarshalAny(m)
+	}
+	if m, ok := resp.(proto.Message); ok && merr == nil && err == nil {
+		e.Response, merr = ptypes.MarshalAny(m)
+	}
+	if merr != nil {
+		return fmt.Errorf("record: %s: %v", method, merr)
+	}
+	b, merr := r.opts.format.marshal(e)
+	if merr != nil {
+		return fmt.Errorf("record: %s: %v", method, merr)
+	}
+
+	r.mu.Lock()
+	r.seq[method]++
+	seq := r.seq[method]
+	r.mu.Unlock()
+	dir := filepath.Join(r.dir, filepath.FromSlash(strings.TrimPrefix(method, "/")))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%06d%s", seq, r.opts.format.ext())), b, 0644)
+}
+
+func (r *Recorder) record(method string, req, resp interface{}, err error) {
+	if r.opts.methods != nil && !r.opts.methods[method] {
+		return
+	}
+	if err := r.Record(method, req, resp, err); err != nil {
+		r.opts.logger.Warning(err)
+	}
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor recording the
+// calls served.
+func (r *Recorder) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		r.record(info.FullMethod, req, resp, err)
+		return resp, err
+	}
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor recording the
+// calls made, typically to a real backend, to replay them later.
+func (r *Recorder) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		r.record(method, req, reply, err)
+		return err
+	}
+}
@@ -0,0 +1,118 @@
+package record
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// greet answers known users and fails with NotFound for others.
+func greet(ctx context.Context, req interface{}) (interface{}, error) {
+	name := req.(*wrappers.StringValue).Value
+	if name != "alice" {
+		return nil, status.Error(codes.NotFound, "unknown user")
+	}
+	return &wrappers.StringValue{Value: "hello " + name}, nil
+}
+
+// replay starts a replaying server of entries.
+func replay(t *testing.T, entries []*Entry) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	srv := NewServer(NewReplayer(entries))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	cc, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return cc
+}
+
+func TestRecordReplay(t *testing.T) {
+	for _, format := range []Format{JSON, Proto} {
+		dir, err := ioutil.TempDir("", "record")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		interceptor := NewRecorder(dir, WithFormat(format), WithMethods("/test.Service/Greet")).UnaryServerInterceptor()
+		for _, name := range []string{"alice", "bob"} {
+			interceptor(context.Background(), &wrappers.StringValue{Value: name}, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Greet"}, greet)
+		}
+		interceptor(context.Background(), &wrappers.StringValue{Value: "alice"}, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Other"}, greet)
+
+		if _, err := os.Stat(filepath.Join(dir, "test.Service", "Greet", "000002"+format.ext())); err != nil {
+			t.Fatalf("format %d: %v", format, err)
+		}
+		entries, err := Load(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := 2, len(entries); want != have {
+			t.Fatalf("format %d: entries: want %d, have %d", format, want, have)
+		}
+
+		cc := replay(t, entries)
+		for _, tc := range []struct {
+			method string
+			name   string
+			code   codes.Code
+			resp   string
+		}{
+			{"/test.Service/Greet", "alice", codes.OK, "hello alice"},
+			{"/test.Service/Greet", "bob", codes.NotFound, ""},
+			{"/test.Service/Greet", "carol", codes.Unimplemented, ""},
+			{"/test.Service/Other", "alice", codes.Unimplemented, ""},
+		} {
+			var resp wrappers.StringValue
+			err := cc.Invoke(context.Background(), tc.method, &wrappers.StringValue{Value: tc.name}, &resp)
+			if want, have := tc.code, status.Code(err); want != have {
+				t.Errorf("format %d: %s %s: code: want %v, have %v", format, tc.method, tc.name, want, have)
+			}
+			if want, have := tc.resp, resp.Value; want != have {
+				t.Errorf("format %d: %s %s: want %q, have %q", format, tc.method, tc.name, want, have)
+			}
+		}
+	}
+}
+
+func TestReplayerUnaryServerInterceptor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := NewRecorder(dir).Record("/test.Service/Greet", &wrappers.StringValue{Value: "alice"}, &wrappers.StringValue{Value: "hello alice"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interceptor := NewReplayer(entries).UnaryServerInterceptor()
+	resp, err := interceptor(context.Background(), &wrappers.StringValue{Value: "alice"}, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Greet"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		t.Fatal("handler called")
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "hello alice", resp.(*wrappers.StringValue).Value; want != have {
+		t.Fatalf("want %q, have %q", want, have)
+	}
+}
@@ -0,0 +1,125 @@
+package record
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Replayer answers calls with recorded responses.
+type Replayer struct {
+	entries map[string][]*Entry
+}
+
+// NewReplayer returns a Replayer of entries, like those of Load.
+func NewReplayer(entries []*Entry) *Replayer {
+	r := &Replayer{entries: make(map[string][]*Entry)}
+	for _, e := range entries {
+		r.entries[e.Method] = append(r.entries[e.Method], e)
+	}
+	return r
+}
+
+// Reply returns the response or error recorded for the first call to method
+// with a request equal to req. Calls without one fail with Unimplemented.
+func (r *Replayer) Reply(method string, req proto.Message) (proto.Message, error) {
+	for _, e := range r.entries[method] {
+		if e.Request == nil {
+			continue
+		}
+		recorded, err := ptypes.Empty(e.Request)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "record: %v", err)
+		}
+		if err := ptypes.UnmarshalAny(e.Request, recorded); err != nil {
+			return nil, status.Errorf(codes.Internal, "record: %v", err)
+		}
+		if !proto.Equal(req, recorded) {
+			continue
+		}
+		if e.Status.GetCode() != int32(codes.OK) {
+			return nil, status.ErrorProto(e.Status)
+		}
+		if e.Response == nil {
+			return nil, status.Errorf(codes.Internal, "record: no response recorded for %s", method)
+		}
+		resp, err := ptypes.Empty(e.Response)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "record: %v", err)
+		}
+		if err := ptypes.UnmarshalAny(e.Response, resp); err != nil {
+			return nil, status.Errorf(codes.Internal, "record: %v", err)
+		}
+		return resp, nil
+	}
+	return nil, status.Errorf(codes.Unimplemented, "record: no recorded call to %s with this request", method)
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor answering
+// calls with recorded responses instead of calling handlers.
+func (r *Replayer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		m, ok := req.(proto.Message)
+		if !ok {
+			return nil, status.Errorf(codes.Internal, "record: %T is not a protobuf message", req)
+		}
+		return r.Reply(info.FullMethod, m)
+	}
+}
+
+// NewServer returns a server answering unary calls to the recorded methods of
+// r, without registering their services. Messages are passed to the server
+// undecoded, so opts should be transport options, like credentials, rather
+// than interceptors.
+func NewServer(r *Replayer, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.CustomCodec(rawCodec{}), grpc.UnknownServiceHandler(r.handle))
+	return grpc.NewServer(opts...)
+}
+
+// handle decodes the request of a call as the type of the recorded requests of
+// its method, and sends the reply.
+func (r *Replayer) handle(srv interface{}, stream grpc.ServerStream) error {
+	method, _ := grpc.MethodFromServerStream(stream)
+	entries := r.entries[method]
+	if len(entries) == 0 || entries[0].Request == nil {
+		return status.Errorf(codes.Unimplemented, "record: no recorded call to %s", method)
+	}
+	req, err := ptypes.Empty(entries[0].Request)
+	if err != nil {
+		return status.Errorf(codes.Internal, "record: %v", err)
+	}
+	var b []byte
+	if err := stream.RecvMsg(&b); err != nil {
+		return err
+	}
+	if err := proto.Unmarshal(b, req); err != nil {
+		return status.Errorf(codes.InvalidArgument, "record: %v", err)
+	}
+	resp, err := r.Reply(method, req)
+	if err != nil {
+		return err
+	}
+	if b, err = proto.Marshal(resp); err != nil {
+		return status.Errorf(codes.Internal, "record: %v", err)
+	}
+	return stream.SendMsg(&b)
+}
+
+// rawCodec passes messages as bytes.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	return *v.(*[]byte), nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*[]byte) = append([]byte(nil), data...)
+	return nil
+}
+
+func (rawCodec) String() string {
+	return "raw"
+}
@@ -0,0 +1,101 @@
+// Package recovery provides server interceptors turning panics into errors.
+package recovery
+
+import (
+	"os"
+	"runtime/debug"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/status"
+)
+
+// HandlerFunc is called with the value and stack of a recovered panic.
+type HandlerFunc func(ctx context.Context, p interface{}, stack []byte)
+
+type options struct {
+	handler HandlerFunc
+	fatal   func(p interface{}) bool
+	logger  grpclog.LoggerV2
+}
+
+// Option for recovery interceptors.
+type Option func(o *options)
+
+// WithHandler sets the function called on every recovered panic. Default logs
+// the panic and its stack.
+func WithHandler(h HandlerFunc) Option {
+	return func(o *options) {
+		o.handler = h
+	}
+}
+
+// WithFatal sets a function reporting panics that must not be recovered. They
+// are passed to the handler and panic again, crashing the process.
+func WithFatal(fatal func(p interface{}) bool) Option {
+	return func(o *options) {
+		o.fatal = fatal
+	}
+}
+
+// WithLogger sets the logger of the default handler.
+func WithLogger(logger grpclog.LoggerV2) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		fatal:  func(interface{}) bool { return false },
+		logger: grpclog.NewLoggerV2(os.Stdout, os.Stdout, os.Stderr),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.handler == nil {
+		logger := o.logger
+		o.handler = func(ctx context.Context, p interface{}, stack []byte) {
+			logger.Errorf("middleware/recovery: panic: %v\n%s", p, stack)
+		}
+	}
+	return o
+}
+
+// recover handles the panic p, if any, setting err to an Internal error.
+func (o options) recover(ctx context.Context, p interface{}, err *error) {
+	if p == nil {
+		return
+	}
+	o.handler(ctx, p, debug.Stack())
+	if o.fatal(p) {
+		panic(p)
+	}
+	*err = status.Error(codes.Internal, "internal error")
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor recovering
+// panics of handlers as Internal errors.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			o.recover(ctx, recover(), &err)
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor
+// recovering panics of handlers as Internal errors.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			o.recover(stream.Context(), recover(), &err)
+		}()
+		return handler(srv, stream)
+	}
+}
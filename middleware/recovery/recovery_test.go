@@ -0,0 +1,81 @@
+package recovery
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fatalError struct{}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	var (
+		recovered interface{}
+		stack     []byte
+	)
+	interceptor := UnaryServerInterceptor(
+		WithHandler(func(ctx context.Context, p interface{}, s []byte) {
+			recovered, stack = p, s
+		}),
+		WithFatal(func(p interface{}) bool {
+			_, ok := p.(fatalError)
+			return ok
+		}),
+	)
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}
+
+	_, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	})
+	if want, have := codes.Internal, status.Code(err); want != have {
+		t.Fatalf("panic: want %v, have %v", want, have)
+	}
+	if want, have := "boom", recovered; want != have {
+		t.Fatalf("recovered: want %v, have %v", want, have)
+	}
+	if !bytes.Contains(stack, []byte("recovery_test.go")) {
+		t.Fatalf("stack doesn't contain the handler:\n%s", stack)
+	}
+
+	resp, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	})
+	if err != nil || resp != "ok" {
+		t.Fatalf("no panic: want ok, have %v, %v", resp, err)
+	}
+
+	defer func() {
+		if _, ok := recover().(fatalError); !ok {
+			t.Fatal("fatal panic recovered")
+		}
+	}()
+	interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic(fatalError{})
+	})
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	var calls int
+	interceptor := StreamServerInterceptor(WithHandler(func(ctx context.Context, p interface{}, s []byte) {
+		calls++
+	}))
+	err := interceptor(nil, stream{}, &grpc.StreamServerInfo{FullMethod: "/test.Service/List"}, func(srv interface{}, stream grpc.ServerStream) error {
+		panic("boom")
+	})
+	if want, have := codes.Internal, status.Code(err); want != have {
+		t.Fatalf("want %v, have %v", want, have)
+	}
+	if want, have := 1, calls; want != have {
+		t.Fatalf("handler calls: want %d, have %d", want, have)
+	}
+}
+
+type stream struct {
+	grpc.ServerStream
+}
+
+func (stream) Context() context.Context { return context.Background() }
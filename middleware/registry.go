@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"path"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// Registry dispatches the calls of a grpc.Server to interceptor chains by
+// service, so services registered on the same server get different
+// middleware, e.g. auth and rate limits for a public API and mTLS only for an
+// internal admin service.
+//
+// Chains are registered before the server starts; a Registry is not safe for
+// registration while serving.
+type Registry struct {
+	unary  map[string]grpc.UnaryServerInterceptor
+	stream map[string]grpc.StreamServerInterceptor
+}
+
+// NewRegistry returns a Registry without chains: calls go straight to their
+// handlers until chains are registered.
+func NewRegistry() *Registry {
+	return &Registry{
+		unary:  make(map[string]grpc.UnaryServerInterceptor),
+		stream: make(map[string]grpc.StreamServerInterceptor),
+	}
+}
+
+// Unary sets the chain of unary interceptors of service, a full service name
+// like "foo.v1.UserService", or "*" for services without a chain of their
+// own. Interceptors run in order like with ChainUnaryServer.
+func (r *Registry) Unary(service string, interceptors ...grpc.UnaryServerInterceptor) {
+	r.unary[service] = ChainUnaryServer(interceptors...)
+}
+
+// Stream sets the chain of streaming interceptors of service, a full service
+// name like "foo.v1.UserService", or "*" for services without a chain of
+// their own. Interceptors run in order like with ChainStreamServer.
+func (r *Registry) Stream(service string, interceptors ...grpc.StreamServerInterceptor) {
+	r.stream[service] = ChainStreamServer(interceptors...)
+}
+
+// service returns the service of a full method name.
+func service(method string) string {
+	return path.Dir(method)[1:]
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor running the
+// chain of the service of each call.
+func (r *Registry) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		chain, ok := r.unary[service(info.FullMethod)]
+		if !ok {
+			chain, ok = r.unary["*"]
+		}
+		if !ok {
+			return handler(ctx, req)
+		}
+		return chain(ctx, req, info, handler)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor running
+// the chain of the service of each stream.
+func (r *Registry) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		chain, ok := r.stream[service(info.FullMethod)]
+		if !ok {
+			chain, ok = r.stream["*"]
+		}
+		if !ok {
+			return handler(srv, stream)
+		}
+		return chain(srv, stream, info, handler)
+	}
+}
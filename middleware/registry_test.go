@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+func TestRegistry(t *testing.T) {
+	var ran []string
+	interceptor := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			ran = append(ran, name)
+			return handler(ctx, req)
+		}
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	r := NewRegistry()
+	unregistered := r.UnaryServerInterceptor()
+	if resp, err := unregistered(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.v1.Public/Get"}, handler); err != nil || resp != "ok" {
+		t.Fatalf("no chains: want ok, have %v, %v", resp, err)
+	}
+
+	r.Unary("test.v1.Public", interceptor("auth"), interceptor("ratelimit"))
+	r.Unary("test.v1.Admin", interceptor("mtls"))
+	r.Unary("*", interceptor("logging"))
+	for _, tc := range []struct {
+		method string
+		ran    []string
+	}{
+		{"/test.v1.Public/Get", []string{"auth", "ratelimit"}},
+		{"/test.v1.Admin/Reset", []string{"mtls"}},
+		{"/test.v1.PublicAdmin/Get", []string{"logging"}},
+		{"/grpc.health.v1.Health/Check", []string{"logging"}},
+	} {
+		ran = nil
+		resp, err := r.UnaryServerInterceptor()(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: tc.method}, handler)
+		if err != nil || resp != "ok" {
+			t.Fatalf("%s: want ok, have %v, %v", tc.method, resp, err)
+		}
+		if want, have := tc.ran, ran; !reflect.DeepEqual(want, have) {
+			t.Errorf("%s: want %v, have %v", tc.method, want, have)
+		}
+	}
+}
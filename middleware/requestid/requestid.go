@@ -0,0 +1,144 @@
+// Package requestid provides interceptors propagating a request ID through
+// the x-request-id metadata.
+package requestid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/ipfans/grpctools/middleware"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// MetadataKey is the metadata key carrying the request ID.
+const MetadataKey = "x-request-id"
+
+// maxLength is the length above which incoming request IDs are replaced.
+const maxLength = 128
+
+// Valid reports whether an incoming request ID can be used as is: at most 128
+// characters among letters, digits, '.', '_' and '-', so that it can't forge
+// log lines or headers.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the request ID id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID of ctx, false if it has none.
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(contextKey{}).(string)
+	return id, ok
+}
+
+// Generate returns a random 128-bit request ID in hex.
+func Generate() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+type options struct {
+	generate func() string
+}
+
+// Option for requestid interceptors.
+type Option func(o *options)
+
+// WithGenerator sets the function generating IDs of requests without one.
+// Default is Generate.
+func WithGenerator(generate func() string) Option {
+	return func(o *options) {
+		o.generate = generate
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{generate: Generate}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// incoming returns the context of a request carrying its request ID, read
+// from metadata or generated if missing or invalid, and sends the ID back in
+// the response header.
+func (o options) incoming(ctx context.Context) context.Context {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(MetadataKey); len(v) > 0 && Valid(v[0]) {
+			id = v[0]
+		}
+	}
+	if id == "" {
+		id = o.generate()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(MetadataKey, id))
+	return NewContext(ctx, id)
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor storing the
+// request ID in the context of handlers.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(o.incoming(ctx), req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor storing
+// the request ID in the context of handlers.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		wrapped := middleware.WrapServerStream(stream)
+		wrapped.SetContext(o.incoming(stream.Context()))
+		return handler(srv, wrapped)
+	}
+}
+
+// outgoing adds the request ID of ctx to the outgoing metadata, unless the
+// caller set one.
+func outgoing(ctx context.Context) context.Context {
+	id, ok := FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(MetadataKey)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, MetadataKey, id)
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor passing the
+// request ID of the context on to calls.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoing(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor returns a new streaming client interceptor passing
+// the request ID of the context on to streams.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoing(ctx), desc, cc, method, opts...)
+	}
+}
@@ -0,0 +1,74 @@
+package requestid
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func serve(ctx context.Context, opts ...Option) string {
+	var id string
+	UnaryServerInterceptor(opts...)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		id, _ = FromContext(ctx)
+		return nil, nil
+	})
+	return id
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	in := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKey, "abc"))
+	if want, have := "abc", serve(in); want != have {
+		t.Fatalf("incoming: want %q, have %q", want, have)
+	}
+
+	generate := WithGenerator(func() string { return "generated" })
+	if want, have := "generated", serve(context.Background(), generate); want != have {
+		t.Fatalf("missing: want %q, have %q", want, have)
+	}
+	long := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKey, strings.Repeat("x", maxLength+1)))
+	if want, have := "generated", serve(long, generate); want != have {
+		t.Fatalf("too long: want %q, have %q", want, have)
+	}
+
+	for _, id := range []string{"abc\ninjected=1", "a b", "<script>", "é"} {
+		invalid := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKey, id))
+		if want, have := "generated", serve(invalid, generate); want != have {
+			t.Fatalf("invalid %q: want %q, have %q", id, want, have)
+		}
+	}
+	uuid := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKey, "3f2c1d9e-0b7a-4c1e.v1_x"))
+	if want, have := "3f2c1d9e-0b7a-4c1e.v1_x", serve(uuid, generate); want != have {
+		t.Fatalf("valid: want %q, have %q", want, have)
+	}
+
+	if have := serve(context.Background()); len(have) != 32 {
+		t.Fatalf("default generator: want 32 hex digits, have %q", have)
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	var sent []string
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		sent = md.Get(MetadataKey)
+		return nil
+	}
+	interceptor := UnaryClientInterceptor()
+
+	for _, tc := range []struct {
+		ctx  context.Context
+		want []string
+	}{
+		{context.Background(), nil},
+		{NewContext(context.Background(), "abc"), []string{"abc"}},
+		{metadata.AppendToOutgoingContext(NewContext(context.Background(), "abc"), MetadataKey, "set"), []string{"set"}},
+	} {
+		interceptor(tc.ctx, "/test.Service/Get", nil, nil, nil, invoker)
+		if want, have := strings.Join(tc.want, ","), strings.Join(sent, ","); want != have {
+			t.Errorf("want %q, have %q", want, have)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package retry
+
+import (
+	"io"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// OpenFunc opens a server-streaming call resuming after cursor, "" for the
+// first call, typically by passing it in the request:
+//
+//	func(ctx context.Context, cursor string) (grpc.ClientStream, error) {
+//		return client.Watch(ctx, &pb.WatchRequest{After: cursor})
+//	}
+type OpenFunc func(ctx context.Context, cursor string) (grpc.ClientStream, error)
+
+// CursorFunc returns the cursor to resume a stream after the message m, or ""
+// if m can't be resumed after, keeping the previous cursor.
+type CursorFunc func(m interface{}) string
+
+// ResumableStream receives the messages of a server-streaming call, reopening
+// it from the cursor of the last message received when it fails.
+type ResumableStream struct {
+	ctx    context.Context
+	policy Policy
+	opts   options
+	open   OpenFunc
+	cursor CursorFunc
+
+	stream grpc.ClientStream
+	last   string
+	// failures counts the attempts failing since the last message.
+	failures int
+}
+
+// Resume opens a server-streaming call with open, and returns a stream
+// reopening it whenever it fails with a code retried by the policy set with
+// WithPolicy. Up to MaxAttempts attempts are made in a row without receiving
+// a message, waiting for the backoff of the policy in between. Method
+// policies don't apply.
+func Resume(ctx context.Context, open OpenFunc, cursor CursorFunc, opts ...Option) (*ResumableStream, error) {
+	o := newOptions(opts)
+	s := &ResumableStream{
+		ctx:    ctx,
+		policy: o.policy.withDefaults(),
+		opts:   o,
+		open:   open,
+		cursor: cursor,
+	}
+	o.deposit()
+	stream, err := open(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	s.stream = stream
+	return s, nil
+}
+
+// Cursor returns the cursor of the last message received, or "" if none
+// had one.
+func (s *ResumableStream) Cursor() string {
+	return s.last
+}
+
+// RecvMsg receives the next message into m, reopening the call if needed.
+// It returns io.EOF at the end of the stream, and the last error once the
+// call can't be resumed.
+func (s *ResumableStream) RecvMsg(m interface{}) error {
+	for {
+		var (
+			err     error
+			trailer metadata.MD
+		)
+		if s.stream == nil {
+			s.stream, err = s.open(s.ctx, s.last)
+		}
+		if err == nil {
+			err = s.stream.RecvMsg(m)
+			if err == nil {
+				if c := s.cursor(m); c != "" {
+					s.last = c
+				}
+				s.failures = 0
+				return nil
+			}
+			if err == io.EOF {
+				return err
+			}
+			trailer = s.stream.Trailer()
+		}
+		s.stream = nil
+		s.failures++
+		if s.failures >= s.policy.MaxAttempts {
+			return err
+		}
+		d, ok := delay(s.policy, s.failures-1, err, trailer)
+		if !ok || s.opts.throttled(trailer) || !s.opts.withdraw() || !sleep(s.ctx, d) {
+			return err
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package retry
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// logStream streams the events after a cursor, failing with err after
+// limit of them, unless limit is negative.
+type logStream struct {
+	grpc.ClientStream
+	next, last int
+	limit      int
+	err        error
+}
+
+func (s *logStream) Trailer() metadata.MD { return nil }
+
+func (s *logStream) RecvMsg(m interface{}) error {
+	if s.limit == 0 {
+		return s.err
+	}
+	if s.next > s.last {
+		return io.EOF
+	}
+	m.(*wrappers.StringValue).Value = strconv.Itoa(s.next)
+	s.next++
+	s.limit--
+	return nil
+}
+
+func cursor(m interface{}) string { return m.(*wrappers.StringValue).Value }
+
+// opener opens streams of events 1 to 6 after their cursor, the first
+// failing ones delivering limits[i] events before failing with err.
+func opener(limits []int, err error, cursors *[]string) OpenFunc {
+	return func(ctx context.Context, c string) (grpc.ClientStream, error) {
+		*cursors = append(*cursors, c)
+		after, _ := strconv.Atoi(c)
+		s := &logStream{next: after + 1, last: 6, limit: -1, err: err}
+		if n := len(*cursors) - 1; n < len(limits) {
+			s.limit = limits[n]
+		}
+		return s, nil
+	}
+}
+
+func TestResume(t *testing.T) {
+	var cursors []string
+	s, err := Resume(context.Background(), opener([]int{2, 0, 2}, status.Error(codes.Unavailable, "connection reset"), &cursors), cursor, WithPolicy(fast))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received []string
+	for {
+		m := &wrappers.StringValue{}
+		err := s.RecvMsg(m)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		received = append(received, m.Value)
+	}
+	if want, have := "1 2 3 4 5 6", strings.Join(received, " "); want != have {
+		t.Fatalf("received: want %q, have %q", want, have)
+	}
+	if want, have := `"" "2" "2" "4"`, quote(cursors); want != have {
+		t.Fatalf("cursors: want %s, have %s", want, have)
+	}
+	if want, have := "6", s.Cursor(); want != have {
+		t.Fatalf("cursor: want %q, have %q", want, have)
+	}
+}
+
+func TestResumeGivesUp(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		err   error
+		opens int
+	}{
+		{"no progress", status.Error(codes.Unavailable, "connection reset"), 3},
+		{"not retryable", status.Error(codes.PermissionDenied, "revoked"), 1},
+	} {
+		var cursors []string
+		s, err := Resume(context.Background(), opener([]int{0, 0, 0, 0}, tc.err, &cursors), cursor, WithPolicy(fast))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := status.Code(tc.err), status.Code(s.RecvMsg(&wrappers.StringValue{})); want != have {
+			t.Errorf("%s: want %v, have %v", tc.name, want, have)
+		}
+		if want, have := tc.opens, len(cursors); want != have {
+			t.Errorf("%s: opens: want %d, have %d", tc.name, want, have)
+		}
+	}
+}
+
+func quote(values []string) string {
+	var out []string
+	for _, v := range values {
+		out = append(out, strconv.Quote(v))
+	}
+	return strings.Join(out, " ")
+}
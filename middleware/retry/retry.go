@@ -0,0 +1,387 @@
+// Package retry provides client interceptors retrying failed calls with
+// exponential backoff.
+package retry
+
+import (
+	"io"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	grpcerrors "github.com/ipfans/grpctools/errors"
+	"github.com/ipfans/grpctools/middleware/budget"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// PushbackKey is the trailer key servers use to push back retries, holding
+// the delay in milliseconds. A negative or malformed value stops retrying.
+const PushbackKey = "grpc-retry-pushback-ms"
+
+// ThrottleKey is the trailer key overloaded servers use to ask clients to
+// stop retrying all their calls for a while, holding the duration in
+// milliseconds. See Throttle.
+const ThrottleKey = "grpc-retry-throttle-ms"
+
+// Throttle sets the ThrottleKey trailer of the call of ctx, so that clients
+// using the interceptors of this package don't retry any call for d. Servers
+// call it when rejecting requests for overload, so retries from every client
+// don't prolong it.
+func Throttle(ctx context.Context, d time.Duration) error {
+	ms := int64((d + time.Millisecond - 1) / time.Millisecond)
+	return grpc.SetTrailer(ctx, metadata.Pairs(ThrottleKey, strconv.FormatInt(ms, 10)))
+}
+
+// Policy describes how a call is retried. Zero fields take the defaults.
+type Policy struct {
+	// MaxAttempts is the number of attempts including the first one.
+	// Default is 3.
+	MaxAttempts int
+	// Codes are the status codes retried. Default is Unavailable.
+	Codes []codes.Code
+	// InitialBackoff is the delay before the first retry. Default is 100ms.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts. Default is 5s.
+	MaxBackoff time.Duration
+	// Multiplier grows the delay after each retry. Default is 2.
+	Multiplier float64
+	// Jitter randomizes each delay by up to this fraction. Default is 0.2;
+	// a negative value disables jitter.
+	Jitter float64
+}
+
+func (p Policy) withDefaults() Policy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 3
+	}
+	if len(p.Codes) == 0 {
+		p.Codes = []codes.Code{codes.Unavailable}
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = 100 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = 5 * time.Second
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = 2
+	}
+	if p.Jitter == 0 {
+		p.Jitter = 0.2
+	}
+	return p
+}
+
+func (p Policy) retryable(code codes.Code) bool {
+	for _, c := range p.Codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay before retry number retry, counting from zero.
+func (p Policy) backoff(retry int) time.Duration {
+	d := float64(p.InitialBackoff)
+	for i := 0; i < retry && d < float64(p.MaxBackoff); i++ {
+		d *= p.Multiplier
+	}
+	if d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+type options struct {
+	policy   Policy
+	methods  map[string]Policy
+	budget   *budget.Budget
+	throttle *throttle
+}
+
+// Option for retry interceptors.
+type Option func(o *options)
+
+// WithPolicy sets the policy of methods without a method policy.
+func WithPolicy(p Policy) Option {
+	return func(o *options) {
+		o.policy = p
+	}
+}
+
+// WithMethodPolicy sets policies per full method name, like
+// "/foo.v1.UserService/Get".
+func WithMethodPolicy(policies map[string]Policy) Option {
+	return func(o *options) {
+		o.methods = policies
+	}
+}
+
+// WithBudget sets the retry budget shared with other interceptors of the
+// client: calls deposit into it, and retries are only sent while it allows.
+func WithBudget(b *budget.Budget) Option {
+	return func(o *options) {
+		o.budget = b
+	}
+}
+
+// deposit records a call in the budget, if any.
+func (o options) deposit() {
+	if o.budget != nil {
+		o.budget.Deposit()
+	}
+}
+
+// withdraw reports whether the budget, if any, allows a retry.
+func (o options) withdraw() bool {
+	return o.budget == nil || o.budget.Withdraw()
+}
+
+// throttle records until when a server asked to stop retrying.
+type throttle struct {
+	until int64 // unix nanoseconds, accessed atomically
+	now   func() time.Time
+}
+
+// throttled records the ThrottleKey trailer, if any, and reports whether
+// retries are suppressed.
+func (o options) throttled(trailer metadata.MD) bool {
+	t := o.throttle
+	now := t.now()
+	if v := trailer.Get(ThrottleKey); len(v) > 0 {
+		if ms, err := strconv.Atoi(v[0]); err == nil && ms > 0 {
+			until := now.Add(time.Duration(ms) * time.Millisecond).UnixNano()
+			for {
+				cur := atomic.LoadInt64(&t.until)
+				if until <= cur || atomic.CompareAndSwapInt64(&t.until, cur, until) {
+					break
+				}
+			}
+		}
+	}
+	return now.UnixNano() < atomic.LoadInt64(&t.until)
+}
+
+func newOptions(opts []Option) options {
+	o := options{throttle: &throttle{now: time.Now}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// policyCallOption overrides the policy of a single call.
+type policyCallOption struct {
+	grpc.EmptyCallOption
+	policy Policy
+}
+
+// CallPolicy returns a CallOption overriding the policy of a call.
+func CallPolicy(p Policy) grpc.CallOption {
+	return policyCallOption{policy: p}
+}
+
+// Disable returns a CallOption making a single attempt.
+func Disable() grpc.CallOption {
+	return CallPolicy(Policy{MaxAttempts: 1})
+}
+
+// callPolicy returns the policy of a call and its options without the retry
+// options.
+func (o options) callPolicy(method string, callOpts []grpc.CallOption) (Policy, []grpc.CallOption) {
+	p, ok := o.methods[method]
+	if !ok {
+		p = o.policy
+	}
+	rest := make([]grpc.CallOption, 0, len(callOpts)+1)
+	for _, opt := range callOpts {
+		if po, ok := opt.(policyCallOption); ok {
+			p = po.policy
+			continue
+		}
+		rest = append(rest, opt)
+	}
+	return p.withDefaults(), rest
+}
+
+// delay returns how long to wait before retrying after err, and false if the
+// call must not be retried.
+func delay(p Policy, retry int, err error, trailer metadata.MD) (time.Duration, bool) {
+	s, _ := status.FromError(err)
+	if !p.retryable(s.Code()) {
+		return 0, false
+	}
+	if d, ok := grpcerrors.RetryDelay(err); ok {
+		return d, true
+	}
+	if v := trailer.Get(PushbackKey); len(v) > 0 {
+		ms, err := strconv.Atoi(v[0])
+		if err != nil || ms < 0 {
+			return 0, false
+		}
+		return time.Duration(ms) * time.Millisecond, true
+	}
+	return p.backoff(retry), true
+}
+
+// sleep waits for d, returning false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor retrying
+// failed calls. Delays pushed by the server, as a RetryInfo error detail or
+// the PushbackKey trailer, take precedence over the backoff. Once a server
+// sets the ThrottleKey trailer, no call made through the interceptor is
+// retried for the duration it asked.
+func UnaryClientInterceptor(opts ...Option) grpc.UnaryClientInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		p, callOpts := o.callPolicy(method, callOpts)
+		o.deposit()
+		for attempt := 0; ; attempt++ {
+			var trailer metadata.MD
+			err := invoker(ctx, method, req, reply, cc, append(callOpts, grpc.Trailer(&trailer))...)
+			if err == nil || attempt+1 >= p.MaxAttempts {
+				return err
+			}
+			d, ok := delay(p, attempt, err, trailer)
+			if !ok || o.throttled(trailer) || !o.withdraw() || !sleep(ctx, d) {
+				return err
+			}
+		}
+	}
+}
+
+// StreamClientInterceptor returns a new streaming client interceptor
+// retrying server-streaming calls until the first response is received.
+// Other streams are passed through, as their messages can't be replayed
+// safely. Like the unary interceptor, it honors ThrottleKey trailers.
+func StreamClientInterceptor(opts ...Option) grpc.StreamClientInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		p, callOpts := o.callPolicy(method, callOpts)
+		o.deposit()
+		if desc.ClientStreams || p.MaxAttempts <= 1 {
+			return streamer(ctx, desc, cc, method, callOpts...)
+		}
+		s := &retryStream{
+			ctx:      ctx,
+			policy:   p,
+			opts:     o,
+			open:     func() (grpc.ClientStream, error) { return streamer(ctx, desc, cc, method, callOpts...) },
+			attempts: 1,
+		}
+		cs, err := s.open()
+		if err != nil {
+			return nil, err
+		}
+		s.ClientStream = cs
+		return s, nil
+	}
+}
+
+// retryStream reopens a server-streaming call failing before its first
+// response, replaying the request.
+type retryStream struct {
+	grpc.ClientStream
+	ctx    context.Context
+	policy Policy
+	opts   options
+	open   func() (grpc.ClientStream, error)
+
+	mu       sync.Mutex
+	req      interface{}
+	closed   bool
+	received bool
+	attempts int
+}
+
+func (s *retryStream) current() grpc.ClientStream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ClientStream
+}
+
+func (s *retryStream) Header() (metadata.MD, error) { return s.current().Header() }
+
+func (s *retryStream) Trailer() metadata.MD { return s.current().Trailer() }
+
+func (s *retryStream) Context() context.Context { return s.current().Context() }
+
+func (s *retryStream) SendMsg(m interface{}) error {
+	s.mu.Lock()
+	s.req = m
+	s.mu.Unlock()
+	return s.current().SendMsg(m)
+}
+
+func (s *retryStream) CloseSend() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	return s.current().CloseSend()
+}
+
+func (s *retryStream) RecvMsg(m interface{}) error {
+	for {
+		cs := s.current()
+		err := cs.RecvMsg(m)
+		s.mu.Lock()
+		if err == nil {
+			s.received = true
+		}
+		retry := err != nil && err != io.EOF && !s.received && s.attempts < s.policy.MaxAttempts
+		attempt := s.attempts
+		s.mu.Unlock()
+		if !retry {
+			return err
+		}
+		trailer := cs.Trailer()
+		d, ok := delay(s.policy, attempt-1, err, trailer)
+		if !ok || s.opts.throttled(trailer) || !s.opts.withdraw() || !sleep(s.ctx, d) {
+			return err
+		}
+		if rerr := s.reopen(); rerr != nil {
+			return rerr
+		}
+	}
+}
+
+// reopen replaces the stream with a new attempt, resending the request.
+func (s *retryStream) reopen() error {
+	cs, err := s.open()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.ClientStream = cs
+	s.attempts++
+	req, closed := s.req, s.closed
+	s.mu.Unlock()
+	if req != nil {
+		if err := cs.SendMsg(req); err != nil {
+			return err
+		}
+	}
+	if closed {
+		return cs.CloseSend()
+	}
+	return nil
+}
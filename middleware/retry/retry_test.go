@@ -0,0 +1,213 @@
+package retry
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/ipfans/grpctools/middleware/budget"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var fast = Policy{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Jitter: -1}
+
+// failingInvoker fails the first n calls with err, setting trailer.
+func failingInvoker(n int, err error, trailer metadata.MD, calls *int) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		*calls++
+		if *calls > n {
+			return nil
+		}
+		for _, opt := range opts {
+			if t, ok := opt.(grpc.TrailerCallOption); ok {
+				*t.TrailerAddr = trailer
+			}
+		}
+		return err
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	for _, tc := range []struct {
+		name    string
+		opts    []Option
+		callOpt []grpc.CallOption
+		fails   int
+		err     error
+		trailer metadata.MD
+		calls   int
+		code    codes.Code
+	}{
+		{name: "succeeds after retries", opts: []Option{WithPolicy(fast)}, fails: 2, err: unavailable, calls: 3, code: codes.OK},
+		{name: "attempts exhausted", opts: []Option{WithPolicy(fast)}, fails: 5, err: unavailable, calls: 3, code: codes.Unavailable},
+		{name: "not retryable", opts: []Option{WithPolicy(fast)}, fails: 5, err: status.Error(codes.InvalidArgument, "bad"), calls: 1, code: codes.InvalidArgument},
+		{name: "pushback stops", opts: []Option{WithPolicy(fast)}, fails: 5, err: unavailable, trailer: metadata.Pairs(PushbackKey, "-1"), calls: 1, code: codes.Unavailable},
+		{name: "pushback delays", opts: []Option{WithPolicy(fast)}, fails: 1, err: unavailable, trailer: metadata.Pairs(PushbackKey, "1"), calls: 2, code: codes.OK},
+		{name: "method policy", opts: []Option{WithPolicy(fast), WithMethodPolicy(map[string]Policy{"/test.Service/Get": {MaxAttempts: 5, InitialBackoff: time.Millisecond}})}, fails: 4, err: unavailable, calls: 5, code: codes.OK},
+		{name: "disabled", opts: []Option{WithPolicy(fast)}, callOpt: []grpc.CallOption{Disable()}, fails: 2, err: unavailable, calls: 1, code: codes.Unavailable},
+		{name: "throttled", opts: []Option{WithPolicy(fast)}, fails: 5, err: unavailable, trailer: metadata.Pairs(ThrottleKey, "1000"), calls: 1, code: codes.Unavailable},
+		{name: "budget exhausted", opts: []Option{WithPolicy(fast), WithBudget(budget.New(0, budget.WithMinPerSecond(0)))}, fails: 2, err: unavailable, calls: 1, code: codes.Unavailable},
+		{name: "within budget", opts: []Option{WithPolicy(fast), WithBudget(budget.New(0, budget.WithMinPerSecond(1)))}, fails: 2, err: unavailable, calls: 3, code: codes.OK},
+		{name: "call policy", opts: []Option{WithPolicy(fast)}, callOpt: []grpc.CallOption{CallPolicy(Policy{MaxAttempts: 2, Codes: []codes.Code{codes.Aborted}, InitialBackoff: time.Millisecond})}, fails: 5, err: status.Error(codes.Aborted, "aborted"), calls: 2, code: codes.Aborted},
+	} {
+		var calls int
+		interceptor := UnaryClientInterceptor(tc.opts...)
+		err := interceptor(context.Background(), "/test.Service/Get", nil, nil, nil, failingInvoker(tc.fails, tc.err, tc.trailer, &calls), tc.callOpt...)
+		if want, have := tc.code, status.Code(err); want != have {
+			t.Errorf("%s: code: want %v, have %v", tc.name, want, have)
+		}
+		if want, have := tc.calls, calls; want != have {
+			t.Errorf("%s: calls: want %d, have %d", tc.name, want, have)
+		}
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	interceptor := UnaryClientInterceptor(WithPolicy(fast))
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	var calls int
+	interceptor(context.Background(), "/test.Service/Get", nil, nil, nil, failingInvoker(5, unavailable, metadata.Pairs(ThrottleKey, "1000"), &calls))
+
+	// Other calls aren't retried either while the server throttles.
+	calls = 0
+	err := interceptor(context.Background(), "/test.Service/List", nil, nil, nil, failingInvoker(5, unavailable, nil, &calls))
+	if want, have := codes.Unavailable, status.Code(err); want != have {
+		t.Fatalf("code: want %v, have %v", want, have)
+	}
+	if want, have := 1, calls; want != have {
+		t.Fatalf("calls while throttled: want %d, have %d", want, have)
+	}
+}
+
+func TestThrottleExpires(t *testing.T) {
+	now := time.Now()
+	o := newOptions(nil)
+	o.throttle.now = func() time.Time { return now }
+	if !o.throttled(metadata.Pairs(ThrottleKey, "1000")) {
+		t.Fatal("not throttled after ThrottleKey")
+	}
+	now = now.Add(time.Second)
+	if o.throttled(nil) {
+		t.Fatal("still throttled after the duration")
+	}
+}
+
+func TestRetryInfo(t *testing.T) {
+	s, err := status.New(codes.ResourceExhausted, "slow down").WithDetails(&errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(50 * time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := Policy{Codes: []codes.Code{codes.ResourceExhausted}}.withDefaults()
+	d, ok := delay(p, 0, s.Err(), nil)
+	if !ok {
+		t.Fatal("RetryInfo: not retried")
+	}
+	if want, have := 50*time.Millisecond, d; want != have {
+		t.Fatalf("RetryInfo: want %v, have %v", want, have)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := Policy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Jitter: -1}.withDefaults()
+	for retry, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		if have := p.backoff(retry); want != have {
+			t.Errorf("backoff(%d): want %v, have %v", retry, want, have)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.backoff(0); d < 50*time.Millisecond || d > 150*time.Millisecond {
+			t.Fatalf("jittered backoff: want 50ms to 150ms, have %v", d)
+		}
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var calls int
+	interceptor := UnaryClientInterceptor(WithPolicy(Policy{InitialBackoff: time.Hour}))
+	err := interceptor(ctx, "/test.Service/Get", nil, nil, nil, failingInvoker(5, status.Error(codes.Unavailable, ""), nil, &calls))
+	if want, have := codes.Unavailable, status.Code(err); want != have {
+		t.Fatalf("want %v, have %v", want, have)
+	}
+	if want, have := 1, calls; want != have {
+		t.Fatalf("calls: want %d, have %d", want, have)
+	}
+}
+
+// fakeStream fails its first RecvMsg with err unless err is nil, then sends
+// one message and io.EOF.
+type fakeStream struct {
+	grpc.ClientStream
+	err  error
+	sent []interface{}
+	recv int
+}
+
+func (s *fakeStream) SendMsg(m interface{}) error { s.sent = append(s.sent, m); return nil }
+func (s *fakeStream) CloseSend() error            { return nil }
+func (s *fakeStream) Trailer() metadata.MD        { return nil }
+
+func (s *fakeStream) RecvMsg(m interface{}) error {
+	s.recv++
+	switch {
+	case s.err != nil:
+		return s.err
+	case s.recv == 1:
+		return nil
+	}
+	return io.EOF
+}
+
+func TestStreamClientInterceptor(t *testing.T) {
+	var streams []*fakeStream
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		s := &fakeStream{}
+		if len(streams) < 2 {
+			s.err = status.Error(codes.Unavailable, "unavailable")
+		}
+		streams = append(streams, s)
+		return s, nil
+	}
+	interceptor := StreamClientInterceptor(WithPolicy(fast))
+
+	cs, err := interceptor(context.Background(), &grpc.StreamDesc{ServerStreams: true}, nil, "/test.Service/List", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.SendMsg("req")
+	cs.CloseSend()
+	if err := cs.RecvMsg(nil); err != nil {
+		t.Fatalf("first message: %v", err)
+	}
+	if want, have := io.EOF, cs.RecvMsg(nil); want != have {
+		t.Fatalf("end of stream: want %v, have %v", want, have)
+	}
+	if want, have := 3, len(streams); want != have {
+		t.Fatalf("attempts: want %d, have %d", want, have)
+	}
+	if want, have := 1, len(streams[2].sent); want != have {
+		t.Fatalf("replayed requests: want %d, have %d", want, have)
+	}
+
+	streams = nil
+	cs, err = interceptor(context.Background(), &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, nil, "/test.Service/Chat", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := codes.Unavailable, status.Code(cs.RecvMsg(nil)); want != have {
+		t.Fatalf("bidi stream: want %v, have %v", want, have)
+	}
+	if want, have := 1, len(streams); want != have {
+		t.Fatalf("bidi attempts: want %d, have %d", want, have)
+	}
+}
@@ -0,0 +1,229 @@
+// Package scheduler provides server interceptors running handlers on a bounded
+// number of workers, queueing the requests over it by priority, so the most
+// important requests are served first during overload instead of in arrival
+// order.
+package scheduler
+
+import (
+	"container/heap"
+	"sync"
+
+	"github.com/ipfans/grpctools/metrics"
+	"github.com/ipfans/grpctools/priority"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type options struct {
+	queueSize int
+	policy    priority.Policy
+	metrics   metrics.Provider
+}
+
+// Option for Scheduler instance.
+type Option func(o *options)
+
+// WithQueueSize sets how many requests wait for a worker. Requests arriving
+// at a full queue are rejected with ResourceExhausted, unless a queued
+// request has a lower priority, which is rejected in their place. Default is
+// 100.
+func WithQueueSize(n int) Option {
+	return func(o *options) {
+		o.queueSize = n
+	}
+}
+
+// WithPriorityPolicy sets how the priority of requests is resolved, unless
+// the priority interceptors ran first. Default is priority.DefaultPolicy.
+func WithPriorityPolicy(p priority.Policy) Option {
+	return func(o *options) {
+		o.policy = p
+	}
+}
+
+// WithMetrics reports workers and queue usage through given provider.
+func WithMetrics(p metrics.Provider) Option {
+	return func(o *options) {
+		o.metrics = p
+	}
+}
+
+// waiter is a request waiting for a worker.
+type waiter struct {
+	prio  priority.Priority
+	seq   uint64
+	index int // in the queue, -1 once granted or evicted
+	ready chan error
+}
+
+// waiters is a heap serving the highest priority first, in arrival order.
+type waiters []*waiter
+
+func (q waiters) Len() int { return len(q) }
+func (q waiters) Less(i, j int) bool {
+	if q[i].prio != q[j].prio {
+		return q[i].prio > q[j].prio
+	}
+	return q[i].seq < q[j].seq
+}
+func (q waiters) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+func (q *waiters) Push(x interface{}) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+func (q *waiters) Pop() interface{} {
+	old := *q
+	w := old[len(old)-1]
+	*q = old[:len(old)-1]
+	w.index = -1
+	return w
+}
+
+// Scheduler runs handlers on a bounded number of workers.
+type Scheduler struct {
+	workers   int
+	queueSize int
+	policy    priority.Policy
+
+	mu      sync.Mutex
+	running int
+	queue   waiters
+	seq     uint64
+
+	busy     metrics.Gauge
+	queued   metrics.Gauge
+	rejected metrics.Counter
+}
+
+// New returns a Scheduler running at most workers handlers at once.
+func New(workers int, opts ...Option) *Scheduler {
+	o := options{
+		queueSize: 100,
+		policy:    priority.DefaultPolicy,
+		metrics:   metrics.Discard,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Scheduler{
+		workers:   workers,
+		queueSize: o.queueSize,
+		policy:    o.policy,
+		busy:      o.metrics.NewGauge("grpc_scheduler_busy_workers", "Number of handlers running."),
+		queued:    o.metrics.NewGauge("grpc_scheduler_queued_requests", "Number of requests waiting for a worker per priority.", "priority"),
+		rejected:  o.metrics.NewCounter("grpc_scheduler_rejected_total", "Total number of requests rejected by a full queue per priority.", "priority"),
+	}
+}
+
+var errQueueFull = status.Error(codes.ResourceExhausted, "scheduler: queue is full")
+
+// acquire waits for a worker for the request of ctx, returning the function
+// releasing it.
+func (s *Scheduler) acquire(ctx context.Context) (func(), error) {
+	prio := s.policy.Resolve(ctx)
+	s.mu.Lock()
+	if s.running < s.workers && len(s.queue) == 0 {
+		s.running++
+		s.mu.Unlock()
+		s.busy.Add(1)
+		return s.release, nil
+	}
+	if len(s.queue) >= s.queueSize {
+		victim := s.last()
+		if victim == nil || victim.prio >= prio {
+			s.mu.Unlock()
+			s.rejected.With(prio.String()).Add(1)
+			return nil, errQueueFull
+		}
+		heap.Remove(&s.queue, victim.index)
+		s.queued.With(victim.prio.String()).Add(-1)
+		s.rejected.With(victim.prio.String()).Add(1)
+		victim.ready <- errQueueFull
+	}
+	s.seq++
+	w := &waiter{prio: prio, seq: s.seq, ready: make(chan error, 1)}
+	heap.Push(&s.queue, w)
+	s.queued.With(prio.String()).Add(1)
+	s.mu.Unlock()
+
+	select {
+	case err := <-w.ready:
+		if err != nil {
+			return nil, err
+		}
+		return s.release, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		if w.index >= 0 {
+			heap.Remove(&s.queue, w.index)
+			s.queued.With(prio.String()).Add(-1)
+			s.mu.Unlock()
+		} else {
+			s.mu.Unlock()
+			// Granted or evicted concurrently.
+			if err := <-w.ready; err == nil {
+				s.release()
+			}
+		}
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// last returns the queued waiter served last. s.mu must be held.
+func (s *Scheduler) last() *waiter {
+	var last *waiter
+	for _, w := range s.queue {
+		if last == nil || w.prio < last.prio || (w.prio == last.prio && w.seq > last.seq) {
+			last = w
+		}
+	}
+	return last
+}
+
+// release hands the worker of a finished handler to the first queued request.
+func (s *Scheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) == 0 {
+		s.running--
+		s.busy.Add(-1)
+		return
+	}
+	w := heap.Pop(&s.queue).(*waiter)
+	s.queued.With(w.prio.String()).Add(-1)
+	w.ready <- nil
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor running
+// handlers on the workers of s.
+func (s *Scheduler) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		release, err := s.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor running
+// handlers on the workers of s. Streams hold their worker until they end, so
+// long-lived streams are better served by their own Scheduler.
+func (s *Scheduler) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		release, err := s.acquire(stream.Context())
+		if err != nil {
+			return err
+		}
+		defer release()
+		return handler(srv, stream)
+	}
+}
@@ -0,0 +1,133 @@
+package scheduler
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfans/grpctools/priority"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// state waits until s runs running handlers and queues queued requests.
+func state(t *testing.T, s *Scheduler, running, queued int) {
+	for i := 0; i < 1000; i++ {
+		s.mu.Lock()
+		r, q := s.running, len(s.queue)
+		s.mu.Unlock()
+		if r == running && q == queued {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("want %d running and %d queued requests", running, queued)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	s := New(1, WithQueueSize(3))
+	interceptor := s.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}
+
+	release := make(chan struct{})
+	go interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+	state(t, s, 1, 0)
+
+	var (
+		mu       sync.Mutex
+		served   []priority.Priority
+		rejected []priority.Priority
+		wg       sync.WaitGroup
+	)
+	for i, prio := range []priority.Priority{priority.Low, priority.Normal, priority.Low, priority.High} {
+		wg.Add(1)
+		go func(prio priority.Priority) {
+			defer wg.Done()
+			_, err := interceptor(priority.NewContext(context.Background(), prio), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				mu.Lock()
+				served = append(served, prio)
+				mu.Unlock()
+				return nil, nil
+			})
+			if status.Code(err) == codes.ResourceExhausted {
+				mu.Lock()
+				rejected = append(rejected, prio)
+				mu.Unlock()
+			}
+		}(prio)
+		if i < 3 {
+			state(t, s, 1, i+1)
+		}
+	}
+	// The high priority request evicts the newest low priority one from the
+	// full queue.
+	for i := 0; i < 1000; i++ {
+		mu.Lock()
+		n := len(rejected)
+		mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	state(t, s, 1, 3)
+	close(release)
+	wg.Wait()
+
+	if want, have := "[high normal low]", fmt.Sprint(served); want != have {
+		t.Fatalf("served: want %s, have %s", want, have)
+	}
+	if want, have := "[low]", fmt.Sprint(rejected); want != have {
+		t.Fatalf("rejected: want %s, have %s", want, have)
+	}
+	state(t, s, 0, 0)
+
+	// A full queue of equal or higher priorities rejects newcomers.
+	release = make(chan struct{})
+	block := func(ctx context.Context, req interface{}) (interface{}, error) {
+		<-release
+		return nil, nil
+	}
+	go interceptor(context.Background(), nil, info, block)
+	state(t, s, 1, 0)
+	for i := 1; i <= 3; i++ {
+		go interceptor(context.Background(), nil, info, block)
+		state(t, s, 1, i)
+	}
+	if _, err := interceptor(priority.NewContext(context.Background(), priority.Low), nil, info, block); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("full queue: want %v, have %v", codes.ResourceExhausted, status.Code(err))
+	}
+	close(release)
+	state(t, s, 0, 0)
+}
+
+func TestCanceled(t *testing.T) {
+	s := New(1)
+	interceptor := s.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}
+	release := make(chan struct{})
+	go interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+	state(t, s, 1, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		t.Fatal("handler called")
+		return nil, nil
+	})
+	if want, have := codes.DeadlineExceeded, status.Code(err); want != have {
+		t.Fatalf("want %v, have %v", want, have)
+	}
+	state(t, s, 1, 0)
+	close(release)
+	state(t, s, 0, 0)
+}
@@ -0,0 +1,173 @@
+// Package selector wraps interceptors so they only apply to some methods,
+// e.g. to keep heavy middleware off health checks and internal methods.
+package selector
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// Info describes a call to match.
+type Info struct {
+	// FullMethod is the full method name, like "/foo.v1.UserService/Get".
+	FullMethod string
+	// Server is the service implementation. It is nil for client calls.
+	Server interface{}
+	// IsClientStream and IsServerStream are false for unary calls.
+	IsClientStream, IsServerStream bool
+}
+
+// Matcher reports whether an interceptor applies to a call.
+type Matcher func(ctx context.Context, info Info) bool
+
+// Methods matches full method names like "/foo.v1.UserService/Get", all
+// methods of a service like "/foo.v1.UserService/*", or "*".
+func Methods(patterns ...string) Matcher {
+	return func(ctx context.Context, info Info) bool {
+		for _, m := range patterns {
+			if m == "*" || m == info.FullMethod || len(m) > 2 && m[len(m)-2:] == "/*" && path.Dir(info.FullMethod) == m[:len(m)-2] {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Prefix matches full method names starting with any of prefixes, like
+// "/grpc." for the gRPC health and reflection services.
+func Prefix(prefixes ...string) Matcher {
+	return func(ctx context.Context, info Info) bool {
+		for _, p := range prefixes {
+			if strings.HasPrefix(info.FullMethod, p) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Regexp matches full method names matching re.
+func Regexp(re *regexp.Regexp) Matcher {
+	return func(ctx context.Context, info Info) bool {
+		return re.MatchString(info.FullMethod)
+	}
+}
+
+// Not matches the calls m doesn't match.
+func Not(m Matcher) Matcher {
+	return func(ctx context.Context, info Info) bool {
+		return !m(ctx, info)
+	}
+}
+
+// Any matches the calls any of matchers matches.
+func Any(matchers ...Matcher) Matcher {
+	return func(ctx context.Context, info Info) bool {
+		for _, m := range matchers {
+			if m(ctx, info) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// All matches the calls all of matchers match.
+func All(matchers ...Matcher) Matcher {
+	return func(ctx context.Context, info Info) bool {
+		for _, m := range matchers {
+			if !m(ctx, info) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor running
+// interceptor for calls m matches, and calling the handler directly for
+// others.
+func UnaryServerInterceptor(interceptor grpc.UnaryServerInterceptor, m Matcher) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if m(ctx, Info{FullMethod: info.FullMethod, Server: info.Server}) {
+			return interceptor(ctx, req, info, handler)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor running
+// interceptor for streams m matches, and calling the handler directly for
+// others.
+func StreamServerInterceptor(interceptor grpc.StreamServerInterceptor, m Matcher) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if m(stream.Context(), Info{FullMethod: info.FullMethod, Server: srv, IsClientStream: info.IsClientStream, IsServerStream: info.IsServerStream}) {
+			return interceptor(srv, stream, info, handler)
+		}
+		return handler(srv, stream)
+	}
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor running
+// interceptor for calls m matches, and invoking others directly.
+func UnaryClientInterceptor(interceptor grpc.UnaryClientInterceptor, m Matcher) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if m(ctx, Info{FullMethod: method}) {
+			return interceptor(ctx, method, req, reply, cc, invoker, opts...)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor returns a new streaming client interceptor running
+// interceptor for streams m matches, and opening others directly.
+func StreamClientInterceptor(interceptor grpc.StreamClientInterceptor, m Matcher) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if m(ctx, Info{FullMethod: method, IsClientStream: desc.ClientStreams, IsServerStream: desc.ServerStreams}) {
+			return interceptor(ctx, desc, cc, method, streamer, opts...)
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+// Infrastructure matches the calls of the gRPC health checking and server
+// reflection services, made by load balancers, probes and tools rather than
+// users.
+func Infrastructure() Matcher {
+	return Prefix("/grpc.health.v1.Health/", "/grpc.reflection.")
+}
+
+// FastPathUnaryServer returns a new unary server interceptor running fast for
+// calls m matches, like Infrastructure(), and chain for others, so cheap calls
+// skip the authentication, logging and rate limiting of chain. A nil fast
+// calls the handler directly; a minimal one usually only recovers panics.
+func FastPathUnaryServer(chain, fast grpc.UnaryServerInterceptor, m Matcher) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !m(ctx, Info{FullMethod: info.FullMethod, Server: info.Server}) {
+			return chain(ctx, req, info, handler)
+		}
+		if fast == nil {
+			return handler(ctx, req)
+		}
+		return fast(ctx, req, info, handler)
+	}
+}
+
+// FastPathStreamServer returns a new streaming server interceptor running fast
+// for streams m matches, like the health Watch streams of Infrastructure(),
+// and chain for others. A nil fast calls the handler directly.
+func FastPathStreamServer(chain, fast grpc.StreamServerInterceptor, m Matcher) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !m(stream.Context(), Info{FullMethod: info.FullMethod, Server: srv, IsClientStream: info.IsClientStream, IsServerStream: info.IsServerStream}) {
+			return chain(srv, stream, info, handler)
+		}
+		if fast == nil {
+			return handler(srv, stream)
+		}
+		return fast(srv, stream, info, handler)
+	}
+}
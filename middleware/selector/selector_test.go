@@ -0,0 +1,125 @@
+package selector
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+func TestMatchers(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		m      Matcher
+		method string
+		want   bool
+	}{
+		{"exact", Methods("/test.Service/Get"), "/test.Service/Get", true},
+		{"exact other", Methods("/test.Service/Get"), "/test.Service/List", false},
+		{"service", Methods("/test.Service/*"), "/test.Service/List", true},
+		{"service prefix", Methods("/test.Service/*"), "/test.ServiceAdmin/List", false},
+		{"all", Methods("*"), "/test.Service/Get", true},
+		{"prefix", Prefix("/grpc."), "/grpc.health.v1.Health/Check", true},
+		{"prefix other", Prefix("/grpc."), "/test.Service/Get", false},
+		{"regexp", Regexp(regexp.MustCompile(`/Internal\w*$`)), "/test.Service/InternalSync", true},
+		{"not", Not(Prefix("/grpc.")), "/grpc.health.v1.Health/Check", false},
+		{"any", Any(Methods("/a.A/X"), Prefix("/test.")), "/test.Service/Get", true},
+		{"all of", All(Prefix("/test."), Not(Methods("/test.Service/Get"))), "/test.Service/Get", false},
+		{"health", Infrastructure(), "/grpc.health.v1.Health/Watch", true},
+		{"reflection", Infrastructure(), "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo", true},
+		{"infrastructure other", Infrastructure(), "/grpc.examples.Echo/Echo", false},
+	} {
+		if have := tc.m(context.Background(), Info{FullMethod: tc.method}); tc.want != have {
+			t.Errorf("%s: %s: want %v, have %v", tc.name, tc.method, tc.want, have)
+		}
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	var intercepted bool
+	interceptor := UnaryServerInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		intercepted = true
+		return handler(ctx, req)
+	}, Not(Prefix("/grpc.health.")))
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	for _, tc := range []struct {
+		method      string
+		intercepted bool
+	}{
+		{"/test.Service/Get", true},
+		{"/grpc.health.v1.Health/Check", false},
+	} {
+		intercepted = false
+		resp, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: tc.method}, handler)
+		if err != nil || resp != "ok" {
+			t.Fatalf("%s: want ok, have %v, %v", tc.method, resp, err)
+		}
+		if want, have := tc.intercepted, intercepted; want != have {
+			t.Errorf("%s: intercepted: want %v, have %v", tc.method, want, have)
+		}
+	}
+}
+
+func TestStreamClientInterceptor(t *testing.T) {
+	var intercepted bool
+	interceptor := StreamClientInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		intercepted = true
+		return streamer(ctx, desc, cc, method, opts...)
+	}, func(ctx context.Context, info Info) bool {
+		return !info.IsClientStream
+	})
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return nil, nil
+	}
+
+	for _, tc := range []struct {
+		desc        *grpc.StreamDesc
+		intercepted bool
+	}{
+		{&grpc.StreamDesc{ServerStreams: true}, true},
+		{&grpc.StreamDesc{ClientStreams: true}, false},
+	} {
+		intercepted = false
+		interceptor(context.Background(), tc.desc, nil, "/test.Service/Watch", streamer)
+		if want, have := tc.intercepted, intercepted; want != have {
+			t.Errorf("%+v: intercepted: want %v, have %v", tc.desc, want, have)
+		}
+	}
+}
+
+func TestFastPathUnaryServer(t *testing.T) {
+	var path []string
+	step := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			path = append(path, name)
+			return handler(ctx, req)
+		}
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		path = append(path, "handler")
+		return nil, nil
+	}
+
+	for _, tc := range []struct {
+		name   string
+		fast   grpc.UnaryServerInterceptor
+		method string
+		want   string
+	}{
+		{"chain", step("recovery"), "/test.Service/Get", "[chain handler]"},
+		{"fast", step("recovery"), "/grpc.health.v1.Health/Check", "[recovery handler]"},
+		{"direct", nil, "/grpc.health.v1.Health/Check", "[handler]"},
+	} {
+		path = nil
+		interceptor := FastPathUnaryServer(step("chain"), tc.fast, Infrastructure())
+		interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: tc.method}, handler)
+		if have := fmt.Sprint(path); tc.want != have {
+			t.Errorf("%s: want %s, have %s", tc.name, tc.want, have)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Package sentry provides server interceptors reporting errors and panics to
+// Sentry.
+package sentry
+
+import (
+	"path"
+	"sync/atomic"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/ipfans/grpctools/middleware"
+	"github.com/ipfans/grpctools/middleware/logging"
+	"github.com/ipfans/grpctools/middleware/recovery"
+	"github.com/ipfans/grpctools/middleware/requestid"
+	"github.com/ipfans/grpctools/middleware/tenant"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type options struct {
+	hub      *sentry.Hub
+	codes    map[codes.Code]bool
+	redactor *logging.Redactor
+}
+
+// Option for sentry interceptors.
+type Option func(o *options)
+
+// WithHub sets the hub events are sent through. Default is
+// sentry.CurrentHub().
+func WithHub(hub *sentry.Hub) Option {
+	return func(o *options) {
+		o.hub = hub
+	}
+}
+
+// WithCodes sets the status codes of errors reported. Default is Internal and
+// Unknown.
+func WithCodes(cs ...codes.Code) Option {
+	return func(o *options) {
+		o.codes = make(map[codes.Code]bool)
+		for _, c := range cs {
+			o.codes[c] = true
+		}
+	}
+}
+
+// WithRedactor sets the redactor masking sensitive fields of requests
+// attached to events. Default masks the fields declared by messages
+// implementing logging.SensitiveFields.
+func WithRedactor(r *logging.Redactor) Option {
+	return func(o *options) {
+		o.redactor = r
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		codes:    map[codes.Code]bool{codes.Internal: true, codes.Unknown: true},
+		redactor: logging.NewRedactor(),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.hub == nil {
+		o.hub = sentry.CurrentHub()
+	}
+	return o
+}
+
+// scoped returns a hub for the request of ctx to method, its scope describing
+// the request.
+func (o options) scoped(ctx context.Context, method string, req interface{}) *sentry.Hub {
+	hub := o.hub.Clone()
+	hub.ConfigureScope(func(scope *sentry.Scope) {
+		if method != "" {
+			scope.SetTag("grpc.service", path.Dir(method)[1:])
+			scope.SetTag("grpc.method", path.Base(method))
+		}
+		if id, ok := requestid.FromContext(ctx); ok {
+			scope.SetTag("request_id", id)
+		}
+		if id := tenant.ID(ctx); id != "" {
+			scope.SetTag("tenant", id)
+		}
+		if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+			scope.SetUser(sentry.User{IPAddress: p.Addr.String()})
+		}
+		if b := o.redactor.JSON(req); b != nil {
+			scope.SetExtra("request", string(b))
+		}
+	})
+	return hub
+}
+
+// recoveredKey is the context key of the flag RecoveryHandler sets, so the
+// Internal error of a panic recovered inside the interceptors isn't reported
+// a second time.
+type recoveredKey struct{}
+
+func withRecoveredFlag(ctx context.Context) (context.Context, *int32) {
+	flag := new(int32)
+	return context.WithValue(ctx, recoveredKey{}, flag), flag
+}
+
+// report sends err to Sentry if its code is reported, unless it is a panic
+// already reported by RecoveryHandler.
+func (o options) report(ctx context.Context, method string, req interface{}, err error, recovered *int32) {
+	if err == nil || !o.codes[status.Code(err)] || atomic.LoadInt32(recovered) == 1 {
+		return
+	}
+	o.scoped(ctx, method, req).CaptureException(err)
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor reporting
+// errors of handlers, with their redacted request.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, recovered := withRecoveredFlag(ctx)
+		resp, err := handler(ctx, req)
+		o.report(ctx, info.FullMethod, req, err, recovered)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor
+// reporting errors of handlers.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, recovered := withRecoveredFlag(stream.Context())
+		wrapped := middleware.WrapServerStream(stream)
+		wrapped.SetContext(ctx)
+		err := handler(srv, wrapped)
+		o.report(ctx, info.FullMethod, nil, err, recovered)
+		return err
+	}
+}
+
+// RecoveryHandler returns a recovery.HandlerFunc reporting panics, for use
+// with recovery.WithHandler. When the recovery interceptors run inside the
+// interceptors of this package, the Internal error they turn a panic into is
+// not reported again.
+func RecoveryHandler(opts ...Option) recovery.HandlerFunc {
+	o := newOptions(opts)
+	return func(ctx context.Context, p interface{}, stack []byte) {
+		if flag, ok := ctx.Value(recoveredKey{}).(*int32); ok {
+			atomic.StoreInt32(flag, 1)
+		}
+		method, _ := grpc.Method(ctx)
+		hub := o.scoped(ctx, method, nil)
+		hub.ConfigureScope(func(scope *sentry.Scope) {
+			scope.SetExtra("stack", string(stack))
+		})
+		hub.Recover(p)
+	}
+}
@@ -0,0 +1,97 @@
+package sentry
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/ipfans/grpctools/middleware/logging"
+	"github.com/ipfans/grpctools/middleware/recovery"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// transport records the events sent.
+type transport struct {
+	mu     sync.Mutex
+	events []*sentry.Event
+}
+
+func (t *transport) Configure(sentry.ClientOptions) {}
+
+func (t *transport) Flush(time.Duration) bool { return true }
+
+func (t *transport) SendEvent(e *sentry.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, e)
+}
+
+func newHub(t *testing.T) (*sentry.Hub, *transport) {
+	tr := &transport{}
+	client, err := sentry.NewClient(sentry.ClientOptions{Transport: tr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sentry.NewHub(client, sentry.NewScope()), tr
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	hub, tr := newHub(t)
+	interceptor := UnaryServerInterceptor(WithHub(hub), WithRedactor(logging.NewRedactor("value")))
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}
+	for _, err := range []error{
+		nil,
+		status.Error(codes.NotFound, "not found"),
+		status.Error(codes.Internal, "boom"),
+	} {
+		interceptor(context.Background(), &wrappers.StringValue{Value: "secret"}, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, err
+		})
+	}
+
+	if want, have := 1, len(tr.events); want != have {
+		t.Fatalf("events: want %d, have %d", want, have)
+	}
+	e := tr.events[0]
+	if want, have := "Get", e.Tags["grpc.method"]; want != have {
+		t.Errorf("method: want %q, have %q", want, have)
+	}
+	if want, have := `"[REDACTED]"`, e.Extra["request"]; want != have {
+		t.Errorf("request: want %v, have %v", want, have)
+	}
+}
+
+func TestRecoveryHandler(t *testing.T) {
+	hub, tr := newHub(t)
+	RecoveryHandler(WithHub(hub))(context.Background(), errors.New("boom"), []byte("stack"))
+	if want, have := 1, len(tr.events); want != have {
+		t.Fatalf("events: want %d, have %d", want, have)
+	}
+	if want, have := "stack", tr.events[0].Extra["stack"]; want != have {
+		t.Fatalf("stack: want %v, have %v", want, have)
+	}
+}
+
+func TestRecoveryInsideInterceptor(t *testing.T) {
+	hub, tr := newHub(t)
+	interceptor := UnaryServerInterceptor(WithHub(hub))
+	recoverer := recovery.UnaryServerInterceptor(recovery.WithHandler(RecoveryHandler(WithHub(hub))))
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}
+	_, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return recoverer(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			panic("boom")
+		})
+	})
+	if want, have := codes.Internal, status.Code(err); want != have {
+		t.Fatalf("code: want %v, have %v", want, have)
+	}
+	if want, have := 1, len(tr.events); want != have {
+		t.Fatalf("events: want %d, have %d", want, have)
+	}
+}
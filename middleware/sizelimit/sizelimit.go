@@ -0,0 +1,156 @@
+// Package sizelimit provides server interceptors limiting the size of request
+// and response messages per method.
+package sizelimit
+
+import (
+	"os"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ipfans/grpctools/metrics"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Limits are the maximum sizes in bytes of encoded messages. Zero is no limit.
+type Limits struct {
+	Request  int
+	Response int
+}
+
+type options struct {
+	limits  Limits
+	methods map[string]Limits
+	metrics metrics.Provider
+	logger  grpclog.LoggerV2
+}
+
+// Option for sizelimit interceptors.
+type Option func(o *options)
+
+// WithLimits sets the limits of methods without method limits.
+func WithLimits(l Limits) Option {
+	return func(o *options) {
+		o.limits = l
+	}
+}
+
+// WithMethodLimits sets limits per full method name, like
+// "/foo.v1.UserService/Upload".
+func WithMethodLimits(limits map[string]Limits) Option {
+	return func(o *options) {
+		o.methods = limits
+	}
+}
+
+// WithMetrics reports breached limits through given provider.
+func WithMetrics(p metrics.Provider) Option {
+	return func(o *options) {
+		o.metrics = p
+	}
+}
+
+// WithLogger replaced built-in logger to given.
+func WithLogger(logger grpclog.LoggerV2) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+type limiter struct {
+	opts     options
+	breaches metrics.Counter
+}
+
+func newLimiter(opts []Option) *limiter {
+	o := options{
+		metrics: metrics.Discard,
+		logger:  grpclog.NewLoggerV2(os.Stdout, os.Stdout, os.Stderr),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &limiter{
+		opts:     o,
+		breaches: o.metrics.NewCounter("grpc_sizelimit_breaches_total", "Total number of messages rejected for their size.", "method", "direction"),
+	}
+}
+
+func (l *limiter) limits(method string) Limits {
+	if lim, ok := l.opts.methods[method]; ok {
+		return lim
+	}
+	return l.opts.limits
+}
+
+// check returns a ResourceExhausted error if msg is larger than max.
+func (l *limiter) check(ctx context.Context, method, direction string, msg interface{}, max int) error {
+	m, ok := msg.(proto.Message)
+	if max <= 0 || !ok {
+		return nil
+	}
+	size := proto.Size(m)
+	if size <= max {
+		return nil
+	}
+	l.breaches.With(method, direction).Add(1)
+	client := "unknown"
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		client = p.Addr.String()
+	}
+	l.opts.logger.Warningf("middleware/sizelimit: %s of %s from %s is %d bytes, above the limit of %d", direction, method, client, size, max)
+	return status.Errorf(codes.ResourceExhausted, "%s message of %d bytes exceeds the limit of %d bytes", direction, size, max)
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor rejecting
+// requests and responses above the limits with ResourceExhausted.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	l := newLimiter(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		lim := l.limits(info.FullMethod)
+		if err := l.check(ctx, info.FullMethod, "request", req, lim.Request); err != nil {
+			return nil, err
+		}
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return resp, err
+		}
+		if err := l.check(ctx, info.FullMethod, "response", resp, lim.Response); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor failing
+// RecvMsg and SendMsg for messages above the limits with ResourceExhausted.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	l := newLimiter(opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &limitedStream{ServerStream: stream, l: l, method: info.FullMethod, limits: l.limits(info.FullMethod)})
+	}
+}
+
+type limitedStream struct {
+	grpc.ServerStream
+	l      *limiter
+	method string
+	limits Limits
+}
+
+func (s *limitedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return s.l.check(s.Context(), s.method, "request", m, s.limits.Request)
+}
+
+func (s *limitedStream) SendMsg(m interface{}) error {
+	if err := s.l.check(s.Context(), s.method, "response", m, s.limits.Response); err != nil {
+		return err
+	}
+	return s.ServerStream.SendMsg(m)
+}
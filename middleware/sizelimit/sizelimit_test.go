@@ -0,0 +1,45 @@
+package sizelimit
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := UnaryServerInterceptor(
+		WithLimits(Limits{Request: 10, Response: 10}),
+		WithMethodLimits(map[string]Limits{"/test.Service/Upload": {Request: 100}}),
+		WithLogger(grpclog.NewLoggerV2(ioutil.Discard, ioutil.Discard, ioutil.Discard)),
+	)
+	echo := func(ctx context.Context, req interface{}) (interface{}, error) { return req, nil }
+	reply := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &wrappers.StringValue{Value: strings.Repeat("x", 50)}, nil
+	}
+
+	for _, tc := range []struct {
+		method  string
+		size    int
+		handler grpc.UnaryHandler
+		code    codes.Code
+	}{
+		{"/test.Service/Get", 5, echo, codes.OK},
+		{"/test.Service/Get", 50, echo, codes.ResourceExhausted},
+		{"/test.Service/Get", 5, reply, codes.ResourceExhausted},
+		{"/test.Service/Upload", 50, echo, codes.OK},
+		{"/test.Service/Upload", 50, reply, codes.OK},
+		{"/test.Service/Upload", 500, echo, codes.ResourceExhausted},
+	} {
+		_, err := interceptor(context.Background(), &wrappers.StringValue{Value: strings.Repeat("x", tc.size)}, &grpc.UnaryServerInfo{FullMethod: tc.method}, tc.handler)
+		if want, have := tc.code, status.Code(err); want != have {
+			t.Errorf("%s with %d bytes: want %v, have %v", tc.method, tc.size, want, have)
+		}
+	}
+}
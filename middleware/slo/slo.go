@@ -0,0 +1,244 @@
+// Package slo provides server interceptors classifying RPCs against service
+// level objectives and exporting their error budget burn rates, so alerts can
+// follow the multi-window burn rate recipe without recording rules.
+package slo
+
+import (
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfans/grpctools/metrics"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// buckets is the number of buckets each window is split into.
+const buckets = 60
+
+// Objective is a service level objective: Target of the RPCs are good.
+type Objective struct {
+	// Target is the ratio of good RPCs, like 0.999.
+	Target float64
+	// Latency is the duration above which unary RPCs are bad, none if 0.
+	Latency time.Duration
+	// Codes are the acceptable status codes. Default is all codes but those
+	// caused by the server: Unknown, DeadlineExceeded, Unimplemented,
+	// Internal, Unavailable and DataLoss.
+	Codes []codes.Code
+}
+
+var serverCodes = map[codes.Code]bool{
+	codes.Unknown:          true,
+	codes.DeadlineExceeded: true,
+	codes.Unimplemented:    true,
+	codes.Internal:         true,
+	codes.Unavailable:      true,
+	codes.DataLoss:         true,
+}
+
+// acceptable reports whether code is acceptable to o.
+func (o Objective) acceptable(code codes.Code) bool {
+	if o.Codes == nil {
+		return !serverCodes[code]
+	}
+	for _, c := range o.Codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+type options struct {
+	windows []time.Duration
+	metrics metrics.Provider
+}
+
+// Option for Tracker instance.
+type Option func(o *options)
+
+// WithWindows sets the windows burn rates are computed over. Default is 5
+// minutes, 1 hour and 6 hours, for fast and slow burn alerts.
+func WithWindows(windows ...time.Duration) Option {
+	return func(o *options) {
+		o.windows = windows
+	}
+}
+
+// WithMetrics exports events and burn rates through given provider.
+func WithMetrics(p metrics.Provider) Option {
+	return func(o *options) {
+		o.metrics = p
+	}
+}
+
+type bucket struct {
+	epoch     int64
+	good, bad float64
+}
+
+// window counts the events of the last d.
+type window struct {
+	d       time.Duration
+	label   string
+	buckets [buckets]bucket
+}
+
+// current returns the bucket of now, reset if it belonged to an older epoch, and
+// the sums of the window.
+func (w *window) current(now time.Time) (cur *bucket, good, bad float64) {
+	epoch := now.UnixNano() / (int64(w.d) / buckets)
+	cur = &w.buckets[epoch%buckets]
+	if cur.epoch != epoch {
+		*cur = bucket{epoch: epoch}
+	}
+	for _, b := range w.buckets {
+		if epoch-b.epoch < buckets {
+			good += b.good
+			bad += b.bad
+		}
+	}
+	return cur, good, bad
+}
+
+// slo tracks the events of an objective.
+type slo struct {
+	name      string
+	objective Objective
+
+	mu      sync.Mutex
+	windows []*window
+}
+
+// Tracker classifies RPCs against objectives per method.
+type Tracker struct {
+	slos map[string]*slo
+	now  func() time.Time
+
+	events   metrics.Counter
+	burnRate metrics.Gauge
+}
+
+// New returns a Tracker of objectives by full method name, service or "*"
+// for other methods, the names of the SLOs in metrics.
+func New(objectives map[string]Objective, opts ...Option) *Tracker {
+	o := options{
+		windows: []time.Duration{5 * time.Minute, time.Hour, 6 * time.Hour},
+		metrics: metrics.Discard,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	sort.Slice(o.windows, func(i, j int) bool { return o.windows[i] < o.windows[j] })
+	t := &Tracker{
+		slos:     make(map[string]*slo, len(objectives)),
+		now:      time.Now,
+		events:   o.metrics.NewCounter("grpc_slo_events_total", "Total number of RPCs classified against an SLO per result, good or bad.", "slo", "result"),
+		burnRate: o.metrics.NewGauge("grpc_slo_burn_rate", "Rate at which the error budget of an SLO is spent over a window, 1 spending it exactly by the end of the SLO period.", "slo", "window"),
+	}
+	for name, objective := range objectives {
+		s := &slo{name: name, objective: objective}
+		for _, d := range o.windows {
+			s.windows = append(s.windows, &window{d: d, label: d.String()})
+		}
+		t.slos[name] = s
+	}
+	return t
+}
+
+// sloOf returns the SLO of method, nil if it has none.
+func (t *Tracker) sloOf(method string) *slo {
+	if s, ok := t.slos[method]; ok {
+		return s
+	}
+	if s, ok := t.slos[path.Dir(method)[1:]]; ok {
+		return s
+	}
+	return t.slos["*"]
+}
+
+// record classifies an RPC to method which returned err after d, 0 for
+// streams.
+func (t *Tracker) record(method string, d time.Duration, err error) {
+	s := t.sloOf(method)
+	if s == nil {
+		return
+	}
+	bad := !s.objective.acceptable(status.Code(err)) || s.objective.Latency > 0 && d > s.objective.Latency
+	result := "good"
+	if bad {
+		result = "bad"
+	}
+	t.events.With(s.name, result).Add(1)
+
+	now := t.now()
+	rates := make([]float64, len(s.windows))
+	s.mu.Lock()
+	for i, w := range s.windows {
+		cur, good, badSum := w.current(now)
+		if bad {
+			cur.bad++
+			badSum++
+		} else {
+			cur.good++
+			good++
+		}
+		rates[i] = burnRate(s.objective.Target, good, badSum)
+	}
+	s.mu.Unlock()
+	for i, w := range s.windows {
+		t.burnRate.With(s.name, w.label).Set(rates[i])
+	}
+}
+
+// burnRate returns the ratio of bad events to the error budget of target.
+func burnRate(target, good, bad float64) float64 {
+	if good+bad == 0 || target >= 1 {
+		return 0
+	}
+	return bad / (good + bad) / (1 - target)
+}
+
+// BurnRate returns the burn rate of the SLO name over window, one of the
+// windows of the Tracker, 0 if there were no RPCs.
+func (t *Tracker) BurnRate(name string, window time.Duration) float64 {
+	s, ok := t.slos[name]
+	if !ok {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, w := range s.windows {
+		if w.d == window {
+			_, good, bad := w.current(t.now())
+			return burnRate(s.objective.Target, good, bad)
+		}
+	}
+	return 0
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor classifying
+// every RPC against the objective of its method.
+func (t *Tracker) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		t.record(info.FullMethod, time.Since(start), err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor
+// classifying every stream against the objective of its method by status
+// code only, as the duration of streams says nothing of their latency.
+func (t *Tracker) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := handler(srv, stream)
+		t.record(info.FullMethod, 0, err)
+		return err
+	}
+}
@@ -0,0 +1,82 @@
+package slo
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestObjective(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		objective Objective
+		code      codes.Code
+		want      bool
+	}{
+		{"ok", Objective{}, codes.OK, true},
+		{"client error", Objective{}, codes.NotFound, true},
+		{"server error", Objective{}, codes.Unavailable, false},
+		{"listed", Objective{Codes: []codes.Code{codes.OK}}, codes.OK, true},
+		{"not listed", Objective{Codes: []codes.Code{codes.OK}}, codes.NotFound, false},
+	} {
+		if have := tc.objective.acceptable(tc.code); tc.want != have {
+			t.Errorf("%s: want %v, have %v", tc.name, tc.want, have)
+		}
+	}
+}
+
+func TestBurnRate(t *testing.T) {
+	tr := New(map[string]Objective{
+		"/test.Service/Get": {Target: 0.99, Latency: 100 * time.Millisecond},
+		"test.Service":      {Target: 0.9},
+	}, WithWindows(time.Minute, time.Hour))
+	now := time.Unix(1500000000, 0)
+	tr.now = func() time.Time { return now }
+
+	// 2 bad RPCs out of 100: twice the 1% budget.
+	for i := 0; i < 97; i++ {
+		tr.record("/test.Service/Get", time.Millisecond, nil)
+	}
+	tr.record("/test.Service/Get", time.Second, nil)
+	tr.record("/test.Service/Get", time.Millisecond, status.Error(codes.Internal, "boom"))
+	tr.record("/test.Service/Get", time.Millisecond, status.Error(codes.NotFound, "no such thing"))
+	// Service objective.
+	tr.record("/test.Service/List", time.Second, status.Error(codes.Unavailable, "down"))
+	// No objective.
+	tr.record("/other.Service/Get", time.Millisecond, status.Error(codes.Internal, "boom"))
+
+	for _, tc := range []struct {
+		slo    string
+		window time.Duration
+		after  time.Duration
+		want   float64
+	}{
+		{"/test.Service/Get", time.Minute, 0, 2},
+		{"/test.Service/Get", time.Hour, 0, 2},
+		{"test.Service", time.Minute, 0, 10},
+		{"/test.Service/Get", time.Minute, 2 * time.Minute, 0},
+		{"/test.Service/Get", time.Hour, 2 * time.Minute, 2},
+	} {
+		now = time.Unix(1500000000, 0).Add(tc.after)
+		if have := tr.BurnRate(tc.slo, tc.window); math.Abs(tc.want-have) > 1e-9 {
+			t.Errorf("%s over %v after %v: want %v, have %v", tc.slo, tc.window, tc.after, tc.want, have)
+		}
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	tr := New(map[string]Objective{"*": {Target: 0.5, Latency: time.Millisecond}})
+	interceptor := tr.UnaryServerInterceptor()
+	interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		time.Sleep(5 * time.Millisecond)
+		return nil, nil
+	})
+	if want, have := 2.0, tr.BurnRate("*", 5*time.Minute); want != have {
+		t.Fatalf("want %v, have %v", want, have)
+	}
+}
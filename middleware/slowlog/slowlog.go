@@ -0,0 +1,140 @@
+// Package slowlog provides server interceptors logging RPCs slower than a
+// threshold.
+package slowlog
+
+import (
+	"os"
+	"path"
+	"time"
+
+	"github.com/ipfans/grpctools/middleware/logging"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type options struct {
+	threshold  time.Duration
+	thresholds map[string]time.Duration
+	logger     logging.Logger
+	traceLink  func(ctx context.Context) string
+	payloads   *logging.Redactor
+}
+
+// Option for slowlog interceptors.
+type Option func(o *options)
+
+// WithThreshold sets the duration above which RPCs of methods without a
+// method threshold are logged. Default is one second.
+func WithThreshold(d time.Duration) Option {
+	return func(o *options) {
+		o.threshold = d
+	}
+}
+
+// WithMethodThresholds sets thresholds per full method name, like
+// "/foo.v1.UserService/List". A negative threshold never logs the method.
+func WithMethodThresholds(thresholds map[string]time.Duration) Option {
+	return func(o *options) {
+		o.thresholds = thresholds
+	}
+}
+
+// WithLogger sets the Logger slow RPCs are logged to. Default writes to a
+// grpclog.LoggerV2.
+func WithLogger(logger logging.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithTraceLink sets a function returning a link to the trace of a request,
+// logged as "trace.link" if not empty.
+func WithTraceLink(f func(ctx context.Context) string) Option {
+	return func(o *options) {
+		o.traceLink = f
+	}
+}
+
+// WithPayloads logs the requests of slow unary RPCs as JSON, "grpc.request",
+// with sensitive fields masked by r. Requests are not logged by default.
+func WithPayloads(r *logging.Redactor) Option {
+	return func(o *options) {
+		o.payloads = r
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		threshold: time.Second,
+		logger:    logging.GRPCLogger(grpclog.NewLoggerV2(os.Stdout, os.Stdout, os.Stderr)),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// log logs the RPC to method started at start at Warn, if it was slow. req is
+// nil for streams.
+func (o options) log(ctx context.Context, method string, start time.Time, err error, req interface{}) {
+	threshold, ok := o.thresholds[method]
+	if !ok {
+		threshold = o.threshold
+	}
+	elapsed := time.Since(start)
+	if threshold < 0 || elapsed <= threshold {
+		return
+	}
+	fields := []logging.Field{
+		{Key: "grpc.service", Value: path.Dir(method)[1:]},
+		{Key: "grpc.method", Value: path.Base(method)},
+		{Key: "grpc.code", Value: status.Code(err).String()},
+		{Key: "grpc.duration", Value: elapsed},
+		{Key: "slow.threshold", Value: threshold},
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		fields = append(fields, logging.Field{Key: "peer.address", Value: p.Addr.String()})
+	}
+	if d, ok := ctx.Deadline(); ok {
+		fields = append(fields, logging.Field{Key: "grpc.deadline_remaining", Value: time.Until(d)})
+	}
+	if o.traceLink != nil {
+		if link := o.traceLink(ctx); link != "" {
+			fields = append(fields, logging.Field{Key: "trace.link", Value: link})
+		}
+	}
+	if o.payloads != nil {
+		if b := o.payloads.JSON(req); b != nil {
+			fields = append(fields, logging.Field{Key: "grpc.request", Value: string(b)})
+		}
+	}
+	o.logger.Log(ctx, logging.Warn, "slow call", fields...)
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor logging RPCs
+// slower than their threshold.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		o.log(ctx, info.FullMethod, start, err, req)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor logging
+// streams open longer than their threshold. Long-lived streams should get a
+// negative method threshold.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, stream)
+		o.log(stream.Context(), info.FullMethod, start, err, nil)
+		return err
+	}
+}
@@ -0,0 +1,62 @@
+package slowlog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/ipfans/grpctools/middleware/logging"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	var logged []map[string]interface{}
+	logger := logging.LoggerFunc(func(ctx context.Context, level logging.Level, msg string, fields ...logging.Field) {
+		if level != logging.Warn {
+			t.Errorf("level: want %v, have %v", logging.Warn, level)
+		}
+		m := make(map[string]interface{})
+		for _, f := range fields {
+			m[f.Key] = f.Value
+		}
+		logged = append(logged, m)
+	})
+	interceptor := UnaryServerInterceptor(
+		WithLogger(logger),
+		WithThreshold(5*time.Millisecond),
+		WithMethodThresholds(map[string]time.Duration{"/test.Service/Export": time.Hour, "/test.Service/Watch": -1}),
+		WithTraceLink(func(ctx context.Context) string { return "https://traces/abc" }),
+		WithPayloads(logging.NewRedactor()),
+	)
+	sleep := func(d time.Duration) grpc.UnaryHandler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			time.Sleep(d)
+			return nil, nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	for _, tc := range []struct {
+		method string
+		d      time.Duration
+		logged int
+	}{
+		{"/test.Service/Get", 0, 0},
+		{"/test.Service/Get", 10 * time.Millisecond, 1},
+		{"/test.Service/Export", 10 * time.Millisecond, 1},
+		{"/test.Service/Watch", 10 * time.Millisecond, 1},
+	} {
+		interceptor(ctx, &wrappers.StringValue{Value: "abc"}, &grpc.UnaryServerInfo{FullMethod: tc.method}, sleep(tc.d))
+		if want, have := tc.logged, len(logged); want != have {
+			t.Fatalf("%s after %v: want %d logged, have %d", tc.method, tc.d, want, have)
+		}
+	}
+
+	for _, key := range []string{"grpc.method", "grpc.duration", "grpc.deadline_remaining", "trace.link", "grpc.request"} {
+		if _, ok := logged[0][key]; !ok {
+			t.Errorf("missing field %s", key)
+		}
+	}
+}
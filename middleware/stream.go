@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// WrappedServerStream is a grpc.ServerStream whose context can be replaced,
+// so stream interceptors can pass values like auth claims or request IDs to
+// the interceptors after them and the handler.
+type WrappedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// WrapServerStream returns a WrappedServerStream of stream, with the context
+// of stream until SetContext is called.
+func WrapServerStream(stream grpc.ServerStream) *WrappedServerStream {
+	return &WrappedServerStream{ServerStream: stream, ctx: stream.Context()}
+}
+
+// Context returns the context of the stream.
+func (s *WrappedServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// SetContext replaces the context of the stream.
+func (s *WrappedServerStream) SetContext(ctx context.Context) {
+	s.ctx = ctx
+}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestWrappedServerStream(t *testing.T) {
+	stream := &backgroundStream{}
+	wrapped := WrapServerStream(stream)
+	if want, have := stream.Context(), wrapped.Context(); want != have {
+		t.Fatalf("initial context: want %v, have %v", want, have)
+	}
+	ctx := context.WithValue(stream.Context(), key("k"), "v")
+	wrapped.SetContext(ctx)
+	if want, have := ctx, wrapped.Context(); want != have {
+		t.Fatalf("context: want %v, have %v", want, have)
+	}
+	if want, have := context.Background(), stream.Context(); want != have {
+		t.Fatalf("wrapped stream context changed: want %v, have %v", want, have)
+	}
+}
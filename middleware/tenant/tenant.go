@@ -0,0 +1,250 @@
+// Package tenant provides server interceptors resolving the tenant of
+// requests in multi-tenant services and storing it in their context.
+package tenant
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/ipfans/grpctools/middleware"
+	"github.com/ipfans/grpctools/middleware/auth/jwt"
+	"github.com/ipfans/grpctools/middleware/logging"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultMetadataKey is the metadata key carrying the tenant ID by default.
+const DefaultMetadataKey = "x-tenant-id"
+
+// ErrUnknownTenant is returned by a Store for tenants it doesn't know.
+var ErrUnknownTenant = errors.New("unknown tenant")
+
+// Tenant describes the tenant of a request.
+type Tenant struct {
+	ID string
+	// Tier is the plan of the tenant, if the Store knows it.
+	Tier string
+	// Metadata holds any other attributes of the tenant.
+	Metadata map[string]string
+}
+
+// Store looks up tenants, returning ErrUnknownTenant for unknown ones.
+type Store interface {
+	Lookup(ctx context.Context, id string) (*Tenant, error)
+}
+
+// StoreFunc is an adapter to use a function as a Store.
+type StoreFunc func(ctx context.Context, id string) (*Tenant, error)
+
+// Lookup calls f.
+func (f StoreFunc) Lookup(ctx context.Context, id string) (*Tenant, error) {
+	return f(ctx, id)
+}
+
+// Extractor returns the tenant ID of a request, false if it doesn't carry
+// one. req is nil for streams.
+type Extractor func(ctx context.Context, req interface{}) (string, bool)
+
+// FromMetadata extracts the tenant ID from the incoming metadata key.
+func FromMetadata(key string) Extractor {
+	return func(ctx context.Context, req interface{}) (string, bool) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if v := md.Get(key); len(v) > 0 && v[0] != "" {
+			return v[0], true
+		}
+		return "", false
+	}
+}
+
+// FromClaim extracts the tenant ID from a string claim of the JWT a request
+// was authenticated with by the jwt interceptors, which must run first.
+func FromClaim(name string) Extractor {
+	return func(ctx context.Context, req interface{}) (string, bool) {
+		claims, ok := jwt.FromContext(ctx)
+		if !ok {
+			return "", false
+		}
+		id, ok := claims.Raw[name].(string)
+		return id, ok && id != ""
+	}
+}
+
+// FromField extracts the tenant ID from a string field of unary request
+// messages, by its proto name like "tenant_id".
+func FromField(name string) Extractor {
+	return func(ctx context.Context, req interface{}) (string, bool) {
+		v := reflect.ValueOf(req)
+		if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+			return "", false
+		}
+		v = v.Elem()
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if f.Type.Kind() != reflect.String || !hasProtoName(f.Tag.Get("protobuf"), name) {
+				continue
+			}
+			id := v.Field(i).String()
+			return id, id != ""
+		}
+		return "", false
+	}
+}
+
+// hasProtoName reports whether a protobuf struct tag names a field name.
+func hasProtoName(tag, name string) bool {
+	for _, part := range strings.Split(tag, ",") {
+		if part == "name="+name {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying t.
+func NewContext(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext returns the tenant of a request, false if it has none.
+func FromContext(ctx context.Context) (*Tenant, bool) {
+	t, ok := ctx.Value(contextKey{}).(*Tenant)
+	return t, ok
+}
+
+// ID returns the tenant ID of a request, or "" if it has none.
+func ID(ctx context.Context) string {
+	if t, ok := FromContext(ctx); ok {
+		return t.ID
+	}
+	return ""
+}
+
+// LogFields returns the "tenant.id" field of requests with a tenant, for
+// logging.WithContextFields.
+func LogFields(ctx context.Context) []logging.Field {
+	if id := ID(ctx); id != "" {
+		return []logging.Field{{Key: "tenant.id", Value: id}}
+	}
+	return nil
+}
+
+type options struct {
+	extractors []Extractor
+	store      Store
+	optional   bool
+	exempt     map[string]bool
+}
+
+// Option for tenant interceptors.
+type Option func(o *options)
+
+// WithExtractors sets the extractors of tenant IDs, tried in order. Default
+// is FromMetadata(DefaultMetadataKey).
+func WithExtractors(extractors ...Extractor) Option {
+	return func(o *options) {
+		o.extractors = extractors
+	}
+}
+
+// WithStore sets the Store tenants are validated against, rejecting unknown
+// ones with PermissionDenied. Default accepts any tenant ID.
+func WithStore(s Store) Option {
+	return func(o *options) {
+		o.store = s
+	}
+}
+
+// WithOptional serves requests without a tenant ID, instead of rejecting
+// them with InvalidArgument.
+func WithOptional() Option {
+	return func(o *options) {
+		o.optional = true
+	}
+}
+
+// WithExemptMethods sets full method names served without a tenant, like
+// health checks.
+func WithExemptMethods(methods ...string) Option {
+	return func(o *options) {
+		for _, m := range methods {
+			o.exempt[m] = true
+		}
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		extractors: []Extractor{FromMetadata(DefaultMetadataKey)},
+		exempt:     make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func (o options) resolve(ctx context.Context, method string, req interface{}) (context.Context, error) {
+	if o.exempt[method] {
+		return ctx, nil
+	}
+	var id string
+	for _, extract := range o.extractors {
+		if v, ok := extract(ctx, req); ok {
+			id = v
+			break
+		}
+	}
+	if id == "" {
+		if o.optional {
+			return ctx, nil
+		}
+		return nil, status.Error(codes.InvalidArgument, "missing tenant")
+	}
+	t := &Tenant{ID: id}
+	if o.store != nil {
+		var err error
+		t, err = o.store.Lookup(ctx, id)
+		switch {
+		case err == ErrUnknownTenant:
+			return nil, status.Errorf(codes.PermissionDenied, "unknown tenant %q", id)
+		case err != nil:
+			return nil, status.Errorf(codes.Unavailable, "looking up tenant: %v", err)
+		}
+	}
+	return NewContext(ctx, t), nil
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor storing the
+// tenant of requests in the context of handlers.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := o.resolve(ctx, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor storing
+// the tenant of streams in the context of handlers. Streams have no request
+// when the tenant is resolved, so FromField never matches them.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := o.resolve(stream.Context(), info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+		wrapped := middleware.WrapServerStream(stream)
+		wrapped.SetContext(ctx)
+		return handler(srv, wrapped)
+	}
+}
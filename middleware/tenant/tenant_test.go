@@ -0,0 +1,92 @@
+package tenant
+
+import (
+	"testing"
+
+	"github.com/ipfans/grpctools/middleware/auth/jwt"
+	"github.com/ipfans/grpctools/middleware/logging"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// getRequest is shaped like a generated message with a tenant_id field.
+type getRequest struct {
+	TenantId string `protobuf:"bytes,1,opt,name=tenant_id,json=tenantId,proto3" json:"tenant_id,omitempty"`
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	store := StoreFunc(func(ctx context.Context, id string) (*Tenant, error) {
+		if id == "acme" || id == "globex" {
+			return &Tenant{ID: id, Tier: "gold"}, nil
+		}
+		return nil, ErrUnknownTenant
+	})
+	interceptor := UnaryServerInterceptor(
+		WithExtractors(FromMetadata(DefaultMetadataKey), FromClaim("tenant"), FromField("tenant_id")),
+		WithStore(store),
+		WithExemptMethods("/grpc.health.v1.Health/Check"),
+	)
+	var have *Tenant
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		have, _ = FromContext(ctx)
+		return nil, nil
+	}
+	withClaims := jwt.NewContext(context.Background(), &jwt.Claims{Raw: map[string]interface{}{"tenant": "globex"}})
+
+	for _, tc := range []struct {
+		name   string
+		ctx    context.Context
+		req    interface{}
+		method string
+		code   codes.Code
+		tenant string
+	}{
+		{"metadata", metadata.NewIncomingContext(context.Background(), metadata.Pairs(DefaultMetadataKey, "acme")), nil, "/test.Service/Get", codes.OK, "acme"},
+		{"claim", withClaims, nil, "/test.Service/Get", codes.OK, "globex"},
+		{"field", context.Background(), &getRequest{TenantId: "acme"}, "/test.Service/Get", codes.OK, "acme"},
+		{"metadata first", metadata.NewIncomingContext(withClaims, metadata.Pairs(DefaultMetadataKey, "acme")), nil, "/test.Service/Get", codes.OK, "acme"},
+		{"unknown", metadata.NewIncomingContext(context.Background(), metadata.Pairs(DefaultMetadataKey, "initech")), nil, "/test.Service/Get", codes.PermissionDenied, ""},
+		{"missing", context.Background(), &getRequest{}, "/test.Service/Get", codes.InvalidArgument, ""},
+		{"exempt", context.Background(), nil, "/grpc.health.v1.Health/Check", codes.OK, ""},
+	} {
+		have = nil
+		_, err := interceptor(tc.ctx, tc.req, &grpc.UnaryServerInfo{FullMethod: tc.method}, handler)
+		if want, have := tc.code, status.Code(err); want != have {
+			t.Errorf("%s: code: want %v, have %v", tc.name, want, have)
+			continue
+		}
+		id := ""
+		if have != nil {
+			id = have.ID
+		}
+		if want := tc.tenant; want != id {
+			t.Errorf("%s: tenant: want %q, have %q", tc.name, want, id)
+		}
+	}
+}
+
+func TestOptional(t *testing.T) {
+	interceptor := UnaryServerInterceptor(WithOptional())
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		if _, ok := FromContext(ctx); ok {
+			t.Error("tenant set without tenant ID")
+		}
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogFields(t *testing.T) {
+	if fields := LogFields(context.Background()); len(fields) != 0 {
+		t.Fatalf("no tenant: want no fields, have %v", fields)
+	}
+	fields := LogFields(NewContext(context.Background(), &Tenant{ID: "acme"}))
+	if want, have := []logging.Field{{Key: "tenant.id", Value: "acme"}}, fields; len(have) != 1 || want[0] != have[0] {
+		t.Fatalf("fields: want %v, have %v", want, have)
+	}
+}
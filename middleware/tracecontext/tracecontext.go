@@ -0,0 +1,304 @@
+// Package tracecontext provides interceptors propagating the trace context of
+// requests through metadata, in the W3C Trace Context or Zipkin B3 formats,
+// so traces started by a mesh or a gateway continue through services that
+// don't run a full tracer.
+package tracecontext
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/ipfans/grpctools/middleware"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// SpanContext identifies a span of a trace.
+type SpanContext struct {
+	// TraceID is the 32 hex digits ID of the trace.
+	TraceID string
+	// SpanID is the 16 hex digits ID of the span.
+	SpanID string
+	// Sampled reports whether the trace is recorded.
+	Sampled bool
+	// TraceState is the vendor-specific W3C tracestate, if any.
+	TraceState string
+}
+
+// child returns a new span of the trace of sc, or of a new sampled trace if
+// sc is not valid.
+func (sc SpanContext) child() SpanContext {
+	if !validID(sc.TraceID, 32) {
+		return SpanContext{TraceID: randomID(16), SpanID: randomID(8), Sampled: true}
+	}
+	sc.SpanID = randomID(8)
+	return sc
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validID reports whether id is n lowercase hex digits, not all zeros.
+func validID(id string, n int) bool {
+	if len(id) != n || strings.Trim(id, "0") == "" {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Propagator reads and writes span contexts in metadata.
+type Propagator interface {
+	// Extract returns the span context of md, false if it has none.
+	Extract(md metadata.MD) (SpanContext, bool)
+	// Inject writes sc in md.
+	Inject(sc SpanContext, md metadata.MD)
+}
+
+// Propagators of the supported formats.
+var (
+	// W3C propagates the traceparent and tracestate headers of W3C Trace
+	// Context.
+	W3C Propagator = w3c{}
+	// B3Single propagates the single b3 header of Zipkin, used by Istio.
+	B3Single Propagator = b3Single{}
+	// B3Multi propagates the x-b3-* headers of Zipkin.
+	B3Multi Propagator = b3Multi{}
+)
+
+func first(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return strings.TrimSpace(v[0])
+	}
+	return ""
+}
+
+type w3c struct{}
+
+func (w3c) Extract(md metadata.MD) (SpanContext, bool) {
+	parts := strings.Split(first(md, "traceparent"), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 || !validID(parts[1], 32) || !validID(parts[2], 16) {
+		return SpanContext{}, false
+	}
+	return SpanContext{
+		TraceID:    parts[1],
+		SpanID:     parts[2],
+		Sampled:    flags[0]&1 == 1,
+		TraceState: strings.Join(md.Get("tracestate"), ","),
+	}, true
+}
+
+func (w3c) Inject(sc SpanContext, md metadata.MD) {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	md.Set("traceparent", "00-"+sc.TraceID+"-"+sc.SpanID+"-"+flags)
+	if sc.TraceState != "" {
+		md.Set("tracestate", sc.TraceState)
+	}
+}
+
+// b3TraceID returns the 32 hex digits form of a 16 or 32 hex digits B3 trace
+// ID, "" if it is not valid.
+func b3TraceID(id string) string {
+	if len(id) == 16 {
+		id = strings.Repeat("0", 16) + id
+	}
+	if !validID(id, 32) {
+		return ""
+	}
+	return id
+}
+
+type b3Single struct{}
+
+func (b3Single) Extract(md metadata.MD) (SpanContext, bool) {
+	// {TraceId}-{SpanId}-{SamplingState}-{ParentSpanId}, the last two being
+	// optional. A lone sampling state carries no span.
+	parts := strings.Split(first(md, "b3"), "-")
+	if len(parts) < 2 {
+		return SpanContext{}, false
+	}
+	traceID := b3TraceID(parts[0])
+	if traceID == "" || !validID(parts[1], 16) {
+		return SpanContext{}, false
+	}
+	sampled := true
+	if len(parts) > 2 {
+		sampled = parts[2] == "1" || parts[2] == "d"
+	}
+	return SpanContext{TraceID: traceID, SpanID: parts[1], Sampled: sampled}, true
+}
+
+func (b3Single) Inject(sc SpanContext, md metadata.MD) {
+	sampled := "0"
+	if sc.Sampled {
+		sampled = "1"
+	}
+	md.Set("b3", sc.TraceID+"-"+sc.SpanID+"-"+sampled)
+}
+
+type b3Multi struct{}
+
+func (b3Multi) Extract(md metadata.MD) (SpanContext, bool) {
+	traceID := b3TraceID(first(md, "x-b3-traceid"))
+	spanID := first(md, "x-b3-spanid")
+	if traceID == "" || !validID(spanID, 16) {
+		return SpanContext{}, false
+	}
+	sampled := true
+	switch first(md, "x-b3-sampled") {
+	case "0", "false":
+		sampled = false
+	}
+	if first(md, "x-b3-flags") == "1" {
+		sampled = true
+	}
+	return SpanContext{TraceID: traceID, SpanID: spanID, Sampled: sampled}, true
+}
+
+func (b3Multi) Inject(sc SpanContext, md metadata.MD) {
+	sampled := "0"
+	if sc.Sampled {
+		sampled = "1"
+	}
+	md.Set("x-b3-traceid", sc.TraceID)
+	md.Set("x-b3-spanid", sc.SpanID)
+	md.Set("x-b3-sampled", sampled)
+}
+
+// Composite returns a Propagator extracting with the first of propagators
+// finding a span context, and injecting with all of them, to interoperate
+// with services using different formats.
+func Composite(propagators ...Propagator) Propagator {
+	return composite(propagators)
+}
+
+type composite []Propagator
+
+func (c composite) Extract(md metadata.MD) (SpanContext, bool) {
+	for _, p := range c {
+		if sc, ok := p.Extract(md); ok {
+			return sc, true
+		}
+	}
+	return SpanContext{}, false
+}
+
+func (c composite) Inject(sc SpanContext, md metadata.MD) {
+	for _, p := range c {
+		p.Inject(sc, md)
+	}
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying sc.
+func NewContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+// FromContext returns the span context of ctx, false if it has none.
+func FromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(contextKey{}).(SpanContext)
+	return sc, ok
+}
+
+type options struct {
+	propagator Propagator
+}
+
+// Option for tracecontext interceptors.
+type Option func(o *options)
+
+// WithPropagator sets the format of trace context metadata, like
+// Composite(W3C, B3Multi). Default is W3C.
+func WithPropagator(p Propagator) Option {
+	return func(o *options) {
+		o.propagator = p
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{propagator: W3C}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// incoming returns ctx carrying the span of the incoming RPC, child of the
+// span of the caller if any.
+func (o options) incoming(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	parent, _ := o.propagator.Extract(md)
+	return NewContext(ctx, parent.child())
+}
+
+// outgoing returns ctx with a child of the span of ctx in outgoing metadata,
+// or ctx if it has none.
+func (o options) outgoing(ctx context.Context) context.Context {
+	sc, ok := FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	o.propagator.Inject(sc.child(), md)
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor storing the
+// span of every RPC in the context of handlers, continuing the trace of the
+// caller or starting a new one.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(o.incoming(ctx), req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor storing
+// the span of every stream in the context of handlers, continuing the trace of
+// the caller or starting a new one.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		wrapped := middleware.WrapServerStream(ss)
+		wrapped.SetContext(o.incoming(ss.Context()))
+		return handler(srv, wrapped)
+	}
+}
+
+// UnaryClientInterceptor returns a new unary client interceptor sending a
+// child of the span of the context on outgoing calls.
+func UnaryClientInterceptor(opts ...Option) grpc.UnaryClientInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		return invoker(o.outgoing(ctx), method, req, reply, cc, callOpts...)
+	}
+}
+
+// StreamClientInterceptor returns a new streaming client interceptor sending
+// a child of the span of the context on outgoing calls.
+func StreamClientInterceptor(opts ...Option) grpc.StreamClientInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(o.outgoing(ctx), desc, cc, method, callOpts...)
+	}
+}
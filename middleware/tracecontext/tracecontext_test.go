@@ -0,0 +1,82 @@
+package tracecontext
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	spanID  = "00f067aa0ba902b7"
+)
+
+func TestExtract(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		p    Propagator
+		md   metadata.MD
+		want SpanContext
+		ok   bool
+	}{
+		{"w3c", W3C, metadata.Pairs("traceparent", "00-"+traceID+"-"+spanID+"-01", "tracestate", "congo=t61rcWkgMzE"), SpanContext{traceID, spanID, true, "congo=t61rcWkgMzE"}, true},
+		{"w3c not sampled", W3C, metadata.Pairs("traceparent", "00-"+traceID+"-"+spanID+"-00"), SpanContext{traceID, spanID, false, ""}, true},
+		{"w3c zero trace", W3C, metadata.Pairs("traceparent", "00-00000000000000000000000000000000-"+spanID+"-01"), SpanContext{}, false},
+		{"w3c invalid version", W3C, metadata.Pairs("traceparent", "ff-"+traceID+"-"+spanID+"-01"), SpanContext{}, false},
+		{"b3 single", B3Single, metadata.Pairs("b3", traceID+"-"+spanID+"-1-"+spanID), SpanContext{traceID, spanID, true, ""}, true},
+		{"b3 single 64-bit", B3Single, metadata.Pairs("b3", "a3ce929d0e0e4736-"+spanID+"-0"), SpanContext{"0000000000000000a3ce929d0e0e4736", spanID, false, ""}, true},
+		{"b3 single deny only", B3Single, metadata.Pairs("b3", "0"), SpanContext{}, false},
+		{"b3 multi", B3Multi, metadata.Pairs("x-b3-traceid", traceID, "x-b3-spanid", spanID, "x-b3-sampled", "0"), SpanContext{traceID, spanID, false, ""}, true},
+		{"b3 multi debug", B3Multi, metadata.Pairs("x-b3-traceid", traceID, "x-b3-spanid", spanID, "x-b3-sampled", "0", "x-b3-flags", "1"), SpanContext{traceID, spanID, true, ""}, true},
+		{"composite", Composite(W3C, B3Multi), metadata.Pairs("x-b3-traceid", traceID, "x-b3-spanid", spanID), SpanContext{traceID, spanID, true, ""}, true},
+	} {
+		sc, ok := tc.p.Extract(tc.md)
+		if want, have := tc.ok, ok; want != have {
+			t.Fatalf("%s: want %v, have %v", tc.name, want, have)
+		}
+		if want, have := tc.want, sc; want != have {
+			t.Fatalf("%s: want %+v, have %+v", tc.name, want, have)
+		}
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	opts := []Option{WithPropagator(Composite(B3Single, B3Multi))}
+	server := UnaryServerInterceptor(opts...)
+	client := UnaryClientInterceptor(opts...)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("b3", traceID+"-"+spanID+"-1"))
+	var sent metadata.MD
+	_, err := server(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		sc, ok := FromContext(ctx)
+		if !ok || sc.TraceID != traceID || sc.SpanID == spanID || !sc.Sampled {
+			t.Fatalf("server span: have %+v", sc)
+		}
+		return nil, client(ctx, "/test.Other/Get", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			sent, _ = metadata.FromOutgoingContext(ctx)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	single, ok := B3Single.Extract(sent)
+	if !ok || single.TraceID != traceID {
+		t.Fatalf("b3: have %+v", single)
+	}
+	multi, ok := B3Multi.Extract(sent)
+	if want, have := single, multi; !ok || want != have {
+		t.Fatalf("x-b3: want %+v, have %+v", want, have)
+	}
+
+	// Requests without a trace start one.
+	server(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		sc, _ := FromContext(ctx)
+		if !validID(sc.TraceID, 32) || !validID(sc.SpanID, 16) {
+			t.Fatalf("new trace: have %+v", sc)
+		}
+		return nil, nil
+	})
+}
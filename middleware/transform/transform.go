@@ -0,0 +1,104 @@
+// Package transform provides server interceptors running registered functions
+// on decoded requests before handlers and on responses after them, for
+// concerns like filling defaults, normalizing units or shimming legacy fields.
+package transform
+
+import (
+	"path"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// Func mutates a decoded message in place. Errors are returned to the client,
+// so they should be status errors, like InvalidArgument for requests that
+// can't be normalized.
+type Func func(ctx context.Context, msg interface{}) error
+
+// Transformer holds the functions of requests and responses. Functions are
+// registered before serving; a Transformer is not safe for registration while
+// serving.
+type Transformer struct {
+	requests  map[string][]Func
+	responses map[string][]Func
+}
+
+// New returns a Transformer without functions.
+func New() *Transformer {
+	return &Transformer{
+		requests:  make(map[string][]Func),
+		responses: make(map[string][]Func),
+	}
+}
+
+// Request registers f on the requests of pattern: a full method name, like
+// "/foo.v1.UserService/Create", a service, like "foo.v1.UserService", or "*"
+// for every method.
+func (t *Transformer) Request(pattern string, f Func) {
+	t.requests[pattern] = append(t.requests[pattern], f)
+}
+
+// Response registers f on the responses of pattern, like Request.
+func (t *Transformer) Response(pattern string, f Func) {
+	t.responses[pattern] = append(t.responses[pattern], f)
+}
+
+// apply runs the functions of method in funcs on msg: those of "*" first, then
+// of its service, then of the method itself, each in registration order.
+func apply(funcs map[string][]Func, ctx context.Context, method string, msg interface{}) error {
+	for _, pattern := range []string{"*", path.Dir(method)[1:], method} {
+		for _, f := range funcs[pattern] {
+			if err := f(ctx, msg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor transforming
+// requests before handlers, and the responses of successful calls after them.
+func (t *Transformer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := apply(t.requests, ctx, info.FullMethod, req); err != nil {
+			return nil, err
+		}
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return resp, err
+		}
+		if err := apply(t.responses, ctx, info.FullMethod, resp); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor
+// transforming each message received before the handler gets it, and each
+// message sent before it is.
+func (t *Transformer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &transformedStream{ServerStream: stream, t: t, method: info.FullMethod})
+	}
+}
+
+type transformedStream struct {
+	grpc.ServerStream
+	t      *Transformer
+	method string
+}
+
+func (s *transformedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return apply(s.t.requests, s.Context(), s.method, m)
+}
+
+func (s *transformedStream) SendMsg(m interface{}) error {
+	if err := apply(s.t.responses, s.Context(), s.method, m); err != nil {
+		return err
+	}
+	return s.ServerStream.SendMsg(m)
+}
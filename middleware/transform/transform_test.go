@@ -0,0 +1,117 @@
+package transform
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// appendValue returns a Func appending s to string messages.
+func appendValue(s string) Func {
+	return func(ctx context.Context, msg interface{}) error {
+		m := msg.(*wrappers.StringValue)
+		m.Value += s
+		return nil
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	tr := New()
+	tr.Request("/test.Service/Get", appendValue("+method"))
+	tr.Request("test.Service", appendValue("+service"))
+	tr.Request("*", appendValue("+all"))
+	tr.Request("/test.Service/Create", func(ctx context.Context, msg interface{}) error {
+		if msg.(*wrappers.StringValue).Value == "" {
+			return status.Error(codes.InvalidArgument, "missing name")
+		}
+		return nil
+	})
+	tr.Response("/test.Service/Get", func(ctx context.Context, msg interface{}) error {
+		m := msg.(*wrappers.StringValue)
+		m.Value = strings.ToUpper(m.Value)
+		return nil
+	})
+	interceptor := tr.UnaryServerInterceptor()
+	echo := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &wrappers.StringValue{Value: req.(*wrappers.StringValue).Value}, nil
+	}
+
+	for _, tc := range []struct {
+		method string
+		req    string
+		code   codes.Code
+		resp   string
+	}{
+		{"/test.Service/Get", "req", codes.OK, "REQ+ALL+SERVICE+METHOD"},
+		{"/test.Service/List", "req", codes.OK, "req+all+service"},
+		{"/other.Service/Get", "req", codes.OK, "req+all"},
+		{"/test.Service/Create", "", codes.OK, "+all+service"},
+	} {
+		resp, err := interceptor(context.Background(), &wrappers.StringValue{Value: tc.req}, &grpc.UnaryServerInfo{FullMethod: tc.method}, echo)
+		if want, have := tc.code, status.Code(err); want != have {
+			t.Errorf("%s: code: want %v, have %v", tc.method, want, have)
+			continue
+		}
+		if want, have := tc.resp, resp.(*wrappers.StringValue).Value; want != have {
+			t.Errorf("%s: want %q, have %q", tc.method, want, have)
+		}
+	}
+}
+
+func TestRequestError(t *testing.T) {
+	tr := New()
+	tr.Request("/test.Service/Create", func(ctx context.Context, msg interface{}) error {
+		return status.Error(codes.InvalidArgument, "unknown unit")
+	})
+	called := false
+	_, err := tr.UnaryServerInterceptor()(context.Background(), &wrappers.StringValue{}, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Create"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		called = true
+		return nil, nil
+	})
+	if want, have := codes.InvalidArgument, status.Code(err); want != have {
+		t.Fatalf("want %v, have %v", want, have)
+	}
+	if called {
+		t.Fatal("handler called")
+	}
+}
+
+type testStream struct {
+	grpc.ServerStream
+	sent []string
+}
+
+func (s *testStream) Context() context.Context { return context.Background() }
+func (s *testStream) RecvMsg(m interface{}) error {
+	m.(*wrappers.StringValue).Value = "in"
+	return nil
+}
+func (s *testStream) SendMsg(m interface{}) error {
+	s.sent = append(s.sent, m.(*wrappers.StringValue).Value)
+	return nil
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	tr := New()
+	tr.Request("*", appendValue("+req"))
+	tr.Response("*", appendValue("+resp"))
+	stream := &testStream{}
+	err := tr.StreamServerInterceptor()(nil, stream, &grpc.StreamServerInfo{FullMethod: "/test.Service/Chat"}, func(srv interface{}, stream grpc.ServerStream) error {
+		m := &wrappers.StringValue{}
+		if err := stream.RecvMsg(m); err != nil {
+			return err
+		}
+		return stream.SendMsg(m)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "in+req+resp", strings.Join(stream.sent, ","); want != have {
+		t.Fatalf("want %q, have %q", want, have)
+	}
+}
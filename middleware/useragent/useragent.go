@@ -0,0 +1,203 @@
+// Package useragent provides server interceptors identifying the client
+// application of requests by its user agent, exposing it to handlers and
+// metrics, and rejecting client versions no longer supported.
+package useragent
+
+import (
+	"fmt"
+	"strings"
+
+	grpcerrors "github.com/ipfans/grpctools/errors"
+	"github.com/ipfans/grpctools/metrics"
+	"github.com/ipfans/grpctools/middleware"
+	"github.com/ipfans/grpctools/middleware/apiversion"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Metadata keys identifying clients by a product like "shop-ios/2.3.1".
+// ClientKey takes precedence over the user agent, which proxies may rewrite.
+const (
+	UserAgentKey = "user-agent"
+	ClientKey    = "x-client-version"
+)
+
+// ViolationType is the type of the PreconditionFailure violation of clients
+// too old, whose subject is the client name and description the minimum
+// version.
+const ViolationType = "CLIENT_VERSION"
+
+// Client is the application a request comes from.
+type Client struct {
+	Name    string
+	Version apiversion.Version
+}
+
+func (c Client) String() string {
+	return c.Name + "/" + c.Version.String()
+}
+
+// Parse parses the first product of a user agent, like "shop-ios/2.3.1" of
+// "shop-ios/2.3.1 grpc-go/1.29.1". Pre-release and build suffixes of versions,
+// like "-beta.1", are ignored. It returns false if the product has no valid
+// version.
+func Parse(ua string) (Client, bool) {
+	fields := strings.Fields(ua)
+	if len(fields) == 0 {
+		return Client{}, false
+	}
+	i := strings.LastIndex(fields[0], "/")
+	if i <= 0 {
+		return Client{}, false
+	}
+	version := fields[0][i+1:]
+	if j := strings.IndexAny(version, "-+"); j >= 0 {
+		version = version[:j]
+	}
+	v, err := apiversion.Parse(version)
+	if err != nil {
+		return Client{}, false
+	}
+	return Client{Name: fields[0][:i], Version: v}, true
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying c.
+func NewContext(ctx context.Context, c Client) context.Context {
+	return context.WithValue(ctx, contextKey{}, c)
+}
+
+// FromContext returns the client of a request, false if it wasn't identified.
+func FromContext(ctx context.Context) (Client, bool) {
+	c, ok := ctx.Value(contextKey{}).(Client)
+	return c, ok
+}
+
+// MinimumVersion returns the minimum version of the FailedPrecondition error
+// a server rejected a client too old with, false if err isn't one.
+func MinimumVersion(err error) (apiversion.Version, bool) {
+	if status.Code(err) != codes.FailedPrecondition {
+		return apiversion.Version{}, false
+	}
+	for _, d := range status.Convert(err).Details() {
+		pf, ok := d.(*errdetails.PreconditionFailure)
+		if !ok {
+			continue
+		}
+		for _, v := range pf.Violations {
+			if v.Type != ViolationType {
+				continue
+			}
+			if min, err := apiversion.Parse(v.Description); err == nil {
+				return min, true
+			}
+		}
+	}
+	return apiversion.Version{}, false
+}
+
+type options struct {
+	minimums map[string]apiversion.Version
+	metrics  metrics.Provider
+}
+
+// Option for useragent interceptors.
+type Option func(o *options)
+
+// WithMinimums sets the oldest version served per client name, like
+// {"shop-ios": 2.0.0}. Other clients, and requests not identifying theirs,
+// are served.
+func WithMinimums(minimums map[string]apiversion.Version) Option {
+	return func(o *options) {
+		o.minimums = minimums
+	}
+}
+
+// WithMetrics counts requests per client name and version through given
+// provider.
+func WithMetrics(p metrics.Provider) Option {
+	return func(o *options) {
+		o.metrics = p
+	}
+}
+
+type identifier struct {
+	minimums map[string]apiversion.Version
+	requests metrics.Counter
+}
+
+func newIdentifier(opts []Option) *identifier {
+	o := options{metrics: metrics.Discard}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &identifier{
+		minimums: o.minimums,
+		requests: o.metrics.NewCounter("grpc_server_client_requests_total", "Total number of requests received per client application and version.", "client", "version"),
+	}
+}
+
+// identify returns ctx with the client of its request, or an error if the
+// client is too old.
+func (id *identifier) identify(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var c Client
+	ok := false
+	for _, key := range []string{ClientKey, UserAgentKey} {
+		if values := md.Get(key); len(values) > 0 {
+			if c, ok = Parse(values[0]); ok {
+				break
+			}
+		}
+	}
+	if !ok {
+		return ctx, nil
+	}
+	id.requests.With(c.Name, c.Version.String()).Add(1)
+	if min, ok := id.minimums[c.Name]; ok && c.Version.Less(min) {
+		return nil, grpcerrors.New(codes.FailedPrecondition, fmt.Sprintf("useragent: %s %s is no longer supported, upgrade to %s or later", c.Name, c.Version, min)).
+			Detail(&errdetails.PreconditionFailure{Violations: []*errdetails.PreconditionFailure_Violation{{
+				Type:        ViolationType,
+				Subject:     c.Name,
+				Description: min.String(),
+			}}}).
+			Err()
+	}
+	return NewContext(ctx, c), nil
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor storing the
+// client of requests in the context, and rejecting clients older than their
+// minimum version with FailedPrecondition and a PreconditionFailure detail
+// holding the minimum.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	id := newIdentifier(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := id.identify(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor storing
+// the client of streams in the context and rejecting clients too old, like
+// UnaryServerInterceptor.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	id := newIdentifier(opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := id.identify(stream.Context())
+		if err != nil {
+			return err
+		}
+		wrapped := middleware.WrapServerStream(stream)
+		wrapped.SetContext(ctx)
+		return handler(srv, wrapped)
+	}
+}
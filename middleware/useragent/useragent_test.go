@@ -0,0 +1,78 @@
+package useragent
+
+import (
+	"testing"
+
+	"github.com/ipfans/grpctools/middleware/apiversion"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		ua   string
+		want string
+		ok   bool
+	}{
+		{"shop-ios/2.3.1 grpc-go/1.29.1", "shop-ios/2.3.1", true},
+		{"shop-web/3.1-beta.2", "shop-web/3.1.0", true},
+		{"grpc-go/1.29.1", "grpc-go/1.29.1", true},
+		{"curl", "", false},
+		{"shop-ios/latest", "", false},
+		{"", "", false},
+	} {
+		c, ok := Parse(tc.ua)
+		if want, have := tc.ok, ok; want != have {
+			t.Errorf("%q: ok: want %v, have %v", tc.ua, want, have)
+			continue
+		}
+		if ok && tc.want != c.String() {
+			t.Errorf("%q: want %s, have %s", tc.ua, tc.want, c)
+		}
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := UnaryServerInterceptor(WithMinimums(map[string]apiversion.Version{"shop-ios": apiversion.MustParse("2.0")}))
+	var have Client
+	var identified bool
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		have, identified = FromContext(ctx)
+		return nil, nil
+	}
+
+	for _, tc := range []struct {
+		md     metadata.MD
+		code   codes.Code
+		client string
+	}{
+		{metadata.Pairs(UserAgentKey, "shop-ios/2.3.1 grpc-go/1.29.1"), codes.OK, "shop-ios/2.3.1"},
+		{metadata.Pairs(UserAgentKey, "shop-ios/1.9.0 grpc-go/1.29.1"), codes.FailedPrecondition, ""},
+		{metadata.Pairs(UserAgentKey, "grpc-go/1.29.1", ClientKey, "shop-ios/2.0.0"), codes.OK, "shop-ios/2.0.0"},
+		{metadata.Pairs(UserAgentKey, "shop-android/1.0.0"), codes.OK, "shop-android/1.0.0"},
+		{metadata.Pairs(UserAgentKey, "curl"), codes.OK, ""},
+	} {
+		have, identified = Client{}, false
+		ctx := metadata.NewIncomingContext(context.Background(), tc.md)
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, handler)
+		if want, have := tc.code, status.Code(err); want != have {
+			t.Errorf("%v: code: want %v, have %v", tc.md, want, have)
+			continue
+		}
+		if err != nil {
+			if min, ok := MinimumVersion(err); !ok || min != apiversion.MustParse("2.0") {
+				t.Errorf("%v: minimum: want 2.0.0, have %v (%v)", tc.md, min, ok)
+			}
+			continue
+		}
+		if want, have := tc.client != "", identified; want != have {
+			t.Errorf("%v: identified: want %v, have %v", tc.md, want, have)
+		}
+		if identified && tc.client != have.String() {
+			t.Errorf("%v: want %s, have %s", tc.md, tc.client, have)
+		}
+	}
+}
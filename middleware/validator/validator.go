@@ -0,0 +1,191 @@
+// Package validator provides server interceptors validating requests with the
+// Validate methods generated by protoc-gen-validate.
+package validator
+
+import (
+	"reflect"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// allValidator is implemented by messages generated by protoc-gen-validate
+// with all violations reported.
+type allValidator interface {
+	ValidateAll() error
+}
+
+type validator interface {
+	Validate() error
+}
+
+// fieldError is implemented by the violations of protoc-gen-validate.
+type fieldError interface {
+	error
+	Field() string
+	Reason() string
+	Cause() error
+}
+
+// multiError is implemented by the errors of ValidateAll.
+type multiError interface {
+	AllErrors() []error
+}
+
+// protoViolation is implemented by the Violation messages of protovalidate.
+type protoViolation interface {
+	GetFieldPath() string
+	GetMessage() string
+}
+
+// ValidateFunc validates a message, returning nil for messages it doesn't
+// handle.
+type ValidateFunc func(msg interface{}) error
+
+type options struct {
+	validate ValidateFunc
+}
+
+// Option for validator interceptors.
+type Option func(o *options)
+
+// WithValidateFunc sets how messages are validated, e.g. to use a
+// protovalidate Validator. Default is Validate.
+func WithValidateFunc(f ValidateFunc) Option {
+	return func(o *options) {
+		o.validate = f
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{validate: Validate}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Validate calls ValidateAll, or else Validate, of msg if it has one.
+func Validate(msg interface{}) error {
+	switch v := msg.(type) {
+	case allValidator:
+		return v.ValidateAll()
+	case validator:
+		return v.Validate()
+	}
+	return nil
+}
+
+// Error converts a validation error into an InvalidArgument status with a
+// BadRequest detail listing the field violations, of protoc-gen-validate or
+// protovalidate.
+func Error(err error) error {
+	br := &errdetails.BadRequest{}
+	if vs, ok := protoViolations(err); ok {
+		br.FieldViolations = vs
+	} else {
+		var errs []error
+		if m, ok := err.(multiError); ok {
+			errs = m.AllErrors()
+		} else {
+			errs = []error{err}
+		}
+		for _, e := range errs {
+			br.FieldViolations = append(br.FieldViolations, violation(e))
+		}
+	}
+	s, derr := status.New(codes.InvalidArgument, err.Error()).WithDetails(br)
+	if derr != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return s.Err()
+}
+
+// violation converts a violation, joining the fields of nested messages with
+// dots.
+func violation(err error) *errdetails.BadRequest_FieldViolation {
+	var fields []string
+	for {
+		fe, ok := err.(fieldError)
+		if !ok {
+			break
+		}
+		fields = append(fields, fe.Field())
+		cause := fe.Cause()
+		if _, ok := cause.(fieldError); !ok {
+			return &errdetails.BadRequest_FieldViolation{Field: strings.Join(fields, "."), Description: fe.Reason()}
+		}
+		err = cause
+	}
+	return &errdetails.BadRequest_FieldViolation{Field: strings.Join(fields, "."), Description: err.Error()}
+}
+
+// protoViolations converts the Violations of a protovalidate ValidationError,
+// held directly or in the Proto field of its violations depending on the
+// version. They are found by reflection, so that this package doesn't depend
+// on protovalidate.
+func protoViolations(err error) ([]*errdetails.BadRequest_FieldViolation, bool) {
+	v := reflect.Indirect(reflect.ValueOf(err))
+	if v.Kind() != reflect.Struct {
+		return nil, false
+	}
+	list := v.FieldByName("Violations")
+	if !list.IsValid() || list.Kind() != reflect.Slice {
+		return nil, false
+	}
+	var out []*errdetails.BadRequest_FieldViolation
+	for i := 0; i < list.Len(); i++ {
+		el := list.Index(i)
+		pv, ok := el.Interface().(protoViolation)
+		if !ok {
+			if p := reflect.Indirect(el).FieldByName("Proto"); p.IsValid() {
+				pv, ok = p.Interface().(protoViolation)
+			}
+		}
+		if !ok {
+			return nil, false
+		}
+		out = append(out, &errdetails.BadRequest_FieldViolation{Field: pv.GetFieldPath(), Description: pv.GetMessage()})
+	}
+	return out, len(out) > 0
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor rejecting
+// invalid requests with InvalidArgument.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := o.validate(req); err != nil {
+			return nil, Error(err)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor failing
+// RecvMsg with InvalidArgument for invalid messages.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &validatingStream{ServerStream: stream, validate: o.validate})
+	}
+}
+
+type validatingStream struct {
+	grpc.ServerStream
+	validate ValidateFunc
+}
+
+func (s *validatingStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if err := s.validate(m); err != nil {
+		return Error(err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package validator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fieldErr mimics the violations generated by protoc-gen-validate.
+type fieldErr struct {
+	field, reason string
+	cause         error
+}
+
+func (e fieldErr) Field() string  { return e.field }
+func (e fieldErr) Reason() string { return e.reason }
+func (e fieldErr) Cause() error   { return e.cause }
+func (e fieldErr) Error() string  { return fmt.Sprintf("invalid %s: %s", e.field, e.reason) }
+
+type multiErr []error
+
+func (m multiErr) Error() string {
+	msgs := make([]string, len(m))
+	for i, e := range m {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (m multiErr) AllErrors() []error { return m }
+
+type request struct{ err error }
+
+func (r request) Validate() error    { return errors.New("Validate called instead of ValidateAll") }
+func (r request) ValidateAll() error { return r.err }
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Create"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+
+	if _, err := interceptor(context.Background(), request{}, info, handler); err != nil {
+		t.Fatalf("valid: %v", err)
+	}
+	if _, err := interceptor(context.Background(), "not validated", info, handler); err != nil {
+		t.Fatalf("no Validate method: %v", err)
+	}
+
+	invalid := request{multiErr{
+		fieldErr{field: "Name", reason: "value length must be at least 1 runes"},
+		fieldErr{field: "Address", reason: "embedded message failed validation", cause: fieldErr{field: "Zip", reason: "value does not match regex"}},
+	}}
+	_, err := interceptor(context.Background(), invalid, info, handler)
+	s := status.Convert(err)
+	if want, have := codes.InvalidArgument, s.Code(); want != have {
+		t.Fatalf("invalid: want %v, have %v", want, have)
+	}
+	if want, have := 1, len(s.Details()); want != have {
+		t.Fatalf("details: want %d, have %d", want, have)
+	}
+	br := s.Details()[0].(*errdetails.BadRequest)
+	for i, want := range []string{"Name: value length must be at least 1 runes", "Address.Zip: value does not match regex"} {
+		v := br.FieldViolations[i]
+		if have := v.Field + ": " + v.Description; want != have {
+			t.Errorf("violation %d: want %q, have %q", i, want, have)
+		}
+	}
+}
+
+// pvViolation and validationError mimic the errors of protovalidate.
+type pvViolation struct{ path, message string }
+
+func (v *pvViolation) GetFieldPath() string { return v.path }
+func (v *pvViolation) GetMessage() string   { return v.message }
+
+type validationError struct {
+	Violations []*pvViolation
+}
+
+func (e *validationError) Error() string { return "validation error" }
+
+func TestProtovalidateViolations(t *testing.T) {
+	interceptor := UnaryServerInterceptor(WithValidateFunc(func(msg interface{}) error {
+		return &validationError{Violations: []*pvViolation{
+			{"name", "value length must be at least 1 characters"},
+			{"address.zip", "value does not match regex pattern"},
+		}}
+	}))
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Create"}
+	_, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil })
+	s := status.Convert(err)
+	if want, have := codes.InvalidArgument, s.Code(); want != have {
+		t.Fatalf("invalid: want %v, have %v", want, have)
+	}
+	br := s.Details()[0].(*errdetails.BadRequest)
+	if want, have := 2, len(br.FieldViolations); want != have {
+		t.Fatalf("violations: want %d, have %d", want, have)
+	}
+	for i, want := range []string{"name: value length must be at least 1 characters", "address.zip: value does not match regex pattern"} {
+		v := br.FieldViolations[i]
+		if have := v.Field + ": " + v.Description; want != have {
+			t.Errorf("violation %d: want %q, have %q", i, want, have)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Package priority defines the request priority convention shared by
+// grpctools middlewares.
+//
+// Clients send the priority they claim in the MetadataKey metadata, either as
+// a name ("low", "normal", "high", "critical") or as a number. Servers never
+// trust the claim as is: a Policy clamps it to what the caller is allowed to
+// use before rate limiters and load shedders act on it.
+package priority
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ipfans/grpctools/middleware"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// MetadataKey is the metadata key carrying the request priority.
+const MetadataKey = "x-request-priority"
+
+// Priority of a request. Higher values are more important and are the last
+// to be throttled or shed.
+type Priority int
+
+// Well-known priorities.
+const (
+	Low      Priority = 0
+	Normal   Priority = 1
+	High     Priority = 2
+	Critical Priority = 3
+)
+
+var names = map[Priority]string{
+	Low:      "low",
+	Normal:   "normal",
+	High:     "high",
+	Critical: "critical",
+}
+
+func (p Priority) String() string {
+	if name, ok := names[p]; ok {
+		return name
+	}
+	return strconv.Itoa(int(p))
+}
+
+// Parse parses a priority name or number.
+func Parse(s string) (Priority, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for p, name := range names {
+		if s == name {
+			return p, true
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, false
+	}
+	return Priority(n), true
+}
+
+// AppendToOutgoingContext returns a context sending p with outgoing calls.
+func AppendToOutgoingContext(ctx context.Context, p Priority) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, MetadataKey, p.String())
+}
+
+// Policy resolves the priority claimed by a caller into the one the server
+// uses.
+type Policy struct {
+	// Default is used when the caller claims no or a malformed priority.
+	Default Priority
+	// Min and Max bound the priority any caller may claim. A zero Max, as in
+	// the zero Policy, means High: use MaxFunc to cap callers at Low.
+	Min, Max Priority
+	// MaxFunc, if set, returns a stricter upper bound for the caller, e.g.
+	// based on its authenticated identity.
+	MaxFunc func(ctx context.Context) Priority
+}
+
+// DefaultPolicy lets clients choose between Low and Normal, so that only
+// servers can grant High and Critical.
+var DefaultPolicy = Policy{
+	Default: Normal,
+	Min:     Low,
+	Max:     Normal,
+}
+
+// Resolve returns the priority of the request in ctx. A priority stored by
+// the interceptors of this package takes precedence over the metadata.
+func (p Policy) Resolve(ctx context.Context) Priority {
+	if prio, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return prio
+	}
+
+	prio := p.Default
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vs := md.Get(MetadataKey); len(vs) > 0 {
+			if claimed, ok := Parse(vs[0]); ok {
+				prio = claimed
+			}
+		}
+	}
+	max := p.Max
+	if max == Low {
+		max = High
+	}
+	if p.MaxFunc != nil {
+		if m := p.MaxFunc(ctx); m < max {
+			max = m
+		}
+	}
+	if prio > max {
+		prio = max
+	}
+	if prio < p.Min {
+		prio = p.Min
+	}
+	return prio
+}
+
+type priorityKey struct{}
+
+// NewContext returns a context carrying p as the resolved priority.
+func NewContext(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// FromContext returns the priority stored by NewContext or the interceptors
+// of this package.
+func FromContext(ctx context.Context) (Priority, bool) {
+	p, ok := ctx.Value(priorityKey{}).(Priority)
+	return p, ok
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor resolving the
+// request priority once with policy and storing it in the context.
+func UnaryServerInterceptor(policy Policy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(NewContext(ctx, policy.Resolve(ctx)), req)
+	}
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor resolving
+// the request priority once with policy and storing it in the context.
+func StreamServerInterceptor(policy Policy) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := NewContext(stream.Context(), policy.Resolve(stream.Context()))
+		wrapped := middleware.WrapServerStream(stream)
+		wrapped.SetContext(ctx)
+		return handler(srv, wrapped)
+	}
+}
@@ -0,0 +1,47 @@
+package priority
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestPolicyResolve(t *testing.T) {
+	incoming := func(v string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKey, v))
+	}
+	policy := Policy{Default: Normal, Min: Low, Max: High}
+
+	for _, tc := range []struct {
+		ctx  context.Context
+		want Priority
+	}{
+		{context.Background(), Normal},
+		{incoming("low"), Low},
+		{incoming("HIGH"), High},
+		{incoming("critical"), High},
+		{incoming("-5"), Low},
+		{incoming("bogus"), Normal},
+		{NewContext(incoming("low"), Critical), Critical},
+	} {
+		if have := policy.Resolve(tc.ctx); tc.want != have {
+			t.Errorf("Resolve: want %v, have %v", tc.want, have)
+		}
+	}
+
+	policy.MaxFunc = func(context.Context) Priority { return Normal }
+	if want, have := Normal, policy.Resolve(incoming("high")); want != have {
+		t.Errorf("Resolve with MaxFunc: want %v, have %v", want, have)
+	}
+}
+
+func TestZeroPolicy(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKey, "critical"))
+	if want, have := High, (Policy{}).Resolve(ctx); want != have {
+		t.Fatalf("zero policy: want %v, have %v", want, have)
+	}
+	if want, have := Low, (Policy{}).Resolve(context.Background()); want != have {
+		t.Fatalf("zero policy default: want %v, have %v", want, have)
+	}
+}
@@ -0,0 +1,134 @@
+// Package health registers the grpc.health.v1 service on servers and keeps
+// it, service discovery and shutdown in agreement on whether the instance is
+// ready.
+package health
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/ipfans/grpctools/server/shutdown"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Instance is the service name of the instance as a whole, checked by load
+// balancers and probes by default.
+const Instance = ""
+
+// ErrShutdown is returned by SetServing once the instance shut down.
+var ErrShutdown = errors.New("health: shut down")
+
+// Registrar adds the instance to and removes it from service discovery.
+type Registrar interface {
+	Register(ctx context.Context) error
+	Deregister(ctx context.Context) error
+}
+
+type options struct {
+	registrar Registrar
+}
+
+// Option for Health instance.
+type Option func(o *options)
+
+// WithRegistrar sets the registrar the instance is registered to while it is
+// serving.
+func WithRegistrar(r Registrar) Option {
+	return func(o *options) {
+		o.registrar = r
+	}
+}
+
+// Health is the readiness of an instance.
+type Health struct {
+	server    *grpchealth.Server
+	registrar Registrar
+
+	mu         sync.Mutex
+	registered bool
+	shutdown   bool
+}
+
+// Register registers the health service on s and returns it. The services
+// already registered on s are SERVING, the instance is NOT_SERVING until
+// SetServing(ctx, Instance) tells it is ready.
+func Register(s *grpc.Server, opts ...Option) *Health {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	h := &Health{server: grpchealth.NewServer(), registrar: o.registrar}
+	for name := range s.GetServiceInfo() {
+		h.server.SetServingStatus(name, healthpb.HealthCheckResponse_SERVING)
+	}
+	h.server.SetServingStatus(Instance, healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(s, h.server)
+	return h
+}
+
+// SetServing marks service as SERVING. For Instance, the instance is then
+// registered to the registrar.
+func (h *Health) SetServing(ctx context.Context, service string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.shutdown {
+		return ErrShutdown
+	}
+	if service == Instance && h.registrar != nil && !h.registered {
+		if err := h.registrar.Register(ctx); err != nil {
+			return err
+		}
+		h.registered = true
+	}
+	h.server.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+	return nil
+}
+
+// SetNotServing marks service as NOT_SERVING. For Instance, the instance is
+// then deregistered from the registrar.
+func (h *Health) SetNotServing(ctx context.Context, service string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.server.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+	if service == Instance {
+		return h.deregister(ctx)
+	}
+	return nil
+}
+
+// deregister removes the instance from the registrar if it is registered.
+func (h *Health) deregister(ctx context.Context) error {
+	if h.registrar == nil || !h.registered {
+		return nil
+	}
+	if err := h.registrar.Deregister(ctx); err != nil {
+		return err
+	}
+	h.registered = false
+	return nil
+}
+
+// Shutdown marks every service NOT_SERVING for good; SetServing fails from
+// then on.
+func (h *Health) Shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.shutdown = true
+	h.server.Shutdown()
+}
+
+// Deregister removes the instance from the registrar, for shutdown managers.
+func (h *Health) Deregister(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.deregister(ctx)
+}
+
+// ShutdownOptions returns the options of shutdown.New making a Manager flip
+// h to NOT_SERVING and deregister the instance through it.
+func (h *Health) ShutdownOptions() []shutdown.Option {
+	return []shutdown.Option{shutdown.WithHealth(h), shutdown.WithRegistrar(h)}
+}
@@ -0,0 +1,72 @@
+package health
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ipfans/grpctools/server/shutdown"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type registrar struct {
+	calls []string
+}
+
+func (r *registrar) Register(ctx context.Context) error {
+	r.calls = append(r.calls, "register")
+	return nil
+}
+
+func (r *registrar) Deregister(ctx context.Context) error {
+	r.calls = append(r.calls, "deregister")
+	return nil
+}
+
+func TestHealth(t *testing.T) {
+	s := grpc.NewServer()
+	s.RegisterService(&grpc.ServiceDesc{ServiceName: "test.Service", HandlerType: (*interface{})(nil)}, struct{}{})
+	r := &registrar{}
+	h := Register(s, WithRegistrar(r))
+	ctx := context.Background()
+
+	check := func(service string) string {
+		resp, err := h.server.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			return err.Error()
+		}
+		return resp.Status.String()
+	}
+	if want, have := "NOT_SERVING", check(Instance); want != have {
+		t.Fatalf("initial: want %s, have %s", want, have)
+	}
+	if want, have := "SERVING", check("test.Service"); want != have {
+		t.Fatalf("registered service: want %s, have %s", want, have)
+	}
+
+	h.SetServing(ctx, Instance)
+	h.SetServing(ctx, Instance)
+	h.SetNotServing(ctx, "users.Users")
+	if want, have := "SERVING", check(Instance); want != have {
+		t.Fatalf("ready: want %s, have %s", want, have)
+	}
+	if want, have := "NOT_SERVING", check("users.Users"); want != have {
+		t.Fatalf("service: want %s, have %s", want, have)
+	}
+
+	m := shutdown.New(s, append(h.ShutdownOptions(), shutdown.WithDrain(0), shutdown.WithTimeout(time.Second))...)
+	if err := m.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "NOT_SERVING", check(Instance); want != have {
+		t.Fatalf("shut down: want %s, have %s", want, have)
+	}
+	if want, have := ErrShutdown, h.SetServing(ctx, Instance); want != have {
+		t.Fatalf("serving after shutdown: want %v, have %v", want, have)
+	}
+	if want, have := "[register deregister]", fmt.Sprint(r.calls); want != have {
+		t.Fatalf("registrar: want %s, have %s", want, have)
+	}
+}
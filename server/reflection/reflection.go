@@ -0,0 +1,77 @@
+// Package reflection registers the gRPC server reflection service only where
+// it is enabled, like staging, and guards it with the auth middleware where it
+// must not be public.
+package reflection
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	grpcreflection "google.golang.org/grpc/reflection"
+)
+
+// EnvVar is the environment variable enabling reflection by default, parsed
+// by strconv.ParseBool.
+const EnvVar = "GRPC_REFLECTION"
+
+// prefix is the prefix of the methods of the reflection service.
+const prefix = "/grpc.reflection."
+
+type options struct {
+	enabled func() bool
+}
+
+// Option for Register.
+type Option func(o *options)
+
+// WithEnabled enables or disables reflection, overriding EnvVar, e.g. from a
+// config flag.
+func WithEnabled(enabled bool) Option {
+	return func(o *options) {
+		o.enabled = func() bool { return enabled }
+	}
+}
+
+// WithEnv reads whether reflection is enabled from the environment variable
+// name instead of EnvVar.
+func WithEnv(name string) Option {
+	return func(o *options) {
+		o.enabled = func() bool { return env(name) }
+	}
+}
+
+// env reports whether the environment variable name is true.
+func env(name string) bool {
+	enabled, _ := strconv.ParseBool(os.Getenv(name))
+	return enabled
+}
+
+// Register registers the reflection service on s if it is enabled, by EnvVar
+// unless opts say otherwise, and reports whether it did.
+func Register(s *grpc.Server, opts ...Option) bool {
+	o := options{enabled: func() bool { return env(EnvVar) }}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if !o.enabled() {
+		return false
+	}
+	grpcreflection.Register(s)
+	return true
+}
+
+// StreamServerInterceptor returns a new streaming server interceptor running
+// guard, like apikey.StreamServerInterceptor or mtls.StreamServerInterceptor,
+// on reflection streams only. Reflection being a streaming service, there is
+// no unary counterpart. Servers skipping their chain for infrastructure calls
+// with selector.FastPathStreamServer pass it as the fast interceptor.
+func StreamServerInterceptor(guard grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !strings.HasPrefix(info.FullMethod, prefix) {
+			return handler(srv, stream)
+		}
+		return guard(srv, stream, info, handler)
+	}
+}
@@ -0,0 +1,55 @@
+package reflection
+
+import (
+	"os"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRegister(t *testing.T) {
+	defer os.Unsetenv(EnvVar)
+	for _, tc := range []struct {
+		name string
+		env  string
+		opts []Option
+		want bool
+	}{
+		{"unset", "", nil, false},
+		{"env", "true", nil, true},
+		{"invalid env", "yes", nil, false},
+		{"disabled by config", "1", []Option{WithEnabled(false)}, false},
+		{"enabled by config", "", []Option{WithEnabled(true)}, true},
+		{"other env", "1", []Option{WithEnv("STAGING")}, false},
+	} {
+		os.Setenv(EnvVar, tc.env)
+		s := grpc.NewServer()
+		if have := Register(s, tc.opts...); tc.want != have {
+			t.Errorf("%s: want %v, have %v", tc.name, tc.want, have)
+		}
+		if _, have := s.GetServiceInfo()["grpc.reflection.v1alpha.ServerReflection"]; tc.want != have {
+			t.Errorf("%s: registered: want %v, have %v", tc.name, tc.want, have)
+		}
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	interceptor := StreamServerInterceptor(func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return status.Error(codes.Unauthenticated, "no credentials")
+	})
+	handler := func(srv interface{}, stream grpc.ServerStream) error { return nil }
+	for _, tc := range []struct {
+		method string
+		want   codes.Code
+	}{
+		{"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo", codes.Unauthenticated},
+		{"/test.Service/Watch", codes.OK},
+	} {
+		err := interceptor(nil, nil, &grpc.StreamServerInfo{FullMethod: tc.method}, handler)
+		if have := status.Code(err); tc.want != have {
+			t.Errorf("%s: want %v, have %v", tc.method, tc.want, have)
+		}
+	}
+}
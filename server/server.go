@@ -0,0 +1,196 @@
+// Package server assembles a grpc.Server with defaults suited to production:
+// keepalive enforcement, bounded streams and messages, and the request ID,
+// logging and recovery interceptors of this repository.
+package server
+
+import (
+	"os"
+	"time"
+
+	"github.com/ipfans/grpctools/middleware"
+	"github.com/ipfans/grpctools/middleware/logging"
+	"github.com/ipfans/grpctools/middleware/recovery"
+	"github.com/ipfans/grpctools/middleware/requestid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/keepalive"
+)
+
+// Defaults of the server options.
+const (
+	DefaultMaxConcurrentStreams = 1000
+	DefaultMaxRecvMsgSize       = 4 << 20
+	DefaultMaxSendMsgSize       = 4 << 20
+)
+
+// DefaultKeepalive pings idle connections every minute, closes connections
+// idle for 15 minutes and recycles connections every 30 minutes, so clients
+// rebalance onto new instances.
+var DefaultKeepalive = keepalive.ServerParameters{
+	MaxConnectionIdle:     15 * time.Minute,
+	MaxConnectionAge:      30 * time.Minute,
+	MaxConnectionAgeGrace: 30 * time.Second,
+	Time:                  time.Minute,
+	Timeout:               20 * time.Second,
+}
+
+// DefaultEnforcement lets clients ping every 10 seconds, even without active
+// streams; connections of clients pinging more often are closed.
+var DefaultEnforcement = keepalive.EnforcementPolicy{
+	MinTime:             10 * time.Second,
+	PermitWithoutStream: true,
+}
+
+type options struct {
+	keepalive   keepalive.ServerParameters
+	enforcement keepalive.EnforcementPolicy
+	streams     uint32
+	recvSize    int
+	sendSize    int
+	logger      grpclog.LoggerV2
+	defaults    bool
+	unary       []grpc.UnaryServerInterceptor
+	stream      []grpc.StreamServerInterceptor
+	raw         []grpc.ServerOption
+}
+
+// Option for New.
+type Option func(o *options)
+
+// WithKeepalive sets the keepalive parameters of the server. Default is
+// DefaultKeepalive.
+func WithKeepalive(p keepalive.ServerParameters) Option {
+	return func(o *options) {
+		o.keepalive = p
+	}
+}
+
+// WithKeepaliveEnforcement sets how often clients may ping. Default is
+// DefaultEnforcement.
+func WithKeepaliveEnforcement(p keepalive.EnforcementPolicy) Option {
+	return func(o *options) {
+		o.enforcement = p
+	}
+}
+
+// WithMaxConcurrentStreams sets the number of concurrent streams of each
+// connection. Default is DefaultMaxConcurrentStreams.
+func WithMaxConcurrentStreams(n uint32) Option {
+	return func(o *options) {
+		o.streams = n
+	}
+}
+
+// WithMaxMsgSize sets the size in bytes of the largest message the server
+// receives and sends. Default is DefaultMaxRecvMsgSize and
+// DefaultMaxSendMsgSize.
+func WithMaxMsgSize(recv, send int) Option {
+	return func(o *options) {
+		o.recvSize = recv
+		o.sendSize = send
+	}
+}
+
+// WithLogger replaced built-in logger to given, for the default logging and
+// recovery interceptors.
+func WithLogger(logger grpclog.LoggerV2) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithoutDefaultInterceptors leaves out the default chain: requestid, logging
+// and recovery.
+func WithoutDefaultInterceptors() Option {
+	return func(o *options) {
+		o.defaults = false
+	}
+}
+
+// WithUnaryInterceptors adds interceptors to the unary chain, after the
+// default ones.
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(o *options) {
+		o.unary = append(o.unary, interceptors...)
+	}
+}
+
+// WithStreamInterceptors adds interceptors to the streaming chain, after the
+// default ones.
+func WithStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) Option {
+	return func(o *options) {
+		o.stream = append(o.stream, interceptors...)
+	}
+}
+
+// WithServerOptions adds raw server options, applied after those of New so
+// they override them. Interceptors must be added with WithUnaryInterceptors
+// and WithStreamInterceptors, or grpc.ChainUnaryInterceptor and
+// grpc.ChainStreamInterceptor: grpc.UnaryInterceptor and grpc.StreamInterceptor
+// panic as New sets them already.
+func WithServerOptions(opts ...grpc.ServerOption) Option {
+	return func(o *options) {
+		o.raw = append(o.raw, opts...)
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		keepalive:   DefaultKeepalive,
+		enforcement: DefaultEnforcement,
+		streams:     DefaultMaxConcurrentStreams,
+		recvSize:    DefaultMaxRecvMsgSize,
+		sendSize:    DefaultMaxSendMsgSize,
+		logger:      grpclog.NewLoggerV2(os.Stdout, os.Stdout, os.Stderr),
+		defaults:    true,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// ServerOptions returns the grpc.ServerOptions New creates its server with,
+// for servers built by other means.
+func ServerOptions(opts ...Option) []grpc.ServerOption {
+	o := newOptions(opts)
+	unary, stream := o.unary, o.stream
+	if o.defaults {
+		logOpts := []logging.Option{
+			logging.WithLogger(logging.GRPCLogger(o.logger)),
+			logging.WithSkipMethods("/grpc.health.v1.Health/Check", "/grpc.health.v1.Health/Watch"),
+		}
+		unary = append([]grpc.UnaryServerInterceptor{
+			requestid.UnaryServerInterceptor(),
+			logging.UnaryServerInterceptor(logOpts...),
+			recovery.UnaryServerInterceptor(recovery.WithLogger(o.logger)),
+		}, unary...)
+		stream = append([]grpc.StreamServerInterceptor{
+			requestid.StreamServerInterceptor(),
+			logging.StreamServerInterceptor(logOpts...),
+			recovery.StreamServerInterceptor(recovery.WithLogger(o.logger)),
+		}, stream...)
+	}
+	serverOpts := []grpc.ServerOption{
+		grpc.KeepaliveParams(o.keepalive),
+		grpc.KeepaliveEnforcementPolicy(o.enforcement),
+		grpc.MaxConcurrentStreams(o.streams),
+		grpc.MaxRecvMsgSize(o.recvSize),
+		grpc.MaxSendMsgSize(o.sendSize),
+	}
+	if len(unary) > 0 {
+		serverOpts = append(serverOpts, grpc.UnaryInterceptor(middleware.ChainUnaryServer(unary...)))
+	}
+	if len(stream) > 0 {
+		serverOpts = append(serverOpts, grpc.StreamInterceptor(middleware.ChainStreamServer(stream...)))
+	}
+	return append(serverOpts, o.raw...)
+}
+
+// New returns a grpc.Server with DefaultKeepalive, DefaultEnforcement,
+// DefaultMaxConcurrentStreams, DefaultMaxRecvMsgSize, DefaultMaxSendMsgSize
+// and the requestid, logging and recovery interceptors, in this order, unless
+// opts say otherwise.
+func New(opts ...Option) *grpc.Server {
+	return grpc.NewServer(ServerOptions(opts...)...)
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/ipfans/grpctools/middleware/requestid"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// serve starts s and returns a health client connected to it.
+func serve(t *testing.T, s *grpc.Server) healthpb.HealthClient {
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	lis := bufconn.Listen(1 << 20)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	cc, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return healthpb.NewHealthClient(cc)
+}
+
+func TestNew(t *testing.T) {
+	var (
+		buf   bytes.Buffer
+		calls []string
+	)
+	s := New(
+		WithLogger(grpclog.NewLoggerV2(&buf, &buf, &buf)),
+		WithMaxMsgSize(64, 64),
+		WithUnaryInterceptors(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if _, ok := requestid.FromContext(ctx); !ok {
+				t.Error("custom interceptor ran before the default ones")
+			}
+			calls = append(calls, info.FullMethod)
+			if req.(*healthpb.HealthCheckRequest).Service == "panic" {
+				panic("boom")
+			}
+			return handler(ctx, req)
+		}),
+	)
+	client := serve(t, s)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		service string
+		want    codes.Code
+	}{
+		{"", codes.OK},
+		{"panic", codes.Internal},
+		{strings.Repeat("x", 100), codes.ResourceExhausted},
+	} {
+		if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: tc.service}); tc.want != status.Code(err) {
+			t.Errorf("%.10q: want %v, have %v", tc.service, tc.want, status.Code(err))
+		}
+	}
+	if want, have := 2, len(calls); want != have {
+		t.Fatalf("calls: want %d, have %d", want, have)
+	}
+	if !strings.Contains(buf.String(), "middleware/recovery: panic: boom") {
+		t.Fatalf("recovered panic not logged: %s", buf.String())
+	}
+}
+
+func TestServerOptions(t *testing.T) {
+	if want, have := 7, len(ServerOptions()); want != have {
+		t.Fatalf("defaults: want %d, have %d", want, have)
+	}
+	if want, have := 6, len(ServerOptions(WithoutDefaultInterceptors(), WithServerOptions(grpc.ConnectionTimeout(0)))); want != have {
+		t.Fatalf("without interceptors: want %d, have %d", want, have)
+	}
+}
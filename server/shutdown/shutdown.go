@@ -0,0 +1,210 @@
+// Package shutdown stops gRPC servers gracefully: it takes the instance out of
+// rotation, drains connections and runs cleanup hooks, on signal or call.
+package shutdown
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/grpclog"
+)
+
+// Server is stopped by a Manager, like *grpc.Server.
+type Server interface {
+	GracefulStop()
+	Stop()
+}
+
+// Health is flipped to NOT_SERVING first, like *health.Server of
+// google.golang.org/grpc/health, so clients and load balancers stop sending
+// new RPCs.
+type Health interface {
+	Shutdown()
+}
+
+// Registrar removes the instance from service discovery.
+type Registrar interface {
+	Deregister(ctx context.Context) error
+}
+
+// Hook cleans up after the server stopped, like closing database pools or
+// flushing telemetry.
+type Hook func(ctx context.Context) error
+
+type hook struct {
+	name string
+	f    Hook
+}
+
+type options struct {
+	health    Health
+	registrar Registrar
+	drain     time.Duration
+	timeout   time.Duration
+	logger    grpclog.LoggerV2
+}
+
+// Option for Manager instance.
+type Option func(o *options)
+
+// WithHealth sets the health service flipped to NOT_SERVING on shutdown.
+func WithHealth(h Health) Option {
+	return func(o *options) {
+		o.health = h
+	}
+}
+
+// WithRegistrar sets the registrar the instance is deregistered from on
+// shutdown.
+func WithRegistrar(r Registrar) Option {
+	return func(o *options) {
+		o.registrar = r
+	}
+}
+
+// WithDrain sets how long the server keeps serving after leaving rotation, so
+// clients notice before connections close. Default is 5 seconds.
+func WithDrain(d time.Duration) Option {
+	return func(o *options) {
+		o.drain = d
+	}
+}
+
+// WithTimeout sets how long GracefulStop may wait for RPCs in flight before
+// the server is stopped hard. Default is 30 seconds.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithLogger replaced built-in logger to given.
+func WithLogger(logger grpclog.LoggerV2) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// Manager shuts a server down once.
+type Manager struct {
+	server Server
+	opts   options
+
+	mu    sync.Mutex
+	hooks []hook
+
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+// New returns a Manager of s.
+func New(s Server, opts ...Option) *Manager {
+	o := options{
+		drain:   5 * time.Second,
+		timeout: 30 * time.Second,
+		logger:  grpclog.NewLoggerV2(os.Stdout, os.Stdout, os.Stderr),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Manager{server: s, opts: o, done: make(chan struct{})}
+}
+
+// Add registers a hook run after the server stopped. Hooks run in the order
+// they were added, each one even if those before it failed.
+func (m *Manager) Add(name string, f Hook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, hook{name: name, f: f})
+}
+
+// Shutdown flips health to NOT_SERVING, deregisters the instance, waits for
+// the drain period, stops the server gracefully, or hard once the timeout is
+// over, and runs the hooks. ctx bounds deregistration, the drain period and
+// hooks. It returns the first error of the registrar or hooks; later calls
+// wait for the first one and return its error.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.once.Do(func() {
+		m.err = m.shutdown(ctx)
+		close(m.done)
+	})
+	<-m.done
+	return m.err
+}
+
+func (m *Manager) shutdown(ctx context.Context) error {
+	var first error
+	fail := func(err error) {
+		if first == nil {
+			first = err
+		}
+	}
+
+	if m.opts.health != nil {
+		m.opts.health.Shutdown()
+	}
+	if m.opts.registrar != nil {
+		if err := m.opts.registrar.Deregister(ctx); err != nil {
+			m.opts.logger.Errorf("shutdown: deregister: %v", err)
+			fail(err)
+		}
+	}
+
+	select {
+	case <-time.After(m.opts.drain):
+	case <-ctx.Done():
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		m.server.GracefulStop()
+		close(stopped)
+	}()
+	timer := time.NewTimer(m.opts.timeout)
+	select {
+	case <-stopped:
+		timer.Stop()
+	case <-timer.C:
+		m.opts.logger.Warningf("shutdown: RPCs still in flight after %v, stopping", m.opts.timeout)
+		m.server.Stop()
+		<-stopped
+	}
+
+	m.mu.Lock()
+	hooks := append([]hook(nil), m.hooks...)
+	m.mu.Unlock()
+	for _, h := range hooks {
+		if err := h.f(ctx); err != nil {
+			m.opts.logger.Errorf("shutdown: %s: %v", h.name, err)
+			fail(err)
+		}
+	}
+	return first
+}
+
+// Done is closed once Shutdown returned.
+func (m *Manager) Done() <-chan struct{} {
+	return m.done
+}
+
+// Wait blocks until one of signals, SIGINT and SIGTERM by default, is received
+// or Shutdown is called, then shuts down and returns its error.
+func (m *Manager) Wait(signals ...os.Signal) error {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, signals...)
+	defer signal.Stop(c)
+	select {
+	case sig := <-c:
+		m.opts.logger.Infof("shutdown: received %v", sig)
+	case <-m.done:
+	}
+	return m.Shutdown(context.Background())
+}
@@ -0,0 +1,85 @@
+package shutdown
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// recorder records the steps of a shutdown in order.
+type recorder struct {
+	mu    sync.Mutex
+	steps []string
+	stop  chan struct{}
+	hang  bool
+}
+
+func (r *recorder) record(step string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.steps = append(r.steps, step)
+}
+
+func (r *recorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return fmt.Sprint(r.steps)
+}
+
+func (r *recorder) Shutdown() { r.record("health") }
+
+func (r *recorder) Deregister(ctx context.Context) error {
+	r.record("deregister")
+	return errors.New("registry down")
+}
+
+func (r *recorder) GracefulStop() {
+	r.record("graceful stop")
+	if r.hang {
+		<-r.stop
+	}
+}
+
+func (r *recorder) Stop() {
+	r.record("stop")
+	close(r.stop)
+}
+
+func TestShutdown(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		hang bool
+		want string
+	}{
+		{"graceful", false, "[health deregister graceful stop db cache]"},
+		{"hard", true, "[health deregister graceful stop stop db cache]"},
+	} {
+		r := &recorder{stop: make(chan struct{}), hang: tc.hang}
+		m := New(r, WithHealth(r), WithRegistrar(r), WithDrain(time.Millisecond), WithTimeout(10*time.Millisecond))
+		m.Add("db", func(ctx context.Context) error {
+			r.record("db")
+			return errors.New("db already closed")
+		})
+		m.Add("cache", func(ctx context.Context) error {
+			r.record("cache")
+			return nil
+		})
+
+		errs := make(chan error, 1)
+		go func() { errs <- m.Wait() }()
+		err := m.Shutdown(context.Background())
+		if want, have := "registry down", fmt.Sprint(err); want != have {
+			t.Errorf("%s: error: want %s, have %s", tc.name, want, have)
+		}
+		if err := <-errs; err == nil {
+			t.Errorf("%s: Wait: want the error of Shutdown", tc.name)
+		}
+		if have := r.String(); tc.want != have {
+			t.Errorf("%s: want %s, have %s", tc.name, tc.want, have)
+		}
+	}
+}